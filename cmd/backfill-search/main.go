@@ -0,0 +1,90 @@
+// backfill-search строит слепой индекс поиска для сообщений, отправленных до его появления
+// или проиндексированных старой версией либо другим ключом индекса (после смены search_key_id).
+// Можно запускать повторно и при работающем сервере.
+package main
+
+import (
+	"flag"
+	"log"
+	"playmates/components/blindindex"
+	"playmates/components/datakeys"
+	"playmates/components/db"
+	"playmates/components/keyprovider"
+	"playmates/components/playmates/config"
+	"playmates/components/repository"
+	"playmates/components/sealer"
+)
+
+func main() {
+	configPath := flag.String("config", "config/config.yaml", "path to config")
+	batchSize := flag.Int("batch", 500, "messages per batch")
+	flag.Parse()
+
+	cfg, err := config.New(*configPath)
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	db, err := db.ConnectPostgres(cfg.DbConnStr)
+	if err != nil {
+		log.Fatalf("Error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	repository := repository.New(db)
+
+	sealer, err := sealer.NewKeyring(cfg.SealerKeyring())
+	if err != nil {
+		log.Fatalf("Error creating sealer: %v", err)
+	}
+
+	keyProvider, err := keyprovider.New(cfg.KeyProvider, sealer)
+	if err != nil {
+		log.Fatalf("Error creating key provider: %v", err)
+	}
+	dataKeys := datakeys.New(repository, keyProvider, sealer, cfg.Messages.RequireAD)
+
+	searchKey, searchKeyID, err := cfg.SearchKey()
+	if err != nil {
+		log.Fatalf("Error creating search key: %v", err)
+	}
+	index := blindindex.New(searchKey, searchKeyID)
+
+	indexed, failed, raced, lastID := 0, 0, 0, 0
+	for {
+		messages, err := repository.GetUnindexedMessages(lastID, index.KeyID(), *batchSize)
+		if err != nil {
+			log.Fatalf("Error getting messages: %v", err)
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		for _, msg := range messages {
+			lastID = msg.ID
+
+			text, err := dataKeys.OpenMessage(msg)
+			if err != nil {
+				// Не расшифровалось - пропускаем, чтобы одно сообщение не останавливало весь проход
+				log.Printf("Error decrypting message %d: %v", msg.ID, err)
+				failed++
+				continue
+			}
+
+			ok, err := repository.SetSearchTokens(msg, index.Tokens(msg.ConversationID, string(text)))
+			if err != nil {
+				log.Fatalf("Error indexing message %d: %v", msg.ID, err)
+			}
+			// Сообщение изменили или удалили после чтения - токены нового текста записала правка
+			if !ok {
+				raced++
+				continue
+			}
+			indexed++
+		}
+
+		log.Printf("Indexed %d messages, last ID %d", indexed, lastID)
+	}
+
+	log.Printf("Done: %d indexed, %d changed concurrently, %d failed", indexed, raced, failed)
+}
@@ -0,0 +1,181 @@
+// backfill-users шифрует email и about_me пользователей, зарегистрированных до шифрования
+// профилей, и заполняет HMAC email и слепой индекс about_me. Открытые значения после этого
+// стираются. Можно запускать повторно и при работающем сервере: строку, изменённую за время
+// прохода, не перезаписывает, она останется для следующего запуска. После смены search_key_id
+// заново индексирует about_me, проиндексированные другим ключом, после смены email_hmac.key_id -
+// перехэширует email, захэшированные другим ключом.
+package main
+
+import (
+	"flag"
+	"log"
+	"playmates/components/blindindex"
+	"playmates/components/db"
+	"playmates/components/playmates/config"
+	"playmates/components/playmates/models"
+	"playmates/components/repository"
+	"playmates/components/sealer"
+)
+
+func main() {
+	configPath := flag.String("config", "config/config.yaml", "path to config")
+	batchSize := flag.Int("batch", 500, "users per batch")
+	flag.Parse()
+
+	cfg, err := config.New(*configPath)
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	db, err := db.ConnectPostgres(cfg.DbConnStr)
+	if err != nil {
+		log.Fatalf("Error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.New(db)
+
+	s, err := sealer.NewKeyring(cfg.SealerKeyring())
+	if err != nil {
+		log.Fatalf("Error creating sealer: %v", err)
+	}
+
+	searchKey, searchKeyID, err := cfg.SearchKey()
+	if err != nil {
+		log.Fatalf("Error creating search key: %v", err)
+	}
+	index := blindindex.New(searchKey, searchKeyID)
+
+	emails, err := cfg.EmailHasher()
+	if err != nil {
+		log.Fatalf("Error creating email hasher: %v", err)
+	}
+
+	sealed, raced, failed, lastID := 0, 0, 0, 0
+	for {
+		users, err := repo.GetPlaintextUsers(lastID, *batchSize)
+		if err != nil {
+			log.Fatalf("Error getting users: %v", err)
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		for _, user := range users {
+			lastID = user.ID
+
+			var emailHash blindindex.EmailHashes
+			var emailSealed, aboutMeSealed []byte
+			tokens := index.ProfileTokens(user.AboutMe.String)
+
+			if user.Email.Valid {
+				email := blindindex.NormalizeEmail(user.Email.String)
+				emailHash = emails.Hashes(email)
+				emailSealed, err = s.EncryptWithAD([]byte(email), models.UserFieldAD(user.ID, models.UserFieldEmail))
+				if err != nil {
+					log.Fatalf("Error encrypting email of user %d: %v", user.ID, err)
+				}
+			}
+
+			if user.AboutMe.Valid && user.AboutMe.String != "" {
+				aboutMeSealed, err = s.EncryptWithAD([]byte(user.AboutMe.String), models.UserFieldAD(user.ID, models.UserFieldAboutMe))
+				if err != nil {
+					log.Fatalf("Error encrypting about_me of user %d: %v", user.ID, err)
+				}
+			}
+
+			ok, err := repo.SealUserPII(user, emailHash, emailSealed, aboutMeSealed, tokens)
+			if err != nil {
+				// Обычно это email, совпадающий с чужим без учёта регистра: такие строки
+				// остаются открытыми, пока дубликат не разрешат вручную
+				log.Printf("Error sealing user %d: %v", user.ID, err)
+				failed++
+				continue
+			}
+			if ok {
+				sealed++
+			} else {
+				// Профиль изменили после чтения - строку подхватит следующий запуск
+				raced++
+			}
+		}
+
+		log.Printf("Sealed %d users, last ID %d", sealed, lastID)
+	}
+
+	reindexed, lastID := 0, 0
+	for {
+		users, err := repo.GetUnindexedUsers(lastID, index.KeyID(), *batchSize)
+		if err != nil {
+			log.Fatalf("Error getting users: %v", err)
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		for _, user := range users {
+			lastID = user.ID
+
+			aboutMe, err := s.DecryptWithAD(user.AboutMeSealed, models.UserFieldAD(user.ID, models.UserFieldAboutMe))
+			if err != nil {
+				log.Printf("Error decrypting about_me of user %d: %v", user.ID, err)
+				failed++
+				continue
+			}
+
+			ok, err := repo.ReindexUser(user, index.ProfileTokens(string(aboutMe)))
+			if err != nil {
+				log.Fatalf("Error indexing user %d: %v", user.ID, err)
+			}
+			if ok {
+				reindexed++
+			} else {
+				raced++
+			}
+		}
+
+		log.Printf("Reindexed %d users, last ID %d", reindexed, lastID)
+	}
+
+	rehashed, lastID := 0, 0
+	for {
+		users, err := repo.GetStaleEmails(lastID, emails.KeyID(), *batchSize)
+		if err != nil {
+			log.Fatalf("Error getting users: %v", err)
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		for _, user := range users {
+			lastID = user.ID
+
+			email, err := s.DecryptWithAD(user.EmailSealed, models.UserFieldAD(user.ID, models.UserFieldEmail))
+			if err != nil {
+				log.Printf("Error decrypting email of user %d: %v", user.ID, err)
+				failed++
+				continue
+			}
+
+			ok, err := repo.RehashEmail(user, emails.Hash(string(email)), emails.KeyID())
+			if err != nil {
+				// Хэш новым ключом совпал с чужим: тот же email у двух пользователей
+				log.Printf("Error rehashing email of user %d: %v", user.ID, err)
+				failed++
+				continue
+			}
+			if ok {
+				rehashed++
+			} else {
+				raced++
+			}
+		}
+
+		log.Printf("Rehashed %d emails, last ID %d", rehashed, lastID)
+	}
+
+	log.Printf("Done: %d sealed, %d reindexed, %d rehashed, %d changed concurrently, %d failed", sealed, reindexed, rehashed, raced, failed)
+	if raced > 0 || failed > 0 {
+		log.Fatalf("Some users were not processed, run again after fixing the errors above")
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	_ "net/http/pprof"
+	"playmates/components/blindindex"
+	"playmates/components/blobstore"
+	"playmates/components/broker"
 	"playmates/components/connection-manager"
+	"playmates/components/datakeys"
 	"playmates/components/db"
 	"playmates/components/entrypoint"
+	"playmates/components/keyprovider"
 	"playmates/components/playmates/config"
 	"playmates/components/playmates/handler"
 	"playmates/components/playmates/service"
+	"playmates/components/recommender"
 	"playmates/components/repository"
 	"playmates/components/sealer"
 	"time"
@@ -35,18 +42,109 @@ func main() {
 
 	repository := repository.New(db)
 
-	connectionManager := connection_manager.New()
+	connectionManager := connection_manager.New(cfg.WebSocket.SendQueueSize, cfg.WebSocket.WriteWait, cfg.WebSocket.PingInterval)
 
-	sealer, err := sealer.New([]byte(cfg.SealerSecret))
+	sealer, err := sealer.NewKeyring(cfg.SealerKeyring())
 	if err != nil {
-		log.Fatalf("Error creating sealer: %w", err)
+		log.Fatalf("Error creating sealer: %v", err)
 	}
 
-	service := service.New(db, cfg.JwtSecret, repository, connectionManager, sealer)
+	keyProvider, err := keyprovider.New(cfg.KeyProvider, sealer)
+	if err != nil {
+		log.Fatalf("Error creating key provider: %v", err)
+	}
+
+	searchKey, searchKeyID, err := cfg.SearchKey()
+	if err != nil {
+		log.Fatalf("Error creating search key: %v", err)
+	}
+
+	emails, err := cfg.EmailHasher()
+	if err != nil {
+		log.Fatalf("Error creating email hasher: %v", err)
+	}
+
+	blobs, err := blobstore.New(cfg.Attachments.Dir)
+	if err != nil {
+		log.Fatalf("Error creating blob store: %v", err)
+	}
+
+	ctx := context.Background()
+
+	nodeID := cfg.Cluster.NodeID
+	if nodeID == "" {
+		nodeID = broker.DefaultNodeID()
+	}
+
+	var registry broker.Registry
+	var msgBroker broker.Broker
+	switch cfg.Cluster.Broker {
+	case "postgres":
+		pgRegistry, err := broker.NewPostgresRegistry(ctx, db, nodeID, cfg.Cluster.NodeTTL)
+		if err != nil {
+			log.Fatalf("Error creating registry: %v", err)
+		}
+		go pgRegistry.Run(ctx)
+
+		pgBroker, err := broker.NewPostgres(db, cfg.DbConnStr, pgRegistry)
+		if err != nil {
+			log.Fatalf("Error creating broker: %v", err)
+		}
+		go pgBroker.Run(ctx)
+
+		registry, msgBroker = pgRegistry, pgBroker
+	case "memory":
+		registry, msgBroker = broker.NewMemoryRegistry(nodeID), broker.NewMemory()
+	default:
+		log.Fatalf("Unknown broker: %s", cfg.Cluster.Broker)
+	}
+	defer msgBroker.Close()
+
+	recommender := recommender.New(recommender.Weights{
+		Games:        cfg.Recommendations.GamesWeight,
+		Age:          cfg.Recommendations.AgeWeight,
+		Languages:    cfg.Recommendations.LanguagesWeight,
+		Availability: cfg.Recommendations.AvailabilityWeight,
+		Activity:     cfg.Recommendations.ActivityWeight,
+	}, cfg.Recommendations.AgeSpan, cfg.Recommendations.ActivityHalfLife)
+
+	service := service.New(db, cfg.JwtSecret, repository, connectionManager, sealer, datakeys.New(repository, keyProvider, sealer, cfg.Messages.RequireAD), blindindex.New(searchKey, searchKeyID), emails, recommender, cfg.Recommendations.CandidatePool, service.WebSocketConfig{
+		PongWait:    cfg.WebSocket.PongWait,
+		IdleTimeout: cfg.WebSocket.IdleTimeout,
+		ReadLimit:   cfg.WebSocket.ReadLimit,
+
+		TypingTimeout: cfg.WebSocket.TypingTimeout,
+		TypingLimit:   cfg.WebSocket.TypingLimit,
+		TypingWindow:  cfg.WebSocket.TypingWindow,
+	}, service.MessagesConfig{
+		EditWindow: cfg.Messages.EditWindow,
+		Reactions:  cfg.Messages.Reactions,
+		MaxPins:    cfg.Messages.MaxPins,
+	}, blobs, service.AttachmentsConfig{
+		MaxSize:       cfg.Attachments.MaxSize,
+		MaxPerMessage: cfg.Attachments.MaxPerMessage,
+		AllowedTypes:  cfg.Attachments.AllowedTypes,
+		ThumbnailSize: cfg.Attachments.ThumbnailSize,
+		PendingTTL:    cfg.Attachments.PendingTTL,
+	}, msgBroker, registry)
+
+	go service.RunSavedSearchDigests(ctx, cfg.SavedSearches.Interval)
+	go service.RunPresenceSweeper(ctx, cfg.Presence.SweepInterval, cfg.Presence.IdleAfter)
+	go service.RunAttachmentSweeper(ctx, cfg.Attachments.SweepInterval)
 
 	handler := handler.New(cfg, db, service)
 
-	server := entrypoint.New(handler)
+	// Запас сверх размера вложения на заголовки multipart
+	server := entrypoint.New(handler, int(cfg.Attachments.MaxSize)+1<<20)
+
+	if cfg.Admin.Addr != "" {
+		admin := entrypoint.NewAdmin()
+		go func() {
+			if err := admin.Listen(cfg.Admin.Addr); err != nil {
+				log.Printf("Error starting admin server: %v", err)
+			}
+		}()
+	}
 
 	if err := server.Listen(":8080"); err != nil {
 		log.Fatal(err)
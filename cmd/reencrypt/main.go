@@ -0,0 +1,222 @@
+// reencrypt перешифровывает данные активным ключом из sealer.keys, а ключи данных бесед -
+// текущей версией мастер-ключа. Уже перешифрованные строки пропускаются, поэтому прерванный
+// проход можно просто запустить заново или продолжить с -after. Работает при запущенном
+// сервере: строку, изменённую за время прохода, не перезаписывает. Старый ключ можно
+// убирать из конфига, когда проход по всем колонкам закончился без ошибок расшифровки.
+//
+// Тексты сообщений и их прошлых версий, зашифрованные ключом сервера, переводятся на
+// ключи бесед с привязкой к полям сообщения (associated data). Зашифрованные поля профиля
+// перешифровываются с привязкой к пользователю.
+package main
+
+import (
+	"flag"
+	"log"
+	"playmates/components/datakeys"
+	"playmates/components/db"
+	"playmates/components/keyprovider"
+	"playmates/components/playmates/config"
+	"playmates/components/playmates/models"
+	"playmates/components/repository"
+	"playmates/components/sealer"
+)
+
+func main() {
+	configPath := flag.String("config", "config/config.yaml", "path to config")
+	batchSize := flag.Int("batch", 500, "rows per batch")
+	only := flag.String("column", "", "process only this column, e.g. messages.message")
+	afterID := flag.Int("after", 0, "start after this row ID (only with -column)")
+	dryRun := flag.Bool("dry-run", false, "count rows to re-encrypt without writing")
+	flag.Parse()
+
+	if *afterID > 0 && *only == "" {
+		log.Fatal("-after requires -column")
+	}
+
+	cfg, err := config.New(*configPath)
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
+	db, err := db.ConnectPostgres(cfg.DbConnStr)
+	if err != nil {
+		log.Fatalf("Error connecting to database: %v", err)
+	}
+	defer db.Close()
+
+	repo := repository.New(db)
+
+	s, err := sealer.NewKeyring(cfg.SealerKeyring())
+	if err != nil {
+		log.Fatalf("Error creating sealer: %v", err)
+	}
+
+	keyProvider, err := keyprovider.New(cfg.KeyProvider, s)
+	if err != nil {
+		log.Fatalf("Error creating key provider: %v", err)
+	}
+	// Строки без associated data reencrypt и переводит, поэтому require_ad здесь не действует
+	dataKeys := datakeys.New(repo, keyProvider, s, false)
+
+	failedTotal := 0
+	if *only == "" || repository.DataKeyColumn.String() == *only {
+		failedTotal += reencryptColumn(repo, withoutID(keyProvider.Rewrap), repository.DataKeyColumn, *afterID, *batchSize, *dryRun)
+	}
+
+	for _, edits := range []bool{false, true} {
+		if *only != "" && messagesColumn(edits) != *only {
+			continue
+		}
+
+		failedTotal += reencryptMessages(repo, dataKeys, edits, *afterID, *batchSize, *dryRun)
+	}
+
+	for _, col := range repository.SealedColumns {
+		if *only != "" && col.String() != *only {
+			continue
+		}
+
+		failedTotal += reencryptColumn(repo, withoutID(s.Reseal), col, *afterID, *batchSize, *dryRun)
+	}
+
+	for _, f := range repository.UserSealedFields {
+		if *only != "" && f.String() != *only {
+			continue
+		}
+
+		field := f.Field
+		reseal := func(id int, data []byte) ([]byte, bool, error) {
+			ad := models.UserFieldAD(id, field)
+			return s.ResealWithAD(data, ad, ad)
+		}
+		failedTotal += reencryptColumn(repo, reseal, f.SealedColumn, *afterID, *batchSize, *dryRun)
+	}
+
+	if failedTotal > 0 {
+		log.Fatalf("%d values could not be decrypted, keep the old keys until they are fixed", failedTotal)
+	}
+}
+
+// withoutID - reseal для колонок без associated data, которым ID строки не нужен.
+func withoutID(reseal func([]byte) ([]byte, bool, error)) func(int, []byte) ([]byte, bool, error) {
+	return func(_ int, data []byte) ([]byte, bool, error) {
+		return reseal(data)
+	}
+}
+
+// reencryptColumn проходит колонку батчами, перешифровывая значения reseal, и возвращает
+// число значений, которые не расшифровались.
+func reencryptColumn(repo *repository.Repository, reseal func(int, []byte) ([]byte, bool, error), col repository.SealedColumn, afterID, batchSize int, dryRun bool) int {
+	resealed, raced, failed := 0, 0, 0
+	lastID := afterID
+
+	for {
+		values, err := repo.GetSealedBatch(col, lastID, batchSize)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", col, err)
+		}
+		if len(values) == 0 {
+			break
+		}
+
+		for _, v := range values {
+			lastID = v.ID
+
+			sealed, changed, err := reseal(v.ID, v.Data)
+			if err != nil {
+				log.Printf("Error decrypting %s id %d: %v", col, v.ID, err)
+				failed++
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if dryRun {
+				resealed++
+				continue
+			}
+
+			ok, err := repo.ReplaceSealed(col, v.ID, v.Data, sealed)
+			if err != nil {
+				log.Fatalf("Error writing %s id %d: %v (resume with -column %s -after %d)", col, v.ID, err, col, v.ID-1)
+			}
+			if ok {
+				resealed++
+			} else {
+				// Строку изменили после чтения - новое значение уже зашифровано активным ключом
+				raced++
+			}
+		}
+
+		log.Printf("%s: up to id %d, %d re-encrypted", col, lastID, resealed)
+	}
+
+	log.Printf("%s done: %d re-encrypted, %d changed concurrently, %d failed", col, resealed, raced, failed)
+
+	return failed
+}
+
+func messagesColumn(edits bool) string {
+	if edits {
+		return "message_edits.message"
+	}
+	return "messages.message"
+}
+
+// reencryptMessages - то же для текстов сообщений: всё, что зашифровано не текущей версией
+// seal_version, перешифровывается ключом беседы.
+func reencryptMessages(repo *repository.Repository, dataKeys *datakeys.Keys, edits bool, afterID, batchSize int, dryRun bool) int {
+	col := messagesColumn(edits)
+	resealed, raced, failed := 0, 0, 0
+	lastID := afterID
+
+	for {
+		messages, err := repo.GetSealedMessages(edits, lastID, batchSize)
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", col, err)
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		for _, sm := range messages {
+			lastID = sm.ID
+			msg := sm.Message
+			if msg.SealVersion == models.MessageSealVersion {
+				continue
+			}
+
+			text, err := dataKeys.OpenMessage(msg)
+			if err != nil {
+				log.Printf("Error decrypting %s id %d: %v", col, sm.ID, err)
+				failed++
+				continue
+			}
+			if dryRun {
+				resealed++
+				continue
+			}
+
+			sealed, err := dataKeys.SealMessage(msg, text)
+			if err != nil {
+				log.Fatalf("Error encrypting %s id %d: %v", col, sm.ID, err)
+			}
+
+			ok, err := repo.ReplaceSealedMessage(edits, sm.ID, msg.Msg, sealed)
+			if err != nil {
+				log.Fatalf("Error writing %s id %d: %v (resume with -column %s -after %d)", col, sm.ID, err, col, sm.ID-1)
+			}
+			if ok {
+				resealed++
+			} else {
+				raced++
+			}
+		}
+
+		log.Printf("%s: up to id %d, %d re-encrypted", col, lastID, resealed)
+	}
+
+	log.Printf("%s done: %d re-encrypted, %d changed concurrently, %d failed", col, resealed, raced, failed)
+
+	return failed
+}
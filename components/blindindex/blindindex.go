@@ -0,0 +1,120 @@
+package blindindex
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"unicode"
+)
+
+const (
+	// Version меняется вместе с нормализацией или форматом токенов; сообщения со старой
+	// версией переиндексирует команда backfill-search
+	Version = 1
+
+	tokenSize = 16
+	// Больше токенов с одного сообщения не пишем
+	maxTokens = 256
+	// Токены профилей солятся нулём: ID переписок начинаются с 1, а искать профили
+	// нужно по всем пользователям сразу
+	profileScope = 0
+)
+
+// Index превращает слова в HMAC-токены. По токенам можно искать точное совпадение слова,
+// но нельзя восстановить слово без ключа. Токены солятся ID переписки, чтобы одно и то же
+// слово в разных переписках нельзя было сопоставить.
+type Index struct {
+	key   []byte
+	keyID uint16
+}
+
+// New создаёт индекс с ключом key. keyID записывается рядом с токенами: по нему
+// backfill-search находит сообщения, проиндексированные другим ключом.
+func New(key []byte, keyID uint16) *Index {
+	return &Index{key: key, keyID: keyID}
+}
+
+// KeyID возвращает ID ключа индекса.
+func (ix *Index) KeyID() uint16 {
+	return ix.keyID
+}
+
+// DeriveKey выводит ключ индекса из ключа шифрования. Выведенный ключ не совпадает
+// с ключом шифрования.
+func DeriveKey(sealerKey []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, sealerKey, nil, "playmates blind index", 32)
+}
+
+// Tokens - токены текста и ID ключа индекса, которым они посчитаны.
+type Tokens struct {
+	KeyID  uint16
+	Values [][]byte
+}
+
+// Tokens возвращает токены уникальных слов текста.
+func (ix *Index) Tokens(conversationID int, text string) Tokens {
+	words := Words(text)
+	if len(words) > maxTokens {
+		words = words[:maxTokens]
+	}
+
+	tokens := Tokens{KeyID: ix.keyID, Values: make([][]byte, len(words))}
+	for i, word := range words {
+		tokens.Values[i] = ix.token(conversationID, word)
+	}
+
+	return tokens
+}
+
+// ProfileTokens возвращает токены слов текста профиля (about_me).
+func (ix *Index) ProfileTokens(text string) Tokens {
+	return ix.Tokens(profileScope, text)
+}
+
+func (ix *Index) token(conversationID int, word string) []byte {
+	mac := hmac.New(sha256.New, ix.key)
+	var prefix [8]byte
+	binary.BigEndian.PutUint64(prefix[:], uint64(conversationID))
+	mac.Write(prefix[:])
+	mac.Write([]byte(word))
+	return mac.Sum(nil)[:tokenSize]
+}
+
+// Words разбивает нормализованный текст на уникальные слова. Однобуквенные слова
+// отбрасываются, числа остаются любой длины, чтобы находились части адресов и портов.
+func Words(text string) []string {
+	fields := strings.FieldsFunc(Normalize(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]struct{}, len(fields))
+	words := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if len([]rune(field)) < 2 && !isNumber(field) {
+			continue
+		}
+		if _, ok := seen[field]; ok {
+			continue
+		}
+		seen[field] = struct{}{}
+		words = append(words, field)
+	}
+
+	return words
+}
+
+// Normalize приводит текст к виду, в котором сравниваются запрос и сообщение.
+func Normalize(text string) string {
+	return strings.NewReplacer("ё", "е").Replace(strings.ToLower(text))
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,85 @@
+package blindindex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EmailHasher считает детерминированный HMAC email для поиска при логине и проверки
+// уникальности. Ключ свой, а не ключ индекса: ключ индекса выводится из ключей шифрования,
+// и их перенос или ротация не должны менять хэши, по которым пользователи входят.
+type EmailHasher struct {
+	active uint16
+	keys   map[uint16][]byte
+	// ids - active первым, затем прежние ключи по возрастанию
+	ids []uint16
+}
+
+// EmailHashes - HMAC email активным ключом и всеми ключами, которыми могут быть посчитаны
+// хэши ещё не перехэшированных пользователей.
+type EmailHashes struct {
+	KeyID  uint16
+	Active []byte
+	All    [][]byte
+}
+
+// NewEmailHasher создаёт EmailHasher. Хэши новых пользователей считает active, остальные
+// ключи нужны для входа, пока backfill-users не перехэширует email.
+func NewEmailHasher(keys map[uint16][]byte, active uint16) (*EmailHasher, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active email hmac key %d is not set", active)
+	}
+
+	h := &EmailHasher{active: active, keys: make(map[uint16][]byte, len(keys)), ids: []uint16{active}}
+	for id, key := range keys {
+		if id == 0 {
+			return nil, fmt.Errorf("email hmac key id 0 is reserved")
+		}
+		if len(key) < 32 {
+			return nil, fmt.Errorf("email hmac key %d must be at least 32 bytes", id)
+		}
+		h.keys[id] = key
+		if id != active {
+			h.ids = append(h.ids, id)
+		}
+	}
+	sort.Slice(h.ids[1:], func(i, j int) bool { return h.ids[1+i] < h.ids[1+j] })
+
+	return h, nil
+}
+
+// KeyID возвращает ID активного ключа.
+func (h *EmailHasher) KeyID() uint16 {
+	return h.active
+}
+
+// Hash - HMAC email активным ключом.
+func (h *EmailHasher) Hash(email string) []byte {
+	return emailHMAC(h.keys[h.active], email)
+}
+
+// Hashes - HMAC email всеми ключами, активным первым.
+func (h *EmailHasher) Hashes(email string) EmailHashes {
+	hashes := EmailHashes{KeyID: h.active, All: make([][]byte, len(h.ids))}
+	for i, id := range h.ids {
+		hashes.All[i] = emailHMAC(h.keys[id], email)
+	}
+	hashes.Active = hashes.All[0]
+
+	return hashes
+}
+
+// NormalizeEmail приводит email к виду, в котором он хэшируется и сравнивается.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func emailHMAC(key []byte, email string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("email:"))
+	mac.Write([]byte(NormalizeEmail(email)))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,73 @@
+package blindindex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var (
+	emailKey1 = []byte(strings.Repeat("1", 32))
+	emailKey2 = []byte(strings.Repeat("2", 32))
+)
+
+func TestEmailHasherNormalizes(t *testing.T) {
+	h, err := NewEmailHasher(map[uint16][]byte{1: emailKey1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(h.Hash(" User@Example.COM "), h.Hash("user@example.com")) {
+		t.Error("Hash() depends on case or surrounding spaces")
+	}
+	if bytes.Equal(h.Hash("a@example.com"), h.Hash("b@example.com")) {
+		t.Error("Hash() of different emails is equal")
+	}
+}
+
+// Пока идёт перехэширование, Hashes находит и строки со старым ключом.
+func TestEmailHasherRotation(t *testing.T) {
+	old, err := NewEmailHasher(map[uint16][]byte{1: emailKey1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewEmailHasher(map[uint16][]byte{1: emailKey1, 2: emailKey2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := rotated.Hashes("user@example.com")
+	if hashes.KeyID != 2 || rotated.KeyID() != 2 {
+		t.Errorf("KeyID = %d, want 2", hashes.KeyID)
+	}
+	if !bytes.Equal(hashes.Active, rotated.Hash("user@example.com")) || !bytes.Equal(hashes.All[0], hashes.Active) {
+		t.Error("Hashes() does not start with the active key hash")
+	}
+	if bytes.Equal(hashes.Active, old.Hash("user@example.com")) {
+		t.Error("new key produces the old hash")
+	}
+	if len(hashes.All) != 2 || !bytes.Equal(hashes.All[1], old.Hash("user@example.com")) {
+		t.Error("Hashes() does not include the old key hash")
+	}
+}
+
+func TestNewEmailHasherErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    map[uint16][]byte
+		active  uint16
+		wantErr string
+	}{
+		{"active missing", map[uint16][]byte{1: emailKey1}, 2, "active email hmac key 2"},
+		{"reserved id", map[uint16][]byte{0: emailKey1, 1: emailKey2}, 1, "reserved"},
+		{"short key", map[uint16][]byte{1: emailKey1, 2: []byte("short")}, 1, "key 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewEmailHasher(tt.keys, tt.active); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewEmailHasher() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package blobstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorePutGetDelete(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Put(key, []byte("sealed")); err != nil {
+		t.Fatal(err)
+	}
+	// Блобы раскладываются по подкаталогам, временных файлов не остаётся
+	entries, err := os.ReadDir(filepath.Join(dir, key[:2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != key {
+		t.Fatalf("blob dir = %v, want only %s", entries, key)
+	}
+
+	data, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("sealed")) {
+		t.Fatalf("Get() = %q", data)
+	}
+
+	if err = store.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get(key); err == nil {
+		t.Fatal("deleted blob is still readable")
+	}
+	// Повторное удаление не ошибка
+	if err = store.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreRejectsInvalidKeys(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "ab", "../../etc/passwd", "zz1234", "ab/cd"} {
+		if err := store.Put(key, []byte("x")); err == nil {
+			t.Errorf("Put(%q) accepted an invalid key", key)
+		}
+		if _, err := store.Get(key); err == nil {
+			t.Errorf("Get(%q) accepted an invalid key", key)
+		}
+	}
+}
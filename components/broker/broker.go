@@ -0,0 +1,71 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Delivery - кадр, который нужно доставить на все соединения пользователя, кроме ExceptConn.
+type Delivery struct {
+	UserID     int             `json:"u"`
+	ExceptConn string          `json:"x,omitempty"`
+	Frame      json.RawMessage `json:"f"`
+}
+
+// Broker доставляет кадры до узла, на котором подключён пользователь.
+type Broker interface {
+	Publish(ctx context.Context, d Delivery) error
+	// Subscribe задаёт обработчик доставок, адресованных этому узлу.
+	Subscribe(handler func(Delivery))
+	Close() error
+}
+
+// Registry знает, какие узлы держат соединения пользователя.
+type Registry interface {
+	Register(ctx context.Context, userID int) error
+	Unregister(ctx context.Context, userID int) error
+	Nodes(ctx context.Context, userID int) ([]string, error)
+	// Online возвращает тех из userIDs, кто подключён хотя бы к одному узлу.
+	Online(ctx context.Context, userIDs []int) (map[int]bool, error)
+	OnlineUsers(ctx context.Context) ([]int, error)
+	NodeID() string
+}
+
+var nodeIDPattern = regexp.MustCompile(`^[a-z0-9_]{1,40}$`)
+
+// ValidNodeID проверяет, что ID узла можно использовать в имени канала LISTEN/NOTIFY.
+func ValidNodeID(nodeID string) bool {
+	return nodeIDPattern.MatchString(nodeID)
+}
+
+// DefaultNodeID строит ID узла из имени хоста и случайного суффикса.
+func DefaultNodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "node"
+	}
+
+	host = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + ('a' - 'A')
+		default:
+			return '_'
+		}
+	}, host)
+	if len(host) > 30 {
+		host = host[:30]
+	}
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return host + "_" + hex.EncodeToString(suffix)
+}
@@ -0,0 +1,119 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+func TestValidNodeID(t *testing.T) {
+	cases := map[string]bool{
+		"node_1":    true,
+		"a":         true,
+		"":          false,
+		"Node":      false,
+		"node-1":    false,
+		"node;drop": false,
+		"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa":  true,
+		"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa": false,
+	}
+
+	for nodeID, want := range cases {
+		if got := ValidNodeID(nodeID); got != want {
+			t.Errorf("ValidNodeID(%q) = %v, want %v", nodeID, got, want)
+		}
+	}
+}
+
+func TestDefaultNodeID(t *testing.T) {
+	first, second := DefaultNodeID(), DefaultNodeID()
+
+	if !ValidNodeID(first) {
+		t.Fatalf("default node id %q is not valid", first)
+	}
+	if first == second {
+		t.Fatalf("default node ids must differ between nodes on one host, got %q twice", first)
+	}
+}
+
+func TestMemoryBrokerPublish(t *testing.T) {
+	b := NewMemory()
+
+	// Без подписчика кадр просто теряется
+	if err := b.Publish(context.Background(), Delivery{UserID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []Delivery
+	b.Subscribe(func(d Delivery) { got = append(got, d) })
+
+	want := Delivery{UserID: 7, ExceptConn: "c1", Frame: json.RawMessage(`{"type":"ping"}`)}
+	if err := b.Publish(context.Background(), want); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].UserID != want.UserID || got[0].ExceptConn != want.ExceptConn || string(got[0].Frame) != string(want.Frame) {
+		t.Fatalf("delivered %+v, want %+v", got, want)
+	}
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemoryRegistry("node_a")
+
+	for _, userID := range []int{1, 2} {
+		if err := r.Register(ctx, userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Unregister(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, _ := r.Nodes(ctx, 1)
+	if len(nodes) != 1 || nodes[0] != "node_a" {
+		t.Fatalf("nodes of online user = %v", nodes)
+	}
+	if nodes, _ := r.Nodes(ctx, 2); len(nodes) != 0 {
+		t.Fatalf("nodes of offline user = %v", nodes)
+	}
+
+	online, _ := r.Online(ctx, []int{1, 2, 3})
+	if !online[1] || online[2] || online[3] {
+		t.Fatalf("online = %v", online)
+	}
+
+	users, _ := r.OnlineUsers(ctx)
+	sort.Ints(users)
+	if len(users) != 1 || users[0] != 1 {
+		t.Fatalf("online users = %v", users)
+	}
+}
+
+// Кадр для пользователя на своём узле доставляется напрямую, без похода в базу.
+func TestPostgresBrokerPublishLocal(t *testing.T) {
+	ctx := context.Background()
+	registry := NewMemoryRegistry("node_a")
+	b := &PostgresBroker{registry: registry}
+
+	var got []Delivery
+	b.Subscribe(func(d Delivery) { got = append(got, d) })
+
+	if err := b.Publish(ctx, Delivery{UserID: 1, Frame: json.RawMessage(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("delivered to offline user: %+v", got)
+	}
+
+	if err := registry.Register(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(ctx, Delivery{UserID: 1, Frame: json.RawMessage(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].UserID != 1 {
+		t.Fatalf("delivered %+v, want one delivery to user 1", got)
+	}
+}
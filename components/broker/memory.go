@@ -0,0 +1,107 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBroker - брокер для одного инстанса, доставляет всё локально.
+type MemoryBroker struct {
+	handler func(Delivery)
+	mu      sync.RWMutex
+}
+
+func NewMemory() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, d Delivery) error {
+	b.mu.RLock()
+	handler := b.handler
+	b.mu.RUnlock()
+
+	if handler != nil {
+		handler(d)
+	}
+
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(handler func(Delivery)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handler = handler
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
+
+// MemoryRegistry - реестр для одного инстанса.
+type MemoryRegistry struct {
+	nodeID string
+	users  map[int]struct{}
+	mu     sync.RWMutex
+}
+
+func NewMemoryRegistry(nodeID string) *MemoryRegistry {
+	return &MemoryRegistry{
+		nodeID: nodeID,
+		users:  make(map[int]struct{}),
+	}
+}
+
+func (r *MemoryRegistry) Register(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[userID] = struct{}{}
+	return nil
+}
+
+func (r *MemoryRegistry) Unregister(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.users, userID)
+	return nil
+}
+
+func (r *MemoryRegistry) Nodes(ctx context.Context, userID int) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.users[userID]; !ok {
+		return nil, nil
+	}
+
+	return []string{r.nodeID}, nil
+}
+
+func (r *MemoryRegistry) Online(ctx context.Context, userIDs []int) (map[int]bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	online := make(map[int]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if _, ok := r.users[userID]; ok {
+			online[userID] = true
+		}
+	}
+
+	return online, nil
+}
+
+func (r *MemoryRegistry) OnlineUsers(ctx context.Context) ([]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]int, 0, len(r.users))
+	for userID := range r.users {
+		users = append(users, userID)
+	}
+
+	return users, nil
+}
+
+func (r *MemoryRegistry) NodeID() string {
+	return r.nodeID
+}
@@ -0,0 +1,195 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	channelPrefix = "playmates_node_"
+	// NOTIFY принимает до 8000 байт, крупные кадры передаются через broker_payloads
+	maxNotifyPayload = 7900
+	payloadRefPrefix = "ref:"
+	payloadTTL       = time.Hour
+)
+
+// PostgresBroker пересылает кадры между узлами через LISTEN/NOTIFY.
+// Каждый узел слушает свой канал, адресатов ищет через Registry.
+type PostgresBroker struct {
+	db       *sql.DB
+	registry Registry
+	listener *pq.Listener
+
+	handler func(Delivery)
+	mu      sync.RWMutex
+}
+
+func NewPostgres(db *sql.DB, connStr string, registry Registry) (*PostgresBroker, error) {
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("broker listener event %d: %v\n", event, err)
+		}
+	})
+
+	if err := listener.Listen(channelName(registry.NodeID())); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen broker channel: %w", err)
+	}
+
+	return &PostgresBroker{
+		db:       db,
+		registry: registry,
+		listener: listener,
+	}, nil
+}
+
+func channelName(nodeID string) string {
+	return channelPrefix + nodeID
+}
+
+func (b *PostgresBroker) Subscribe(handler func(Delivery)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handler = handler
+}
+
+func (b *PostgresBroker) deliver(d Delivery) {
+	b.mu.RLock()
+	handler := b.handler
+	b.mu.RUnlock()
+
+	if handler != nil {
+		handler(d)
+	}
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, d Delivery) error {
+	nodes, err := b.registry.Nodes(ctx, d.UserID)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery: %w", err)
+	}
+
+	for _, node := range nodes {
+		if node == b.registry.NodeID() {
+			b.deliver(d)
+			continue
+		}
+
+		if err := b.notify(ctx, node, data); err != nil {
+			log.Printf("err broker notify node %s: %v\n", node, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *PostgresBroker) notify(ctx context.Context, node string, data []byte) error {
+	payload, err := b.encodePayload(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channelName(node), payload)
+	if err != nil {
+		return fmt.Errorf("failed to notify: %w", err)
+	}
+
+	return nil
+}
+
+// encodePayload возвращает тело уведомления: сам кадр или ссылку на него в broker_payloads, если кадр не влезает в NOTIFY.
+func (b *PostgresBroker) encodePayload(ctx context.Context, data []byte) (string, error) {
+	if len(data) <= maxNotifyPayload {
+		return string(data), nil
+	}
+
+	var id int64
+	err := b.db.QueryRowContext(ctx,
+		"INSERT INTO broker_payloads (payload) VALUES ($1) RETURNING id", data,
+	).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to store payload: %w", err)
+	}
+
+	return payloadRefPrefix + strconv.FormatInt(id, 10), nil
+}
+
+// Run читает уведомления своего канала и периодически чистит забытые крупные кадры.
+func (b *PostgresBroker) Run(ctx context.Context) {
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+	cleanup := time.NewTicker(payloadTTL / 4)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-b.listener.Notify:
+			// nil приходит после переподключения, пропущенные кадры клиенты доберут через синхронизацию
+			if n == nil {
+				continue
+			}
+			b.handleNotification(ctx, n.Extra)
+		case <-ping.C:
+			if err := b.listener.Ping(); err != nil {
+				log.Printf("err broker listener ping: %v\n", err)
+			}
+		case <-cleanup.C:
+			_, err := b.db.ExecContext(ctx,
+				"DELETE FROM broker_payloads WHERE created_at < NOW() - make_interval(secs => $1)",
+				payloadTTL.Seconds(),
+			)
+			if err != nil {
+				log.Printf("err broker payloads cleanup: %v\n", err)
+			}
+		}
+	}
+}
+
+func (b *PostgresBroker) handleNotification(ctx context.Context, payload string) {
+	data := []byte(payload)
+
+	if strings.HasPrefix(payload, payloadRefPrefix) {
+		id, err := strconv.ParseInt(strings.TrimPrefix(payload, payloadRefPrefix), 10, 64)
+		if err != nil {
+			log.Printf("err broker payload ref: %v\n", err)
+			return
+		}
+
+		err = b.db.QueryRowContext(ctx, "DELETE FROM broker_payloads WHERE id = $1 RETURNING payload", id).Scan(&data)
+		if err != nil {
+			log.Printf("err broker load payload %d: %v\n", id, err)
+			return
+		}
+	}
+
+	var d Delivery
+	if err := json.Unmarshal(data, &d); err != nil {
+		log.Printf("err broker unmarshal delivery: %v\n", err)
+		return
+	}
+
+	b.deliver(d)
+}
+
+func (b *PostgresBroker) Close() error {
+	return b.listener.Close()
+}
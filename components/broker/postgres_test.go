@@ -0,0 +1,145 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"playmates/components/db"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDB подключается к базе с применёнными миграциями из PLAYMATES_TEST_DB.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	connStr := os.Getenv("PLAYMATES_TEST_DB")
+	if connStr == "" {
+		t.Skip("PLAYMATES_TEST_DB is not set")
+	}
+
+	conn, err := db.ConnectPostgres(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func insertTestUser(t *testing.T, conn *sql.DB, username string) int {
+	t.Helper()
+
+	var id int
+	err := conn.QueryRow(`
+        INSERT INTO users (username, password_hash, age, gender, games)
+        VALUES ($1, 'hash', 0, '', '{}')
+        RETURNING id
+    `, username).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Exec("DELETE FROM users WHERE id = $1", id) })
+
+	return id
+}
+
+// Кадр в пределах лимита NOTIFY идёт как есть, база не нужна.
+func TestPostgresBrokerSmallPayloadInline(t *testing.T) {
+	b := &PostgresBroker{registry: NewMemoryRegistry("node_a")}
+
+	data, _ := json.Marshal(Delivery{UserID: 1, Frame: json.RawMessage(`{"type":"ping"}`)})
+	payload, err := b.encodePayload(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if payload != string(data) {
+		t.Fatalf("small payload = %q, want the frame itself", payload)
+	}
+}
+
+// Кадр больше лимита NOTIFY уходит в broker_payloads, получатель забирает его по ссылке и удаляет.
+func TestPostgresBrokerSpillsLargePayload(t *testing.T) {
+	ctx := context.Background()
+	conn := testDB(t)
+	b := &PostgresBroker{db: conn, registry: NewMemoryRegistry("node_a")}
+
+	frame, _ := json.Marshal(map[string]string{"text": strings.Repeat("x", maxNotifyPayload)})
+	want := Delivery{UserID: 42, ExceptConn: "c1", Frame: frame}
+	data, _ := json.Marshal(want)
+
+	payload, err := b.encodePayload(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(payload, payloadRefPrefix) {
+		t.Fatalf("large payload was not spilled: %d bytes inline", len(payload))
+	}
+	if len(payload) > maxNotifyPayload {
+		t.Fatalf("reference payload is %d bytes", len(payload))
+	}
+
+	var got []Delivery
+	b.Subscribe(func(d Delivery) { got = append(got, d) })
+	b.handleNotification(ctx, payload)
+
+	if len(got) != 1 || got[0].UserID != want.UserID || got[0].ExceptConn != want.ExceptConn || string(got[0].Frame) != string(want.Frame) {
+		t.Fatalf("spilled delivery did not round-trip")
+	}
+
+	// Кадр читается один раз
+	b.handleNotification(ctx, payload)
+	if len(got) != 1 {
+		t.Fatalf("spilled payload delivered %d times", len(got))
+	}
+}
+
+func TestPostgresRegistryOnline(t *testing.T) {
+	ctx := context.Background()
+	conn := testDB(t)
+
+	nodeID := DefaultNodeID()
+	r, err := NewPostgresRegistry(ctx, conn, nodeID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Exec("DELETE FROM ws_nodes WHERE node_id = $1", nodeID) })
+
+	userA, userB := insertTestUser(t, conn, nodeID+"_a"), insertTestUser(t, conn, nodeID+"_b")
+	if err := r.Register(ctx, userA); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(ctx, userB); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Unregister(ctx, userB); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := r.Nodes(ctx, userA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0] != nodeID {
+		t.Fatalf("nodes = %v, want [%s]", nodes, nodeID)
+	}
+
+	online, err := r.Online(ctx, []int{userA, userB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !online[userA] || online[userB] {
+		t.Fatalf("online = %v", online)
+	}
+
+	// Узел без heartbeat дольше ttl считается мёртвым
+	if _, err := conn.Exec("UPDATE ws_nodes SET heartbeat_at = NOW() - INTERVAL '2 minutes' WHERE node_id = $1", nodeID); err != nil {
+		t.Fatal(err)
+	}
+	if online, _ := r.Online(ctx, []int{userA}); online[userA] {
+		t.Fatalf("user on a dead node is still online")
+	}
+}
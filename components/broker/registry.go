@@ -0,0 +1,230 @@
+package broker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresRegistry хранит, какой узел держит какого пользователя, в ws_sessions.
+// Узел считается живым, пока обновляет heartbeat_at в ws_nodes; сессии мёртвых узлов удаляются каскадно.
+type PostgresRegistry struct {
+	db     *sql.DB
+	nodeID string
+	ttl    time.Duration
+
+	local map[int]struct{}
+	mu    sync.Mutex
+}
+
+func NewPostgresRegistry(ctx context.Context, db *sql.DB, nodeID string, ttl time.Duration) (*PostgresRegistry, error) {
+	if !ValidNodeID(nodeID) {
+		return nil, fmt.Errorf("invalid node id: %q", nodeID)
+	}
+
+	r := &PostgresRegistry{
+		db:     db,
+		nodeID: nodeID,
+		ttl:    ttl,
+		local:  make(map[int]struct{}),
+	}
+
+	if err := r.registerNode(ctx); err != nil {
+		return nil, err
+	}
+
+	// После перезапуска узла его старые сессии недействительны
+	if _, err := db.ExecContext(ctx, "DELETE FROM ws_sessions WHERE node_id = $1", nodeID); err != nil {
+		return nil, fmt.Errorf("failed to clear node sessions: %w", err)
+	}
+
+	return r, nil
+}
+
+func (r *PostgresRegistry) registerNode(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO ws_nodes (node_id, heartbeat_at) VALUES ($1, NOW())
+        ON CONFLICT (node_id) DO UPDATE SET heartbeat_at = NOW()
+    `, r.nodeID)
+	if err != nil {
+		return fmt.Errorf("failed to register node: %w", err)
+	}
+
+	return nil
+}
+
+// Run обновляет heartbeat узла и удаляет узлы, которые перестали его обновлять.
+func (r *PostgresRegistry) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.heartbeat(ctx); err != nil {
+				log.Printf("err registry heartbeat: %v\n", err)
+			}
+		}
+	}
+}
+
+func (r *PostgresRegistry) heartbeat(ctx context.Context) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE ws_nodes SET heartbeat_at = NOW() WHERE node_id = $1", r.nodeID)
+	if err != nil {
+		return fmt.Errorf("failed to update heartbeat: %w", err)
+	}
+
+	// Нас успели посчитать мёртвыми и удалили вместе с сессиями - восстанавливаем
+	if rowsAffected, _ := res.RowsAffected(); rowsAffected == 0 {
+		if err := r.registerNode(ctx); err != nil {
+			return err
+		}
+
+		r.mu.Lock()
+		users := make([]int64, 0, len(r.local))
+		for userID := range r.local {
+			users = append(users, int64(userID))
+		}
+		r.mu.Unlock()
+
+		_, err = r.db.ExecContext(ctx, `
+            INSERT INTO ws_sessions (node_id, user_id)
+            SELECT $1, unnest($2::int[])
+            ON CONFLICT DO NOTHING
+        `, r.nodeID, pq.Array(users))
+		if err != nil {
+			return fmt.Errorf("failed to restore sessions: %w", err)
+		}
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		"DELETE FROM ws_nodes WHERE heartbeat_at < NOW() - make_interval(secs => $1)",
+		r.ttl.Seconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete dead nodes: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresRegistry) Register(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	r.local[userID] = struct{}{}
+	r.mu.Unlock()
+
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO ws_sessions (node_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		r.nodeID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresRegistry) Unregister(ctx context.Context, userID int) error {
+	r.mu.Lock()
+	delete(r.local, userID)
+	r.mu.Unlock()
+
+	_, err := r.db.ExecContext(ctx, "DELETE FROM ws_sessions WHERE node_id = $1 AND user_id = $2", r.nodeID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to unregister session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *PostgresRegistry) Nodes(ctx context.Context, userID int) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT s.node_id
+        FROM ws_sessions s
+        JOIN ws_nodes n ON n.node_id = s.node_id
+        WHERE s.user_id = $1 AND n.heartbeat_at > NOW() - make_interval(secs => $2)
+    `, userID, r.ttl.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user nodes: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []string
+	for rows.Next() {
+		var node string
+		if err := rows.Scan(&node); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+func (r *PostgresRegistry) Online(ctx context.Context, userIDs []int) (map[int]bool, error) {
+	online := make(map[int]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return online, nil
+	}
+
+	ids := make([]int64, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = int64(id)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT DISTINCT s.user_id
+        FROM ws_sessions s
+        JOIN ws_nodes n ON n.node_id = s.node_id
+        WHERE s.user_id = ANY($1) AND n.heartbeat_at > NOW() - make_interval(secs => $2)
+    `, pq.Array(ids), r.ttl.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get online users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		online[userID] = true
+	}
+
+	return online, nil
+}
+
+func (r *PostgresRegistry) OnlineUsers(ctx context.Context) ([]int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT DISTINCT s.user_id
+        FROM ws_sessions s
+        JOIN ws_nodes n ON n.node_id = s.node_id
+        WHERE n.heartbeat_at > NOW() - make_interval(secs => $1)
+    `, r.ttl.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get online users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		users = append(users, userID)
+	}
+
+	return users, nil
+}
+
+func (r *PostgresRegistry) NodeID() string {
+	return r.nodeID
+}
@@ -0,0 +1,161 @@
+package connection_manager
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// Причины отключения, попадают в метрики.
+const (
+	ReasonClientClosed = "client_closed"
+	ReasonPongTimeout  = "pong_timeout"
+	ReasonIdleTimeout  = "idle_timeout"
+	ReasonReadError    = "read_error"
+	ReasonWriteError   = "write_error"
+	ReasonSlowConsumer = "slow_consumer"
+	ReasonPolicy       = "policy_violation"
+	ReasonServerError  = "server_error"
+)
+
+// Conn - то, что писатель использует от WebSocket-соединения.
+type Conn interface {
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// Client - одно соединение пользователя. У пользователя их может быть несколько (телефон, ПК и т.д.).
+// Писать в соединение может только writePump, остальные ставят кадры в очередь через Send.
+type Client struct {
+	ID     string
+	UserID int
+
+	conn         Conn
+	send         chan []byte
+	writeWait    time.Duration
+	pingInterval time.Duration
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+	reason    string
+
+	lastActive time.Time
+	idle       bool
+}
+
+func newClient(id string, userID int, conn Conn, queueSize int, writeWait, pingInterval time.Duration) *Client {
+	return &Client{
+		ID:           id,
+		UserID:       userID,
+		conn:         conn,
+		send:         make(chan []byte, queueSize),
+		writeWait:    writeWait,
+		pingInterval: pingInterval,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		lastActive:   time.Now(),
+	}
+}
+
+// Send ставит кадр в очередь и никогда не блокируется. Если очередь переполнена,
+// клиент не успевает читать: соединение закрывается с кодом 1008, кадр отбрасывается.
+func (c *Client) Send(frame []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- frame:
+		return true
+	default:
+		log.Printf("send queue overflow, dropping connection: user %d, conn %s\n", c.UserID, c.ID)
+		c.Disconnect(websocket.ClosePolicyViolation, "too slow", ReasonSlowConsumer)
+		return false
+	}
+}
+
+// SendWait ждёт места в очереди не дольше writeWait. Нужен для больших пачек кадров (resume),
+// которые заведомо не помещаются в очередь целиком; вызывать только из читающей горутины клиента.
+func (c *Client) SendWait(frame []byte) bool {
+	timer := time.NewTimer(c.writeWait)
+	defer timer.Stop()
+
+	select {
+	case c.send <- frame:
+		return true
+	case <-c.done:
+		return false
+	case <-timer.C:
+		c.Disconnect(websocket.ClosePolicyViolation, "too slow", ReasonSlowConsumer)
+		return false
+	}
+}
+
+// Disconnect просит писателя отправить close-кадр с кодом code и закрыть соединение.
+// Срабатывает только первый вызов, его reason и попадает в метрики.
+func (c *Client) Disconnect(code int, text, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		c.reason = reason
+		close(c.done)
+	})
+}
+
+// Close закрывает соединение, если это ещё не сделано, и ждёт выхода писателя.
+// После Close соединение можно отдавать обратно.
+func (c *Client) Close() {
+	c.Disconnect(websocket.CloseNormalClosure, "", ReasonClientClosed)
+	<-c.stopped
+}
+
+// Reason возвращает причину отключения. Имеет смысл только после Disconnect.
+func (c *Client) Reason() string {
+	select {
+	case <-c.done:
+		return c.reason
+	default:
+		return ""
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		close(c.stopped)
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			if c.closeCode != 0 {
+				c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText), time.Now().Add(c.writeWait))
+			}
+			return
+		case frame := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				log.Printf("write error, dropping connection: user %d, conn %s, err: %v\n", c.UserID, c.ID, err)
+				c.Disconnect(0, "", ReasonWriteError)
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.writeWait)); err != nil {
+				log.Printf("ping error, dropping connection: user %d, conn %s, err: %v\n", c.UserID, c.ID, err)
+				c.Disconnect(0, "", ReasonWriteError)
+				return
+			}
+		}
+	}
+}
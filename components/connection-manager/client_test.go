@@ -0,0 +1,267 @@
+package connection_manager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// fakeConn запоминает кадры и ловит одновременную запись, которую настоящее
+// WebSocket-соединение не допускает.
+type fakeConn struct {
+	mu       sync.Mutex
+	frames   [][]byte
+	controls []int
+	closeMsg []byte
+	closed   int
+
+	writing    atomic.Bool
+	concurrent atomic.Bool
+	// block, если задан, держит WriteMessage, пока его не закроют
+	block    chan struct{}
+	writeErr error
+}
+
+func (f *fakeConn) enter() {
+	if !f.writing.CompareAndSwap(false, true) {
+		f.concurrent.Store(true)
+	}
+}
+
+func (f *fakeConn) leave() {
+	f.writing.Store(false)
+}
+
+func (f *fakeConn) WriteMessage(messageType int, data []byte) error {
+	f.enter()
+	defer f.leave()
+
+	if f.block != nil {
+		<-f.block
+	}
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.frames = append(f.frames, data)
+	return nil
+}
+
+func (f *fakeConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	f.enter()
+	defer f.leave()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.controls = append(f.controls, messageType)
+	if messageType == websocket.CloseMessage {
+		f.closeMsg = data
+	}
+	return nil
+}
+
+func (f *fakeConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (f *fakeConn) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed++
+	return nil
+}
+
+func (f *fakeConn) written() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.frames)
+}
+
+func startClient(conn Conn, queueSize int) *Client {
+	client := newClient("test", 1, conn, queueSize, time.Second, time.Hour)
+	go client.writePump()
+	return client
+}
+
+func TestClientConcurrentSendAndClose(t *testing.T) {
+	const senders, perSender = 32, 200
+
+	conn := &fakeConn{}
+	client := startClient(conn, senders*perSender)
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			for j := 0; j < perSender; j++ {
+				if client.Send([]byte(fmt.Sprintf("%d-%d", i, j))) {
+					accepted.Add(1)
+				}
+			}
+		}(i)
+	}
+
+	// Close вызывается из нескольких горутин одновременно с отправкой
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			time.Sleep(time.Millisecond)
+			client.Close()
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	if conn.concurrent.Load() {
+		t.Error("connection was written concurrently")
+	}
+	if conn.closed != 1 {
+		t.Errorf("conn closed %d times, want 1", conn.closed)
+	}
+	if written := conn.written(); int64(written) > accepted.Load() {
+		t.Errorf("written %d frames, but only %d were accepted", written, accepted.Load())
+	}
+	if client.Reason() != ReasonClientClosed {
+		t.Errorf("Reason() = %q, want %q", client.Reason(), ReasonClientClosed)
+	}
+	if client.Send([]byte("late")) {
+		t.Error("Send after Close returned true")
+	}
+}
+
+func TestClientDeliversAllFramesInOrder(t *testing.T) {
+	const frames = 1000
+
+	conn := &fakeConn{}
+	client := startClient(conn, frames)
+
+	for i := 0; i < frames; i++ {
+		if !client.Send([]byte(fmt.Sprint(i))) {
+			t.Fatalf("Send(%d) returned false", i)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.written() < frames && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	client.Close()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if len(conn.frames) != frames {
+		t.Fatalf("written %d frames, want %d", len(conn.frames), frames)
+	}
+	for i, frame := range conn.frames {
+		if string(frame) != fmt.Sprint(i) {
+			t.Fatalf("frame %d = %q, want %q", i, frame, fmt.Sprint(i))
+		}
+	}
+}
+
+func TestClientOverflowDisconnectsSlowConsumer(t *testing.T) {
+	const queueSize = 4
+
+	conn := &fakeConn{block: make(chan struct{})}
+	client := startClient(conn, queueSize)
+
+	// Писатель застрял на первом кадре, остальные заполняют очередь
+	var wg sync.WaitGroup
+	var rejected atomic.Int64
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if !client.Send([]byte("x")) {
+					rejected.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rejected.Load() == 0 {
+		t.Fatal("no frame was rejected on a full queue")
+	}
+	if client.Reason() != ReasonSlowConsumer {
+		t.Errorf("Reason() = %q, want %q", client.Reason(), ReasonSlowConsumer)
+	}
+
+	close(conn.block)
+	client.Close()
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if len(conn.closeMsg) < 2 || int(conn.closeMsg[0])<<8|int(conn.closeMsg[1]) != websocket.ClosePolicyViolation {
+		t.Errorf("close frame = %v, want code %d", conn.closeMsg, websocket.ClosePolicyViolation)
+	}
+}
+
+func TestClientWriteErrorStopsWriter(t *testing.T) {
+	conn := &fakeConn{writeErr: errors.New("broken pipe")}
+	client := startClient(conn, 8)
+
+	client.Send([]byte("x"))
+	<-client.stopped
+
+	if client.Reason() != ReasonWriteError {
+		t.Errorf("Reason() = %q, want %q", client.Reason(), ReasonWriteError)
+	}
+	if client.Send([]byte("y")) {
+		t.Error("Send after write error returned true")
+	}
+	// Close после выхода писателя не блокируется
+	client.Close()
+	if conn.closed != 1 {
+		t.Errorf("conn closed %d times, want 1", conn.closed)
+	}
+}
+
+func TestManagerConcurrentClients(t *testing.T) {
+	cm := New(16, time.Second, time.Hour)
+
+	var wg sync.WaitGroup
+	for user := 1; user <= 8; user++ {
+		for conn := 0; conn < 4; conn++ {
+			wg.Add(1)
+			go func(user int) {
+				defer wg.Done()
+				client, _ := cm.Add(user, &fakeConn{})
+
+				// Рассылка всем соединениям пользователя, пока они подключаются и отключаются
+				for i := 0; i < 50; i++ {
+					clients, _ := cm.Get(user)
+					for _, c := range clients {
+						c.Send([]byte("hi"))
+					}
+					cm.Touch(client)
+				}
+
+				client.Close()
+				cm.Remove(client)
+			}(user)
+		}
+	}
+	wg.Wait()
+
+	for user := 1; user <= 8; user++ {
+		if _, connected := cm.Status(user); connected {
+			t.Errorf("user %d still has connections", user)
+		}
+	}
+}
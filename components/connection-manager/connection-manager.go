@@ -1,43 +1,182 @@
 package connection_manager
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"sync"
-
-	"github.com/gofiber/contrib/websocket"
+	"time"
 )
 
 type ConnectionManager struct {
-	connections map[int]*websocket.Conn
+	connections map[int]map[string]*Client
 	mu          sync.Mutex
+
+	sendQueueSize int
+	writeWait     time.Duration
+	pingInterval  time.Duration
 }
 
-func New() *ConnectionManager {
+func New(sendQueueSize int, writeWait, pingInterval time.Duration) *ConnectionManager {
+	if sendQueueSize <= 0 {
+		sendQueueSize = 64
+	}
+	if writeWait <= 0 {
+		writeWait = 10 * time.Second
+	}
+	if pingInterval <= 0 {
+		pingInterval = 30 * time.Second
+	}
+
 	return &ConnectionManager{
-		connections: make(map[int]*websocket.Conn),
+		connections:   make(map[int]map[string]*Client),
+		sendQueueSize: sendQueueSize,
+		writeWait:     writeWait,
+		pingInterval:  pingInterval,
+	}
+}
+
+// Add регистрирует новое соединение и запускает для него писателя.
+// first = true, если до этого у пользователя не было соединений.
+func (cm *ConnectionManager) Add(userID int, conn Conn) (client *Client, first bool) {
+	client = newClient(newConnectionID(), userID, conn, cm.sendQueueSize, cm.writeWait, cm.pingInterval)
+	go client.writePump()
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	clients, exists := cm.connections[userID]
+	if !exists {
+		clients = make(map[string]*Client)
+		cm.connections[userID] = clients
+	}
+	clients[client.ID] = client
+
+	metricConnections.Add(1)
+	metricConnectionsTotal.Add(1)
+	if !exists {
+		metricConnectedUsers.Add(1)
+	}
+
+	return client, !exists
+}
+
+// Remove удаляет только указанное соединение. last = true, если у пользователя не осталось соединений.
+// Причина отключения берётся из client.Reason(), поэтому Disconnect нужно вызвать до Remove.
+func (cm *ConnectionManager) Remove(client *Client) (last bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	clients, exists := cm.connections[client.UserID]
+	if !exists {
+		return false
+	}
+
+	if _, ok := clients[client.ID]; !ok {
+		return false
+	}
+
+	delete(clients, client.ID)
+	metricConnections.Add(-1)
+	if reason := client.Reason(); reason != "" {
+		metricDisconnects.Add(reason, 1)
+	}
+
+	if len(clients) == 0 {
+		delete(cm.connections, client.UserID)
+		metricConnectedUsers.Add(-1)
+		return true
 	}
+
+	return false
 }
 
-func (cm *ConnectionManager) Add(userID int, conn *websocket.Conn) {
+func (cm *ConnectionManager) Get(userID int) ([]*Client, bool) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	// Если соединение уже существует, закрываем его
-	if existingConn, exists := cm.connections[userID]; exists {
-		existingConn.Close()
+	clients, exists := cm.connections[userID]
+	if !exists {
+		return nil, false
+	}
+
+	result := make([]*Client, 0, len(clients))
+	for _, client := range clients {
+		result = append(result, client)
 	}
 
-	cm.connections[userID] = conn
+	return result, true
 }
 
-func (cm *ConnectionManager) Remove(userID int) {
+// Touch отмечает активность соединения. Возвращает true, если до этого пользователь
+// считался неактивным на всех устройствах.
+func (cm *ConnectionManager) Touch(client *Client) bool {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	delete(cm.connections, userID)
+
+	wasIdle := allIdle(cm.connections[client.UserID])
+	client.lastActive = time.Now()
+	client.idle = false
+
+	return wasIdle
 }
 
-func (cm *ConnectionManager) Get(userID int) (*websocket.Conn, bool) {
+// SweepIdle помечает неактивными соединения, от которых ничего не приходило дольше idleAfter,
+// и возвращает пользователей, у которых в этот раз стали неактивными все устройства.
+func (cm *ConnectionManager) SweepIdle(idleAfter time.Duration) []int {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	conn, exists := cm.connections[userID]
-	return conn, exists
+
+	var becameIdle []int
+	deadline := time.Now().Add(-idleAfter)
+	for userID, clients := range cm.connections {
+		if allIdle(clients) {
+			continue
+		}
+
+		for _, client := range clients {
+			if !client.idle && client.lastActive.Before(deadline) {
+				client.idle = true
+			}
+		}
+
+		if allIdle(clients) {
+			becameIdle = append(becameIdle, userID)
+		}
+	}
+
+	return becameIdle
+}
+
+// Status возвращает idle, если все устройства пользователя неактивны; connected = false, если соединений нет.
+func (cm *ConnectionManager) Status(userID int) (idle bool, connected bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	clients, exists := cm.connections[userID]
+	if !exists {
+		return false, false
+	}
+
+	return allIdle(clients), true
+}
+
+func allIdle(clients map[string]*Client) bool {
+	if len(clients) == 0 {
+		return false
+	}
+
+	for _, client := range clients {
+		if !client.idle {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newConnectionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,97 @@
+package connection_manager
+
+import (
+	"testing"
+	"time"
+)
+
+func clientIDs(cm *ConnectionManager, userID int) map[string]bool {
+	clients, _ := cm.Get(userID)
+	ids := make(map[string]bool, len(clients))
+	for _, client := range clients {
+		ids[client.ID] = true
+	}
+	return ids
+}
+
+// Новое устройство не выбивает старое, у каждого соединения свой ID.
+func TestManagerKeepsAllDevices(t *testing.T) {
+	cm := New(16, time.Second, time.Hour)
+
+	phone, first := cm.Add(1, &fakeConn{})
+	defer phone.Close()
+	if !first {
+		t.Error("first connection of the user is not reported as first")
+	}
+	desktop, first := cm.Add(1, &fakeConn{})
+	defer desktop.Close()
+	if first {
+		t.Error("second connection is reported as first")
+	}
+	if phone.ID == desktop.ID || phone.ID == "" {
+		t.Fatalf("connection IDs %q and %q are not unique", phone.ID, desktop.ID)
+	}
+
+	ids := clientIDs(cm, 1)
+	if len(ids) != 2 || !ids[phone.ID] || !ids[desktop.ID] {
+		t.Errorf("Get() = %v, want both devices", ids)
+	}
+
+	select {
+	case <-phone.done:
+		t.Error("old connection was closed when a new one arrived")
+	default:
+	}
+}
+
+// Remove убирает только своё соединение: отложенный Remove старого сокета не трогает новый.
+func TestManagerRemoveOnlyThatConnection(t *testing.T) {
+	cm := New(16, time.Second, time.Hour)
+
+	old, _ := cm.Add(1, &fakeConn{})
+	fresh, _ := cm.Add(1, &fakeConn{})
+	defer fresh.Close()
+
+	old.Close()
+	if last := cm.Remove(old); last {
+		t.Error("Remove() of one of two connections reported the last one")
+	}
+	if ids := clientIDs(cm, 1); len(ids) != 1 || !ids[fresh.ID] {
+		t.Errorf("Get() after Remove() = %v, want only %s", ids, fresh.ID)
+	}
+
+	// Повторный Remove уже удалённого соединения ничего не делает
+	if last := cm.Remove(old); last {
+		t.Error("second Remove() of the same connection reported the last one")
+	}
+	if _, connected := cm.Status(1); !connected {
+		t.Error("user went offline after a stale Remove()")
+	}
+
+	fresh.Close()
+	if last := cm.Remove(fresh); !last {
+		t.Error("Remove() of the only connection did not report the last one")
+	}
+	if _, exists := cm.Get(1); exists {
+		t.Error("Get() returns a user without connections")
+	}
+}
+
+func TestManagerSeparatesUsers(t *testing.T) {
+	cm := New(16, time.Second, time.Hour)
+
+	a, _ := cm.Add(1, &fakeConn{})
+	defer a.Close()
+	b, first := cm.Add(2, &fakeConn{})
+	defer b.Close()
+	if !first {
+		t.Error("first connection of another user is not reported as first")
+	}
+
+	if ids := clientIDs(cm, 1); len(ids) != 1 || !ids[a.ID] {
+		t.Errorf("Get(1) = %v", ids)
+	}
+	if _, exists := cm.Get(3); exists {
+		t.Error("Get() of a user without connections succeeded")
+	}
+}
@@ -0,0 +1,11 @@
+package connection_manager
+
+import "expvar"
+
+// Метрики отдаются через /debug/vars служебного сервера (admin.addr).
+var (
+	metricConnections      = expvar.NewInt("ws_connections")
+	metricConnectedUsers   = expvar.NewInt("ws_connected_users")
+	metricConnectionsTotal = expvar.NewInt("ws_connections_total")
+	metricDisconnects      = expvar.NewMap("ws_disconnects")
+)
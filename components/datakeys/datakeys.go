@@ -0,0 +1,150 @@
+package datakeys
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"playmates/components/keyprovider"
+	"playmates/components/playmates/models"
+	"playmates/components/sealer"
+	"sync"
+)
+
+const (
+	dataKeySize = 32
+	// Сколько расшифрованных ключей бесед держать в памяти; при переполнении кэш сбрасывается
+	maxCachedKeys = 10000
+)
+
+// ErrLegacySeal - текст сообщения зашифрован без привязки к полям, а такие строки запрещены.
+var ErrLegacySeal = errors.New("message is sealed without associated data")
+
+// Store хранит обёрнутые ключи бесед, в приложении это repository.Repository.
+type Store interface {
+	GetConversationKey(conversationID int) ([]byte, bool, error)
+	InitConversationKey(conversationID int, wrapped []byte) ([]byte, error)
+}
+
+// Keys шифрует тексты сообщений ключами данных бесед. Ключ беседы создаётся при первом
+// сообщении и хранится в conversations.data_key обёрнутым мастер-ключом KeyProvider:
+// без мастер-ключа дамп базы не раскрывает ни текстов, ни ключей.
+type Keys struct {
+	repo     Store
+	provider keyprovider.KeyProvider
+	// server расшифровывает сообщения, зашифрованные до появления ключей бесед
+	server *sealer.Sealer
+	// requireAD запрещает строки с seal_version = 0: seal_version лежит в той же строке,
+	// что и шифротекст, и иначе его подмена отключала бы проверку associated data
+	requireAD bool
+
+	mu    sync.Mutex
+	cache map[int]*sealer.Sealer
+}
+
+// New создаёт Keys. requireAD включается, когда reencrypt перевёл все сообщения
+// на шифрование с associated data.
+func New(repo Store, provider keyprovider.KeyProvider, server *sealer.Sealer, requireAD bool) *Keys {
+	return &Keys{
+		repo:      repo,
+		provider:  provider,
+		server:    server,
+		requireAD: requireAD,
+		cache:     make(map[int]*sealer.Sealer),
+	}
+}
+
+// SealMessage шифрует текст ключом беседы msg с привязкой к ID, беседе и собеседникам msg.
+// Результат нужно сохранять с seal_version = models.MessageSealVersion.
+func (k *Keys) SealMessage(msg models.MessageDB, text []byte) ([]byte, error) {
+	s, err := k.conversation(msg.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.SealVersion = models.MessageSealVersion
+	return s.EncryptWithAD(text, msg.SealedAD())
+}
+
+// OpenMessage расшифровывает msg.Msg тем ключом, которым его шифровали при seal_version строки.
+func (k *Keys) OpenMessage(msg models.MessageDB) ([]byte, error) {
+	if k.requireAD && msg.SealVersion == models.MessageSealLegacy {
+		return nil, ErrLegacySeal
+	}
+
+	s := k.server
+	if msg.SealVersion >= models.MessageSealConversationKey {
+		var err error
+		if s, err = k.conversation(msg.ConversationID); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.DecryptWithAD(msg.Msg, msg.SealedAD())
+}
+
+// conversation возвращает sealer с ключом беседы, создавая ключ при первом обращении.
+func (k *Keys) conversation(conversationID int) (*sealer.Sealer, error) {
+	if conversationID <= 0 {
+		return nil, fmt.Errorf("message has no conversation")
+	}
+
+	k.mu.Lock()
+	s, ok := k.cache[conversationID]
+	k.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	// Разворачиваем без блокировки: провайдер может ходить по сети
+	s, err := k.load(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	if len(k.cache) >= maxCachedKeys {
+		k.cache = make(map[int]*sealer.Sealer)
+	}
+	k.cache[conversationID] = s
+	k.mu.Unlock()
+
+	return s, nil
+}
+
+func (k *Keys) load(conversationID int) (*sealer.Sealer, error) {
+	wrapped, exists, err := k.repo.GetConversationKey(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("conversation %d not found", conversationID)
+	}
+
+	if wrapped == nil {
+		if wrapped, err = k.create(conversationID); err != nil {
+			return nil, err
+		}
+	}
+
+	dataKey, err := k.provider.Unwrap(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping key of conversation %d: %w", conversationID, err)
+	}
+
+	return sealer.NewKeyring(map[uint16][]byte{1: dataKey}, 1, 0)
+}
+
+func (k *Keys) create(conversationID int) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := k.provider.Wrap(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping key of conversation %d: %w", conversationID, err)
+	}
+
+	// Если ключ успел создать другой запрос, вернётся его ключ, а наш выбрасывается
+	return k.repo.InitConversationKey(conversationID, wrapped)
+}
@@ -0,0 +1,198 @@
+package datakeys
+
+import (
+	"errors"
+	"playmates/components/keyprovider"
+	"playmates/components/playmates/models"
+	"playmates/components/sealer"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStore - conversations.data_key в памяти, InitConversationKey ведёт себя как
+// UPDATE ... SET data_key = COALESCE(data_key, $2).
+type fakeStore struct {
+	mu    sync.Mutex
+	keys  map[int][]byte
+	inits int
+	// barrier, если задан, не отдаёт прочитанный ключ, пока его не прочитают все
+	barrier *sync.WaitGroup
+}
+
+func newFakeStore(conversationIDs ...int) *fakeStore {
+	store := &fakeStore{keys: make(map[int][]byte)}
+	for _, id := range conversationIDs {
+		store.keys[id] = nil
+	}
+	return store
+}
+
+func (f *fakeStore) GetConversationKey(conversationID int) ([]byte, bool, error) {
+	f.mu.Lock()
+	wrapped, ok := f.keys[conversationID]
+	f.mu.Unlock()
+
+	if f.barrier != nil {
+		f.barrier.Done()
+		f.barrier.Wait()
+	}
+
+	return wrapped, ok, nil
+}
+
+func (f *fakeStore) InitConversationKey(conversationID int, wrapped []byte) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inits++
+	if f.keys[conversationID] == nil {
+		f.keys[conversationID] = wrapped
+	}
+	return f.keys[conversationID], nil
+}
+
+func newTestKeys(t *testing.T, store Store, requireAD bool) (*Keys, *sealer.Sealer) {
+	t.Helper()
+
+	server, err := sealer.New([]byte(strings.Repeat("s", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(store, keyprovider.NewLocal(server), server, requireAD), server
+}
+
+// sealRow шифрует текст так же, как сервис при отправке: ключом беседы с AD строки.
+func sealRow(t *testing.T, k *Keys, msg models.MessageDB, text string) models.MessageDB {
+	t.Helper()
+
+	sealed, err := k.SealMessage(msg, []byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Msg, msg.SealVersion = sealed, models.MessageSealVersion
+	return msg
+}
+
+func TestOpenMessage(t *testing.T) {
+	k, _ := newTestKeys(t, newFakeStore(1), false)
+
+	msg := sealRow(t, k, models.MessageDB{ID: 10, ConversationID: 1, SenderID: 1, ReceiverID: 2}, "hello")
+	text, err := k.OpenMessage(msg)
+	if err != nil || string(text) != "hello" {
+		t.Errorf("OpenMessage() = %q, %v", text, err)
+	}
+}
+
+// Значение messages.message, скопированное в другую строку, не расшифровывается.
+func TestOpenMessageRejectsCopiedCiphertext(t *testing.T) {
+	k, _ := newTestKeys(t, newFakeStore(1, 2), false)
+
+	source := sealRow(t, k, models.MessageDB{ID: 10, ConversationID: 1, SenderID: 1, ReceiverID: 2}, "secret")
+
+	targets := []struct {
+		name string
+		row  models.MessageDB
+	}{
+		{"another message", models.MessageDB{ID: 11, ConversationID: 1, SenderID: 1, ReceiverID: 2}},
+		{"reply from peer", models.MessageDB{ID: 11, ConversationID: 1, SenderID: 2, ReceiverID: 1}},
+		{"same id, other sender", models.MessageDB{ID: 10, ConversationID: 1, SenderID: 3, ReceiverID: 2}},
+		{"another conversation", models.MessageDB{ID: 12, ConversationID: 2, SenderID: 1, ReceiverID: 2}},
+	}
+
+	for _, tt := range targets {
+		t.Run(tt.name, func(t *testing.T) {
+			row := tt.row
+			row.Msg, row.SealVersion = source.Msg, source.SealVersion
+			if _, err := k.OpenMessage(row); err == nil {
+				t.Error("OpenMessage() of copied ciphertext succeeded")
+			}
+		})
+	}
+}
+
+// seal_version лежит в той же строке: понизив его до 0, можно было бы открыть шифротекст
+// ключа сервера без AD. require_ad такие строки отклоняет.
+func TestOpenMessageRequireAD(t *testing.T) {
+	store := newFakeStore(1)
+	loose, server := newTestKeys(t, store, false)
+	strict := New(store, keyprovider.NewLocal(server), server, true)
+
+	// Строка до привязки к полям: ключ сервера, без AD
+	sealed, err := server.Encrypt([]byte("legacy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := models.MessageDB{ID: 11, ConversationID: 1, SenderID: 1, ReceiverID: 2, Msg: sealed, SealVersion: models.MessageSealLegacy}
+
+	if text, err := loose.OpenMessage(legacy); err != nil || string(text) != "legacy" {
+		t.Errorf("OpenMessage() of legacy row without require_ad = %q, %v", text, err)
+	}
+	if _, err := strict.OpenMessage(legacy); !errors.Is(err, ErrLegacySeal) {
+		t.Errorf("OpenMessage() of legacy row with require_ad = %v, want ErrLegacySeal", err)
+	}
+
+	// Привязанная строка открывается и в строгом режиме
+	bound := sealRow(t, strict, models.MessageDB{ID: 12, ConversationID: 1, SenderID: 1, ReceiverID: 2}, "bound")
+	if text, err := strict.OpenMessage(bound); err != nil || string(text) != "bound" {
+		t.Errorf("OpenMessage() of bound row with require_ad = %q, %v", text, err)
+	}
+}
+
+// Первые сообщения беседы, отправленные одновременно, создают ключ наперегонки. Все они
+// должны быть зашифрованы тем ключом, что остался в базе.
+func TestConcurrentFirstKey(t *testing.T) {
+	const senders = 32
+
+	store := newFakeStore(1)
+	store.barrier = &sync.WaitGroup{}
+	store.barrier.Add(senders)
+	k, server := newTestKeys(t, store, false)
+
+	msgs := make([]models.MessageDB, senders)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := range msgs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+
+			msg := models.MessageDB{ID: 100 + i, ConversationID: 1, SenderID: 1, ReceiverID: 2}
+			sealed, err := k.SealMessage(msg, []byte("hello"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			msg.Msg, msg.SealVersion = sealed, models.MessageSealVersion
+			msgs[i] = msg
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	// Все прочитали пустой ключ до того, как кто-то его записал
+	if store.inits != senders {
+		t.Fatalf("conversation key created %d times, want %d", store.inits, senders)
+	}
+
+	// Новый процесс с пустым кэшем знает только ключ из базы
+	store.barrier = nil
+	fresh := New(store, keyprovider.NewLocal(server), server, false)
+	for _, msg := range msgs {
+		if text, err := fresh.OpenMessage(msg); err != nil || string(text) != "hello" {
+			t.Errorf("OpenMessage(%d) with stored key = %q, %v", msg.ID, text, err)
+		}
+	}
+}
+
+func TestConversationMissing(t *testing.T) {
+	k, _ := newTestKeys(t, newFakeStore(1), false)
+
+	if _, err := k.SealMessage(models.MessageDB{ID: 1, ConversationID: 2}, []byte("hello")); err == nil {
+		t.Error("SealMessage() in a missing conversation succeeded")
+	}
+	if _, err := k.SealMessage(models.MessageDB{ID: 1}, []byte("hello")); err == nil {
+		t.Error("SealMessage() without conversation succeeded")
+	}
+}
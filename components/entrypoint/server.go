@@ -6,15 +6,16 @@ import (
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/expvar"
 )
 
-func New(handler *handler.Handler) *fiber.App {
-	app := fiber.New()
+func New(handler *handler.Handler, bodyLimit int) *fiber.App {
+	app := fiber.New(fiber.Config{BodyLimit: bodyLimit})
 
 	// Добавляем CORS middleware
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:3000",
-		AllowMethods:     "GET, POST, PUT, DELETE",
+		AllowMethods:     "GET, POST, PUT, PATCH, DELETE",
 		AllowHeaders:     "Content-Type, Authorization",
 		AllowCredentials: true,
 	}))
@@ -31,15 +32,70 @@ func New(handler *handler.Handler) *fiber.App {
 
 	app.Get("/search", handler.AuthMiddleware, handler.Search)
 
+	app.Post("/searches", handler.AuthMiddleware, handler.CreateSavedSearch)
+	app.Get("/searches", handler.AuthMiddleware, handler.GetSavedSearches)
+	app.Delete("/searches/:id", handler.AuthMiddleware, handler.DeleteSavedSearch)
+
+	app.Get("/notifications", handler.AuthMiddleware, handler.GetNotifications)
+	app.Post("/notifications/:id/read", handler.AuthMiddleware, handler.ReadNotification)
+
 	app.Get("/profile/:id", handler.AuthMiddleware, handler.GetProfileById)
 
+	app.Get("/recommendations", handler.AuthMiddleware, handler.GetRecommendations)
+
+	app.Post("/block/:id", handler.AuthMiddleware, handler.BlockUser)
+	app.Delete("/block/:id", handler.AuthMiddleware, handler.UnblockUser)
+
 	app.Get("/chat/:id", handler.AuthMiddleware, handler.GetChatMessages)
+	app.Post("/chat/:id/read", handler.AuthMiddleware, handler.ReadChat)
+	app.Post("/chat/:id/delivered", handler.AuthMiddleware, handler.DeliverChat)
+	app.Get("/chat/:id/pins", handler.AuthMiddleware, handler.GetChatPins)
+	app.Get("/chat/:id/e2ee", handler.AuthMiddleware, handler.GetChatE2EE)
+	app.Put("/chat/:id/e2ee", handler.AuthMiddleware, handler.SetChatE2EE)
+	app.Get("/chat/:id/search", handler.AuthMiddleware, handler.SearchChat)
+
+	app.Post("/conversations", handler.AuthMiddleware, handler.CreateConversation)
+	app.Get("/conversations/:id", handler.AuthMiddleware, handler.GetConversation)
+	app.Get("/conversations/:id/messages", handler.AuthMiddleware, handler.GetConversationMessages)
+	app.Get("/conversations/:id/pins", handler.AuthMiddleware, handler.GetConversationPins)
+	app.Get("/conversations/:id/search", handler.AuthMiddleware, handler.SearchConversation)
+	app.Post("/conversations/:id/read", handler.AuthMiddleware, handler.ReadConversation)
+	app.Post("/conversations/:id/leave", handler.AuthMiddleware, handler.LeaveConversation)
+	app.Post("/conversations/:id/members", handler.AuthMiddleware, handler.AddConversationMembers)
+	app.Patch("/conversations/:id/members/:userId", handler.AuthMiddleware, handler.SetConversationMemberRole)
+	app.Delete("/conversations/:id/members/:userId", handler.AuthMiddleware, handler.RemoveConversationMember)
 
 	app.Get("/ws/", websocket.New(handler.WebSocketConnect))
 
 	app.Get("/messages", handler.AuthMiddleware, handler.GetMessages)
+	app.Patch("/messages/:id", handler.AuthMiddleware, handler.EditMessage)
+	app.Delete("/messages/:id", handler.AuthMiddleware, handler.DeleteMessage)
+	app.Get("/messages/:id/history", handler.AuthMiddleware, handler.GetMessageHistory)
+	app.Post("/messages/:id/reactions", handler.AuthMiddleware, handler.AddReaction)
+	app.Delete("/messages/:id/reactions", handler.AuthMiddleware, handler.RemoveReaction)
+	app.Post("/messages/:id/pin", handler.AuthMiddleware, handler.PinMessage)
+	app.Delete("/messages/:id/pin", handler.AuthMiddleware, handler.UnpinMessage)
+
+	app.Post("/attachments", handler.AuthMiddleware, handler.UploadAttachment)
+	app.Get("/attachments/:id", handler.AuthMiddleware, handler.GetAttachment)
+	app.Get("/attachments/:id/thumbnail", handler.AuthMiddleware, handler.GetAttachmentThumbnail)
+
+	app.Get("/sync", handler.AuthMiddleware, handler.Sync)
+
+	app.Put("/keys/devices/:deviceId", handler.AuthMiddleware, handler.PublishDeviceKeys)
+	app.Delete("/keys/devices/:deviceId", handler.AuthMiddleware, handler.DeleteDeviceKeys)
+	app.Get("/keys/users/:id", handler.AuthMiddleware, handler.GetKeyBundle)
 
 	app.Post("/refresh", handler.Refresh)
 
 	return app
 }
+
+// NewAdmin - служебный сервер с метриками (/debug/vars). В них memstats и cmdline,
+// поэтому он слушает отдельный адрес, недоступный снаружи.
+func NewAdmin() *fiber.App {
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(expvar.New())
+
+	return app
+}
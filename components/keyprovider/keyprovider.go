@@ -0,0 +1,72 @@
+package keyprovider
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"playmates/components/sealer"
+	"strings"
+	"time"
+)
+
+// KeyProvider оборачивает ключи данных мастер-ключом. Сам мастер-ключ может и не покидать
+// хранилище (Vault Transit), поэтому наружу отдаются только операции над ключами данных.
+type KeyProvider interface {
+	Wrap(dataKey []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+	// Rewrap перешифровывает обёрнутый ключ текущей версией мастер-ключа.
+	// changed = false, если он уже обёрнут текущей версией.
+	Rewrap(wrapped []byte) ([]byte, bool, error)
+}
+
+// Config - откуда брать мастер-ключ.
+type Config struct {
+	// Type: sealer - ключи sealer (по умолчанию), file - ключ из файла, env - ключ из
+	// переменной окружения, vault - Vault Transit
+	Type  string `yaml:"type" env-default:"sealer"`
+	File  string `yaml:"file"`
+	Env   string `yaml:"env" env-default:"PLAYMATES_MASTER_KEY"`
+	Vault Vault  `yaml:"vault"`
+}
+
+type Vault struct {
+	Addr    string        `yaml:"addr" env:"VAULT_ADDR"`
+	Token   string        `yaml:"token" env:"VAULT_TOKEN"`
+	Mount   string        `yaml:"mount" env-default:"transit"`
+	Key     string        `yaml:"key" env-default:"playmates"`
+	Timeout time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// New создаёт провайдер по конфигу. sealer нужен для типа sealer.
+func New(cfg Config, s *sealer.Sealer) (KeyProvider, error) {
+	switch cfg.Type {
+	case "", "sealer":
+		return NewLocal(s), nil
+	case "file":
+		return NewFile(cfg.File)
+	case "env":
+		return NewEnv(cfg.Env)
+	case "vault":
+		return NewVault(cfg.Vault.Addr, cfg.Vault.Token, cfg.Vault.Mount, cfg.Vault.Key, cfg.Vault.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown key provider %q", cfg.Type)
+	}
+}
+
+// ParseKey разбирает ключ AES, записанный в hex или base64, и проверяет его длину.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, fmt.Errorf("key must be hex or base64")
+		}
+	}
+
+	if err = sealer.CheckKey(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,70 @@
+package keyprovider
+
+import (
+	"fmt"
+	"os"
+	"playmates/components/sealer"
+)
+
+// dataKeyAD отличает обёрнутые ключи данных от других шифротекстов того же ключа,
+// например ключей файлов вложений.
+var dataKeyAD = []byte("playmates data key")
+
+// Local оборачивает ключи данных мастер-ключом, который лежит в памяти процесса.
+type Local struct {
+	sealer *sealer.Sealer
+}
+
+func NewLocal(s *sealer.Sealer) *Local {
+	return &Local{sealer: s}
+}
+
+// NewFile читает мастер-ключ в hex или base64 из файла.
+func NewFile(path string) (*Local, error) {
+	if path == "" {
+		return nil, fmt.Errorf("key file is not set")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %w", err)
+	}
+
+	return newLocalKey(string(data))
+}
+
+// NewEnv читает мастер-ключ в hex или base64 из переменной окружения name.
+func NewEnv(name string) (*Local, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	return newLocalKey(value)
+}
+
+func newLocalKey(value string) (*Local, error) {
+	key, err := ParseKey(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+
+	s, err := sealer.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLocal(s), nil
+}
+
+func (l *Local) Wrap(dataKey []byte) ([]byte, error) {
+	return l.sealer.EncryptWithAD(dataKey, dataKeyAD)
+}
+
+func (l *Local) Unwrap(wrapped []byte) ([]byte, error) {
+	return l.sealer.DecryptWithAD(wrapped, dataKeyAD)
+}
+
+func (l *Local) Rewrap(wrapped []byte) ([]byte, bool, error) {
+	return l.sealer.ResealWithAD(wrapped, dataKeyAD, dataKeyAD)
+}
@@ -0,0 +1,141 @@
+package keyprovider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"playmates/components/sealer"
+	"strings"
+	"testing"
+)
+
+var masterKey = []byte(strings.Repeat("m", 32))
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []byte
+		wantErr bool
+	}{
+		{"hex", hex.EncodeToString(masterKey), masterKey, false},
+		{"base64", base64.StdEncoding.EncodeToString(masterKey), masterKey, false},
+		{"trailing newline", hex.EncodeToString(masterKey) + "\n", masterKey, false},
+		{"aes-128", hex.EncodeToString(masterKey[:16]), masterKey[:16], false},
+		{"wrong length", hex.EncodeToString(masterKey[:20]), nil, true},
+		{"too long", base64.StdEncoding.EncodeToString(append(masterKey, masterKey...)), nil, true},
+		{"raw string", string(masterKey[:31]) + "!", nil, true},
+		{"empty", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKey(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKey() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ParseKey() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFile(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "master.key")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(masterKey)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile() = %v", err)
+	}
+
+	// Обёрнутое ключом из файла разворачивается тем же ключом из окружения
+	t.Setenv("TEST_MASTER_KEY", base64.StdEncoding.EncodeToString(masterKey))
+	fromEnv, err := NewEnv("TEST_MASTER_KEY")
+	if err != nil {
+		t.Fatalf("NewEnv() = %v", err)
+	}
+
+	wrapped, err := fromFile.Wrap([]byte("data key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataKey, err := fromEnv.Unwrap(wrapped); err != nil || string(dataKey) != "data key" {
+		t.Errorf("Unwrap() = %q, %v", dataKey, err)
+	}
+
+	short := filepath.Join(dir, "short.key")
+	if err := os.WriteFile(short, []byte(hex.EncodeToString(masterKey[:10])), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFile(short); err == nil || !strings.Contains(err.Error(), "invalid master key") {
+		t.Errorf("NewFile() with short key = %v, want invalid master key", err)
+	}
+
+	if _, err := NewFile(""); err == nil {
+		t.Error("NewFile() without path succeeded")
+	}
+	if _, err := NewFile(filepath.Join(dir, "missing.key")); err == nil {
+		t.Error("NewFile() with missing file succeeded")
+	}
+}
+
+func TestNewEnv(t *testing.T) {
+	if _, err := NewEnv("TEST_MASTER_KEY_UNSET"); err == nil {
+		t.Error("NewEnv() with unset variable succeeded")
+	}
+
+	t.Setenv("TEST_MASTER_KEY", "")
+	if _, err := NewEnv("TEST_MASTER_KEY"); err == nil {
+		t.Error("NewEnv() with empty variable succeeded")
+	}
+
+	t.Setenv("TEST_MASTER_KEY", hex.EncodeToString(masterKey[:17]))
+	if _, err := NewEnv("TEST_MASTER_KEY"); err == nil {
+		t.Error("NewEnv() with 17-byte key succeeded")
+	}
+}
+
+func TestLocalWrapIsBoundToDataKeys(t *testing.T) {
+	s, err := sealer.New(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := NewLocal(s)
+
+	wrapped, err := local.Wrap([]byte("data key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Тем же ключом sealer шифрует и другие данные: без AD ключа данных шифротекст не откроется
+	if _, err := s.Decrypt(wrapped); err == nil {
+		t.Error("wrapped data key decrypted without data key AD")
+	}
+
+	// Чужой шифротекст того же ключа не принимается за ключ данных
+	other, err := s.Encrypt([]byte("file key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Unwrap(other); err == nil {
+		t.Error("Unwrap() of a non data key ciphertext succeeded")
+	}
+
+	rewrapped, changed, err := local.Rewrap(wrapped)
+	if err != nil || changed || !bytes.Equal(rewrapped, wrapped) {
+		t.Errorf("Rewrap() with unchanged key = %v, %v", changed, err)
+	}
+}
+
+func TestNewUnknownType(t *testing.T) {
+	if _, err := New(Config{Type: "kms"}, nil); err == nil {
+		t.Error("New() with unknown type succeeded")
+	}
+}
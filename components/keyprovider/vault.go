@@ -0,0 +1,143 @@
+package keyprovider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"playmates/components/sealer"
+	"strings"
+	"time"
+)
+
+// VaultTransit оборачивает ключи данных через Vault Transit: мастер-ключ остаётся в Vault,
+// в базе лежит его шифротекст вида vault:v1:... Подходит и любой сервер с тем же API.
+type VaultTransit struct {
+	addr   string
+	token  string
+	mount  string
+	key    string
+	client *http.Client
+}
+
+func NewVault(addr, token, mount, key string, timeout time.Duration) (*VaultTransit, error) {
+	if addr == "" || key == "" {
+		return nil, fmt.Errorf("vault address and key name are required")
+	}
+
+	return &VaultTransit{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		mount:  strings.Trim(mount, "/"),
+		key:    key,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+type vaultRequest struct {
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type vaultResponse struct {
+	Data struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (v *VaultTransit) Wrap(dataKey []byte) ([]byte, error) {
+	resp, err := v.call("encrypt", vaultRequest{Plaintext: base64.StdEncoding.EncodeToString(dataKey)})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Data.Ciphertext == "" {
+		return nil, fmt.Errorf("vault encrypt: empty ciphertext")
+	}
+
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+func (v *VaultTransit) Unwrap(wrapped []byte) ([]byte, error) {
+	resp, err := v.call("decrypt", vaultRequest{Ciphertext: string(wrapped)})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Data.Plaintext == "" {
+		return nil, fmt.Errorf("vault decrypt: empty plaintext")
+	}
+
+	dataKey, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("vault returned invalid plaintext: %w", err)
+	}
+	// Ответ с чужим ключом или обрезанный ответ не должен дойти до шифрования сообщений
+	if err = sealer.CheckKey(dataKey); err != nil {
+		return nil, fmt.Errorf("vault returned invalid data key: %w", err)
+	}
+
+	return dataKey, nil
+}
+
+// Rewrap перешифровывает ключ последней версией ключа Vault; сам ключ данных Vault не отдаёт.
+func (v *VaultTransit) Rewrap(wrapped []byte) ([]byte, bool, error) {
+	resp, err := v.call("rewrap", vaultRequest{Ciphertext: string(wrapped)})
+	if err != nil {
+		return nil, false, err
+	}
+	if resp.Data.Ciphertext == "" {
+		return nil, false, fmt.Errorf("vault rewrap: empty ciphertext")
+	}
+
+	rewrapped := []byte(resp.Data.Ciphertext)
+	if keyVersion(rewrapped) == keyVersion(wrapped) {
+		return wrapped, false, nil
+	}
+
+	return rewrapped, true, nil
+}
+
+// keyVersion - префикс vault:vN шифротекста.
+func keyVersion(ciphertext []byte) string {
+	parts := strings.SplitN(string(ciphertext), ":", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+func (v *VaultTransit) call(op string, body vaultRequest) (vaultResponse, error) {
+	var resp vaultResponse
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return resp, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/%s/%s/%s", v.addr, v.mount, op, v.key), bytes.NewReader(data))
+	if err != nil {
+		return resp, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return resp, fmt.Errorf("vault %s: %w", op, err)
+	}
+	defer res.Body.Close()
+
+	if err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&resp); err != nil && res.StatusCode == http.StatusOK {
+		return resp, fmt.Errorf("vault %s: invalid response: %w", op, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return resp, fmt.Errorf("vault %s: status %d: %s", op, res.StatusCode, strings.Join(resp.Errors, "; "))
+	}
+
+	return resp, nil
+}
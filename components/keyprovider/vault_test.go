@@ -0,0 +1,233 @@
+package keyprovider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTransit - Vault Transit с одним ключом: шифротекст vault:vN:<plaintext>, где N -
+// версия ключа на момент шифрования.
+type fakeTransit struct {
+	mu      sync.Mutex
+	version int
+	paths   []string
+	tokens  []string
+}
+
+func (f *fakeTransit) rotate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.version++
+}
+
+func (f *fakeTransit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paths = append(f.paths, r.Method+" "+r.URL.Path)
+	f.tokens = append(f.tokens, r.Header.Get("X-Vault-Token"))
+
+	var req vaultRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var resp vaultResponse
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/transit/encrypt/"):
+		resp.Data.Ciphertext = fmt.Sprintf("vault:v%d:%s", f.version, req.Plaintext)
+	case strings.HasPrefix(r.URL.Path, "/v1/transit/decrypt/"):
+		parts := strings.SplitN(req.Ciphertext, ":", 3)
+		if len(parts) != 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(vaultResponse{Errors: []string{"invalid ciphertext"}})
+			return
+		}
+		resp.Data.Plaintext = parts[2]
+	case strings.HasPrefix(r.URL.Path, "/v1/transit/rewrap/"):
+		parts := strings.SplitN(req.Ciphertext, ":", 3)
+		resp.Data.Ciphertext = fmt.Sprintf("vault:v%d:%s", f.version, parts[2])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTestVault(t *testing.T, handler http.Handler) *VaultTransit {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	v, err := NewVault(server.URL+"/", "secret-token", "/transit/", "playmates", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestVaultWrapUnwrapRewrap(t *testing.T) {
+	transit := &fakeTransit{version: 1}
+	v := newTestVault(t, transit)
+
+	dataKey := []byte(strings.Repeat("d", 32))
+	wrapped, err := v.Wrap(dataKey)
+	if err != nil {
+		t.Fatalf("Wrap() = %v", err)
+	}
+	if !strings.HasPrefix(string(wrapped), "vault:v1:") {
+		t.Errorf("Wrap() = %q, want vault:v1 ciphertext", wrapped)
+	}
+
+	unwrapped, err := v.Unwrap(wrapped)
+	if err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Errorf("Unwrap() = %q, %v", unwrapped, err)
+	}
+
+	// Версия ключа не менялась - Rewrap ничего не трогает
+	same, changed, err := v.Rewrap(wrapped)
+	if err != nil || changed || !bytes.Equal(same, wrapped) {
+		t.Errorf("Rewrap() before rotation = %q, %v, %v", same, changed, err)
+	}
+
+	transit.rotate()
+	rewrapped, changed, err := v.Rewrap(wrapped)
+	if err != nil || !changed || !strings.HasPrefix(string(rewrapped), "vault:v2:") {
+		t.Errorf("Rewrap() after rotation = %q, %v, %v", rewrapped, changed, err)
+	}
+	if unwrapped, err = v.Unwrap(rewrapped); err != nil || !bytes.Equal(unwrapped, dataKey) {
+		t.Errorf("Unwrap() after Rewrap() = %q, %v", unwrapped, err)
+	}
+
+	transit.mu.Lock()
+	defer transit.mu.Unlock()
+	if transit.paths[0] != "POST /v1/transit/encrypt/playmates" {
+		t.Errorf("request path = %q", transit.paths[0])
+	}
+	for _, token := range transit.tokens {
+		if token != "secret-token" {
+			t.Errorf("X-Vault-Token = %q, want secret-token", token)
+		}
+	}
+}
+
+func TestVaultErrorStatuses(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{"permission denied", http.StatusForbidden, `{"errors":["permission denied"]}`, "status 403: permission denied"},
+		{"not json", http.StatusBadGateway, `<html>bad gateway</html>`, "status 502"},
+		{"sealed", http.StatusServiceUnavailable, `{"errors":["Vault is sealed"]}`, "Vault is sealed"},
+		{"invalid response", http.StatusOK, `not json`, "invalid response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestVault(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+
+			if _, err := v.Wrap([]byte("key")); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Wrap() = %v, want error containing %q", err, tt.wantErr)
+			}
+			if _, err := v.Unwrap([]byte("vault:v1:a2V5")); err == nil {
+				t.Error("Unwrap() succeeded")
+			}
+			if _, _, err := v.Rewrap([]byte("vault:v1:a2V5")); err == nil {
+				t.Error("Rewrap() succeeded")
+			}
+		})
+	}
+}
+
+func TestVaultInvalidPlaintext(t *testing.T) {
+	v := newTestVault(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"plaintext":"not base64!"}}`))
+	}))
+
+	if _, err := v.Unwrap([]byte("vault:v1:x")); err == nil || !strings.Contains(err.Error(), "invalid plaintext") {
+		t.Errorf("Unwrap() = %v, want invalid plaintext error", err)
+	}
+}
+
+func TestNewVaultRequiresAddrAndKey(t *testing.T) {
+	if _, err := NewVault("", "token", "transit", "playmates", time.Second); err == nil {
+		t.Error("NewVault() without address succeeded")
+	}
+	if _, err := NewVault("http://vault", "token", "transit", "", time.Second); err == nil {
+		t.Error("NewVault() without key succeeded")
+	}
+}
+
+func TestKeyVersion(t *testing.T) {
+	tests := map[string]string{
+		"vault:v1:abc":    "v1",
+		"vault:v12:a:b:c": "v12",
+		"vault:v1":        "",
+		"":                "",
+	}
+
+	for ciphertext, want := range tests {
+		if got := keyVersion([]byte(ciphertext)); got != want {
+			t.Errorf("keyVersion(%q) = %q, want %q", ciphertext, got, want)
+		}
+	}
+}
+
+// Vault ответил 200, но без нужного поля: ключ нельзя считать обёрнутым или развёрнутым.
+func TestVaultEmptyResponse(t *testing.T) {
+	v := newTestVault(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{}}`))
+	}))
+
+	if _, err := v.Wrap([]byte(strings.Repeat("d", 32))); err == nil || !strings.Contains(err.Error(), "empty ciphertext") {
+		t.Errorf("Wrap() = %v, want empty ciphertext error", err)
+	}
+	if _, err := v.Unwrap([]byte("vault:v1:x")); err == nil || !strings.Contains(err.Error(), "empty plaintext") {
+		t.Errorf("Unwrap() = %v, want empty plaintext error", err)
+	}
+	if _, _, err := v.Rewrap([]byte("vault:v1:x")); err == nil || !strings.Contains(err.Error(), "empty ciphertext") {
+		t.Errorf("Rewrap() = %v, want empty ciphertext error", err)
+	}
+}
+
+func TestVaultUnwrapChecksKeySize(t *testing.T) {
+	tests := map[string]int{
+		"short":  8,
+		"long":   64,
+		"aes128": 16,
+		"aes256": 32,
+	}
+
+	for name, size := range tests {
+		t.Run(name, func(t *testing.T) {
+			plaintext := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, size))
+			v := newTestVault(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": plaintext}})
+			}))
+
+			dataKey, err := v.Unwrap([]byte("vault:v1:x"))
+			valid := size == 16 || size == 32
+			if valid && (err != nil || len(dataKey) != size) {
+				t.Errorf("Unwrap() = %d bytes, %v", len(dataKey), err)
+			}
+			if !valid && (err == nil || !strings.Contains(err.Error(), "invalid data key")) {
+				t.Errorf("Unwrap() = %v, want invalid data key error", err)
+			}
+		})
+	}
+}
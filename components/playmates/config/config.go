@@ -2,14 +2,238 @@ package config
 
 import (
 	"fmt"
+	"playmates/components/blindindex"
+	"playmates/components/keyprovider"
+	"playmates/components/sealer"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
 type Config struct {
-	DbConnStr    string `yaml:"db_conn_str"`
-	JwtSecret    string `yaml:"jwt_secret"`
-	SealerSecret string `yaml:"sealer_secret"`
+	DbConnStr       string             `yaml:"db_conn_str"`
+	JwtSecret       string             `yaml:"jwt_secret"`
+	SealerSecret    string             `yaml:"sealer_secret"` // единственный ключ шифрования, если не задан Sealer.Keys
+	Sealer          Sealer             `yaml:"sealer"`
+	SearchSecret    string             `yaml:"search_secret"` // ключ слепого индекса, см. SearchKey
+	SearchKeyID     uint16             `yaml:"search_key_id" env-default:"1"`
+	EmailHMAC       EmailHMAC          `yaml:"email_hmac"`
+	KeyProvider     keyprovider.Config `yaml:"key_provider"` // мастер-ключ для ключей данных бесед
+	Recommendations Recommendations    `yaml:"recommendations"`
+	SavedSearches   SavedSearches      `yaml:"saved_searches"`
+	Presence        Presence           `yaml:"presence"`
+	WebSocket       WebSocket          `yaml:"websocket"`
+	Cluster         Cluster            `yaml:"cluster"`
+	Messages        Messages           `yaml:"messages"`
+	Attachments     Attachments        `yaml:"attachments"`
+	Admin           Admin              `yaml:"admin"`
+}
+
+// Sealer - набор ключей шифрования. Шифрует ActiveKey, остальные только расшифровывают,
+// пока reencrypt не перешифрует данные. LegacyKey расшифровывает записи старого формата
+// без ID ключа. При переходе с sealer_secret он становится ключом с ID 1 и LegacyKey: 1.
+// Ключ с ID search_key_id нельзя убирать из Keys после reencrypt, пока не задан search_secret:
+// из него выводится ключ слепого индекса.
+type Sealer struct {
+	ActiveKey uint16      `yaml:"active_key"`
+	LegacyKey uint16      `yaml:"legacy_key"`
+	Keys      []SealerKey `yaml:"keys"`
+}
+
+type SealerKey struct {
+	ID     uint16 `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
+// EmailHMAC - ключи хэша email, по которому ищется пользователь при логине. Ключ обязателен
+// и ни из чего не выводится: ключи шифрования и индекса можно ротировать, не трогая логин.
+// ID ключа хранится рядом с хэшем. При ротации новый ключ ставится в secret с новым key_id,
+// старый переносится в old_keys, backfill-users перехэширует email, после чего старый
+// ключ можно убрать.
+type EmailHMAC struct {
+	Secret  string      `yaml:"secret"`
+	KeyID   uint16      `yaml:"key_id" env-default:"1"`
+	OldKeys []SealerKey `yaml:"old_keys"`
+}
+
+type Recommendations struct {
+	GamesWeight        float64       `yaml:"games_weight" env-default:"0.4"`
+	AgeWeight          float64       `yaml:"age_weight" env-default:"0.15"`
+	LanguagesWeight    float64       `yaml:"languages_weight" env-default:"0.2"`
+	AvailabilityWeight float64       `yaml:"availability_weight" env-default:"0.15"`
+	ActivityWeight     float64       `yaml:"activity_weight" env-default:"0.1"`
+	AgeSpan            int           `yaml:"age_span" env-default:"10"`
+	ActivityHalfLife   time.Duration `yaml:"activity_half_life" env-default:"72h"`
+	CandidatePool      int           `yaml:"candidate_pool" env-default:"500"`
+}
+
+type SavedSearches struct {
+	Interval time.Duration `yaml:"interval" env-default:"1h"`
+}
+
+type Presence struct {
+	IdleAfter     time.Duration `yaml:"idle_after" env-default:"5m"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env-default:"30s"`
+}
+
+type WebSocket struct {
+	SendQueueSize int           `yaml:"send_queue_size" env-default:"64"`
+	WriteWait     time.Duration `yaml:"write_wait" env-default:"10s"`
+	PingInterval  time.Duration `yaml:"ping_interval" env-default:"30s"`
+	PongWait      time.Duration `yaml:"pong_wait" env-default:"60s"`
+	IdleTimeout   time.Duration `yaml:"idle_timeout" env-default:"30m"`
+	ReadLimit     int64         `yaml:"read_limit" env-default:"65536"`
+	// typing.stop отправляется сам, если клиент не прислал его за TypingTimeout
+	TypingTimeout time.Duration `yaml:"typing_timeout" env-default:"6s"`
+	TypingLimit   int           `yaml:"typing_limit" env-default:"10"`
+	TypingWindow  time.Duration `yaml:"typing_window" env-default:"10s"`
+}
+
+type Cluster struct {
+	// Broker: memory - один инстанс, postgres - несколько инстансов через LISTEN/NOTIFY
+	Broker  string        `yaml:"broker" env-default:"memory"`
+	NodeID  string        `yaml:"node_id"`
+	NodeTTL time.Duration `yaml:"node_ttl" env-default:"30s"`
+}
+
+type Messages struct {
+	// Сколько времени после отправки сообщение можно редактировать
+	EditWindow time.Duration `yaml:"edit_window" env-default:"15m"`
+	Reactions  []string      `yaml:"reactions" env-default:"👍,👎,❤️,😂,😮,😢,😡,🔥,🎉,🎮"`
+	MaxPins    int           `yaml:"max_pins" env-default:"20"`
+	// Не расшифровывать тексты без associated data (seal_version = 0). Включать после того,
+	// как reencrypt перевёл все сообщения, иначе такие сообщения перестанут открываться
+	RequireAD bool `yaml:"require_ad" env-default:"false"`
+}
+
+type Attachments struct {
+	Dir           string   `yaml:"dir" env-default:"data/attachments"`
+	MaxSize       int64    `yaml:"max_size" env-default:"26214400"`
+	MaxPerMessage int      `yaml:"max_per_message" env-default:"10"`
+	AllowedTypes  []string `yaml:"allowed_types" env-default:"image/jpeg,image/png,image/gif,image/webp,video/mp4,video/webm,application/pdf,text/plain"`
+	ThumbnailSize int      `yaml:"thumbnail_size" env-default:"320"`
+	// Загруженные, но не отправленные вложения удаляются через PendingTTL
+	PendingTTL    time.Duration `yaml:"pending_ttl" env-default:"24h"`
+	SweepInterval time.Duration `yaml:"sweep_interval" env-default:"1h"`
+}
+
+type Admin struct {
+	// Адрес служебного сервера с метриками; пустой - не запускать
+	Addr string `yaml:"addr" env-default:"127.0.0.1:9090"`
+}
+
+// SealerKeyring возвращает ключи, активный ключ и legacy-ключ для sealer.NewKeyring.
+func (c *Config) SealerKeyring() (map[uint16][]byte, uint16, uint16) {
+	if len(c.Sealer.Keys) == 0 {
+		return map[uint16][]byte{1: []byte(c.SealerSecret)}, 1, 1
+	}
+
+	keys := make(map[uint16][]byte, len(c.Sealer.Keys))
+	for _, key := range c.Sealer.Keys {
+		keys[key.ID] = []byte(key.Secret)
+	}
+
+	return keys, c.Sealer.ActiveKey, c.Sealer.LegacyKey
+}
+
+// SearchKey возвращает ключ слепого индекса и его ID. Если search_secret задан, ключ - он сам,
+// иначе выводится из ключа шифрования с ID search_key_id. sealer_secret - это ключ с ID 1,
+// поэтому перенос sealer_secret в sealer.keys с ID 1 ключ индекса не меняет, а ротация
+// active_key его не трогает. ID ключа хранится рядом с токенами; после смены search_secret
+// или search_key_id нужно сменить ID и запустить backfill-search и backfill-users, до этого
+// поиск не находит старые сообщения и профили.
+func (c *Config) SearchKey() ([]byte, uint16, error) {
+	if c.SearchKeyID == 0 {
+		return nil, 0, fmt.Errorf("search_key_id must be positive")
+	}
+	if c.SearchSecret != "" {
+		return []byte(c.SearchSecret), c.SearchKeyID, nil
+	}
+
+	keys, _, _ := c.SealerKeyring()
+	key, ok := keys[c.SearchKeyID]
+	if !ok || len(key) == 0 {
+		return nil, 0, fmt.Errorf("search_key_id %d is not in sealer keys, set search_secret or keep the key", c.SearchKeyID)
+	}
+
+	derived, err := blindindex.DeriveKey(key)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error while deriving search key: %w", err)
+	}
+
+	return derived, c.SearchKeyID, nil
+}
+
+// EmailHasher создаёт хэшер email из email_hmac.
+func (c *Config) EmailHasher() (*blindindex.EmailHasher, error) {
+	if c.EmailHMAC.Secret == "" {
+		return nil, fmt.Errorf("email_hmac.secret is required")
+	}
+	if c.EmailHMAC.KeyID == 0 {
+		return nil, fmt.Errorf("email_hmac.key_id must be positive")
+	}
+
+	keys := map[uint16][]byte{c.EmailHMAC.KeyID: []byte(c.EmailHMAC.Secret)}
+	for _, key := range c.EmailHMAC.OldKeys {
+		if _, ok := keys[key.ID]; ok {
+			return nil, fmt.Errorf("email_hmac key %d is set twice", key.ID)
+		}
+		keys[key.ID] = []byte(key.Secret)
+	}
+
+	hasher, err := blindindex.NewEmailHasher(keys, c.EmailHMAC.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("email_hmac: %w", err)
+	}
+
+	return hasher, nil
+}
+
+func (c *Config) validate() error {
+	keys, _, _ := c.SealerKeyring()
+	for id, key := range keys {
+		if err := sealer.CheckKey(key); err != nil {
+			return fmt.Errorf("sealer key %d: %w", id, err)
+		}
+	}
+
+	if _, _, err := c.SearchKey(); err != nil {
+		return err
+	}
+	if _, err := c.EmailHasher(); err != nil {
+		return err
+	}
+
+	// Из этих интервалов строятся тикеры: ноль из конфига или переменной окружения
+	// cleanenv принимает, а time.NewTicker на нём паникует
+	intervals := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"saved_searches.interval", c.SavedSearches.Interval},
+		{"presence.sweep_interval", c.Presence.SweepInterval},
+		{"attachments.sweep_interval", c.Attachments.SweepInterval},
+		// На нулевых таймаутах дедлайн чтения WebSocket истекает сразу
+		{"websocket.pong_wait", c.WebSocket.PongWait},
+		{"websocket.idle_timeout", c.WebSocket.IdleTimeout},
+	}
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			return fmt.Errorf("%s must be positive", interval.name)
+		}
+	}
+
+	// Heartbeat узла идёт раз в node_ttl/3
+	if c.Cluster.NodeTTL < 3*time.Second {
+		return fmt.Errorf("cluster.node_ttl must be at least 3s")
+	}
+
+	// Понг на пинг должен успеть прийти до дедлайна чтения
+	if c.WebSocket.PingInterval > 0 && c.WebSocket.PongWait <= c.WebSocket.PingInterval {
+		return fmt.Errorf("websocket.pong_wait must be greater than websocket.ping_interval")
+	}
+
+	return nil
 }
 
 func New(path string) (*Config, error) {
@@ -20,5 +244,9 @@ func New(path string) (*Config, error) {
 		return nil, fmt.Errorf("unmarshalling config data: %w", err)
 	}
 
+	if err = cfg.validate(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
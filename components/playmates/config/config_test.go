@@ -0,0 +1,152 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig - минимальный конфиг, который проходит validate.
+func validConfig() Config {
+	return Config{
+		SealerSecret:  strings.Repeat("k", 32),
+		SearchKeyID:   1,
+		EmailHMAC:     EmailHMAC{Secret: strings.Repeat("e", 32), KeyID: 1},
+		SavedSearches: SavedSearches{Interval: time.Hour},
+		Presence:      Presence{IdleAfter: 5 * time.Minute, SweepInterval: 30 * time.Second},
+		WebSocket:     WebSocket{PingInterval: 30 * time.Second, PongWait: time.Minute, IdleTimeout: 30 * time.Minute},
+		Cluster:       Cluster{NodeTTL: 30 * time.Second},
+		Attachments:   Attachments{PendingTTL: 24 * time.Hour, SweepInterval: time.Hour},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := validConfig()
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate() of valid config: %v", err)
+	}
+}
+
+func TestValidateRejectsNonPositiveIntervals(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Config)
+	}{
+		{"saved_searches.interval", func(c *Config) { c.SavedSearches.Interval = 0 }},
+		{"presence.sweep_interval", func(c *Config) { c.Presence.SweepInterval = -time.Second }},
+		{"websocket.pong_wait", func(c *Config) { c.WebSocket.PongWait = 0 }},
+		{"websocket.idle_timeout", func(c *Config) { c.WebSocket.IdleTimeout = 0 }},
+		{"websocket.pong_wait must be greater", func(c *Config) { c.WebSocket.PongWait = c.WebSocket.PingInterval }},
+		{"attachments.sweep_interval", func(c *Config) { c.Attachments.SweepInterval = 0 }},
+		{"cluster.node_ttl", func(c *Config) { c.Cluster.NodeTTL = 0 }},
+		{"cluster.node_ttl", func(c *Config) { c.Cluster.NodeTTL = 2 * time.Second }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.mutate(&cfg)
+
+			err := cfg.validate()
+			if err == nil || !strings.Contains(err.Error(), tt.name) {
+				t.Errorf("validate() = %v, want error about %s", err, tt.name)
+			}
+		})
+	}
+}
+
+func TestSearchKeySurvivesMoveToKeyring(t *testing.T) {
+	secret := strings.Repeat("k", 32)
+
+	single := validConfig()
+	before, id, err := single.SearchKey()
+	if err != nil {
+		t.Fatalf("SearchKey() with sealer_secret: %v", err)
+	}
+	if id != 1 {
+		t.Errorf("SearchKey() id = %d, want 1", id)
+	}
+
+	// sealer_secret перенесён в keys с ID 1, активным стал новый ключ
+	keyring := validConfig()
+	keyring.SealerSecret = ""
+	keyring.Sealer = Sealer{ActiveKey: 2, LegacyKey: 1, Keys: []SealerKey{
+		{ID: 1, Secret: secret},
+		{ID: 2, Secret: strings.Repeat("n", 32)},
+	}}
+	after, id, err := keyring.SearchKey()
+	if err != nil {
+		t.Fatalf("SearchKey() with keyring: %v", err)
+	}
+	if id != 1 || string(after) != string(before) {
+		t.Error("search key changed after moving sealer_secret to sealer.keys")
+	}
+	if string(after) == secret {
+		t.Error("search key equals the encryption key")
+	}
+}
+
+func TestSearchKey(t *testing.T) {
+	cfg := validConfig()
+	cfg.SearchSecret = "search"
+	cfg.SearchKeyID = 7
+	key, id, err := cfg.SearchKey()
+	if err != nil || string(key) != "search" || id != 7 {
+		t.Errorf("SearchKey() with search_secret = %q, %d, %v", key, id, err)
+	}
+
+	// Ключа, из которого выводится индекс, нет среди ключей шифрования
+	cfg = validConfig()
+	cfg.SearchKeyID = 2
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "search_key_id") {
+		t.Errorf("validate() = %v, want error about search_key_id", err)
+	}
+
+	cfg = validConfig()
+	cfg.SearchKeyID = 0
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "search_key_id") {
+		t.Errorf("validate() = %v, want error about search_key_id", err)
+	}
+}
+
+func TestEmailHasher(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Config)
+		wantErr string
+	}{
+		// Ключа по умолчанию нет: ни sealer_secret, ни ключ индекса не подставляются
+		{"missing secret", func(c *Config) { c.EmailHMAC.Secret = "" }, "email_hmac.secret is required"},
+		{"short secret", func(c *Config) { c.EmailHMAC.Secret = "short" }, "at least 32 bytes"},
+		{"zero key id", func(c *Config) { c.EmailHMAC.KeyID = 0 }, "email_hmac.key_id"},
+		{"old key with active id", func(c *Config) {
+			c.EmailHMAC.OldKeys = []SealerKey{{ID: 1, Secret: strings.Repeat("o", 32)}}
+		}, "set twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.mutate(&cfg)
+			if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Ротация ключей шифрования и индекса не меняет хэш email
+	cfg := validConfig()
+	before, err := cfg.EmailHasher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SealerSecret = strings.Repeat("n", 32)
+	cfg.SearchSecret = "search"
+	after, err := cfg.EmailHasher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before.Hash("a@b.c")) != string(after.Hash("a@b.c")) {
+		t.Error("email hash changed after sealer and search key rotation")
+	}
+}
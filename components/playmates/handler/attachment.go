@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"playmates/components/playmates/service"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// UploadAttachment принимает файл в поле file формы multipart/form-data.
+func (h *Handler) UploadAttachment(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing file"})
+	}
+	if fileHeader.Size > h.cfg.Attachments.MaxSize {
+		return attachmentError(c, service.ErrAttachmentTooLarge)
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file"})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, h.cfg.Attachments.MaxSize+1))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid file"})
+	}
+
+	attachment, err := h.service.UploadAttachment(userID, fileHeader.Filename, data)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(attachment)
+}
+
+func (h *Handler) GetAttachment(c *fiber.Ctx) error {
+	return h.sendAttachment(c, false)
+}
+
+func (h *Handler) GetAttachmentThumbnail(c *fiber.Ctx) error {
+	return h.sendAttachment(c, true)
+}
+
+func (h *Handler) sendAttachment(c *fiber.Ctx, thumbnail bool) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	attachmentID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || attachmentID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid attachment ID"})
+	}
+
+	attachment, data, err := h.service.OpenAttachment(userID, attachmentID, thumbnail)
+	if err != nil {
+		return attachmentError(c, err)
+	}
+
+	// Картинки и видео показываем в клиенте, остальное только скачиваем,
+	// чтобы загруженный html или текст не исполнился в браузере
+	disposition := "attachment"
+	if !thumbnail && (strings.HasPrefix(attachment.ContentType, "image/") || strings.HasPrefix(attachment.ContentType, "video/")) {
+		disposition = "inline"
+	}
+	if !thumbnail {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name})
+	}
+
+	c.Set(fiber.HeaderContentType, attachment.ContentType)
+	c.Set(fiber.HeaderContentDisposition, disposition)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=3600")
+
+	return c.Send(data)
+}
+
+func attachmentError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrAttachmentNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrAttachmentTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrAttachmentType):
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrEmptyAttachment):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+}
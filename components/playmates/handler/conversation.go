@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"errors"
+	"playmates/components/playmates/service"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *Handler) CreateConversation(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	var req struct {
+		Title     string `json:"title"`
+		MemberIDs []int  `json:"member_ids"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	conversation, err := h.service.CreateConversation(userID, req.Title, req.MemberIDs)
+	if err != nil {
+		return conversationError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(conversation)
+}
+
+func (h *Handler) GetConversation(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	conversationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || conversationID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid conversation ID"})
+	}
+
+	conversation, err := h.service.GetConversation(userID, conversationID)
+	if err != nil {
+		return conversationError(c, err)
+	}
+
+	return c.JSON(conversation)
+}
+
+func (h *Handler) AddConversationMembers(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	conversationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || conversationID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid conversation ID"})
+	}
+
+	var req struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	added, err := h.service.AddMembers(userID, conversationID, req.UserIDs)
+	if err != nil {
+		return conversationError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"added": added})
+}
+
+// RemoveConversationMember исключает участника; если :userId - сам пользователь, это выход из группы.
+func (h *Handler) RemoveConversationMember(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	conversationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || conversationID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid conversation ID"})
+	}
+
+	memberID, err := strconv.Atoi(c.Params("userId"))
+	if err != nil || memberID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	if err := h.service.KickMember(userID, conversationID, memberID); err != nil {
+		return conversationError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "member removed"})
+}
+
+func (h *Handler) SetConversationMemberRole(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	conversationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || conversationID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid conversation ID"})
+	}
+
+	memberID, err := strconv.Atoi(c.Params("userId"))
+	if err != nil || memberID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if err := h.service.SetMemberRole(userID, conversationID, memberID, req.Role); err != nil {
+		return conversationError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "role updated"})
+}
+
+func (h *Handler) LeaveConversation(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	conversationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || conversationID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid conversation ID"})
+	}
+
+	if err := h.service.LeaveConversation(userID, conversationID); err != nil {
+		return conversationError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "left conversation"})
+}
+
+func (h *Handler) GetConversationMessages(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	conversationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || conversationID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid conversation ID"})
+	}
+
+	page, err := parseMessagePage(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	messages, err := h.service.GetConversationMessages(userID, conversationID, page)
+	if err != nil {
+		return conversationError(c, err)
+	}
+
+	return c.JSON(messages)
+}
+
+func (h *Handler) ReadConversation(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	conversationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || conversationID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid conversation ID"})
+	}
+
+	var req struct {
+		MessageID int `json:"message_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil || req.MessageID < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	receipt, err := h.service.MarkConversationRead(userID, conversationID, req.MessageID)
+	if err != nil {
+		return conversationError(c, err)
+	}
+
+	return c.JSON(receipt)
+}
+
+func (h *Handler) GetConversationPins(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	conversationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || conversationID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid conversation ID"})
+	}
+
+	pins, err := h.service.GetConversationPins(userID, conversationID)
+	if err != nil {
+		return conversationError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"pins": pins})
+}
+
+func conversationError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrConversationNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrConversationRights):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrConversationFull), errors.Is(err, service.ErrInvalidConversation):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"errors"
+	"playmates/components/playmates/models"
+	"playmates/components/playmates/service"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PublishDeviceKeys публикует ключи устройства :deviceId. Ключи и подпись передаются в base64.
+func (h *Handler) PublishDeviceKeys(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	var req struct {
+		IdentityKey  []byte              `json:"identity_key"`
+		SignedPrekey models.SignedPrekey `json:"signed_prekey"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	keys, err := h.service.PublishDeviceKeys(userID, models.DeviceKeys{
+		DeviceID:     c.Params("deviceId"),
+		IdentityKey:  req.IdentityKey,
+		SignedPrekey: req.SignedPrekey,
+	})
+	if err != nil {
+		return deviceKeysError(c, err)
+	}
+
+	return c.JSON(keys)
+}
+
+func (h *Handler) DeleteDeviceKeys(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	if err := h.service.DeleteDeviceKeys(userID, c.Params("deviceId")); err != nil {
+		return deviceKeysError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "device removed"})
+}
+
+func (h *Handler) GetKeyBundle(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	targetID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || targetID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	bundle, err := h.service.GetKeyBundle(userID, targetID)
+	if err != nil {
+		return deviceKeysError(c, err)
+	}
+
+	return c.JSON(bundle)
+}
+
+// GetChatE2EE возвращает, включено ли E2EE в личной переписке с :id.
+func (h *Handler) GetChatE2EE(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	peerID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || peerID <= 0 || peerID == userID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	e2ee, err := h.service.GetChatE2EE(userID, peerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"e2ee": e2ee})
+}
+
+// SetChatE2EE включает или выключает E2EE в личной переписке с :id для обоих собеседников.
+func (h *Handler) SetChatE2EE(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	peerID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || peerID <= 0 || peerID == userID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Enabled == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if err := h.service.SetChatE2EE(userID, peerID, *req.Enabled); err != nil {
+		if errors.Is(err, service.ErrE2EEKeysMissing) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"e2ee": *req.Enabled})
+}
+
+func deviceKeysError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrKeysNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidKeys):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrTooManyDevices):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"playmates/components/playmates/config"
+	"playmates/components/playmates/models"
 	"playmates/components/playmates/service"
 	"strconv"
 	"strings"
@@ -68,7 +69,7 @@ func (h *Handler) Login(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
-	user, err := h.service.GetUser(id)
+	user, err := h.service.GetUser(id, id)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -102,7 +103,7 @@ func (h *Handler) GetProfile(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	user, err := h.service.GetUser(userId)
+	user, err := h.service.GetUser(userId, userId)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -118,16 +119,19 @@ func (h *Handler) UpdateProfile(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	user, err := h.service.GetUser(userId)
+	user, err := h.service.GetUser(userId, userId)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	type ProfileUpdate struct {
-		Age     int      `json:"age"`
-		Gender  string   `json:"gender"`
-		Games   []string `json:"games"`
-		AboutMe string   `json:"about_me"`
+		Age          int      `json:"age"`
+		Gender       string   `json:"gender"`
+		Games        []string `json:"games"`
+		AboutMe      string   `json:"about_me"`
+		Languages    []string `json:"languages"`
+		Availability []string `json:"availability"`
+		HidePresence *bool    `json:"hide_presence"`
 	}
 
 	var updateData ProfileUpdate
@@ -140,6 +144,11 @@ func (h *Handler) UpdateProfile(c *fiber.Ctx) error {
 	user.Gender = updateData.Gender
 	user.Games = updateData.Games
 	user.AboutMe = updateData.AboutMe
+	user.Languages = updateData.Languages
+	user.Availability = updateData.Availability
+	if updateData.HidePresence != nil {
+		user.HidePresence = *updateData.HidePresence
+	}
 
 	err = h.service.SetUser(user)
 	if err != nil {
@@ -150,13 +159,18 @@ func (h *Handler) UpdateProfile(c *fiber.Ctx) error {
 }
 
 func (h *Handler) GetProfileById(c *fiber.Ctx) error {
+	viewerID, err := h.service.GetIdFromToken(c.Get("Authorization"))
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
 	userID, err := strconv.Atoi(c.Params("id"))
 	if err != nil || userID <= 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
 	// Получаем данные пользователя из базы данных
-	user, err := h.service.GetUser(userID)
+	user, err := h.service.GetUser(viewerID, userID)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
 	}
@@ -165,49 +179,144 @@ func (h *Handler) GetProfileById(c *fiber.Ctx) error {
 }
 
 func (h *Handler) Search(c *fiber.Ctx) error {
+	params, err := parseSearchParams(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	users, total, err := h.service.SearchUsers(params)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"total": total,
+		"users": users,
+	})
+}
+
+func parseSearchParams(c *fiber.Ctx) (models.SearchParams, error) {
 	var err error
 	minAgeStr := c.Query("minAge")
 	maxAgeStr := c.Query("maxAge")
 	gamesStr := c.Query("games")
-	gender := c.Query("gender")
+	excludeGamesStr := c.Query("-games")
+	excludeIDsStr := c.Query("exclude_ids")
 	offsetStr := c.Query("offset")
 
-	minAge := -1
-	maxAge := -1
-	offset := 0
+	params := models.SearchParams{
+		MinAge: -1,
+		MaxAge: -1,
+		Gender: c.Query("gender"),
+		Match:  c.Query("match"),
+		Query:  strings.TrimSpace(c.Query("q")),
+		Online: c.QueryBool("online"),
+	}
+
+	if len([]rune(params.Query)) > 100 {
+		return models.SearchParams{}, fmt.Errorf("search query is too long")
+	}
 
 	if minAgeStr != "" {
-		minAge, err = strconv.Atoi(minAgeStr)
+		params.MinAge, err = strconv.Atoi(minAgeStr)
 		if err != nil {
 			log.Println(fmt.Sprintf("invalid min age: %v", err))
 		}
 	}
 	if maxAgeStr != "" {
-		maxAge, err = strconv.Atoi(maxAgeStr)
+		params.MaxAge, err = strconv.Atoi(maxAgeStr)
 		if err != nil {
 			log.Println(fmt.Sprintf("invalid max age: %v", err))
 		}
 	}
 	if offsetStr != "" {
-		offset, err = strconv.Atoi(offsetStr)
+		params.Offset, err = strconv.Atoi(offsetStr)
 		if err != nil {
 			log.Println(fmt.Sprintf("invalid offset: %v", err))
 		}
 	}
-	var games []string
 	if gamesStr != "" {
-		games = strings.Split(gamesStr, ",")
+		params.Games = strings.Split(gamesStr, ",")
+	}
+	if excludeGamesStr != "" {
+		params.ExcludeGames = strings.Split(excludeGamesStr, ",")
+	}
+	if excludeIDsStr != "" {
+		for _, idStr := range strings.Split(excludeIDsStr, ",") {
+			id, err := strconv.Atoi(idStr)
+			if err != nil {
+				return models.SearchParams{}, fmt.Errorf("invalid exclude_ids: %s", idStr)
+			}
+			params.ExcludeIDs = append(params.ExcludeIDs, id)
+		}
+	}
+
+	if _, _, err := params.MatchMode(); len(params.Games) > 0 && err != nil {
+		return models.SearchParams{}, err
 	}
 
-	users, total, err := h.service.SearchUsers(minAge, maxAge, offset, games, gender)
+	return params, nil
+}
+
+func (h *Handler) GetRecommendations(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	limit := 20
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 50 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid limit"})
+		}
+	}
+
+	recommendations, err := h.service.GetRecommendations(userID, limit)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	return c.JSON(fiber.Map{
-		"total": total,
-		"users": users,
-	})
+	return c.JSON(fiber.Map{"recommendations": recommendations})
+}
+
+func (h *Handler) BlockUser(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	blockedID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || blockedID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	if err := h.service.BlockUser(userID, blockedID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "user blocked"})
+}
+
+func (h *Handler) UnblockUser(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	blockedID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || blockedID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	if err := h.service.UnblockUser(userID, blockedID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "user unblocked"})
 }
 
 func (h *Handler) GetChatMessages(c *fiber.Ctx) error {
@@ -222,30 +331,137 @@ func (h *Handler) GetChatMessages(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
 	}
 
-	// Получаем все сообщения между двумя пользователями
-	messages, err := h.service.GetMessages(currentUserID, otherUserID)
+	page, err := parseMessagePage(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
 
+	messages, err := h.service.GetMessages(currentUserID, otherUserID, page)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	user, err := h.service.GetUser(otherUserID)
+	user, err := h.service.GetUser(currentUserID, otherUserID)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
 	}
 
+	// Докуда собеседник получил и прочитал мои сообщения
+	readState, err := h.service.GetReadState(currentUserID, otherUserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
 	return c.JSON(fiber.Map{
-		"messages": messages,
-		"user":     user,
+		"messages":   messages.Messages,
+		"has_more":   messages.HasMore,
+		"user":       user,
+		"read_state": readState,
 	})
 }
 
+// parseMessagePage разбирает ?before=<id> (старше сообщения), ?after=<id> (новее) и ?limit.
+// Без курсоров отдаётся последняя страница.
+func parseMessagePage(c *fiber.Ctx) (models.MessagePageParams, error) {
+	var (
+		page models.MessagePageParams
+		err  error
+	)
+
+	if beforeStr := c.Query("before"); beforeStr != "" {
+		page.Before, err = strconv.Atoi(beforeStr)
+		if err != nil || page.Before <= 0 {
+			return models.MessagePageParams{}, fmt.Errorf("invalid before cursor")
+		}
+	}
+	if afterStr := c.Query("after"); afterStr != "" {
+		page.After, err = strconv.Atoi(afterStr)
+		if err != nil || page.After <= 0 {
+			return models.MessagePageParams{}, fmt.Errorf("invalid after cursor")
+		}
+	}
+	if page.Before > 0 && page.After > 0 {
+		return models.MessagePageParams{}, fmt.Errorf("before and after can't be used together")
+	}
+
+	if limitStr := c.Query("limit"); limitStr != "" {
+		page.Limit, err = strconv.Atoi(limitStr)
+		if err != nil || page.Limit <= 0 || page.Limit > 200 {
+			return models.MessagePageParams{}, fmt.Errorf("invalid limit")
+		}
+	}
+
+	return page, nil
+}
+
+func (h *Handler) ReadChat(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	currentUserID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	otherUserID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || otherUserID <= 0 || otherUserID == currentUserID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	// Без message_id читаем всю переписку
+	var req struct {
+		MessageID int `json:"message_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil || req.MessageID < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	receipt, err := h.service.MarkRead(currentUserID, otherUserID, req.MessageID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(receipt)
+}
+
+// DeliverChat отмечает сообщения собеседника доставленными на устройство. GET /chat и /sync
+// этого не делают: их вызывают заранее и повторяют.
+func (h *Handler) DeliverChat(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	currentUserID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	otherUserID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || otherUserID <= 0 || otherUserID == currentUserID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	// Без message_id - всё полученное
+	var req struct {
+		MessageID int `json:"message_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil || req.MessageID < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	receipt, err := h.service.MarkDelivered(currentUserID, otherUserID, req.MessageID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(receipt)
+}
+
 func (h *Handler) WebSocketConnect(c *websocket.Conn) {
 	token := c.Query("token")
 	userID, err := h.service.GetIdFromToken(token)
 	if err != nil {
 		fmt.Println("error getting user ID: ", err)
-		c.WriteMessage(websocket.CloseMessage, []byte(fmt.Sprintf("err getting userID: %v", err)))
+		service.CloseConn(c, websocket.ClosePolicyViolation, "invalid token")
 		return
 	}
 	h.service.HandleWebSocket(c, userID)
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"playmates/components/playmates/models"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseMessagePage(t *testing.T) {
+	app := fiber.New()
+	app.Get("/chat", func(c *fiber.Ctx) error {
+		page, err := parseMessagePage(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return c.JSON(page)
+	})
+
+	tests := []struct {
+		query string
+		want  models.MessagePageParams
+		ok    bool
+	}{
+		{"", models.MessagePageParams{}, true},
+		{"?before=10&limit=20", models.MessagePageParams{Before: 10, Limit: 20}, true},
+		{"?after=7", models.MessagePageParams{After: 7}, true},
+		{"?before=10&after=7", models.MessagePageParams{}, false},
+		{"?before=0", models.MessagePageParams{}, false},
+		{"?after=abc", models.MessagePageParams{}, false},
+		{"?limit=0", models.MessagePageParams{}, false},
+		{"?limit=201", models.MessagePageParams{}, false},
+	}
+
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", "/chat"+tt.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !tt.ok {
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Errorf("%q: status %d, want 400", tt.query, resp.StatusCode)
+			}
+			continue
+		}
+
+		var got models.MessagePageParams
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: page = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
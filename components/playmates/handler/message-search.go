@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"errors"
+	"playmates/components/playmates/service"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SearchChat ищет по переписке с пользователем :id: ?q=слова&before=ID&limit=N.
+// Сообщения отдаются от новых к старым.
+func (h *Handler) SearchChat(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	peerID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || peerID <= 0 || peerID == userID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	before, limit, err := parseSearchPage(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	page, err := h.service.SearchChat(userID, peerID, c.Query("q"), before, limit)
+	if err != nil {
+		return searchError(c, err)
+	}
+
+	return c.JSON(page)
+}
+
+func (h *Handler) SearchConversation(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	conversationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || conversationID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid conversation ID"})
+	}
+
+	before, limit, err := parseSearchPage(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	page, err := h.service.SearchConversation(userID, conversationID, c.Query("q"), before, limit)
+	if err != nil {
+		return searchError(c, err)
+	}
+
+	return c.JSON(page)
+}
+
+func parseSearchPage(c *fiber.Ctx) (int, int, error) {
+	page, err := parseMessagePage(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	if page.After > 0 {
+		return 0, 0, errors.New("after is not supported for search")
+	}
+
+	return page.Before, page.Limit, nil
+}
+
+func searchError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, service.ErrSearchQuery) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return conversationError(c, err)
+}
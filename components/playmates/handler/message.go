@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"errors"
+	"playmates/components/playmates/service"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func (h *Handler) EditMessage(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	messageID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid message ID"})
+	}
+
+	var req struct {
+		Msg string `json:"msg"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	message, err := h.service.EditMessage(userID, messageID, req.Msg)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	return c.JSON(message)
+}
+
+// DeleteMessage удаляет сообщение у себя, с ?for=everyone - у обоих участников.
+func (h *Handler) DeleteMessage(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	messageID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid message ID"})
+	}
+
+	var forEveryone bool
+	switch c.Query("for", "me") {
+	case "me":
+	case "everyone":
+		forEveryone = true
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid for parameter"})
+	}
+
+	if err := h.service.DeleteMessage(userID, messageID, forEveryone); err != nil {
+		return messageError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "message deleted"})
+}
+
+func (h *Handler) GetMessageHistory(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	messageID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid message ID"})
+	}
+
+	edits, err := h.service.GetMessageHistory(userID, messageID)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"history": edits})
+}
+
+// AddReaction ставит реакцию из тела {"emoji": "..."}.
+func (h *Handler) AddReaction(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	messageID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid message ID"})
+	}
+
+	var req struct {
+		Emoji string `json:"emoji"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	reactions, err := h.service.AddReaction(userID, messageID, req.Emoji)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"reactions": reactions})
+}
+
+// RemoveReaction снимает реакцию ?emoji=...
+func (h *Handler) RemoveReaction(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	messageID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid message ID"})
+	}
+
+	reactions, err := h.service.RemoveReaction(userID, messageID, c.Query("emoji"))
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"reactions": reactions})
+}
+
+func (h *Handler) PinMessage(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	messageID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid message ID"})
+	}
+
+	if err := h.service.PinMessage(userID, messageID); err != nil {
+		return messageError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "message pinned"})
+}
+
+func (h *Handler) UnpinMessage(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	messageID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || messageID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid message ID"})
+	}
+
+	if err := h.service.UnpinMessage(userID, messageID); err != nil {
+		return messageError(c, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "message unpinned"})
+}
+
+// GetChatPins - закреплённые сообщения личной переписки с пользователем :id.
+func (h *Handler) GetChatPins(c *fiber.Ctx) error {
+	token := c.Get("Authorization")
+	userID, err := h.service.GetIdFromToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token"})
+	}
+
+	peerID, err := strconv.Atoi(c.Params("id"))
+	if err != nil || peerID <= 0 || peerID == userID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid user ID"})
+	}
+
+	pins, err := h.service.GetChatPins(userID, peerID)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	return c.JSON(fiber.Map{"pins": pins})
+}
+
+func messageError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, service.ErrMessageNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrNotMessageSender), errors.Is(err, service.ErrEditWindowPassed):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidMessage), errors.Is(err, service.ErrReactionNotAllowed),
+		errors.Is(err, service.ErrEncryptedMessage):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, service.ErrTooManyPins):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+const (
+	AttachmentImage = "image"
+	AttachmentVideo = "video"
+	AttachmentFile  = "file"
+)
+
+// Attachment - вложение сообщения. URL и ThumbnailURL требуют заголовка Authorization
+// и отдают содержимое только участникам переписки.
+type Attachment struct {
+	ID           int       `json:"id"`
+	Kind         string    `json:"kind"`
+	Name         string    `json:"name"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+type AttachmentDB struct {
+	ID           int
+	UploaderID   int
+	MessageID    *int
+	Kind         string
+	Name         []byte
+	ContentType  string
+	Size         int64
+	Width        *int
+	Height       *int
+	FileKey      []byte
+	StorageKey   string
+	ThumbnailKey *string
+	CreatedAt    time.Time
+}
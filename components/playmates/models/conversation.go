@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+const (
+	ConversationDirect = "direct"
+	ConversationGroup  = "group"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Действия с участниками в событии conversation.members
+const (
+	MembersCreated = "created"
+	MembersAdded   = "added"
+	MembersLeft    = "left"
+	MembersKicked  = "kicked"
+	MembersRole    = "role"
+)
+
+type Conversation struct {
+	ID        int                  `json:"id"`
+	Type      string               `json:"type"`
+	Title     string               `json:"title,omitempty"`
+	CreatedBy int                  `json:"created_by,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+	Members   []ConversationMember `json:"members,omitempty"`
+}
+
+type ConversationMember struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// MembersPayload - изменение состава группы. UserIDs - кого затронуло действие.
+type MembersPayload struct {
+	ConversationID int    `json:"conversation_id"`
+	Action         string `json:"action"`
+	ActorID        int    `json:"actor_id"`
+	UserIDs        []int  `json:"user_ids"`
+	Role           string `json:"role,omitempty"`
+}
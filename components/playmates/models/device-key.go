@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// DeviceKeys - открытые ключи устройства для установки E2EE-сессии: долговременный
+// identity-ключ и подписанный им prekey. Сервер хранит их как есть и подпись не проверяет,
+// это делает клиент собеседника.
+type DeviceKeys struct {
+	DeviceID     string       `json:"device_id"`
+	IdentityKey  []byte       `json:"identity_key"`
+	SignedPrekey SignedPrekey `json:"signed_prekey"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+}
+
+type SignedPrekey struct {
+	ID        int    `json:"id"`
+	PublicKey []byte `json:"public_key"`
+	Signature []byte `json:"signature"`
+}
+
+// KeyBundle - ключи всех устройств пользователя. Сообщение шифруется для каждого устройства.
+type KeyBundle struct {
+	UserID  int          `json:"user_id"`
+	Devices []DeviceKeys `json:"devices"`
+}
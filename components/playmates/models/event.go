@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const ProtocolVersion = 1
+
+const (
+	EventMessageSend = "message.send"
+	EventMessageNew  = "message.new"
+	EventAck         = "ack"
+	EventError       = "error"
+	EventTypingStart = "typing.start"
+	EventTypingStop  = "typing.stop"
+	// EventTyping - старое название typing.start
+	EventTyping       = "typing"
+	EventPresence     = "presence"
+	EventNotification = "notification"
+	EventResume       = "resume"
+	EventResumeDone   = "resume.done"
+	EventRead         = "read"
+	EventDelivered    = "delivered"
+	EventReceipt      = "receipt"
+
+	EventMessageEdit    = "message.edit"
+	EventMessageDelete  = "message.delete"
+	EventMessageEdited  = "message.edited"
+	EventMessageDeleted = "message.deleted"
+	// EventMessageHidden приходит только на устройства удалившего у себя
+	EventMessageHidden = "message.hidden"
+
+	EventReactionAdd    = "reaction.add"
+	EventReactionRemove = "reaction.remove"
+	EventReaction       = "message.reaction"
+
+	EventMessagePinned   = "message.pinned"
+	EventMessageUnpinned = "message.unpinned"
+
+	EventConversationMembers = "conversation.members"
+
+	// EventChatE2EE - один из собеседников включил или выключил E2EE в личной беседе
+	EventChatE2EE = "chat.e2ee"
+)
+
+const (
+	ErrCodeBadRequest         = "bad_request"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeRateLimited        = "rate_limited"
+	ErrCodeInternal           = "internal"
+)
+
+// Envelope - кадр WebSocket-протокола. ID задаёт клиент, сервер повторяет его в ack/error.
+// Cursor есть у событий из журнала синхронизации, его клиент передаёт в resume и /sync.
+type Envelope struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Cursor  int64           `json:"cursor,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// MessageSendPayload - либо ReceiverID для личной переписки, либо ConversationID для группы.
+type MessageSendPayload struct {
+	ReceiverID     int    `json:"receiver_id,omitempty"`
+	ConversationID int    `json:"conversation_id,omitempty"`
+	Msg            string `json:"msg"`
+	// Загруженные заранее через POST /attachments; с ними Msg может быть пустым
+	AttachmentIDs []int `json:"attachment_ids,omitempty"`
+	ReplyToID     int   `json:"reply_to_id,omitempty"`
+	// Шифротекст e2ee-сообщения в base64, Msg при этом пустой. Только для личных чатов
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+type AckPayload struct {
+	MessageID int       `json:"message_id"`
+	Time      time.Time `json:"time"`
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type TypingPayload struct {
+	UserID         int `json:"user_id,omitempty"`
+	ReceiverID     int `json:"receiver_id,omitempty"`
+	ConversationID int `json:"conversation_id,omitempty"`
+}
+
+type ResumePayload struct {
+	Cursor int64 `json:"cursor"`
+}
+
+type ResumeDonePayload struct {
+	Cursor  int64 `json:"cursor"`
+	HasMore bool  `json:"has_more"`
+}
+
+type PresencePayload struct {
+	UserID   int      `json:"user_id"`
+	Presence Presence `json:"presence"`
+}
+
+type MessageEditPayload struct {
+	MessageID int    `json:"message_id"`
+	Msg       string `json:"msg"`
+}
+
+type MessageDeletePayload struct {
+	MessageID   int  `json:"message_id"`
+	ForEveryone bool `json:"for_everyone"`
+}
+
+type MessageHiddenPayload struct {
+	MessageID int `json:"message_id"`
+}
+
+// E2EEPayload - событие chat.e2ee. UserID - кто изменил настройку.
+type E2EEPayload struct {
+	ConversationID int       `json:"conversation_id"`
+	UserID         int       `json:"user_id"`
+	Enabled        bool      `json:"enabled"`
+	Time           time.Time `json:"time"`
+}
+
+// PinPayload - событие message.pinned и message.unpinned.
+type PinPayload struct {
+	MessageID      int       `json:"message_id"`
+	ConversationID int       `json:"conversation_id"`
+	UserID         int       `json:"user_id"`
+	Time           time.Time `json:"time"`
+}
@@ -1,39 +1,177 @@
 package models
 
-import "time"
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	MessageKindText = "text"
+	// Текст зашифрован на клиенте, сервер хранит и пересылает шифротекст как есть
+	MessageKindE2EE = "e2ee"
+)
 
 type Message struct {
-	ID         int       `json:"id"`
-	SenderID   int       `json:"sender_id"`
-	ReceiverID int       `json:"receiver_id"`
-	Msg        string    `json:"msg"`
-	Time       time.Time `json:"time"`
+	ID             int           `json:"id"`
+	ConversationID int           `json:"conversation_id,omitempty"`
+	SenderID       int           `json:"sender_id"`
+	ReceiverID     int           `json:"receiver_id"` // 0 для сообщений в группе
+	Kind           string        `json:"kind"`
+	Msg            string        `json:"msg"`
+	Ciphertext     []byte        `json:"ciphertext,omitempty"` // только у e2ee, Msg пустой
+	Time           time.Time     `json:"time"`
+	EditedAt       *time.Time    `json:"edited_at,omitempty"`
+	Deleted        bool          `json:"deleted,omitempty"` // удалено у всех, Msg пустой
+	Attachments    []Attachment  `json:"attachments,omitempty"`
+	Reactions      []Reaction    `json:"reactions,omitempty"`
+	ReplyTo        *MessageQuote `json:"reply_to,omitempty"`
 }
 
 type MessageDB struct {
-	ID         int
-	SenderID   int
-	ReceiverID int
-	Msg        []byte
-	Time       time.Time
+	ID             int
+	ConversationID int
+	SenderID       int
+	ReceiverID     int
+	Msg            []byte
+	Time           time.Time
+	EditedAt       *time.Time
+	DeletedAt      *time.Time
+	ReplyToID      *int
+	SealVersion    int
+	Kind           string // для e2ee в Msg лежит шифротекст клиента
+}
+
+// Версии шифрования текста сообщения (seal_version). Строки старых версий переводит
+// на текущую команда reencrypt.
+const (
+	// Ключ сервера без associated data
+	MessageSealLegacy = 0
+	// Ключ сервера, текст привязан к полям сообщения
+	MessageSealServerKey = 1
+	// Ключ данных беседы, текст привязан к полям сообщения
+	MessageSealConversationKey = 2
+
+	MessageSealVersion = MessageSealConversationKey
+)
+
+// MessageAD - associated data текста сообщения. Шифротекст, перенесённый в другую строку,
+// беседу или пару собеседников, не расшифруется. receiverID = 0 для групп.
+func MessageAD(messageID, conversationID, senderID, receiverID int) []byte {
+	ad := make([]byte, 0, 2+4*8)
+	ad = append(ad, 'm', 1)
+	for _, v := range []int{messageID, conversationID, senderID, receiverID} {
+		ad = binary.BigEndian.AppendUint64(ad, uint64(v))
+	}
+	return ad
+}
+
+// SealedAD - associated data, с которым зашифрован Msg; nil для строк до привязки.
+func (m MessageDB) SealedAD() []byte {
+	if m.SealVersion == MessageSealLegacy {
+		return nil
+	}
+	return MessageAD(m.ID, m.ConversationID, m.SenderID, m.ReceiverID)
+}
+
+// MessageQuote - краткая цитата сообщения, на которое отвечают. Msg обрезан.
+type MessageQuote struct {
+	ID       int    `json:"id"`
+	SenderID int    `json:"sender_id"`
+	Msg      string `json:"msg"`
+	Deleted  bool   `json:"deleted,omitempty"`
+	// Цитата e2ee-сообщения: текст сервер не знает, клиент берёт его из своей копии
+	Encrypted bool `json:"encrypted,omitempty"`
 }
 
+// Pin - закреплённое сообщение переписки.
+type Pin struct {
+	Message  Message   `json:"message"`
+	PinnedBy int       `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+type PinDB struct {
+	Message  MessageDB
+	PinnedBy int
+	PinnedAt time.Time
+}
+
+// MessageEdit - предыдущая версия сообщения.
+type MessageEdit struct {
+	Msg  string    `json:"msg"`
+	Time time.Time `json:"time"`
+}
+
+// MessageEditDB - прошлая версия текста. Зашифрована с associated data самого сообщения.
+type MessageEditDB struct {
+	Msg         []byte
+	Time        time.Time
+	SealVersion int
+}
+
+// ChatPreview - строка списка чатов. Для групп поля Other* пустые, вместо них Title и MemberCount.
 type ChatPreview struct {
+	Type            string    `json:"type"`
+	ConversationID  int       `json:"conversation_id,omitempty"`
+	Title           string    `json:"title,omitempty"`
+	MemberCount     int       `json:"member_count,omitempty"`
+	E2EE            bool      `json:"e2ee,omitempty"` // текста сообщений сервер не знает, LastMessage - заглушка
 	LastMessageID   int       `json:"last_message_id"`
 	SenderID        int       `json:"sender_id"`
 	ReceiverID      int       `json:"receiver_id"`
 	LastMessage     string    `json:"last_message"`
 	LastMessageTime time.Time `json:"last_message_time"`
-	OtherUserID     int       `json:"other_user_id"`
-	OtherUsername   string    `json:"other_username"`
+	LastEdited      bool      `json:"last_message_edited,omitempty"`
+	LastDeleted     bool      `json:"last_message_deleted,omitempty"`
+	OtherUserID     int       `json:"other_user_id,omitempty"`
+	OtherUsername   string    `json:"other_username,omitempty"`
+	OtherPresence   *Presence `json:"other_presence,omitempty"`
+	UnreadCount     int       `json:"unread_count"`
 }
 
 type ChatPreviewDB struct {
+	Type            string
+	ConversationID  int
+	Title           string
+	MemberCount     int
 	LastMessageID   int
 	SenderID        int
 	ReceiverID      int
+	E2EE            bool
+	LastKind        string
 	LastMessage     []byte
+	LastSealVersion int
 	LastMessageTime time.Time
+	LastEditedAt    *time.Time
+	LastDeletedAt   *time.Time
 	OtherUserID     int
 	OtherUsername   string
+	OtherLastSeenAt *time.Time
+	OtherHidden     bool
+	UnreadCount     int
+}
+
+// MessagePageParams - курсоры постраничной выдачи переписки. Before и After - ID сообщений,
+// без них отдаётся последняя страница.
+type MessagePageParams struct {
+	Before int
+	After  int
+	Limit  int
+}
+
+type MessagePage struct {
+	Messages []Message `json:"messages"`
+	HasMore  bool      `json:"has_more"`
+}
+
+// LastMessageDB - последнее сообщение в виде строки messages, для расшифровки.
+func (c ChatPreviewDB) LastMessageDB() MessageDB {
+	return MessageDB{
+		ID:             c.LastMessageID,
+		ConversationID: c.ConversationID,
+		SenderID:       c.SenderID,
+		ReceiverID:     c.ReceiverID,
+		Msg:            c.LastMessage,
+		SealVersion:    c.LastSealVersion,
+	}
 }
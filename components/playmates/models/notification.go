@@ -0,0 +1,34 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const NotificationSavedSearch = "saved_search.matches"
+
+type Notification struct {
+	ID        int             `json:"id"`
+	UserID    int             `json:"-"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	ReadAt    *time.Time      `json:"read_at"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type SavedSearch struct {
+	ID        int          `json:"id"`
+	UserID    int          `json:"-"`
+	Name      string       `json:"name"`
+	Params    SearchParams `json:"params"`
+	LastRunAt time.Time    `json:"last_run_at"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+type SavedSearchDigest struct {
+	SavedSearchID int                `json:"saved_search_id"`
+	Name          string             `json:"name"`
+	Since         time.Time          `json:"since"`
+	Total         int                `json:"total"`
+	Users         []UserSearchResult `json:"users"`
+}
@@ -0,0 +1,29 @@
+package models
+
+const (
+	ReactionAdded   = "added"
+	ReactionRemoved = "removed"
+)
+
+// Reaction - сводка по одной эмодзи на сообщении. Mine - поставил ли её текущий пользователь.
+type Reaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Mine  bool   `json:"mine"`
+}
+
+// ReactionPayload - кадр reaction.add и reaction.remove.
+type ReactionPayload struct {
+	MessageID int    `json:"message_id"`
+	Emoji     string `json:"emoji"`
+}
+
+// ReactionEventPayload - событие message.reaction. Count - сколько раз эмодзи стоит после изменения.
+type ReactionEventPayload struct {
+	MessageID      int    `json:"message_id"`
+	ConversationID int    `json:"conversation_id,omitempty"`
+	UserID         int    `json:"user_id"`
+	Emoji          string `json:"emoji"`
+	Action         string `json:"action"`
+	Count          int    `json:"count"`
+}
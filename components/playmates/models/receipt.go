@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// Receipt - пользователь UserID получил или прочитал сообщения от PeerID (или в группе
+// ConversationID) до MessageID включительно.
+type Receipt struct {
+	UserID         int       `json:"user_id"`
+	PeerID         int       `json:"peer_id,omitempty"`
+	ConversationID int       `json:"conversation_id,omitempty"`
+	Status         string    `json:"status"`
+	MessageID      int       `json:"message_id"`
+	Time           time.Time `json:"time"`
+}
+
+// ReadPayload - событие read; для delivered годится только PeerID.
+type ReadPayload struct {
+	PeerID         int `json:"peer_id,omitempty"`
+	ConversationID int `json:"conversation_id,omitempty"`
+	MessageID      int `json:"message_id"`
+}
+
+// ReadState - докуда собеседник получил и прочитал мои сообщения.
+type ReadState struct {
+	DeliveredUpTo int `json:"delivered_up_to"`
+	ReadUpTo      int `json:"read_up_to"`
+}
@@ -0,0 +1,6 @@
+package models
+
+type Recommendation struct {
+	User  User    `json:"user"`
+	Score float64 `json:"score"`
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	MatchAll     = "all"
+	MatchAny     = "any"
+	MatchAtLeast = "at_least"
+)
+
+type SearchParams struct {
+	MinAge       int      `json:"min_age"`
+	MaxAge       int      `json:"max_age"`
+	Gender       string   `json:"gender"`
+	Games        []string `json:"games"`
+	Match        string   `json:"match"`
+	ExcludeGames []string `json:"exclude_games"`
+	ExcludeIDs   []int    `json:"exclude_ids"`
+	Query        string   `json:"q"`
+	// QueryTokens - токены слепого индекса about_me для Query, заполняет сервис.
+	QueryTokens [][]byte `json:"-"`
+	Online      bool     `json:"online"`
+	// OnlineIDs заполняет сервис из ConnectionManager, когда задан Online.
+	OnlineIDs []int `json:"-"`
+	Offset    int   `json:"-"`
+	// UpdatedSince ограничивает выдачу профилями, созданными или изменёнными после этого момента.
+	UpdatedSince time.Time `json:"-"`
+}
+
+type UserSearchResult struct {
+	User
+	Snippet string  `json:"snippet,omitempty"`
+	Rank    float64 `json:"rank,omitempty"`
+}
+
+// UniqueGames возвращает игры в нижнем регистре без повторов и пустых названий, в исходном порядке.
+func UniqueGames(games []string) []string {
+	seen := make(map[string]struct{}, len(games))
+	unique := make([]string, 0, len(games))
+	for _, game := range games {
+		game = strings.ToLower(strings.TrimSpace(game))
+		if game == "" {
+			continue
+		}
+		if _, ok := seen[game]; ok {
+			continue
+		}
+		seen[game] = struct{}{}
+		unique = append(unique, game)
+	}
+
+	return unique
+}
+
+// MatchMode разбирает Match: "all" (по умолчанию), "any" или "at_least:N". N считается
+// по играм без повторов и не больше их числа.
+func (p SearchParams) MatchMode() (string, int, error) {
+	games := len(UniqueGames(p.Games))
+
+	switch {
+	case p.Match == "" || p.Match == MatchAll:
+		return MatchAll, games, nil
+	case p.Match == MatchAny:
+		return MatchAny, 1, nil
+	case strings.HasPrefix(p.Match, MatchAtLeast+":"):
+		n, err := strconv.Atoi(strings.TrimPrefix(p.Match, MatchAtLeast+":"))
+		if err != nil || n <= 0 {
+			return "", 0, fmt.Errorf("invalid match mode: %s", p.Match)
+		}
+		return MatchAtLeast, min(n, games), nil
+	default:
+		return "", 0, fmt.Errorf("invalid match mode: %s", p.Match)
+	}
+}
+
+// PrefixTsQuery превращает строку поиска в tsquery вида "word1:* & word2:*".
+// Всё, кроме букв и цифр, отбрасывается, поэтому результат безопасно передавать в to_tsquery.
+func (p SearchParams) PrefixTsQuery() string {
+	words := strings.FieldsFunc(strings.ToLower(p.Query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, word+":*")
+	}
+
+	return strings.Join(terms, " & ")
+}
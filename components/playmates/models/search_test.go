@@ -0,0 +1,67 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUniqueGames(t *testing.T) {
+	got := UniqueGames([]string{"Dota", "cs", " dota ", "", "CS", "chess"})
+	want := []string{"dota", "cs", "chess"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UniqueGames() = %v, want %v", got, want)
+	}
+}
+
+func TestMatchMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		games    []string
+		match    string
+		wantMode string
+		wantN    int
+		wantErr  bool
+	}{
+		{"default is all", []string{"dota", "cs"}, "", MatchAll, 2, false},
+		{"all counts distinct games", []string{"dota", "Dota", "cs"}, MatchAll, MatchAll, 2, false},
+		{"any", []string{"dota", "cs"}, MatchAny, MatchAny, 1, false},
+		{"at least", []string{"dota", "cs", "chess"}, "at_least:2", MatchAtLeast, 2, false},
+		// Повторы не считаются отдельными играми, N ограничивается их числом
+		{"at least with repeats", []string{"dota", "dota", "DOTA", "cs"}, "at_least:3", MatchAtLeast, 2, false},
+		{"at least above count", []string{"dota"}, "at_least:5", MatchAtLeast, 1, false},
+		{"at least zero", []string{"dota"}, "at_least:0", "", 0, true},
+		{"at least not a number", []string{"dota"}, "at_least:x", "", 0, true},
+		{"unknown", []string{"dota"}, "some", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, n, err := SearchParams{Games: tt.games, Match: tt.match}.MatchMode()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MatchMode() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if mode != tt.wantMode || n != tt.wantN {
+				t.Errorf("MatchMode() = %q, %d, want %q, %d", mode, n, tt.wantMode, tt.wantN)
+			}
+		})
+	}
+}
+
+func TestPrefixTsQuery(t *testing.T) {
+	tests := map[string]string{
+		"dota":            "dota:*",
+		"Dota Player":     "dota:* & player:*",
+		"  mid   carry  ": "mid:* & carry:*",
+		"c++ & dota | !x": "c:* & dota:* & x:*",
+		"o'neil:*":        "o:* & neil:*",
+		"игрок 2024":      "игрок:* & 2024:*",
+		"":                "",
+		"&|!():*<->":      "",
+	}
+
+	for query, want := range tests {
+		if got := (SearchParams{Query: query}).PrefixTsQuery(); got != want {
+			t.Errorf("PrefixTsQuery(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ChatEventDB - запись журнала событий пользователя. ID служит курсором синхронизации.
+type ChatEventDB struct {
+	ID        int64
+	UserID    int
+	Type      string
+	MessageID *int
+	Data      []byte
+	CreatedAt time.Time
+}
+
+type SyncEvent struct {
+	Cursor  int64           `json:"cursor"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Time    time.Time       `json:"time"`
+}
+
+type SyncResult struct {
+	Events  []SyncEvent `json:"events"`
+	Cursor  int64       `json:"cursor"`
+	HasMore bool        `json:"has_more"`
+}
@@ -1,12 +1,38 @@
 package models
 
+import (
+	"strconv"
+	"time"
+)
+
 type User struct {
-	ID           int      `json:"id"`
-	Age          int      `json:"age"`
-	Gender       string   `json:"gender"`
-	Username     string   `json:"username"`
-	Email        string   `json:"email"`
-	PasswordHash string   `json:"password_hash"`
-	AboutMe      string   `json:"about_me"`
-	Games        []string `json:"games"`
+	ID           int        `json:"id"`
+	Age          int        `json:"age"`
+	Gender       string     `json:"gender"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email"`
+	PasswordHash string     `json:"password_hash"`
+	AboutMe      string     `json:"about_me"`
+	Games        []string   `json:"games"`
+	Languages    []string   `json:"languages"`
+	Availability []string   `json:"availability"`
+	LastActiveAt time.Time  `json:"-"`
+	LastSeenAt   *time.Time `json:"-"`
+	HidePresence bool       `json:"hide_presence"`
+	Presence     *Presence  `json:"presence,omitempty"`
+	// Зашифрованные Email и AboutMe как в БД, расшифровывает сервис
+	EmailSealed   []byte `json:"-"`
+	AboutMeSealed []byte `json:"-"`
+}
+
+// Поля профиля, которые хранятся зашифрованными
+const (
+	UserFieldEmail   = "email"
+	UserFieldAboutMe = "about_me"
+)
+
+// UserFieldAD - associated data зашифрованного поля профиля. Шифротекст привязан к
+// пользователю и колонке и не расшифруется, если его перенести в другую строку.
+func UserFieldAD(userID int, field string) []byte {
+	return []byte("user:" + strconv.Itoa(userID) + ":" + field)
 }
@@ -0,0 +1,497 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"playmates/components/blobstore"
+	"playmates/components/playmates/models"
+	"playmates/components/sealer"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	maxAttachmentName = 255
+	// Картинки больше этого не декодируем ради миниатюры - защита от распаковочных бомб
+	maxThumbnailSourcePixels = 25_000_000
+	thumbnailQuality         = 80
+	// Сколько файлов удалённых вложений удалять за один запрос к deleted_blobs
+	deletedBlobsBatch = 500
+)
+
+type AttachmentsConfig struct {
+	MaxSize       int64
+	MaxPerMessage int
+	AllowedTypes  []string
+	ThumbnailSize int
+	PendingTTL    time.Duration
+}
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrAttachmentTooLarge = errors.New("attachment is too large")
+	ErrAttachmentType     = errors.New("attachment type is not allowed")
+	ErrTooManyAttachments = errors.New("too many attachments")
+	ErrEmptyAttachment    = errors.New("attachment is empty")
+)
+
+// UploadAttachment шифрует файл отдельным ключом и кладёт его в blob store. Вложение
+// остаётся черновиком, пока его ID не придёт в message.send.
+func (s *Service) UploadAttachment(userID int, name string, data []byte) (models.Attachment, error) {
+	if len(data) == 0 {
+		return models.Attachment{}, ErrEmptyAttachment
+	}
+	if int64(len(data)) > s.attachments.MaxSize {
+		return models.Attachment{}, ErrAttachmentTooLarge
+	}
+
+	// Тип определяем по содержимому, заголовку клиента не доверяем
+	contentType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil || !s.allowedType(contentType) {
+		return models.Attachment{}, ErrAttachmentType
+	}
+
+	attachment := models.AttachmentDB{
+		UploaderID:  userID,
+		Kind:        attachmentKind(contentType),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	var thumbnail []byte
+	if attachment.Kind == models.AttachmentImage {
+		var width, height int
+		thumbnail, width, height = makeThumbnail(data, s.attachments.ThumbnailSize)
+		if width > 0 {
+			attachment.Width, attachment.Height = &width, &height
+		}
+	}
+
+	fileKey := make([]byte, 32)
+	if _, err := rand.Read(fileKey); err != nil {
+		return models.Attachment{}, err
+	}
+	fileSealer, err := sealer.New(fileKey)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+
+	if attachment.FileKey, err = s.sealer.Encrypt(fileKey); err != nil {
+		log.Printf("err encrypt attachment key: %v\n", err)
+		return models.Attachment{}, err
+	}
+	if attachment.Name, err = s.sealer.Encrypt([]byte(cleanAttachmentName(name))); err != nil {
+		log.Printf("err encrypt attachment name: %v\n", err)
+		return models.Attachment{}, err
+	}
+
+	if attachment.StorageKey, err = s.putBlob(fileSealer, data); err != nil {
+		log.Printf("err store attachment: %v\n", err)
+		return models.Attachment{}, err
+	}
+	if thumbnail != nil {
+		thumbnailKey, err := s.putBlob(fileSealer, thumbnail)
+		if err != nil {
+			log.Printf("err store thumbnail: %v\n", err)
+			s.deleteBlobs([]string{attachment.StorageKey})
+			return models.Attachment{}, err
+		}
+		attachment.ThumbnailKey = &thumbnailKey
+	}
+
+	saved, err := s.repo.CreateAttachment(attachment)
+	if err != nil {
+		log.Printf("err create attachment: %v\n", err)
+		keys := []string{attachment.StorageKey}
+		if attachment.ThumbnailKey != nil {
+			keys = append(keys, *attachment.ThumbnailKey)
+		}
+		s.deleteBlobs(keys)
+		return models.Attachment{}, err
+	}
+
+	return s.openAttachment(saved)
+}
+
+// OpenAttachment расшифровывает файл или его миниатюру. Отдаётся загрузившему
+// и участникам переписки, в которую вложение отправлено.
+func (s *Service) OpenAttachment(userID, attachmentID int, thumbnail bool) (models.Attachment, []byte, error) {
+	stored, exists, err := s.repo.GetAttachment(attachmentID)
+	if err != nil {
+		log.Printf("err get attachment: %v\n", err)
+		return models.Attachment{}, nil, err
+	}
+	if !exists {
+		return models.Attachment{}, nil, ErrAttachmentNotFound
+	}
+
+	if stored.UploaderID != userID || stored.MessageID != nil {
+		visible, err := s.canSeeAttachment(userID, stored)
+		if err != nil {
+			return models.Attachment{}, nil, err
+		}
+		if !visible {
+			return models.Attachment{}, nil, ErrAttachmentNotFound
+		}
+	}
+
+	key := stored.StorageKey
+	if thumbnail {
+		if stored.ThumbnailKey == nil {
+			return models.Attachment{}, nil, ErrAttachmentNotFound
+		}
+		key = *stored.ThumbnailKey
+	}
+
+	attachment, err := s.openAttachment(stored)
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+
+	fileKey, err := s.sealer.Decrypt(stored.FileKey)
+	if err != nil {
+		log.Printf("err decrypt attachment key: %v\n", err)
+		return models.Attachment{}, nil, err
+	}
+	fileSealer, err := sealer.New(fileKey)
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+
+	sealed, err := s.blobs.Get(key)
+	if err != nil {
+		log.Printf("err get attachment blob: %v\n", err)
+		return models.Attachment{}, nil, err
+	}
+
+	data, err := fileSealer.Decrypt(sealed)
+	if err != nil {
+		log.Printf("err decrypt attachment: %v\n", err)
+		return models.Attachment{}, nil, err
+	}
+
+	if thumbnail {
+		attachment.ContentType = "image/jpeg"
+		attachment.Size = int64(len(data))
+	}
+
+	return attachment, data, nil
+}
+
+// RunAttachmentSweeper периодически удаляет неотправленные черновики старше PendingTTL,
+// вложения сообщений, удалённых у всех, и файлы всех удалённых вложений.
+func (s *Service) RunAttachmentSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.repo.DeleteStaleAttachments(time.Now().Add(-s.attachments.PendingTTL)); err != nil {
+				log.Printf("err delete stale attachments: %v\n", err)
+			}
+			s.sweepDeletedBlobs()
+		}
+	}
+}
+
+// sweepDeletedBlobs удаляет файлы из deleted_blobs. Ключ убирается только после удаления
+// файла, поэтому неудавшиеся попадут в следующий проход.
+func (s *Service) sweepDeletedBlobs() {
+	for {
+		keys, err := s.repo.GetDeletedBlobs(deletedBlobsBatch)
+		if err != nil {
+			log.Printf("err get deleted blobs: %v\n", err)
+			return
+		}
+
+		done := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if err := s.blobs.Delete(key); err != nil {
+				log.Printf("err delete blob: %v\n", err)
+				continue
+			}
+			done = append(done, key)
+		}
+
+		if len(done) > 0 {
+			if err := s.repo.ForgetDeletedBlobs(done); err != nil {
+				log.Printf("err forget deleted blobs: %v\n", err)
+				return
+			}
+		}
+
+		// Все оставшиеся не удалились - ждём следующего прохода
+		if len(keys) < deletedBlobsBatch || len(done) == 0 {
+			return
+		}
+	}
+}
+
+// checkMessageContent проверяет текст и вложения нового сообщения и возвращает ID вложений
+// без повторов. С вложениями текст может быть пустым.
+func (s *Service) checkMessageContent(userID int, msg string, attachmentIDs []int) ([]int, error) {
+	ids := uniqueIDs(attachmentIDs, 0)
+	if len(ids) > s.attachments.MaxPerMessage {
+		return nil, ErrTooManyAttachments
+	}
+
+	if !validMessage(msg) && (len(ids) == 0 || strings.TrimSpace(msg) != "") {
+		return nil, ErrInvalidMessage
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	pending, err := s.repo.CountPendingAttachments(userID, ids)
+	if err != nil {
+		log.Printf("err count attachments: %v\n", err)
+		return nil, err
+	}
+	if pending != len(ids) {
+		return nil, ErrAttachmentNotFound
+	}
+
+	return ids, nil
+}
+
+// withAttachments дополняет сообщения их вложениями одним запросом.
+func (s *Service) withAttachments(msgs []models.Message) error {
+	ids := make([]int, 0, len(msgs))
+	for _, msg := range msgs {
+		if !msg.Deleted {
+			ids = append(ids, msg.ID)
+		}
+	}
+
+	stored, err := s.repo.GetAttachmentsByMessageIDs(ids)
+	if err != nil {
+		log.Printf("err get attachments: %v\n", err)
+		return err
+	}
+
+	for i, msg := range msgs {
+		for _, a := range stored[msg.ID] {
+			attachment, err := s.openAttachment(a)
+			if err != nil {
+				return err
+			}
+			msgs[i].Attachments = append(msgs[i].Attachments, attachment)
+		}
+	}
+
+	return nil
+}
+
+// newMessageEnvelope собирает message.new для только что сохранённого сообщения.
+func (s *Service) newMessageEnvelope(message models.Message, hasAttachments bool) (models.Envelope, error) {
+	msgs := []models.Message{message}
+	if hasAttachments {
+		if err := s.withAttachments(msgs); err != nil {
+			return models.Envelope{}, err
+		}
+	}
+	if message.ReplyTo != nil {
+		if err := s.withQuotes(msgs); err != nil {
+			return models.Envelope{}, err
+		}
+	}
+	message = msgs[0]
+
+	return newEnvelope(models.EventMessageNew, "", message)
+}
+
+func (s *Service) openAttachment(a models.AttachmentDB) (models.Attachment, error) {
+	name, err := s.sealer.Decrypt(a.Name)
+	if err != nil {
+		log.Printf("err decrypt attachment name: %v\n", err)
+		return models.Attachment{}, err
+	}
+
+	attachment := models.Attachment{
+		ID:          a.ID,
+		Kind:        a.Kind,
+		Name:        string(name),
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		URL:         fmt.Sprintf("/attachments/%d", a.ID),
+		Time:        a.CreatedAt,
+	}
+	if a.Width != nil && a.Height != nil {
+		attachment.Width, attachment.Height = *a.Width, *a.Height
+	}
+	if a.ThumbnailKey != nil {
+		attachment.ThumbnailURL = fmt.Sprintf("/attachments/%d/thumbnail", a.ID)
+	}
+
+	return attachment, nil
+}
+
+// canSeeAttachment - отправлено ли вложение в переписку, которую видит userID.
+func (s *Service) canSeeAttachment(userID int, a models.AttachmentDB) (bool, error) {
+	if a.MessageID == nil {
+		return false, nil
+	}
+
+	msg, exists, err := s.repo.GetMessage(*a.MessageID)
+	if err != nil {
+		log.Printf("err get message: %v\n", err)
+		return false, err
+	}
+	if !exists || msg.DeletedAt != nil {
+		return false, nil
+	}
+
+	return s.canSeeMessage(userID, msg)
+}
+
+func (s *Service) putBlob(fileSealer *sealer.Sealer, data []byte) (string, error) {
+	sealed, err := fileSealer.Encrypt(data)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := blobstore.NewKey()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.blobs.Put(key, sealed); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+func (s *Service) deleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := s.blobs.Delete(key); err != nil {
+			log.Printf("err delete blob: %v\n", err)
+		}
+	}
+}
+
+func (s *Service) allowedType(contentType string) bool {
+	for _, allowed := range s.attachments.AllowedTypes {
+		if strings.EqualFold(strings.TrimSpace(allowed), contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+func attachmentKind(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return models.AttachmentImage
+	case strings.HasPrefix(contentType, "video/"):
+		return models.AttachmentVideo
+	default:
+		return models.AttachmentFile
+	}
+}
+
+// cleanAttachmentName оставляет от имени, присланного клиентом, только имя файла без пути.
+func cleanAttachmentName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if runes := []rune(name); len(runes) > maxAttachmentName {
+		name = string(runes[:maxAttachmentName])
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+
+	return name
+}
+
+// makeThumbnail возвращает JPEG-миниатюру не больше size по большей стороне и размеры
+// исходной картинки. Форматы, которые stdlib не декодирует, остаются без миниатюры.
+func makeThumbnail(data []byte, size int) ([]byte, int, int) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0
+	}
+	if size <= 0 || cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxThumbnailSourcePixels {
+		return nil, cfg.Width, cfg.Height
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, cfg.Width, cfg.Height
+	}
+
+	width, height := cfg.Width, cfg.Height
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(src, width, height), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, cfg.Width, cfg.Height
+	}
+
+	return buf.Bytes(), cfg.Width, cfg.Height
+}
+
+// scaleDown уменьшает картинку усреднением по областям и кладёт прозрачное на белый фон.
+func scaleDown(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			// Цвета премультиплицированы, поэтому белый фон - это просто добавка 0xffff-alpha
+			white := 0xffff - a/n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + white) >> 8),
+				G: uint8((g/n + white) >> 8),
+				B: uint8((b/n + white) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"playmates/components/blobstore"
+	"playmates/components/playmates/models"
+	"playmates/components/sealer"
+	"strings"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func testAttachmentsConfig() AttachmentsConfig {
+	return AttachmentsConfig{
+		MaxSize:       1 << 20,
+		MaxPerMessage: 2,
+		AllowedTypes:  []string{"image/png", " IMAGE/JPEG "},
+		ThumbnailSize: 64,
+	}
+}
+
+// Размер и тип проверяются до шифрования и записи файла; тип - по содержимому.
+func TestUploadAttachmentRejects(t *testing.T) {
+	s := &Service{attachments: testAttachmentsConfig()}
+	s.attachments.MaxSize = 1024
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"empty", nil, ErrEmptyAttachment},
+		{"too large", bytes.Repeat([]byte{0x89}, 1025), ErrAttachmentTooLarge},
+		{"text", []byte("just text"), ErrAttachmentType},
+		{"html named png", []byte("<html><script>alert(1)</script></html>"), ErrAttachmentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.UploadAttachment(1, "pic.png", tt.data); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UploadAttachment() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckMessageContentLimits(t *testing.T) {
+	s := &Service{attachments: testAttachmentsConfig()}
+
+	if _, err := s.checkMessageContent(1, "hi", []int{1, 2, 3}); !errors.Is(err, ErrTooManyAttachments) {
+		t.Fatalf("three attachments: %v", err)
+	}
+	// Без вложений текст обязателен
+	if _, err := s.checkMessageContent(1, " ", nil); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("empty message: %v", err)
+	}
+	// С вложениями текст может быть пустым, но не длиннее лимита
+	if _, err := s.checkMessageContent(1, strings.Repeat("x", maxMessageLength+1), []int{1, 1}); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("long message with attachments: %v", err)
+	}
+
+	ids, err := s.checkMessageContent(1, "hi", nil)
+	if err != nil || ids != nil {
+		t.Fatalf("text message: ids = %v, err = %v", ids, err)
+	}
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	thumbnail, width, height := makeThumbnail(testPNG(t, 200, 100), 64)
+	if width != 200 || height != 100 {
+		t.Fatalf("source size = %dx%d, want 200x100", width, height)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(thumbnail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || cfg.Width != 64 || cfg.Height != 32 {
+		t.Fatalf("thumbnail = %s %dx%d, want jpeg 64x32", format, cfg.Width, cfg.Height)
+	}
+
+	// Маленькая картинка не растягивается
+	thumbnail, _, _ = makeThumbnail(testPNG(t, 10, 40), 64)
+	if cfg, err = jpeg.DecodeConfig(bytes.NewReader(thumbnail)); err != nil || cfg.Width != 10 || cfg.Height != 40 {
+		t.Fatalf("small thumbnail = %dx%d, %v", cfg.Width, cfg.Height, err)
+	}
+
+	if thumbnail, width, _ = makeThumbnail([]byte("not an image"), 64); thumbnail != nil || width != 0 {
+		t.Fatal("thumbnail of a non-image")
+	}
+}
+
+func TestCleanAttachmentName(t *testing.T) {
+	tests := map[string]string{
+		"photo.png":              "photo.png",
+		"../../etc/passwd":       "passwd",
+		`C:\Users\me\cat.jpg`:    "cat.jpg",
+		"  bad\x00\nname.txt  ":  "badname.txt",
+		"":                       "file",
+		"/":                      "file",
+		strings.Repeat("я", 300): strings.Repeat("я", maxAttachmentName),
+	}
+
+	for name, want := range tests {
+		if got := cleanAttachmentName(name); got != want {
+			t.Errorf("cleanAttachmentName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAttachmentKind(t *testing.T) {
+	tests := map[string]string{
+		"image/png":       models.AttachmentImage,
+		"video/mp4":       models.AttachmentVideo,
+		"application/pdf": models.AttachmentFile,
+	}
+
+	for contentType, want := range tests {
+		if got := attachmentKind(contentType); got != want {
+			t.Errorf("attachmentKind(%s) = %s, want %s", contentType, got, want)
+		}
+	}
+}
+
+// Файл на диске лежит зашифрованным ключом вложения.
+func TestPutBlobEncrypts(t *testing.T) {
+	store, err := blobstore.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{blobs: store}
+
+	fileSealer, err := sealer.New(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := testPNG(t, 16, 16)
+	key, err := s.putBlob(fileSealer, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, data[:32]) {
+		t.Fatal("blob is stored in plaintext")
+	}
+
+	opened, err := fileSealer.Decrypt(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Fatal("blob does not decrypt to the uploaded file")
+	}
+}
+
+func TestUploadAndOpenAttachment(t *testing.T) {
+	s, conn := testService(t)
+	uploader, other := insertTestUser(t, conn, "uploader"), insertTestUser(t, conn, "other")
+
+	store, err := blobstore.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.blobs = store
+	s.attachments = testAttachmentsConfig()
+	if s.sealer, err = sealer.New(bytes.Repeat([]byte{9}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	data := testPNG(t, 200, 100)
+	attachment, err := s.UploadAttachment(uploader, "../cat.png", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.Kind != models.AttachmentImage || attachment.ContentType != "image/png" || attachment.Name != "cat.png" {
+		t.Fatalf("attachment = %+v", attachment)
+	}
+	if attachment.Width != 200 || attachment.Height != 100 || attachment.ThumbnailURL == "" {
+		t.Fatalf("image metadata = %+v", attachment)
+	}
+
+	_, opened, err := s.OpenAttachment(uploader, attachment.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Fatal("opened file differs from the upload")
+	}
+
+	thumb, thumbnail, err := s.OpenAttachment(uploader, attachment.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumb.ContentType != "image/jpeg" || thumb.Size != int64(len(thumbnail)) {
+		t.Fatalf("thumbnail = %+v", thumb)
+	}
+
+	// Черновик видит только загрузивший
+	if _, _, err = s.OpenAttachment(other, attachment.ID, false); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Fatalf("draft opened by another user: %v", err)
+	}
+}
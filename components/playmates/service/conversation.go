@@ -0,0 +1,464 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"playmates/components/connection-manager"
+	"playmates/components/playmates/models"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxConversationMembers = 50
+	maxConversationTitle   = 100
+)
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrConversationRights   = errors.New("not enough rights in conversation")
+	ErrConversationFull     = errors.New("conversation members limit reached")
+	ErrInvalidConversation  = errors.New("invalid conversation request")
+)
+
+func (s *Service) CreateConversation(ownerID int, title string, memberIDs []int) (models.Conversation, error) {
+	title = strings.TrimSpace(title)
+	if title == "" || utf8.RuneCountInString(title) > maxConversationTitle {
+		return models.Conversation{}, fmt.Errorf("%w: title must be 1-%d characters", ErrInvalidConversation, maxConversationTitle)
+	}
+
+	memberIDs = uniqueIDs(memberIDs, ownerID)
+	if len(memberIDs) == 0 {
+		return models.Conversation{}, fmt.Errorf("%w: at least one member is required", ErrInvalidConversation)
+	}
+	if len(memberIDs)+1 > maxConversationMembers {
+		return models.Conversation{}, ErrConversationFull
+	}
+
+	if err := s.checkInvitees(ownerID, memberIDs); err != nil {
+		return models.Conversation{}, err
+	}
+
+	conversation, cursors, err := s.repo.CreateConversation(ownerID, title, memberIDs)
+	if err != nil {
+		log.Printf("err create conversation: %v\n", err)
+		return models.Conversation{}, err
+	}
+
+	s.publishMembersEvent(cursors, models.MembersPayload{
+		ConversationID: conversation.ID,
+		Action:         models.MembersCreated,
+		ActorID:        ownerID,
+		UserIDs:        keys(cursors),
+	})
+
+	return s.GetConversation(ownerID, conversation.ID)
+}
+
+// GetConversation возвращает группу с составом. Видна только участникам.
+func (s *Service) GetConversation(userID, conversationID int) (models.Conversation, error) {
+	if _, err := s.memberRole(conversationID, userID); err != nil {
+		return models.Conversation{}, err
+	}
+
+	conversation, exists, err := s.repo.GetConversation(conversationID)
+	if err != nil {
+		log.Printf("err get conversation: %v\n", err)
+		return models.Conversation{}, err
+	}
+	if !exists {
+		return models.Conversation{}, ErrConversationNotFound
+	}
+
+	return conversation, nil
+}
+
+// AddMembers приглашает пользователей в группу. Доступно владельцу и администраторам.
+func (s *Service) AddMembers(actorID, conversationID int, userIDs []int) ([]int, error) {
+	role, err := s.groupRole(conversationID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if role == models.RoleMember {
+		return nil, ErrConversationRights
+	}
+
+	userIDs = uniqueIDs(userIDs, actorID)
+	if len(userIDs) == 0 {
+		return nil, fmt.Errorf("%w: at least one user is required", ErrInvalidConversation)
+	}
+
+	members, err := s.repo.GetConversationMemberIDs(conversationID)
+	if err != nil {
+		log.Printf("err get conversation members: %v\n", err)
+		return nil, err
+	}
+	if len(members)+len(userIDs) > maxConversationMembers {
+		return nil, ErrConversationFull
+	}
+
+	if err := s.checkInvitees(actorID, userIDs); err != nil {
+		return nil, err
+	}
+
+	added, cursors, err := s.repo.AddConversationMembers(conversationID, actorID, userIDs)
+	if err != nil {
+		log.Printf("err add conversation members: %v\n", err)
+		return nil, err
+	}
+
+	if len(added) > 0 {
+		s.publishMembersEvent(cursors, models.MembersPayload{
+			ConversationID: conversationID,
+			Action:         models.MembersAdded,
+			ActorID:        actorID,
+			UserIDs:        added,
+		})
+	}
+
+	return added, nil
+}
+
+func (s *Service) LeaveConversation(userID, conversationID int) error {
+	if _, err := s.groupRole(conversationID, userID); err != nil {
+		return err
+	}
+
+	return s.removeMember(conversationID, userID, userID, models.MembersLeft)
+}
+
+// KickMember исключает участника. Владелец может исключить кого угодно, администратор - только участников.
+func (s *Service) KickMember(actorID, conversationID, userID int) error {
+	if actorID == userID {
+		return s.LeaveConversation(actorID, conversationID)
+	}
+
+	actorRole, err := s.groupRole(conversationID, actorID)
+	if err != nil {
+		return err
+	}
+
+	targetRole, err := s.memberRole(conversationID, userID)
+	if errors.Is(err, ErrConversationNotFound) {
+		return fmt.Errorf("%w: user is not a member", ErrInvalidConversation)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !outranks(actorRole, targetRole) {
+		return ErrConversationRights
+	}
+
+	return s.removeMember(conversationID, actorID, userID, models.MembersKicked)
+}
+
+// SetMemberRole назначает или снимает администратора. Доступно только владельцу.
+func (s *Service) SetMemberRole(actorID, conversationID, userID int, role string) error {
+	if role != models.RoleAdmin && role != models.RoleMember {
+		return fmt.Errorf("%w: role must be admin or member", ErrInvalidConversation)
+	}
+
+	actorRole, err := s.groupRole(conversationID, actorID)
+	if err != nil {
+		return err
+	}
+	if actorRole != models.RoleOwner || actorID == userID {
+		return ErrConversationRights
+	}
+
+	cursors, changed, err := s.repo.SetMemberRole(conversationID, actorID, userID, role)
+	if err != nil {
+		log.Printf("err set member role: %v\n", err)
+		return err
+	}
+
+	if changed {
+		s.publishMembersEvent(cursors, models.MembersPayload{
+			ConversationID: conversationID,
+			Action:         models.MembersRole,
+			ActorID:        actorID,
+			UserIDs:        []int{userID},
+			Role:           role,
+		})
+		return nil
+	}
+
+	if _, err := s.memberRole(conversationID, userID); errors.Is(err, ErrConversationNotFound) {
+		return fmt.Errorf("%w: user is not a member", ErrInvalidConversation)
+	}
+
+	return nil
+}
+
+func (s *Service) GetConversationMessages(userID, conversationID int, page models.MessagePageParams) (models.MessagePage, error) {
+	if _, err := s.memberRole(conversationID, userID); err != nil {
+		return models.MessagePage{}, err
+	}
+
+	if page.Limit <= 0 || page.Limit > maxMessagePageSize {
+		page.Limit = defaultMessagePageSize
+	}
+
+	msgsDB, hasMore, err := s.repo.GetConversationMessages(userID, conversationID, page)
+	if err != nil {
+		log.Printf("err get conversation messages: %v\n", err)
+		return models.MessagePage{}, err
+	}
+
+	msgs := make([]models.Message, len(msgsDB))
+	for i, msg := range msgsDB {
+		msgs[i], err = s.openMessage(msg)
+		if err != nil {
+			log.Printf("err decrypt message: %v\n", err)
+			return models.MessagePage{}, err
+		}
+	}
+
+	if err = s.enrichMessages(userID, msgs); err != nil {
+		return models.MessagePage{}, err
+	}
+
+	return models.MessagePage{Messages: msgs, HasMore: hasMore}, nil
+}
+
+// MarkConversationRead отмечает прочитанными сообщения группы до messageID; messageID <= 0 - все.
+func (s *Service) MarkConversationRead(userID, conversationID, messageID int) (models.Receipt, error) {
+	return s.markConversationRead(userID, conversationID, messageID, "")
+}
+
+func (s *Service) markConversationRead(userID, conversationID, messageID int, exceptConn string) (models.Receipt, error) {
+	if _, err := s.memberRole(conversationID, userID); err != nil {
+		return models.Receipt{}, err
+	}
+
+	if messageID <= 0 {
+		messageID = math.MaxInt32
+	}
+
+	receipt, cursors, changed, err := s.repo.MarkConversationRead(userID, conversationID, messageID)
+	if err != nil {
+		log.Printf("err mark conversation read: %v\n", err)
+		return models.Receipt{}, err
+	}
+
+	if changed {
+		env, err := newEnvelope(models.EventReceipt, "", receipt)
+		if err != nil {
+			log.Println("Error encoding event:", err)
+			return receipt, nil
+		}
+		s.publishCursors(env, cursors, userID, exceptConn)
+	}
+
+	return receipt, nil
+}
+
+func (s *Service) handleConversationSend(client *connection_manager.Client, env models.Envelope, payload models.MessageSendPayload) {
+	userID := client.UserID
+
+	if _, err := s.groupRole(payload.ConversationID, userID); err != nil {
+		s.sendConversationError(client, env.ID, err, "failed to send message")
+		return
+	}
+
+	// E2EE пока только в личных чатах: ключи устройств всех участников группы не согласовать
+	if len(payload.Ciphertext) > 0 {
+		s.sendMessageError(client, env.ID, ErrInvalidCiphertext, "failed to send message")
+		return
+	}
+
+	attachmentIDs, err := s.checkMessageContent(userID, payload.Msg, payload.AttachmentIDs)
+	if err != nil {
+		s.sendMessageError(client, env.ID, err, "failed to send message")
+		return
+	}
+
+	if err = s.checkReply(userID, payload); err != nil {
+		s.sendMessageError(client, env.ID, err, "failed to send message")
+		return
+	}
+
+	messageID, sealedMsg, err := s.sealNewMessage(payload.ConversationID, userID, 0, payload.Msg)
+	if err != nil {
+		log.Println("Error encrypting message:", err)
+		s.sendError(client, env.ID, models.ErrCodeInternal, "failed to send message")
+		return
+	}
+
+	saved, cursors, err := s.repo.PostConversationMessage(messageID, sealedMsg, userID, payload.ConversationID, attachmentIDs, payload.ReplyToID, s.index.Tokens(payload.ConversationID, payload.Msg))
+	if err != nil {
+		log.Println("Error saving message:", err)
+		s.sendError(client, env.ID, models.ErrCodeInternal, "failed to send message")
+		return
+	}
+
+	s.typing.stop(typingKey{connID: client.ID, conversationID: payload.ConversationID})
+
+	if err = s.repo.TouchUser(userID); err != nil {
+		log.Println("Error updating last activity:", err)
+	}
+
+	ack, err := newEnvelope(models.EventAck, env.ID, models.AckPayload{
+		MessageID: saved.ID,
+		Time:      saved.Time,
+	})
+	if err == nil {
+		ack.Cursor = cursors[userID]
+		s.sendToClient(client, ack)
+	}
+
+	message, err := s.newMessageEnvelope(models.Message{
+		ID:             saved.ID,
+		ConversationID: saved.ConversationID,
+		SenderID:       saved.SenderID,
+		Kind:           saved.Kind,
+		Msg:            payload.Msg,
+		Time:           saved.Time,
+		ReplyTo:        replyRef(saved.ReplyToID),
+	}, len(attachmentIDs) > 0)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	// Каждый участник получает сообщение на все устройства, отправитель - на остальные свои
+	s.publishCursors(message, cursors, userID, client.ID)
+}
+
+func (s *Service) removeMember(conversationID, actorID, userID int, action string) error {
+	cursors, newOwner, ownerCursors, removed, err := s.repo.RemoveConversationMember(conversationID, actorID, userID, action)
+	if err != nil {
+		log.Printf("err remove conversation member: %v\n", err)
+		return err
+	}
+	if !removed {
+		return ErrConversationNotFound
+	}
+
+	s.publishMembersEvent(cursors, models.MembersPayload{
+		ConversationID: conversationID,
+		Action:         action,
+		ActorID:        actorID,
+		UserIDs:        []int{userID},
+	})
+
+	if newOwner != 0 {
+		s.publishMembersEvent(ownerCursors, models.MembersPayload{
+			ConversationID: conversationID,
+			Action:         models.MembersRole,
+			ActorID:        actorID,
+			UserIDs:        []int{newOwner},
+			Role:           models.RoleOwner,
+		})
+	}
+
+	return nil
+}
+
+// publishMembersEvent рассылает изменение состава всем, в чей журнал оно записано.
+func (s *Service) publishMembersEvent(cursors map[int]int64, payload models.MembersPayload) {
+	env, err := newEnvelope(models.EventConversationMembers, "", payload)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	s.publishCursors(env, cursors, 0, "")
+}
+
+// memberRole возвращает роль участника; ErrConversationNotFound, если userID не состоит в беседе.
+func (s *Service) memberRole(conversationID, userID int) (string, error) {
+	role, member, err := s.repo.GetMemberRole(conversationID, userID)
+	if err != nil {
+		log.Printf("err get member role: %v\n", err)
+		return "", err
+	}
+	if !member {
+		return "", ErrConversationNotFound
+	}
+
+	return role, nil
+}
+
+// groupRole - то же, что memberRole, но только для групп: личную переписку нельзя пополнять или покидать.
+func (s *Service) groupRole(conversationID, userID int) (string, error) {
+	role, err := s.memberRole(conversationID, userID)
+	if err != nil {
+		return "", err
+	}
+
+	conversation, exists, err := s.repo.GetConversation(conversationID)
+	if err != nil {
+		log.Printf("err get conversation: %v\n", err)
+		return "", err
+	}
+	if !exists || conversation.Type != models.ConversationGroup {
+		return "", ErrConversationNotFound
+	}
+
+	return role, nil
+}
+
+// checkInvitees не даёт добавить в группу того, кто заблокировал приглашающего или заблокирован им.
+func (s *Service) checkInvitees(actorID int, userIDs []int) error {
+	for _, userID := range userIDs {
+		blocked, err := s.repo.IsBlocked(actorID, userID)
+		if err != nil {
+			log.Printf("err check block: %v\n", err)
+			return err
+		}
+		if blocked {
+			return fmt.Errorf("%w: user %d can't be added", ErrConversationRights, userID)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) sendConversationError(client *connection_manager.Client, id string, err error, internal string) {
+	switch {
+	case errors.Is(err, ErrConversationNotFound):
+		s.sendError(client, id, models.ErrCodeNotFound, err.Error())
+	case errors.Is(err, ErrConversationRights):
+		s.sendError(client, id, models.ErrCodeForbidden, err.Error())
+	case errors.Is(err, ErrConversationFull), errors.Is(err, ErrInvalidConversation):
+		s.sendError(client, id, models.ErrCodeBadRequest, err.Error())
+	default:
+		s.sendError(client, id, models.ErrCodeInternal, internal)
+	}
+}
+
+func outranks(actor, target string) bool {
+	rank := map[string]int{models.RoleMember: 0, models.RoleAdmin: 1, models.RoleOwner: 2}
+	return rank[actor] > rank[target]
+}
+
+// uniqueIDs убирает повторы, неположительные ID и self.
+func uniqueIDs(ids []int, self int) []int {
+	seen := make(map[int]struct{}, len(ids))
+	result := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if id <= 0 || id == self {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		result = append(result, id)
+	}
+
+	return result
+}
+
+func keys(m map[int]int64) []int {
+	result := make([]int, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+
+	return result
+}
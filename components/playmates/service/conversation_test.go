@@ -0,0 +1,191 @@
+package service
+
+import (
+	"errors"
+	"playmates/components/playmates/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutranks(t *testing.T) {
+	tests := []struct {
+		actor, target string
+		want          bool
+	}{
+		{models.RoleOwner, models.RoleAdmin, true},
+		{models.RoleOwner, models.RoleMember, true},
+		{models.RoleAdmin, models.RoleMember, true},
+		{models.RoleAdmin, models.RoleAdmin, false},
+		{models.RoleAdmin, models.RoleOwner, false},
+		{models.RoleMember, models.RoleMember, false},
+	}
+
+	for _, tt := range tests {
+		if got := outranks(tt.actor, tt.target); got != tt.want {
+			t.Errorf("outranks(%s, %s) = %v, want %v", tt.actor, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestUniqueIDs(t *testing.T) {
+	got := uniqueIDs([]int{3, 1, 3, 0, -2, 5, 1}, 1)
+	if want := []int{3, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("uniqueIDs() = %v, want %v", got, want)
+	}
+}
+
+// Некорректная группа отклоняется до обращения к базе.
+func TestCreateConversationRejectsInvalid(t *testing.T) {
+	tooMany := make([]int, maxConversationMembers)
+	for i := range tooMany {
+		tooMany[i] = i + 2
+	}
+
+	tests := []struct {
+		name    string
+		title   string
+		members []int
+		wantErr error
+	}{
+		{"empty title", "  ", []int{2}, ErrInvalidConversation},
+		{"long title", strings.Repeat("т", maxConversationTitle+1), []int{2}, ErrInvalidConversation},
+		{"no members", "squad", nil, ErrInvalidConversation},
+		{"only owner", "squad", []int{1, 1}, ErrInvalidConversation},
+		{"too many members", "squad", tooMany, ErrConversationFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			if _, err := s.CreateConversation(1, tt.title, tt.members); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateConversation() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetMemberRoleRejectsOwnerRole(t *testing.T) {
+	s := &Service{}
+	if err := s.SetMemberRole(1, 10, 2, models.RoleOwner); !errors.Is(err, ErrInvalidConversation) {
+		t.Fatalf("SetMemberRole(owner) error = %v, want ErrInvalidConversation", err)
+	}
+}
+
+func TestSendConversationError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{ErrConversationNotFound, models.ErrCodeNotFound},
+		{ErrConversationRights, models.ErrCodeForbidden},
+		{ErrConversationFull, models.ErrCodeBadRequest},
+		{ErrInvalidConversation, models.ErrCodeBadRequest},
+		{errors.New("pq: timeout"), models.ErrCodeInternal},
+	}
+
+	for _, tt := range tests {
+		s := &Service{}
+		client, conn := addTestClient(t, s, 1)
+
+		s.sendConversationError(client, "g1", tt.err, "failed")
+		assertError(t, nextEnvelope(t, conn), "g1", tt.code)
+	}
+}
+
+func memberRoles(t *testing.T, s *Service, userID, conversationID int) map[int]string {
+	t.Helper()
+
+	conversation, err := s.GetConversation(userID, conversationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles := make(map[int]string, len(conversation.Members))
+	for _, member := range conversation.Members {
+		roles[member.UserID] = member.Role
+	}
+
+	return roles
+}
+
+func TestConversationRoles(t *testing.T) {
+	s, conn := testService(t)
+	owner := insertTestUser(t, conn, "owner")
+	admin := insertTestUser(t, conn, "admin")
+	member := insertTestUser(t, conn, "member")
+	guest := insertTestUser(t, conn, "guest")
+	outsider := insertTestUser(t, conn, "outsider")
+
+	conversation, err := s.CreateConversation(owner, "squad", []int{admin, member, member})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Exec("DELETE FROM conversations WHERE id = $1", conversation.ID) })
+
+	if _, err = s.GetConversation(outsider, conversation.ID); !errors.Is(err, ErrConversationNotFound) {
+		t.Fatalf("outsider sees the group: %v", err)
+	}
+
+	if err = s.SetMemberRole(owner, conversation.ID, admin, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Участник не приглашает и не назначает, администратор не назначает
+	if _, err = s.AddMembers(member, conversation.ID, []int{guest}); !errors.Is(err, ErrConversationRights) {
+		t.Fatalf("member added users: %v", err)
+	}
+	if err = s.SetMemberRole(admin, conversation.ID, member, models.RoleAdmin); !errors.Is(err, ErrConversationRights) {
+		t.Fatalf("admin changed roles: %v", err)
+	}
+
+	added, err := s.AddMembers(admin, conversation.ID, []int{guest, member})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(added, []int{guest}) {
+		t.Fatalf("added = %v, want only the new user", added)
+	}
+
+	// Администратор исключает только участников
+	if err = s.KickMember(admin, conversation.ID, owner); !errors.Is(err, ErrConversationRights) {
+		t.Fatalf("admin kicked the owner: %v", err)
+	}
+	if err = s.KickMember(member, conversation.ID, guest); !errors.Is(err, ErrConversationRights) {
+		t.Fatalf("member kicked a member: %v", err)
+	}
+	if err = s.KickMember(admin, conversation.ID, guest); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.KickMember(admin, conversation.ID, guest); !errors.Is(err, ErrInvalidConversation) {
+		t.Fatalf("kick of a non-member: %v", err)
+	}
+
+	// Ушедший владелец передаёт группу администратору
+	if err = s.LeaveConversation(owner, conversation.ID); err != nil {
+		t.Fatal(err)
+	}
+	roles := memberRoles(t, s, admin, conversation.ID)
+	want := map[int]string{admin: models.RoleOwner, member: models.RoleMember}
+	if !reflect.DeepEqual(roles, want) {
+		t.Fatalf("roles after owner left = %v, want %v", roles, want)
+	}
+}
+
+// Личную переписку нельзя пополнять или покидать как группу.
+func TestDirectConversationIsNotGroup(t *testing.T) {
+	s, conn := testService(t)
+	alice, bob, eve := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob"), insertTestUser(t, conn, "eve")
+
+	conversationID, err := s.repo.EnsureDirectConversation(alice, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = s.AddMembers(alice, conversationID, []int{eve}); !errors.Is(err, ErrConversationNotFound) {
+		t.Fatalf("direct chat accepted a member: %v", err)
+	}
+	if err = s.LeaveConversation(alice, conversationID); !errors.Is(err, ErrConversationNotFound) {
+		t.Fatalf("left a direct chat: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"playmates/components/playmates/models"
+)
+
+const (
+	maxDevices     = 10
+	maxDeviceIDLen = 64
+)
+
+var (
+	ErrInvalidKeys    = errors.New("invalid device keys")
+	ErrTooManyDevices = errors.New("too many devices")
+	ErrKeysNotFound   = errors.New("keys not found")
+)
+
+// PublishDeviceKeys публикует ключи устройства; повторная публикация заменяет signed prekey.
+func (s *Service) PublishDeviceKeys(userID int, keys models.DeviceKeys) (models.DeviceKeys, error) {
+	if !validDeviceID(keys.DeviceID) || !validPublicKey(keys.IdentityKey) ||
+		keys.SignedPrekey.ID <= 0 || !validPublicKey(keys.SignedPrekey.PublicKey) ||
+		len(keys.SignedPrekey.Signature) != 64 {
+		return models.DeviceKeys{}, ErrInvalidKeys
+	}
+
+	saved, ok, err := s.repo.SetDeviceKeys(userID, keys, maxDevices)
+	if err != nil {
+		log.Printf("err set device keys: %v\n", err)
+		return models.DeviceKeys{}, err
+	}
+	if !ok {
+		return models.DeviceKeys{}, ErrTooManyDevices
+	}
+
+	return saved, nil
+}
+
+// GetKeyBundle возвращает ключи устройств targetID, чтобы зашифровать ему сообщение.
+// При блокировке в любую сторону ключи не отдаются, как будто их нет.
+func (s *Service) GetKeyBundle(userID, targetID int) (models.KeyBundle, error) {
+	if targetID != userID {
+		blocked, err := s.repo.IsBlocked(userID, targetID)
+		if err != nil {
+			log.Printf("err check block: %v\n", err)
+			return models.KeyBundle{}, err
+		}
+		if blocked {
+			return models.KeyBundle{}, ErrKeysNotFound
+		}
+	}
+
+	devices, err := s.repo.GetDeviceKeys(targetID)
+	if err != nil {
+		log.Printf("err get device keys: %v\n", err)
+		return models.KeyBundle{}, err
+	}
+	if len(devices) == 0 {
+		return models.KeyBundle{}, ErrKeysNotFound
+	}
+
+	return models.KeyBundle{UserID: targetID, Devices: devices}, nil
+}
+
+func (s *Service) DeleteDeviceKeys(userID int, deviceID string) error {
+	ok, err := s.repo.DeleteDeviceKeys(userID, deviceID)
+	if err != nil {
+		log.Printf("err delete device keys: %v\n", err)
+		return err
+	}
+	if !ok {
+		return ErrKeysNotFound
+	}
+
+	return nil
+}
+
+func validDeviceID(id string) bool {
+	if id == "" || len(id) > maxDeviceIDLen {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// validPublicKey - Curve25519: 32 байта или 33 с байтом типа ключа, как в Signal.
+func validPublicKey(key []byte) bool {
+	return len(key) == 32 || len(key) == 33
+}
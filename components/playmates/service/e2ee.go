@@ -0,0 +1,135 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"playmates/components/blindindex"
+	"playmates/components/playmates/models"
+	"strings"
+)
+
+const (
+	// Шифротекст содержит копии сообщения для всех устройств собеседников
+	maxCiphertextSize = 32 << 10
+	// Что показывать в списке чатов вместо текста e2ee-сообщений
+	e2eePreview = "Encrypted message"
+)
+
+var (
+	ErrInvalidCiphertext = errors.New("invalid e2ee message")
+	ErrE2EERequired      = errors.New("conversation is end-to-end encrypted, message must be sent as ciphertext")
+	ErrEncryptedMessage  = errors.New("end-to-end encrypted messages can't be edited")
+	ErrE2EEKeysMissing   = errors.New("both users must publish device keys to enable end-to-end encryption")
+)
+
+// checkEncryptedContent проверяет e2ee-сообщение. Содержимое сервер не видит, поэтому только
+// размер; вложения шифруются ключом сервера и в e2ee-сообщениях не допускаются.
+func checkEncryptedContent(payload models.MessageSendPayload) error {
+	if len(payload.Ciphertext) > maxCiphertextSize || strings.TrimSpace(payload.Msg) != "" || len(payload.AttachmentIDs) > 0 {
+		return ErrInvalidCiphertext
+	}
+	return nil
+}
+
+// directMessageBody готовит то, что ляжет в messages.message: обычный текст шифруется ключом
+// беседы и индексируется для поиска, шифротекст e2ee сохраняется как есть и не индексируется.
+func (s *Service) directMessageBody(conversationID, senderID int, payload models.MessageSendPayload) (int, string, []byte, blindindex.Tokens, error) {
+	if len(payload.Ciphertext) > 0 {
+		messageID, err := s.repo.NextMessageID()
+		if err != nil {
+			log.Printf("err reserve message id: %v\n", err)
+			return 0, "", nil, blindindex.Tokens{}, err
+		}
+		return messageID, models.MessageKindE2EE, payload.Ciphertext, blindindex.Tokens{}, nil
+	}
+
+	// Открытый текст в e2ee-беседу - скорее всего клиент без поддержки E2EE, молча понижать не даём
+	e2ee, err := s.repo.IsConversationE2EE(conversationID)
+	if err != nil {
+		log.Printf("err get conversation: %v\n", err)
+		return 0, "", nil, blindindex.Tokens{}, err
+	}
+	if e2ee {
+		return 0, "", nil, blindindex.Tokens{}, ErrE2EERequired
+	}
+
+	messageID, sealed, err := s.sealNewMessage(conversationID, senderID, payload.ReceiverID, payload.Msg)
+	if err != nil {
+		log.Printf("err encrypt message: %v\n", err)
+		return 0, "", nil, blindindex.Tokens{}, err
+	}
+
+	return messageID, models.MessageKindText, sealed, s.index.Tokens(conversationID, payload.Msg), nil
+}
+
+// GetChatE2EE - включено ли E2EE в личной переписке с peerID.
+func (s *Service) GetChatE2EE(userID, peerID int) (bool, error) {
+	conversationID, exists, err := s.repo.GetDirectConversationID(userID, peerID)
+	if err != nil {
+		log.Printf("err get direct conversation: %v\n", err)
+		return false, err
+	}
+	if !exists {
+		return false, nil
+	}
+
+	e2ee, err := s.repo.IsConversationE2EE(conversationID)
+	if err != nil {
+		log.Printf("err get conversation: %v\n", err)
+		return false, err
+	}
+
+	return e2ee, nil
+}
+
+// SetChatE2EE включает или выключает E2EE в личной переписке с peerID. Настройка общая:
+// менять её может любой из собеседников, оба получают chat.e2ee. Включить можно, только
+// когда ключи устройств опубликовали оба, иначе одному из них нечем шифровать.
+func (s *Service) SetChatE2EE(userID, peerID int, enabled bool) error {
+	if enabled {
+		own, err := s.repo.GetDeviceKeys(userID)
+		if err != nil {
+			log.Printf("err get device keys: %v\n", err)
+			return err
+		}
+		if len(own) == 0 {
+			return ErrE2EEKeysMissing
+		}
+
+		// Ключи собеседника не видны и при блокировке
+		if _, err = s.GetKeyBundle(userID, peerID); err != nil {
+			if errors.Is(err, ErrKeysNotFound) {
+				return ErrE2EEKeysMissing
+			}
+			return err
+		}
+	} else {
+		_, exists, err := s.repo.GetDirectConversationID(userID, peerID)
+		if err != nil {
+			log.Printf("err get direct conversation: %v\n", err)
+			return err
+		}
+		// Выключать в беседе, которой ещё нет, нечего
+		if !exists {
+			return nil
+		}
+	}
+
+	payload, cursors, changed, err := s.repo.SetDirectE2EE(userID, peerID, enabled)
+	if err != nil {
+		log.Printf("err set e2ee: %v\n", err)
+		return err
+	}
+
+	if changed {
+		env, err := newEnvelope(models.EventChatE2EE, "", payload)
+		if err != nil {
+			log.Println("Error encoding event:", err)
+			return nil
+		}
+		// Настройка меняется через REST, поэтому событие получают и все устройства инициатора
+		s.publishCursors(env, cursors, 0, "")
+	}
+
+	return nil
+}
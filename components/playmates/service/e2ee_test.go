@@ -0,0 +1,159 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"playmates/components/datakeys"
+	"playmates/components/keyprovider"
+	"playmates/components/playmates/models"
+	"playmates/components/sealer"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyStore - conversations.data_key в памяти для datakeys.Keys.
+type keyStore struct {
+	mu   sync.Mutex
+	keys map[int][]byte
+}
+
+func (k *keyStore) GetConversationKey(conversationID int) ([]byte, bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.keys[conversationID], true, nil
+}
+
+func (k *keyStore) InitConversationKey(conversationID int, wrapped []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys[conversationID] == nil {
+		k.keys[conversationID] = wrapped
+	}
+	return k.keys[conversationID], nil
+}
+
+func newTestDataKeys(t *testing.T) *datakeys.Keys {
+	t.Helper()
+
+	server, err := sealer.New([]byte(strings.Repeat("s", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return datakeys.New(&keyStore{keys: make(map[int][]byte)}, keyprovider.NewLocal(server), server, true)
+}
+
+// Клиентский шифротекст похож на что угодно, в том числе на шифротекст сервера
+var clientCiphertext = []byte{0xFE, 0x01, 0x00, 0x01, 'o', 'p', 'a', 'q', 'u', 'e'}
+
+func TestCheckEncryptedContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload models.MessageSendPayload
+		wantErr bool
+	}{
+		{"ciphertext", models.MessageSendPayload{ReceiverID: 2, Ciphertext: clientCiphertext}, false},
+		{"ciphertext with text", models.MessageSendPayload{ReceiverID: 2, Ciphertext: clientCiphertext, Msg: "leak"}, true},
+		{"ciphertext with attachments", models.MessageSendPayload{ReceiverID: 2, Ciphertext: clientCiphertext, AttachmentIDs: []int{1}}, true},
+		{"too large", models.MessageSendPayload{ReceiverID: 2, Ciphertext: make([]byte, maxCiphertextSize+1)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkEncryptedContent(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkEncryptedContent() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidCiphertext) {
+				t.Errorf("checkEncryptedContent() = %v, want ErrInvalidCiphertext", err)
+			}
+		})
+	}
+}
+
+// Сохранённый шифротекст e2ee отдаётся как есть: сервер его не расшифровывает и не трогает.
+func TestOpenMessageKeepsCiphertextOpaque(t *testing.T) {
+	// Без dataKeys: попытка расшифровать упала бы
+	s := &Service{}
+
+	stored := append([]byte(nil), clientCiphertext...)
+	message, err := s.openMessage(models.MessageDB{
+		ID: 10, ConversationID: 1, SenderID: 1, ReceiverID: 2,
+		Msg: stored, SealVersion: models.MessageSealVersion, Kind: models.MessageKindE2EE,
+	})
+	if err != nil {
+		t.Fatalf("openMessage() = %v", err)
+	}
+
+	if !bytes.Equal(message.Ciphertext, clientCiphertext) || message.Msg != "" || message.Kind != models.MessageKindE2EE {
+		t.Errorf("openMessage() = %+v, want ciphertext as stored and empty msg", message)
+	}
+}
+
+func TestNewMessageEnvelopeRelaysCiphertext(t *testing.T) {
+	s := &Service{}
+
+	env, err := s.newMessageEnvelope(models.Message{
+		ID: 10, ConversationID: 1, SenderID: 1, ReceiverID: 2,
+		Kind: models.MessageKindE2EE, Ciphertext: clientCiphertext, Time: time.Now(),
+	}, false)
+	if err != nil {
+		t.Fatalf("newMessageEnvelope() = %v", err)
+	}
+	if env.Type != models.EventMessageNew {
+		t.Errorf("envelope type = %q, want %q", env.Type, models.EventMessageNew)
+	}
+
+	var relayed models.Message
+	if err = json.Unmarshal(env.Payload, &relayed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(relayed.Ciphertext, clientCiphertext) || relayed.Msg != "" || relayed.Kind != models.MessageKindE2EE {
+		t.Errorf("relayed message = %+v, want ciphertext as sent", relayed)
+	}
+}
+
+func TestLastMessagePreview(t *testing.T) {
+	keys := newTestDataKeys(t)
+	s := &Service{dataKeys: keys}
+
+	text := models.MessageDB{ID: 10, ConversationID: 1, SenderID: 1, ReceiverID: 2}
+	sealed, err := keys.SealMessage(text, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chat := func(e2ee bool, kind string, msg []byte) models.ChatPreviewDB {
+		return models.ChatPreviewDB{
+			Type: models.ConversationDirect, ConversationID: 1, LastMessageID: 10, SenderID: 1, ReceiverID: 2,
+			E2EE: e2ee, LastKind: kind, LastMessage: msg, LastSealVersion: models.MessageSealVersion,
+		}
+	}
+	deleted := chat(false, models.MessageKindText, nil)
+	deleted.LastDeletedAt = &time.Time{}
+
+	tests := []struct {
+		name string
+		chat models.ChatPreviewDB
+		want string
+	}{
+		{"text", chat(false, models.MessageKindText, sealed), "hello"},
+		{"e2ee message", chat(false, models.MessageKindE2EE, clientCiphertext), e2eePreview},
+		// E2EE включили после обычного сообщения - его текст в списке тоже не показываем
+		{"text in e2ee chat", chat(true, models.MessageKindText, sealed), e2eePreview},
+		{"deleted", deleted, ""},
+		{"no messages", models.ChatPreviewDB{Type: models.ConversationGroup, ConversationID: 3}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.lastMessagePreview(tt.chat)
+			if err != nil || got != tt.want {
+				t.Errorf("lastMessagePreview() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"playmates/components/blindindex"
+	"playmates/components/playmates/models"
+)
+
+const (
+	defaultMessageSearchSize = 20
+	maxMessageSearchSize     = 100
+)
+
+var ErrSearchQuery = errors.New("query must contain a word of at least 2 letters or a number")
+
+// SearchChat ищет по личной переписке с peerID. Результат - от новых к старым, следующая
+// страница запрашивается с before = ID последнего сообщения.
+func (s *Service) SearchChat(userID, peerID int, query string, before, limit int) (models.MessagePage, error) {
+	conversationID, exists, err := s.repo.GetDirectConversationID(userID, peerID)
+	if err != nil {
+		log.Printf("err get direct conversation: %v\n", err)
+		return models.MessagePage{}, err
+	}
+	if !exists {
+		if len(blindindex.Words(query)) == 0 {
+			return models.MessagePage{}, ErrSearchQuery
+		}
+		return models.MessagePage{Messages: []models.Message{}}, nil
+	}
+
+	return s.searchMessages(userID, conversationID, query, before, limit)
+}
+
+func (s *Service) SearchConversation(userID, conversationID int, query string, before, limit int) (models.MessagePage, error) {
+	if _, err := s.memberRole(conversationID, userID); err != nil {
+		return models.MessagePage{}, err
+	}
+
+	return s.searchMessages(userID, conversationID, query, before, limit)
+}
+
+// searchMessages находит сообщения, в которых есть все слова запроса. База видит только
+// HMAC-токены слов, текст расшифровывается уже после выборки.
+func (s *Service) searchMessages(userID, conversationID int, query string, before, limit int) (models.MessagePage, error) {
+	tokens := s.index.Tokens(conversationID, query)
+	if len(tokens.Values) == 0 {
+		return models.MessagePage{}, ErrSearchQuery
+	}
+
+	if limit <= 0 || limit > maxMessageSearchSize {
+		limit = defaultMessageSearchSize
+	}
+
+	msgsDB, hasMore, err := s.repo.SearchMessages(userID, conversationID, tokens.Values, before, limit)
+	if err != nil {
+		log.Printf("err search messages: %v\n", err)
+		return models.MessagePage{}, err
+	}
+
+	msgs := make([]models.Message, len(msgsDB))
+	for i, msg := range msgsDB {
+		msgs[i], err = s.openMessage(msg)
+		if err != nil {
+			log.Printf("err decrypt message: %v\n", err)
+			return models.MessagePage{}, err
+		}
+	}
+
+	if err = s.enrichMessages(userID, msgs); err != nil {
+		return models.MessagePage{}, err
+	}
+
+	return models.MessagePage{Messages: msgs, HasMore: hasMore}, nil
+}
@@ -0,0 +1,433 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"playmates/components/connection-manager"
+	"playmates/components/playmates/models"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultMessagePageSize = 50
+	maxMessagePageSize     = 200
+	// Длина текста в цитате ответа, в рунах
+	maxQuoteLength = 100
+)
+
+type MessagesConfig struct {
+	EditWindow time.Duration
+	// Эмодзи, которые можно ставить реакцией
+	Reactions []string
+	// Сколько сообщений можно закрепить в одной переписке
+	MaxPins int
+}
+
+var (
+	ErrMessageNotFound  = errors.New("message not found")
+	ErrNotMessageSender = errors.New("only the sender can change the message")
+	ErrEditWindowPassed = errors.New("message can no longer be edited")
+	ErrInvalidMessage   = errors.New("invalid message length")
+	ErrReplyNotFound    = fmt.Errorf("reply %w", ErrMessageNotFound)
+)
+
+func validMessage(msg string) bool {
+	return strings.TrimSpace(msg) != "" && utf8.RuneCountInString(msg) <= maxMessageLength
+}
+
+func (s *Service) EditMessage(userID, messageID int, text string) (models.Message, error) {
+	return s.editMessage(userID, messageID, text, "")
+}
+
+func (s *Service) editMessage(userID, messageID int, text, exceptConn string) (models.Message, error) {
+	if !validMessage(text) {
+		return models.Message{}, ErrInvalidMessage
+	}
+
+	// Беседа нужна для токенов поиска; права проверяет сам EditMessage
+	current, exists, err := s.repo.GetMessage(messageID)
+	if err != nil {
+		log.Printf("err get message: %v\n", err)
+		return models.Message{}, err
+	}
+	if !exists {
+		return models.Message{}, ErrMessageNotFound
+	}
+	// Чужое сообщение отклонит EditMessage с понятной ошибкой
+	if current.Kind == models.MessageKindE2EE && current.SenderID == userID {
+		return models.Message{}, ErrEncryptedMessage
+	}
+
+	sealedMsg, err := s.dataKeys.SealMessage(current, []byte(text))
+	if err != nil {
+		log.Printf("err encrypt message: %v\n", err)
+		return models.Message{}, err
+	}
+
+	tokens := s.index.Tokens(current.ConversationID, text)
+	saved, cursors, ok, err := s.repo.EditMessage(messageID, userID, sealedMsg, tokens, time.Now().Add(-s.messages.EditWindow))
+	if err != nil {
+		log.Printf("err edit message: %v\n", err)
+		return models.Message{}, err
+	}
+	if !ok {
+		return models.Message{}, s.explainRejected(userID, messageID, true, ErrEditWindowPassed)
+	}
+
+	message, err := s.openMessage(saved)
+	if err != nil {
+		log.Printf("err decrypt message: %v\n", err)
+		return models.Message{}, err
+	}
+
+	// Правку видят все участники, поэтому реакции с точки зрения одного пользователя не кладём
+	msgs := []models.Message{message}
+	if err = s.withContent(msgs); err != nil {
+		return models.Message{}, err
+	}
+	message = msgs[0]
+
+	s.publishMessageEvent(models.EventMessageEdited, message, cursors, exceptConn)
+
+	return message, nil
+}
+
+// DeleteMessage удаляет сообщение у всех (только отправитель) или только у себя.
+func (s *Service) DeleteMessage(userID, messageID int, forEveryone bool) error {
+	return s.deleteMessage(userID, messageID, forEveryone, "")
+}
+
+func (s *Service) deleteMessage(userID, messageID int, forEveryone bool, exceptConn string) error {
+	if !forEveryone {
+		cursors, ok, err := s.repo.HideMessage(messageID, userID)
+		if err != nil {
+			log.Printf("err hide message: %v\n", err)
+			return err
+		}
+		if !ok {
+			// Повторное удаление у себя - не ошибка, если пользователь участник переписки
+			return s.explainRejected(userID, messageID, false, nil)
+		}
+
+		env, err := newEnvelope(models.EventMessageHidden, "", models.MessageHiddenPayload{MessageID: messageID})
+		if err != nil {
+			log.Println("Error encoding event:", err)
+			return nil
+		}
+		env.Cursor = cursors[userID]
+		s.publish(userID, exceptConn, env)
+
+		return nil
+	}
+
+	saved, cursors, ok, err := s.repo.DeleteMessage(messageID, userID)
+	if err != nil {
+		log.Printf("err delete message: %v\n", err)
+		return err
+	}
+	if !ok {
+		// Уже удалённое сообщение удаляем повторно без ошибки
+		return s.explainRejected(userID, messageID, true, nil)
+	}
+
+	message, err := s.openMessage(saved)
+	if err != nil {
+		return err
+	}
+
+	s.publishMessageEvent(models.EventMessageDeleted, message, cursors, exceptConn)
+
+	return nil
+}
+
+// GetMessageHistory возвращает предыдущие версии сообщения, от старых к новым.
+func (s *Service) GetMessageHistory(userID, messageID int) ([]models.MessageEdit, error) {
+	msg, exists, err := s.repo.GetMessage(messageID)
+	if err != nil {
+		log.Printf("err get message: %v\n", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrMessageNotFound
+	}
+
+	visible, err := s.canSeeMessage(userID, msg)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, ErrMessageNotFound
+	}
+
+	editsDB, err := s.repo.GetMessageEdits(messageID)
+	if err != nil {
+		log.Printf("err get message edits: %v\n", err)
+		return nil, err
+	}
+
+	edits := make([]models.MessageEdit, len(editsDB))
+	for i, edit := range editsDB {
+		// Прошлые версии привязаны к тем же полям сообщения, но могли быть зашифрованы по-старому
+		msg.Msg, msg.SealVersion = edit.Msg, edit.SealVersion
+		text, err := s.dataKeys.OpenMessage(msg)
+		if err != nil {
+			log.Printf("err decrypt message edit: %v\n", err)
+			return nil, err
+		}
+		edits[i] = models.MessageEdit{Msg: string(text), Time: edit.Time}
+	}
+
+	return edits, nil
+}
+
+// sealNewMessage резервирует ID нового сообщения и шифрует текст ключом беседы с привязкой
+// к ID, беседе и собеседникам. receiverID = 0 для групп.
+func (s *Service) sealNewMessage(conversationID, senderID, receiverID int, text string) (int, []byte, error) {
+	messageID, err := s.repo.NextMessageID()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sealed, err := s.dataKeys.SealMessage(models.MessageDB{
+		ID:             messageID,
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		ReceiverID:     receiverID,
+	}, []byte(text))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return messageID, sealed, nil
+}
+
+// checkReply проверяет, что сообщение, на которое отвечают, есть и лежит в той же переписке.
+func (s *Service) checkReply(userID int, payload models.MessageSendPayload) error {
+	if payload.ReplyToID == 0 {
+		return nil
+	}
+	if payload.ReplyToID < 0 {
+		return ErrReplyNotFound
+	}
+
+	parent, exists, err := s.repo.GetMessage(payload.ReplyToID)
+	if err != nil {
+		log.Printf("err get message: %v\n", err)
+		return err
+	}
+	if !exists || parent.DeletedAt != nil {
+		return ErrReplyNotFound
+	}
+
+	if payload.ConversationID > 0 {
+		if parent.ConversationID != payload.ConversationID {
+			return ErrReplyNotFound
+		}
+		return nil
+	}
+
+	samePair := (parent.SenderID == userID && parent.ReceiverID == payload.ReceiverID) ||
+		(parent.SenderID == payload.ReceiverID && parent.ReceiverID == userID)
+	if !samePair {
+		return ErrReplyNotFound
+	}
+
+	return nil
+}
+
+// enrichMessages дополняет сообщения всем, что хранится отдельно от текста. Реакции
+// считаются с точки зрения userID.
+func (s *Service) enrichMessages(userID int, msgs []models.Message) error {
+	if err := s.withContent(msgs); err != nil {
+		return err
+	}
+	return s.withReactions(userID, msgs)
+}
+
+// withContent - вложения и цитаты, одинаковые для всех участников.
+func (s *Service) withContent(msgs []models.Message) error {
+	if err := s.withAttachments(msgs); err != nil {
+		return err
+	}
+	return s.withQuotes(msgs)
+}
+
+// withQuotes заполняет цитаты ответов расшифрованным и обрезанным текстом исходных сообщений.
+func (s *Service) withQuotes(msgs []models.Message) error {
+	var ids []int
+	for _, msg := range msgs {
+		if msg.ReplyTo != nil {
+			ids = append(ids, msg.ReplyTo.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	parents, err := s.repo.GetMessagesByIDs(ids)
+	if err != nil {
+		log.Printf("err get messages by ids: %v\n", err)
+		return err
+	}
+
+	for i, msg := range msgs {
+		if msg.ReplyTo == nil {
+			continue
+		}
+
+		parent, exists := parents[msg.ReplyTo.ID]
+		if !exists {
+			msgs[i].ReplyTo = nil
+			continue
+		}
+
+		quote := &models.MessageQuote{ID: parent.ID, SenderID: parent.SenderID}
+		if parent.DeletedAt != nil {
+			quote.Deleted = true
+		} else if parent.Kind == models.MessageKindE2EE {
+			quote.Encrypted = true
+		} else {
+			text, err := s.dataKeys.OpenMessage(parent)
+			if err != nil {
+				log.Printf("err decrypt message: %v\n", err)
+				return err
+			}
+			quote.Msg = truncateRunes(string(text), maxQuoteLength)
+		}
+		msgs[i].ReplyTo = quote
+	}
+
+	return nil
+}
+
+func replyRef(replyToID *int) *models.MessageQuote {
+	if replyToID == nil {
+		return nil
+	}
+	return &models.MessageQuote{ID: *replyToID}
+}
+
+func truncateRunes(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	return string([]rune(text)[:limit]) + "…"
+}
+
+// explainRejected выясняет, почему репозиторий не изменил сообщение. fallback - ошибка для случая,
+// когда права есть, но сообщение уже не подходит; nil означает, что операция уже была выполнена.
+func (s *Service) explainRejected(userID, messageID int, senderOnly bool, fallback error) error {
+	msg, exists, err := s.repo.GetMessage(messageID)
+	if err != nil {
+		log.Printf("err get message: %v\n", err)
+		return err
+	}
+
+	if !exists {
+		return ErrMessageNotFound
+	}
+
+	visible, err := s.canSeeMessage(userID, msg)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !visible:
+		return ErrMessageNotFound
+	case senderOnly && msg.SenderID != userID:
+		return ErrNotMessageSender
+	case msg.DeletedAt != nil && fallback != nil:
+		return ErrMessageNotFound
+	default:
+		return fallback
+	}
+}
+
+// canSeeMessage - участник ли userID переписки, в которой отправлено сообщение.
+func (s *Service) canSeeMessage(userID int, msg models.MessageDB) (bool, error) {
+	if msg.SenderID == userID || msg.ReceiverID == userID {
+		return true, nil
+	}
+	if msg.ReceiverID != 0 {
+		return false, nil
+	}
+
+	_, member, err := s.repo.GetMemberRole(msg.ConversationID, userID)
+	if err != nil {
+		log.Printf("err get member role: %v\n", err)
+		return false, err
+	}
+
+	return member, nil
+}
+
+// publishMessageEvent рассылает изменение сообщения всем участникам, кроме соединения-инициатора.
+func (s *Service) publishMessageEvent(eventType string, message models.Message, cursors map[int]int64, exceptConn string) {
+	env, err := newEnvelope(eventType, "", message)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	s.publishCursors(env, cursors, message.SenderID, exceptConn)
+}
+
+func (s *Service) handleMessageEdit(client *connection_manager.Client, env models.Envelope) {
+	var payload models.MessageEditPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil || payload.MessageID <= 0 {
+		s.sendError(client, env.ID, models.ErrCodeBadRequest, "invalid payload")
+		return
+	}
+
+	message, err := s.editMessage(client.UserID, payload.MessageID, payload.Msg, client.ID)
+	if err != nil {
+		s.sendMessageError(client, env.ID, err, "failed to edit message")
+		return
+	}
+
+	s.sendEventToClient(client, models.EventAck, env.ID, models.AckPayload{
+		MessageID: message.ID,
+		Time:      *message.EditedAt,
+	})
+}
+
+func (s *Service) handleMessageDelete(client *connection_manager.Client, env models.Envelope) {
+	var payload models.MessageDeletePayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil || payload.MessageID <= 0 {
+		s.sendError(client, env.ID, models.ErrCodeBadRequest, "invalid payload")
+		return
+	}
+
+	if err := s.deleteMessage(client.UserID, payload.MessageID, payload.ForEveryone, client.ID); err != nil {
+		s.sendMessageError(client, env.ID, err, "failed to delete message")
+		return
+	}
+
+	s.sendEventToClient(client, models.EventAck, env.ID, models.AckPayload{
+		MessageID: payload.MessageID,
+		Time:      time.Now(),
+	})
+}
+
+// sendMessageError переводит ошибку операции с сообщением в код протокола. Текст внутренних
+// ошибок клиенту не показываем.
+func (s *Service) sendMessageError(client *connection_manager.Client, id string, err error, internal string) {
+	switch {
+	case errors.Is(err, ErrMessageNotFound):
+		s.sendError(client, id, models.ErrCodeNotFound, err.Error())
+	case errors.Is(err, ErrNotMessageSender), errors.Is(err, ErrEditWindowPassed):
+		s.sendError(client, id, models.ErrCodeForbidden, err.Error())
+	case errors.Is(err, ErrAttachmentNotFound):
+		s.sendError(client, id, models.ErrCodeNotFound, err.Error())
+	case errors.Is(err, ErrInvalidMessage), errors.Is(err, ErrTooManyAttachments), errors.Is(err, ErrReactionNotAllowed),
+		errors.Is(err, ErrInvalidCiphertext), errors.Is(err, ErrE2EERequired), errors.Is(err, ErrEncryptedMessage):
+		s.sendError(client, id, models.ErrCodeBadRequest, err.Error())
+	default:
+		s.sendError(client, id, models.ErrCodeInternal, internal)
+	}
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"playmates/components/blindindex"
+	"playmates/components/playmates/models"
+	"strings"
+	"testing"
+)
+
+func TestValidMessage(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		{"hi", true},
+		{"", false},
+		{" \n\t", false},
+		{strings.Repeat("я", maxMessageLength), true},
+		{strings.Repeat("я", maxMessageLength+1), false},
+	}
+
+	for _, tt := range tests {
+		if got := validMessage(tt.msg); got != tt.want {
+			t.Errorf("validMessage(%d runes) = %v, want %v", len([]rune(tt.msg)), got, tt.want)
+		}
+	}
+}
+
+// Пустая правка отклоняется до обращения к базе.
+func TestEditMessageRejectsInvalid(t *testing.T) {
+	s := &Service{}
+
+	if _, err := s.EditMessage(1, 10, "  "); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("EditMessage() error = %v, want ErrInvalidMessage", err)
+	}
+}
+
+func TestHandleMessageEditDeleteRejectInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		payload   string
+	}{
+		{"edit invalid payload", models.EventMessageEdit, `"text"`},
+		{"edit no message", models.EventMessageEdit, `{"msg":"hi"}`},
+		{"edit invalid text", models.EventMessageEdit, `{"message_id":10,"msg":""}`},
+		{"delete invalid payload", models.EventMessageDelete, `[]`},
+		{"delete no message", models.EventMessageDelete, `{"for_everyone":true}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			client, conn := addTestClient(t, s, 1)
+
+			s.dispatch(client, models.Envelope{V: models.ProtocolVersion, Type: tt.eventType, ID: "e1", Payload: json.RawMessage(tt.payload)})
+			assertError(t, nextEnvelope(t, conn), "e1", models.ErrCodeBadRequest)
+			assertNoFrame(t, conn)
+		})
+	}
+}
+
+func TestSendMessageError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{ErrMessageNotFound, models.ErrCodeNotFound},
+		{ErrReplyNotFound, models.ErrCodeNotFound},
+		{ErrNotMessageSender, models.ErrCodeForbidden},
+		{ErrEditWindowPassed, models.ErrCodeForbidden},
+		{ErrInvalidMessage, models.ErrCodeBadRequest},
+		{fmt.Errorf("wrapped: %w", ErrEditWindowPassed), models.ErrCodeForbidden},
+		{errors.New("pq: connection refused"), models.ErrCodeInternal},
+	}
+
+	for _, tt := range tests {
+		s := &Service{}
+		client, conn := addTestClient(t, s, 1)
+
+		s.sendMessageError(client, "e1", tt.err, "failed")
+		env := nextEnvelope(t, conn)
+		assertError(t, env, "e1", tt.code)
+
+		// Текст внутренних ошибок клиенту не уходит
+		if tt.code == models.ErrCodeInternal && strings.Contains(string(env.Payload), "pq:") {
+			t.Errorf("internal error leaked: %s", env.Payload)
+		}
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	if got := truncateRunes("привет", 6); got != "привет" {
+		t.Errorf("truncateRunes() = %q, want the text unchanged", got)
+	}
+	if got := truncateRunes("привет", 3); got != "при…" {
+		t.Errorf("truncateRunes() = %q, want %q", got, "при…")
+	}
+}
+
+// Ответ принимается только на живое сообщение той же переписки.
+func TestCheckReply(t *testing.T) {
+	s, conn := testService(t)
+	alice, bob, eve := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob"), insertTestUser(t, conn, "eve")
+
+	parent := postTestMessage(t, s, bob, alice, "question")
+	foreign := postTestMessage(t, s, bob, eve, "secret")
+	deleted := postTestMessage(t, s, alice, bob, "oops")
+	if _, _, _, err := s.repo.DeleteMessage(deleted, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		replyTo int
+		wantErr error
+	}{
+		{"no reply", 0, nil},
+		{"same chat", parent, nil},
+		{"negative", -1, ErrReplyNotFound},
+		{"missing", foreign + 1_000_000, ErrReplyNotFound},
+		{"other chat", foreign, ErrReplyNotFound},
+		{"deleted", deleted, ErrReplyNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.checkReply(alice, models.MessageSendPayload{ReceiverID: bob, ReplyToID: tt.replyTo})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkReply() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithQuotes(t *testing.T) {
+	s, conn := testService(t)
+	s.dataKeys = newTestDataKeys(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+
+	conversationID, err := s.repo.EnsureDirectConversation(alice, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := strings.Repeat("ё", maxQuoteLength+20)
+	parentID, sealed, err := s.sealNewMessage(conversationID, bob, alice, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.repo.PostMessage(parentID, models.MessageKindText, sealed, bob, alice, nil, 0, blindindex.Tokens{}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []models.Message{{ID: parentID + 1, ReplyTo: &models.MessageQuote{ID: parentID}}, {ID: parentID + 2}}
+	if err = s.withQuotes(msgs); err != nil {
+		t.Fatal(err)
+	}
+	want := models.MessageQuote{ID: parentID, SenderID: bob, Msg: strings.Repeat("ё", maxQuoteLength) + "…"}
+	if msgs[0].ReplyTo == nil || *msgs[0].ReplyTo != want {
+		t.Fatalf("quote = %+v, want %+v", msgs[0].ReplyTo, want)
+	}
+	if msgs[1].ReplyTo != nil {
+		t.Fatalf("message without reply got a quote: %+v", msgs[1].ReplyTo)
+	}
+
+	// После удаления цитата остаётся без текста
+	if _, _, _, err = s.repo.DeleteMessage(parentID, bob); err != nil {
+		t.Fatal(err)
+	}
+	msgs = []models.Message{{ID: parentID + 1, ReplyTo: &models.MessageQuote{ID: parentID}}}
+	if err = s.withQuotes(msgs); err != nil {
+		t.Fatal(err)
+	}
+	if quote := msgs[0].ReplyTo; quote == nil || !quote.Deleted || quote.Msg != "" {
+		t.Fatalf("quote of deleted message = %+v", quote)
+	}
+}
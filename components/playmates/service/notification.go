@@ -0,0 +1,39 @@
+package service
+
+import (
+	"log"
+	"playmates/components/playmates/models"
+)
+
+// Notify сохраняет уведомление и, если пользователь онлайн, сразу отправляет его по WebSocket.
+func (s *Service) Notify(userID int, notificationType string, payload []byte) error {
+	notification, err := s.repo.CreateNotification(userID, notificationType, payload)
+	if err != nil {
+		log.Printf("err create notification: %v\n", err)
+		return err
+	}
+
+	s.sendEvent(userID, models.EventNotification, "", notification)
+
+	return nil
+}
+
+func (s *Service) GetNotifications(userID int, unreadOnly bool) ([]models.Notification, error) {
+	notifications, err := s.repo.GetNotifications(userID, unreadOnly, 50)
+	if err != nil {
+		log.Printf("err get notifications: %v\n", err)
+		return nil, err
+	}
+
+	return notifications, nil
+}
+
+func (s *Service) MarkNotificationRead(userID, notificationID int) (bool, error) {
+	ok, err := s.repo.MarkNotificationRead(userID, notificationID)
+	if err != nil {
+		log.Printf("err mark notification read: %v\n", err)
+		return false, err
+	}
+
+	return ok, nil
+}
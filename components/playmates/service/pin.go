@@ -0,0 +1,134 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"playmates/components/playmates/models"
+)
+
+var ErrTooManyPins = errors.New("too many pinned messages")
+
+// PinMessage закрепляет сообщение в его переписке. Закреплять может любой участник.
+func (s *Service) PinMessage(userID, messageID int) error {
+	msg, err := s.pinnableMessage(userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	payload, cursors, changed, full, err := s.repo.PinMessage(msg, userID, s.messages.MaxPins)
+	if err != nil {
+		log.Printf("err pin message: %v\n", err)
+		return err
+	}
+	if full {
+		return ErrTooManyPins
+	}
+
+	if changed {
+		s.publishPinEvent(models.EventMessagePinned, payload, cursors)
+	}
+
+	return nil
+}
+
+func (s *Service) UnpinMessage(userID, messageID int) error {
+	msg, err := s.pinnableMessage(userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	payload, cursors, changed, err := s.repo.UnpinMessage(msg, userID)
+	if err != nil {
+		log.Printf("err unpin message: %v\n", err)
+		return err
+	}
+
+	if changed {
+		s.publishPinEvent(models.EventMessageUnpinned, payload, cursors)
+	}
+
+	return nil
+}
+
+// GetChatPins - закреплённые сообщения личной переписки с peerID.
+func (s *Service) GetChatPins(userID, peerID int) ([]models.Pin, error) {
+	conversationID, exists, err := s.repo.GetDirectConversationID(userID, peerID)
+	if err != nil {
+		log.Printf("err get direct conversation: %v\n", err)
+		return nil, err
+	}
+	if !exists {
+		return []models.Pin{}, nil
+	}
+
+	return s.getPins(userID, conversationID)
+}
+
+func (s *Service) GetConversationPins(userID, conversationID int) ([]models.Pin, error) {
+	if _, err := s.memberRole(conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.getPins(userID, conversationID)
+}
+
+func (s *Service) getPins(userID, conversationID int) ([]models.Pin, error) {
+	pinsDB, err := s.repo.GetPins(conversationID)
+	if err != nil {
+		log.Printf("err get pins: %v\n", err)
+		return nil, err
+	}
+
+	msgs := make([]models.Message, len(pinsDB))
+	for i, pin := range pinsDB {
+		msgs[i], err = s.openMessage(pin.Message)
+		if err != nil {
+			log.Printf("err decrypt message: %v\n", err)
+			return nil, err
+		}
+	}
+
+	if err = s.enrichMessages(userID, msgs); err != nil {
+		return nil, err
+	}
+
+	pins := make([]models.Pin, len(pinsDB))
+	for i, pin := range pinsDB {
+		pins[i] = models.Pin{Message: msgs[i], PinnedBy: pin.PinnedBy, PinnedAt: pin.PinnedAt}
+	}
+
+	return pins, nil
+}
+
+// pinnableMessage возвращает сообщение, если userID видит его и оно не удалено.
+func (s *Service) pinnableMessage(userID, messageID int) (models.MessageDB, error) {
+	msg, exists, err := s.repo.GetMessage(messageID)
+	if err != nil {
+		log.Printf("err get message: %v\n", err)
+		return models.MessageDB{}, err
+	}
+	if !exists || msg.DeletedAt != nil || msg.ConversationID == 0 {
+		return models.MessageDB{}, ErrMessageNotFound
+	}
+
+	visible, err := s.canSeeMessage(userID, msg)
+	if err != nil {
+		return models.MessageDB{}, err
+	}
+	if !visible {
+		return models.MessageDB{}, ErrMessageNotFound
+	}
+
+	return msg, nil
+}
+
+func (s *Service) publishPinEvent(eventType string, payload models.PinPayload, cursors map[int]int64) {
+	env, err := newEnvelope(eventType, "", payload)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	// Закрепление идёт через REST, поэтому событие получают и все устройства инициатора
+	s.publishCursors(env, cursors, 0, "")
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPinLimit(t *testing.T) {
+	s, conn := testService(t)
+	s.messages.MaxPins = 2
+	alice, bob, eve := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob"), insertTestUser(t, conn, "eve")
+
+	first := postTestMessage(t, s, alice, bob, "one")
+	second := postTestMessage(t, s, bob, alice, "two")
+	third := postTestMessage(t, s, alice, bob, "three")
+
+	if err := s.PinMessage(eve, first); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("outsider pinned a message: %v", err)
+	}
+
+	// Закреплять может любой участник переписки
+	if err := s.PinMessage(alice, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PinMessage(bob, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PinMessage(alice, third); !errors.Is(err, ErrTooManyPins) {
+		t.Fatalf("pin over the limit: %v", err)
+	}
+	// Повторное закрепление не упирается в лимит
+	if err := s.PinMessage(bob, first); err != nil {
+		t.Fatalf("repeated pin at the limit: %v", err)
+	}
+
+	if err := s.UnpinMessage(bob, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PinMessage(alice, third); err != nil {
+		t.Fatalf("pin after unpin: %v", err)
+	}
+
+	// Удалённое у всех сообщение откреплено и не закрепляется снова
+	if err := s.DeleteMessage(alice, third, true); err != nil {
+		t.Fatal(err)
+	}
+	pins, err := s.repo.GetPins(conversationOf(t, s, alice, bob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 1 || pins[0].Message.ID != second {
+		t.Fatalf("pins after delete = %+v, want only %d", pins, second)
+	}
+	if err := s.PinMessage(alice, third); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("deleted message pinned: %v", err)
+	}
+}
+
+func conversationOf(t *testing.T, s *Service, userA, userB int) int {
+	t.Helper()
+
+	conversationID, exists, err := s.repo.GetDirectConversationID(userA, userB)
+	if err != nil || !exists {
+		t.Fatalf("direct conversation: exists = %v, err = %v", exists, err)
+	}
+
+	return conversationID
+}
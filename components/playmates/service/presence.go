@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"log"
+	"playmates/components/playmates/models"
+	"time"
+)
+
+// presenceOf вычисляет статус пользователя. Если он скрыл статус, всегда отдаём offline без last_seen.
+// online - результат onlineSet, нужен для пользователей, подключённых к другим узлам.
+func (s *Service) presenceOf(userID int, hidden bool, lastSeen *time.Time, online map[int]bool) *models.Presence {
+	if hidden {
+		return &models.Presence{Status: models.PresenceOffline}
+	}
+
+	idle, connected := s.connectionManager.Status(userID)
+	switch {
+	case connected && idle:
+		return &models.Presence{Status: models.PresenceIdle}
+	case connected || online[userID]:
+		return &models.Presence{Status: models.PresenceOnline}
+	default:
+		return &models.Presence{Status: models.PresenceOffline, LastSeenAt: lastSeen}
+	}
+}
+
+// onlineSet спрашивает у реестра, кто из userIDs подключён к какому-либо узлу.
+func (s *Service) onlineSet(userIDs []int) map[int]bool {
+	online, err := s.registry.Online(context.Background(), userIDs)
+	if err != nil {
+		log.Printf("err get online users: %v\n", err)
+		return map[int]bool{}
+	}
+
+	return online
+}
+
+func (s *Service) userConnected(userID int) {
+	ctx := context.Background()
+	wasOnline := s.onlineSet([]int{userID})[userID]
+
+	if err := s.registry.Register(ctx, userID); err != nil {
+		log.Printf("err register session: %d, err: %v\n", userID, err)
+	}
+
+	// Уже подключён к другому узлу - для собеседников ничего не изменилось
+	if !wasOnline {
+		s.broadcastPresence(userID)
+	}
+}
+
+func (s *Service) userDisconnected(userID int) {
+	if err := s.registry.Unregister(context.Background(), userID); err != nil {
+		log.Printf("err unregister session: %d, err: %v\n", userID, err)
+	}
+
+	if s.onlineSet([]int{userID})[userID] {
+		return
+	}
+
+	if err := s.repo.SetLastSeen(userID, time.Now()); err != nil {
+		log.Printf("err set last seen: %d, err: %v\n", userID, err)
+	}
+
+	s.broadcastPresence(userID)
+}
+
+// broadcastPresence рассылает текущий статус пользователя тем, с кем у него есть переписка.
+// Для скрывших статус ничего не отправляем, иначе сам момент рассылки выдаёт подключение.
+func (s *Service) broadcastPresence(userID int) {
+	user, err := s.repo.GetUser(userID)
+	if err != nil {
+		log.Printf("err get user for presence: %d, err: %v\n", userID, err)
+		return
+	}
+	if user.HidePresence {
+		return
+	}
+
+	s.sendPresence(userID, *s.presenceOf(userID, false, user.LastSeenAt, s.onlineSet([]int{userID})))
+}
+
+func (s *Service) sendPresence(userID int, presence models.Presence) {
+	partners, err := s.repo.GetChatPartnerIDs(userID)
+	if err != nil {
+		log.Printf("err get chat partners: %d, err: %v\n", userID, err)
+		return
+	}
+
+	payload := models.PresencePayload{UserID: userID, Presence: presence}
+	for _, partnerID := range partners {
+		s.sendEvent(partnerID, models.EventPresence, "", payload)
+	}
+}
+
+// RunPresenceSweeper периодически переводит в idle тех, кто давно ничего не присылал.
+func (s *Service) RunPresenceSweeper(ctx context.Context, interval, idleAfter time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, userID := range s.connectionManager.SweepIdle(idleAfter) {
+				s.broadcastPresence(userID)
+			}
+		}
+	}
+}
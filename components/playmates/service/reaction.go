@@ -0,0 +1,117 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"playmates/components/connection-manager"
+	"playmates/components/playmates/models"
+	"time"
+)
+
+var ErrReactionNotAllowed = errors.New("reaction is not allowed")
+
+// AddReaction ставит реакцию и возвращает сводку реакций сообщения. Повторная постановка не ошибка.
+func (s *Service) AddReaction(userID, messageID int, emoji string) ([]models.Reaction, error) {
+	return s.setReaction(userID, messageID, emoji, true, "")
+}
+
+func (s *Service) RemoveReaction(userID, messageID int, emoji string) ([]models.Reaction, error) {
+	return s.setReaction(userID, messageID, emoji, false, "")
+}
+
+func (s *Service) setReaction(userID, messageID int, emoji string, add bool, exceptConn string) ([]models.Reaction, error) {
+	if !s.allowedReaction(emoji) {
+		return nil, ErrReactionNotAllowed
+	}
+
+	msg, exists, err := s.repo.GetMessage(messageID)
+	if err != nil {
+		log.Printf("err get message: %v\n", err)
+		return nil, err
+	}
+	if !exists || msg.DeletedAt != nil {
+		return nil, ErrMessageNotFound
+	}
+
+	visible, err := s.canSeeMessage(userID, msg)
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, ErrMessageNotFound
+	}
+
+	payload, cursors, changed, err := s.repo.SetReaction(messageID, userID, emoji, add)
+	if err != nil {
+		log.Printf("err set reaction: %v\n", err)
+		return nil, err
+	}
+
+	if changed {
+		env, err := newEnvelope(models.EventReaction, "", payload)
+		if err != nil {
+			log.Println("Error encoding event:", err)
+		} else {
+			s.publishCursors(env, cursors, userID, exceptConn)
+		}
+	}
+
+	reactions, err := s.repo.GetReactions([]int{messageID}, userID)
+	if err != nil {
+		log.Printf("err get reactions: %v\n", err)
+		return nil, err
+	}
+
+	return reactions[messageID], nil
+}
+
+// withReactions дополняет сообщения сводкой реакций с точки зрения userID.
+func (s *Service) withReactions(userID int, msgs []models.Message) error {
+	ids := make([]int, 0, len(msgs))
+	for _, msg := range msgs {
+		if !msg.Deleted {
+			ids = append(ids, msg.ID)
+		}
+	}
+
+	reactions, err := s.repo.GetReactions(ids, userID)
+	if err != nil {
+		log.Printf("err get reactions: %v\n", err)
+		return err
+	}
+
+	for i, msg := range msgs {
+		msgs[i].Reactions = reactions[msg.ID]
+	}
+
+	return nil
+}
+
+func (s *Service) allowedReaction(emoji string) bool {
+	for _, allowed := range s.messages.Reactions {
+		if allowed == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) handleReaction(client *connection_manager.Client, env models.Envelope) {
+	var payload models.ReactionPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil || payload.MessageID <= 0 {
+		s.sendError(client, env.ID, models.ErrCodeBadRequest, "invalid payload")
+		return
+	}
+
+	_, err := s.setReaction(client.UserID, payload.MessageID, payload.Emoji, env.Type == models.EventReactionAdd, client.ID)
+	if err != nil {
+		s.sendMessageError(client, env.ID, err, "failed to set reaction")
+		return
+	}
+
+	s.sendEventToClient(client, models.EventAck, env.ID, models.AckPayload{
+		MessageID: payload.MessageID,
+		Time:      time.Now(),
+	})
+}
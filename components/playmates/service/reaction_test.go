@@ -0,0 +1,81 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"playmates/components/playmates/models"
+	"testing"
+)
+
+func TestAllowedReaction(t *testing.T) {
+	s := &Service{messages: MessagesConfig{Reactions: []string{"👍", "❤️"}}}
+
+	tests := map[string]bool{
+		"👍":  true,
+		"❤️": true,
+		// Без variation selector это другая строка
+		"❤":     false,
+		"👍🏽":    false,
+		"":      false,
+		"<img>": false,
+	}
+
+	for emoji, want := range tests {
+		if got := s.allowedReaction(emoji); got != want {
+			t.Errorf("allowedReaction(%q) = %v, want %v", emoji, got, want)
+		}
+	}
+}
+
+// Чужая эмодзи отклоняется до обращения к базе.
+func TestAddReactionRejectsEmoji(t *testing.T) {
+	s := &Service{messages: MessagesConfig{Reactions: []string{"👍"}}}
+
+	if _, err := s.AddReaction(1, 10, "💩"); !errors.Is(err, ErrReactionNotAllowed) {
+		t.Fatalf("AddReaction() error = %v, want ErrReactionNotAllowed", err)
+	}
+}
+
+func TestHandleReactionRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		code    string
+	}{
+		{"invalid payload", `"👍"`, models.ErrCodeBadRequest},
+		{"no message", `{"emoji":"👍"}`, models.ErrCodeBadRequest},
+		{"not allowed", `{"message_id":10,"emoji":"💩"}`, models.ErrCodeBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{messages: MessagesConfig{Reactions: []string{"👍"}}}
+			client, conn := addTestClient(t, s, 1)
+
+			s.dispatch(client, models.Envelope{V: models.ProtocolVersion, Type: models.EventReactionAdd, ID: "x1", Payload: json.RawMessage(tt.payload)})
+			assertError(t, nextEnvelope(t, conn), "x1", tt.code)
+			assertNoFrame(t, conn)
+		})
+	}
+}
+
+// Реагировать можно только на сообщения своей переписки.
+func TestAddReactionVisibility(t *testing.T) {
+	s, conn := testService(t)
+	s.messages.Reactions = []string{"👍"}
+	alice, bob, eve := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob"), insertTestUser(t, conn, "eve")
+
+	messageID := postTestMessage(t, s, alice, bob, "gg")
+
+	if _, err := s.AddReaction(eve, messageID, "👍"); !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("outsider reacted: %v", err)
+	}
+
+	reactions, err := s.AddReaction(bob, messageID, "👍")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reactions) != 1 || reactions[0] != (models.Reaction{Emoji: "👍", Count: 1, Mine: true}) {
+		t.Fatalf("reactions = %+v", reactions)
+	}
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"playmates/components/connection-manager"
+	"playmates/components/playmates/models"
+)
+
+// MarkRead отмечает прочитанными сообщения от peerID до messageID включительно.
+// messageID <= 0 - прочитать всё.
+func (s *Service) MarkRead(userID, peerID, messageID int) (models.Receipt, error) {
+	return s.markRead(userID, peerID, messageID, "")
+}
+
+func (s *Service) markRead(userID, peerID, messageID int, exceptConn string) (models.Receipt, error) {
+	if messageID <= 0 {
+		messageID = math.MaxInt32
+	}
+
+	receipt, cursors, changed, err := s.repo.MarkRead(userID, peerID, messageID)
+	if err != nil {
+		log.Printf("err mark read: %v\n", err)
+		return models.Receipt{}, err
+	}
+
+	if changed {
+		s.publishReceipt(receipt, cursors, exceptConn)
+	}
+
+	return receipt, nil
+}
+
+// MarkDelivered отмечает доставленными сообщения от peerID до messageID включительно.
+// Клиент вызывает его сам, когда сообщения дошли до устройства: ни история и /sync, ни отправка
+// в WebSocket и resume доставку не отмечают - кадр мог остаться в очереди закрывшегося соединения.
+// messageID <= 0 - всё полученное.
+func (s *Service) MarkDelivered(userID, peerID, messageID int) (models.Receipt, error) {
+	return s.deliver(userID, peerID, messageID, "")
+}
+
+func (s *Service) deliver(userID, peerID, messageID int, exceptConn string) (models.Receipt, error) {
+	if messageID <= 0 {
+		messageID = math.MaxInt32
+	}
+
+	receipt, cursors, changed, err := s.repo.MarkDelivered(userID, peerID, messageID)
+	if err != nil {
+		log.Printf("err mark delivered: %v\n", err)
+		return models.Receipt{}, err
+	}
+
+	if changed {
+		s.publishReceipt(receipt, cursors, exceptConn)
+	}
+
+	return receipt, nil
+}
+
+// GetReadState - докуда peerID получил и прочитал сообщения userID.
+func (s *Service) GetReadState(userID, peerID int) (models.ReadState, error) {
+	state, err := s.repo.GetReadState(userID, peerID)
+	if err != nil {
+		log.Printf("err get read state: %v\n", err)
+		return models.ReadState{}, err
+	}
+
+	return state, nil
+}
+
+// publishReceipt отправляет отметку отправителю сообщений и остальным устройствам читателя.
+func (s *Service) publishReceipt(receipt models.Receipt, cursors map[int]int64, exceptConn string) {
+	env, err := newEnvelope(models.EventReceipt, "", receipt)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	env.Cursor = cursors[receipt.PeerID]
+	s.publish(receipt.PeerID, "", env)
+
+	env.Cursor = cursors[receipt.UserID]
+	s.publish(receipt.UserID, exceptConn, env)
+}
+
+func (s *Service) handleRead(client *connection_manager.Client, env models.Envelope) {
+	var payload models.ReadPayload
+	err := json.Unmarshal(env.Payload, &payload)
+	validTarget := payload.ConversationID > 0 || (payload.PeerID > 0 && payload.PeerID != client.UserID)
+	if err != nil || !validTarget {
+		s.sendError(client, env.ID, models.ErrCodeBadRequest, "invalid payload")
+		return
+	}
+
+	var receipt models.Receipt
+	if payload.ConversationID > 0 {
+		receipt, err = s.markConversationRead(client.UserID, payload.ConversationID, payload.MessageID, client.ID)
+	} else {
+		receipt, err = s.markRead(client.UserID, payload.PeerID, payload.MessageID, client.ID)
+	}
+	if err != nil {
+		s.sendConversationError(client, env.ID, err, "failed to mark read")
+		return
+	}
+
+	s.sendEventToClient(client, models.EventAck, env.ID, models.AckPayload{
+		MessageID: receipt.MessageID,
+		Time:      receipt.Time,
+	})
+}
+
+func (s *Service) handleDelivered(client *connection_manager.Client, env models.Envelope) {
+	var payload models.ReadPayload
+	err := json.Unmarshal(env.Payload, &payload)
+	if err != nil || payload.PeerID <= 0 || payload.PeerID == client.UserID {
+		s.sendError(client, env.ID, models.ErrCodeBadRequest, "invalid payload")
+		return
+	}
+
+	receipt, err := s.deliver(client.UserID, payload.PeerID, payload.MessageID, client.ID)
+	if err != nil {
+		s.sendError(client, env.ID, models.ErrCodeInternal, "failed to mark delivered")
+		return
+	}
+
+	s.sendEventToClient(client, models.EventAck, env.ID, models.AckPayload{
+		MessageID: receipt.MessageID,
+		Time:      receipt.Time,
+	})
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"encoding/json"
+	"playmates/components/playmates/models"
+	"testing"
+)
+
+// Невалидные read и delivered отклоняются до обращения к базе.
+func TestReceiptHandlersRejectInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		payload   string
+	}{
+		{"read invalid payload", models.EventRead, `[]`},
+		{"read no target", models.EventRead, `{"message_id":5}`},
+		{"read own chat", models.EventRead, `{"peer_id":1,"message_id":5}`},
+		{"delivered invalid payload", models.EventDelivered, `"x"`},
+		{"delivered no peer", models.EventDelivered, `{"message_id":5}`},
+		{"delivered own chat", models.EventDelivered, `{"peer_id":1}`},
+		// Доставку в группах не отмечаем
+		{"delivered conversation", models.EventDelivered, `{"conversation_id":3}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			client, conn := addTestClient(t, s, 1)
+
+			s.dispatch(client, models.Envelope{V: models.ProtocolVersion, Type: tt.eventType, ID: "r1", Payload: json.RawMessage(tt.payload)})
+			assertError(t, nextEnvelope(t, conn), "r1", models.ErrCodeBadRequest)
+			assertNoFrame(t, conn)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"playmates/components/playmates/models"
+	"strings"
+	"time"
+)
+
+const (
+	maxSavedSearches  = 20
+	savedSearchBatch  = 100
+	savedSearchMaxAge = 30 * 24 * time.Hour
+)
+
+func (s *Service) CreateSavedSearch(userID int, name string, params models.SearchParams) (models.SavedSearch, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return models.SavedSearch{}, fmt.Errorf("name is required")
+	}
+
+	total, err := s.repo.CountSavedSearches(userID)
+	if err != nil {
+		log.Printf("err count saved searches: %v\n", err)
+		return models.SavedSearch{}, err
+	}
+	if total >= maxSavedSearches {
+		return models.SavedSearch{}, fmt.Errorf("saved searches limit reached")
+	}
+
+	for i := range params.Games {
+		params.Games[i] = strings.ToLower(params.Games[i])
+	}
+	for i := range params.ExcludeGames {
+		params.ExcludeGames[i] = strings.ToLower(params.ExcludeGames[i])
+	}
+
+	search, err := s.repo.CreateSavedSearch(userID, name, params)
+	if err != nil {
+		log.Printf("err create saved search: %v\n", err)
+		return models.SavedSearch{}, err
+	}
+
+	return search, nil
+}
+
+func (s *Service) GetSavedSearches(userID int) ([]models.SavedSearch, error) {
+	searches, err := s.repo.GetSavedSearches(userID)
+	if err != nil {
+		log.Printf("err get saved searches: %v\n", err)
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+func (s *Service) DeleteSavedSearch(userID, searchID int) (bool, error) {
+	ok, err := s.repo.DeleteSavedSearch(userID, searchID)
+	if err != nil {
+		log.Printf("err delete saved search: %v\n", err)
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// RunSavedSearchDigests раз в interval прогоняет сохранённые поиски и присылает владельцам
+// дайджест профилей, появившихся или обновившихся с прошлого запуска.
+func (s *Service) RunSavedSearchDigests(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.processSavedSearches(interval)
+		}
+	}
+}
+
+func (s *Service) processSavedSearches(interval time.Duration) {
+	for {
+		runAt := time.Now()
+		searches, err := s.repo.ClaimDueSavedSearches(runAt.Add(-interval), runAt, savedSearchBatch)
+		if err != nil {
+			log.Printf("err claim saved searches: %v\n", err)
+			return
+		}
+
+		for _, search := range searches {
+			if err := s.runSavedSearch(search); err != nil {
+				log.Printf("err run saved search: %d, err: %v\n", search.ID, err)
+			}
+		}
+
+		if len(searches) < savedSearchBatch {
+			return
+		}
+	}
+}
+
+func (s *Service) runSavedSearch(search models.SavedSearch) error {
+	params := search.Params
+	params.Offset = 0
+	params.ExcludeIDs = append(params.ExcludeIDs, search.UserID)
+
+	// Если поиск долго не запускался, не присылаем всю базу разом
+	params.UpdatedSince = search.LastRunAt
+	if oldest := time.Now().Add(-savedSearchMaxAge); params.UpdatedSince.Before(oldest) {
+		params.UpdatedSince = oldest
+	}
+
+	users, total, err := s.searchUsers(params)
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(models.SavedSearchDigest{
+		SavedSearchID: search.ID,
+		Name:          search.Name,
+		Since:         params.UpdatedSince,
+		Total:         total,
+		Users:         users,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal digest: %w", err)
+	}
+
+	return s.Notify(search.UserID, models.NotificationSavedSearch, payload)
+}
@@ -1,21 +1,26 @@
 package service
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"log"
+	"playmates/components/blindindex"
+	"playmates/components/blobstore"
+	"playmates/components/broker"
 	"playmates/components/connection-manager"
+	"playmates/components/datakeys"
 	"playmates/components/playmates/models"
+	"playmates/components/recommender"
 	"playmates/components/repository"
 	"playmates/components/sealer"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/gofiber/contrib/websocket"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -26,16 +31,44 @@ type Service struct {
 	repo              *repository.Repository
 	connectionManager *connection_manager.ConnectionManager
 	sealer            *sealer.Sealer
+	dataKeys          *datakeys.Keys
+	index             *blindindex.Index
+	emails            *blindindex.EmailHasher
+	recommender       *recommender.Recommender
+	candidatePool     int
+	ws                WebSocketConfig
+	messages          MessagesConfig
+	blobs             *blobstore.Store
+	attachments       AttachmentsConfig
+	broker            broker.Broker
+	registry          broker.Registry
+	typing            *typingTracker
 }
 
-func New(db *sql.DB, jwtSecret string, repository *repository.Repository, connManager *connection_manager.ConnectionManager, sealer *sealer.Sealer) *Service {
-	return &Service{
+func New(db *sql.DB, jwtSecret string, repository *repository.Repository, connManager *connection_manager.ConnectionManager, sealer *sealer.Sealer, dataKeys *datakeys.Keys, index *blindindex.Index, emails *blindindex.EmailHasher, recommender *recommender.Recommender, candidatePool int, ws WebSocketConfig, messages MessagesConfig, blobs *blobstore.Store, attachments AttachmentsConfig, msgBroker broker.Broker, registry broker.Registry) *Service {
+	s := &Service{
 		db:                db,
 		jwtSecret:         jwtSecret,
 		repo:              repository,
 		connectionManager: connManager,
 		sealer:            sealer,
+		dataKeys:          dataKeys,
+		index:             index,
+		emails:            emails,
+		recommender:       recommender,
+		candidatePool:     candidatePool,
+		ws:                ws,
+		messages:          messages,
+		blobs:             blobs,
+		attachments:       attachments,
+		broker:            msgBroker,
+		registry:          registry,
+		typing:            newTypingTracker(),
 	}
+
+	msgBroker.Subscribe(s.deliverLocal)
+
+	return s
 }
 
 func (s *Service) GetIdFromToken(tokenString string) (int, error) {
@@ -61,78 +94,35 @@ func (s *Service) GetIdFromToken(tokenString string) (int, error) {
 	return UserId, nil
 }
 
-func (s *Service) HandleWebSocket(c *websocket.Conn, userID int) {
-	if userID <= 0 {
-		c.WriteMessage(websocket.CloseMessage, []byte("Invalid user ID"))
-		return
+func (s *Service) Register(username, email, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
 	}
 
-	// Добавляем соединение в менеджер
-	s.connectionManager.Add(userID, c)
-	defer s.connectionManager.Remove(userID)
-
-	for {
-		// Чтение сообщения
-		_, msg, err := c.ReadMessage()
-		if err != nil {
-			log.Println("WebSocket read error:", err)
-			c.WriteMessage(websocket.CloseMessage, []byte("Error occurred during reading"))
-			break
-		}
+	email = blindindex.NormalizeEmail(email)
 
-		// Парсим сообщение
-		var message models.Message
-		err = json.Unmarshal(msg, &message)
-		if err != nil {
-			log.Println("Error parsing message:", err)
-			continue
-		}
-
-		sealedMsg, err := s.sealer.Encrypt([]byte(message.Msg))
-		if err != nil {
-			log.Println("Error encrypting message:", err)
-			continue
-		}
-
-		// Сохраняем сообщение в базе данных
-		err = s.repo.PostMessage(string(sealedMsg), userID, message.ReceiverID)
-		if err != nil {
-			log.Println("Error saving message:", err)
-			continue
-		}
-
-		// Проверяем, что получатель существует
-		receiverConn, exists := s.connectionManager.Get(message.ReceiverID)
-		if !exists {
-			continue
-		}
-
-		// Отправляем сообщение получателю
-		err = receiverConn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			log.Println("Error sending message:", err)
-			continue
-		}
+	id, err := s.repo.NextUserID()
+	if err != nil {
+		return err
 	}
-}
 
-func (s *Service) Register(username, email, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	emailSealed, err := s.sealer.EncryptWithAD([]byte(email), models.UserFieldAD(id, models.UserFieldEmail))
 	if err != nil {
-		return fmt.Errorf("failed to hash password: %w", err)
+		return fmt.Errorf("failed to encrypt email: %w", err)
 	}
 
-	err = s.repo.Register(username, email, string(hashedPassword))
+	err = s.repo.Register(id, username, s.emails.Hashes(email), emailSealed, email, string(hashedPassword))
 
 	return err
 }
 
 func (s *Service) Login(email, password, fingerprint string) (string, string, time.Time, error) {
-	email = strings.ToLower(email)
+	email = blindindex.NormalizeEmail(email)
 
-	user, err := s.repo.Login(email)
+	user, err := s.repo.Login(s.emails.Hashes(email).All, email)
 	if err != nil {
-		log.Printf("err login email: %s, err: %w\n", email, err)
+		log.Printf("err login, err: %v\n", err)
 		return "", "", time.Time{}, fmt.Errorf("invalid email or password")
 	}
 
@@ -153,6 +143,10 @@ func (s *Service) Login(email, password, fingerprint string) (string, string, ti
 		return "", "", time.Time{}, fmt.Errorf("failed to insert token into db: %w", err)
 	}
 
+	if err = s.repo.TouchUser(user.ID); err != nil {
+		log.Printf("err touch user: %d, err: %v\n", user.ID, err)
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"username": user.Username,
 		"user_id":  user.ID,
@@ -167,32 +161,63 @@ func (s *Service) Login(email, password, fingerprint string) (string, string, ti
 	return jwtString, rawRefreshToken, expiresAt, nil
 }
 
-func (s *Service) GetUser(userID int) (models.User, error) {
+// GetUser возвращает профиль userID глазами viewerID: email виден только владельцу.
+func (s *Service) GetUser(viewerID, userID int) (models.User, error) {
 	user, err := s.repo.GetUser(userID)
 	if err != nil {
 		log.Printf("err get user: %d, err: %v\n", userID, err)
 		return models.User{}, err
 	}
 
+	if err = s.openUser(&user, viewerID); err != nil {
+		log.Printf("err open user: %d, err: %v\n", userID, err)
+		return models.User{}, err
+	}
+
+	user.Presence = s.presenceOf(user.ID, user.HidePresence, user.LastSeenAt, s.onlineSet([]int{user.ID}))
+
 	return user, nil
 }
 
 func (s *Service) SetUser(user models.User) error {
-	err := s.repo.SetUser(user)
+	current, err := s.repo.GetUser(user.ID)
+	if err != nil {
+		log.Printf("err get user: %d, err: %v\n", user.ID, err)
+		return err
+	}
+
+	tokens, err := s.sealAboutMe(&user)
+	if err != nil {
+		log.Printf("err seal user: %d, err: %v\n", user.ID, err)
+		return err
+	}
+
+	err = s.repo.SetUser(user, tokens)
 	if err != nil {
 		log.Printf("err set user: %d, err: %v\n", user.ID, err)
 		return err
 	}
 
+	if current.HidePresence != user.HidePresence {
+		if user.HidePresence {
+			s.sendPresence(user.ID, models.Presence{Status: models.PresenceOffline})
+		} else {
+			s.broadcastPresence(user.ID)
+		}
+	}
+
 	return nil
 }
 
-func (s *Service) SearchUsers(minAge, maxAge, offset int, games []string, gender string) ([]models.User, int, error) {
-	for i, _ := range games {
-		games[i] = strings.ToLower(games[i])
+func (s *Service) SearchUsers(params models.SearchParams) ([]models.UserSearchResult, int, error) {
+	for i := range params.Games {
+		params.Games[i] = strings.ToLower(params.Games[i])
+	}
+	for i := range params.ExcludeGames {
+		params.ExcludeGames[i] = strings.ToLower(params.ExcludeGames[i])
 	}
 
-	users, total, err := s.repo.SearchUsers(minAge, maxAge, offset, games, gender)
+	users, total, err := s.searchUsers(params)
 	if err != nil {
 		log.Printf("err search users: %v\n", err)
 		return nil, 0, err
@@ -201,32 +226,148 @@ func (s *Service) SearchUsers(minAge, maxAge, offset int, games []string, gender
 	return users, total, nil
 }
 
-func (s *Service) GetMessages(currentUserID, otherUserID int) ([]models.Message, error) {
-	msgsDB, err := s.repo.GetMessages(currentUserID, otherUserID)
+func (s *Service) searchUsers(params models.SearchParams) ([]models.UserSearchResult, int, error) {
+	if params.Online {
+		online, err := s.registry.OnlineUsers(context.Background())
+		if err != nil {
+			return nil, 0, err
+		}
+		params.OnlineIDs = online
+	}
+	if params.Query != "" {
+		params.QueryTokens = s.index.ProfileTokens(params.Query).Values
+	}
+
+	users, total, err := s.repo.SearchUsers(params)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := make([]int, len(users))
+	for i := range users {
+		ids[i] = users[i].ID
+	}
+	online := s.onlineSet(ids)
+
+	for i := range users {
+		s.openListedUser(&users[i].User)
+		// ts_headline видит только ещё не зашифрованный about_me, по остальным сниппет
+		// строится из расшифрованного по тем же целым словам, что и слепой индекс
+		if params.Query != "" && users[i].Snippet == "" {
+			users[i].Snippet = profileSnippet(users[i].AboutMe, params.Query)
+		}
+		users[i].Presence = s.presenceOf(users[i].ID, users[i].HidePresence, users[i].LastSeenAt, online)
+	}
+
+	return users, total, nil
+}
+
+func (s *Service) GetRecommendations(userID, limit int) ([]models.Recommendation, error) {
+	me, err := s.repo.GetUser(userID)
 	if err != nil {
-		log.Printf("err get messages: %v\n", err)
+		log.Printf("err get user: %d, err: %v\n", userID, err)
+		return nil, err
+	}
+
+	candidates, err := s.repo.GetRecommendationCandidates(userID, s.candidatePool)
+	if err != nil {
+		log.Printf("err get recommendation candidates: %v\n", err)
 		return nil, err
 	}
 
+	recommendations := s.recommender.Rank(me, candidates, limit, time.Now())
+	for i := range recommendations {
+		s.openListedUser(&recommendations[i].User)
+	}
+
+	return recommendations, nil
+}
+
+// BlockUser скрывает пользователей друг от друга в рекомендациях, не даёт приглашать
+// друг друга в группы, видеть набор текста и получать ключи E2EE.
+func (s *Service) BlockUser(userID, blockedID int) error {
+	if userID == blockedID {
+		return fmt.Errorf("can't block yourself")
+	}
+
+	err := s.repo.BlockUser(userID, blockedID)
+	if err != nil {
+		log.Printf("err block user: %d -> %d, err: %v\n", userID, blockedID, err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) UnblockUser(userID, blockedID int) error {
+	err := s.repo.UnblockUser(userID, blockedID)
+	if err != nil {
+		log.Printf("err unblock user: %d -> %d, err: %v\n", userID, blockedID, err)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Service) GetMessages(currentUserID, otherUserID int, page models.MessagePageParams) (models.MessagePage, error) {
+	if page.Limit <= 0 || page.Limit > maxMessagePageSize {
+		page.Limit = defaultMessagePageSize
+	}
+
+	msgsDB, hasMore, err := s.repo.GetMessages(currentUserID, otherUserID, page)
+	if err != nil {
+		log.Printf("err get messages: %v\n", err)
+		return models.MessagePage{}, err
+	}
+
 	msgs := make([]models.Message, len(msgsDB))
 
 	for i, msg := range msgsDB {
-		decryptedMsg, err := s.sealer.Decrypt(msg.Msg)
+		msgs[i], err = s.openMessage(msg)
 		if err != nil {
 			log.Printf("err decrypt message: %v\n", err)
-			return nil, err
+			return models.MessagePage{}, err
 		}
+	}
 
-		msgs[i] = models.Message{
-			ID:         msg.ID,
-			SenderID:   msg.SenderID,
-			ReceiverID: msg.ReceiverID,
-			Msg:        string(decryptedMsg),
-			Time:       msg.Time,
-		}
+	if err = s.enrichMessages(currentUserID, msgs); err != nil {
+		return models.MessagePage{}, err
 	}
 
-	return msgs, nil
+	return models.MessagePage{Messages: msgs, HasMore: hasMore}, nil
+}
+
+func (s *Service) openMessage(msg models.MessageDB) (models.Message, error) {
+	message := models.Message{
+		ID:             msg.ID,
+		ConversationID: msg.ConversationID,
+		SenderID:       msg.SenderID,
+		ReceiverID:     msg.ReceiverID,
+		Kind:           msg.Kind,
+		Time:           msg.Time,
+		EditedAt:       msg.EditedAt,
+	}
+
+	// У удалённого сообщения текста нет, отдаём надгробие
+	if msg.DeletedAt != nil {
+		message.Deleted = true
+		return message, nil
+	}
+
+	message.ReplyTo = replyRef(msg.ReplyToID)
+
+	if msg.Kind == models.MessageKindE2EE {
+		message.Ciphertext = msg.Msg
+		return message, nil
+	}
+
+	decryptedMsg, err := s.dataKeys.OpenMessage(msg)
+	if err != nil {
+		return models.Message{}, err
+	}
+	message.Msg = string(decryptedMsg)
+
+	return message, nil
 }
 
 func (s *Service) GetUserChats(userID int) ([]models.ChatPreview, error) {
@@ -236,29 +377,78 @@ func (s *Service) GetUserChats(userID int) ([]models.ChatPreview, error) {
 		return nil, err
 	}
 
+	groupsDB, err := s.repo.GetGroupChats(userID)
+	if err != nil {
+		log.Printf("err get group chats: %v\n", err)
+		return nil, err
+	}
+
+	ids := make([]int, len(chatsDB))
+	for i, chat := range chatsDB {
+		ids[i] = chat.OtherUserID
+	}
+	online := s.onlineSet(ids)
+
+	chatsDB = append(chatsDB, groupsDB...)
 	chats := make([]models.ChatPreview, len(chatsDB))
 
 	for i, chat := range chatsDB {
-		decryptedLastMsg, err := s.sealer.Decrypt(chat.LastMessage)
+		lastMessage, err := s.lastMessagePreview(chat)
 		if err != nil {
 			log.Printf("err decrypt last message: %v\n", err)
 			return nil, err
 		}
 
 		chats[i] = models.ChatPreview{
+			Type:            chat.Type,
+			ConversationID:  chat.ConversationID,
+			Title:           chat.Title,
+			MemberCount:     chat.MemberCount,
+			E2EE:            chat.E2EE,
 			LastMessageID:   chat.LastMessageID,
 			SenderID:        chat.SenderID,
 			ReceiverID:      chat.ReceiverID,
-			LastMessage:     string(decryptedLastMsg),
+			LastMessage:     lastMessage,
 			LastMessageTime: chat.LastMessageTime,
+			LastEdited:      chat.LastEditedAt != nil,
+			LastDeleted:     chat.LastDeletedAt != nil,
 			OtherUserID:     chat.OtherUserID,
 			OtherUsername:   chat.OtherUsername,
+			UnreadCount:     chat.UnreadCount,
+		}
+
+		if chat.Type == models.ConversationDirect {
+			chats[i].OtherPresence = s.presenceOf(chat.OtherUserID, chat.OtherHidden, chat.OtherLastSeenAt, online)
 		}
 	}
 
+	sort.SliceStable(chats, func(i, j int) bool {
+		return chats[i].LastMessageTime.After(chats[j].LastMessageTime)
+	})
+
 	return chats, nil
 }
 
+// lastMessagePreview - текст последнего сообщения для списка чатов.
+func (s *Service) lastMessagePreview(chat models.ChatPreviewDB) (string, error) {
+	// В новой группе сообщений может ещё не быть
+	if chat.LastMessageID == 0 || chat.LastDeletedAt != nil {
+		return "", nil
+	}
+
+	// Текст e2ee-бесед не показываем, даже если последнее сообщение отправлено до E2EE
+	if chat.E2EE || chat.LastKind == models.MessageKindE2EE {
+		return e2eePreview, nil
+	}
+
+	text, err := s.dataKeys.OpenMessage(chat.LastMessageDB())
+	if err != nil {
+		return "", err
+	}
+
+	return string(text), nil
+}
+
 func (s *Service) ParseToken(tokenStr string) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		return []byte(s.jwtSecret), nil
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"playmates/components/blindindex"
+	"playmates/components/broker"
+	"playmates/components/db"
+	"playmates/components/playmates/models"
+	"playmates/components/repository"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testService собирает сервис на базе из PLAYMATES_TEST_DB для проверок, которые держатся
+// на репозитории. Без неё тесты пропускаются. Шифрование подставляют сами тесты.
+func testService(t *testing.T) (*Service, *sql.DB) {
+	t.Helper()
+
+	connStr := os.Getenv("PLAYMATES_TEST_DB")
+	if connStr == "" {
+		t.Skip("PLAYMATES_TEST_DB is not set")
+	}
+
+	conn, err := db.ConnectPostgres(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &Service{
+		db:     conn,
+		repo:   repository.New(conn),
+		broker: broker.NewMemory(),
+		typing: newTypingTracker(),
+	}, conn
+}
+
+var testSeq atomic.Int64
+
+func insertTestUser(t *testing.T, conn *sql.DB, name string) int {
+	t.Helper()
+
+	var id int
+	err := conn.QueryRow(`
+        INSERT INTO users (username, password_hash, age, gender, games)
+        VALUES ($1, 'hash', 0, '', '{}')
+        RETURNING id
+    `, fmt.Sprintf("%s-%d-%d", name, time.Now().UnixNano(), testSeq.Add(1))).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Exec("DELETE FROM users WHERE id = $1", id) })
+
+	return id
+}
+
+// postTestMessage сохраняет личное сообщение в обход шифрования, текст лежит как есть.
+func postTestMessage(t *testing.T, s *Service, senderID, receiverID int, text string) int {
+	t.Helper()
+
+	messageID, err := s.repo.NextMessageID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.repo.PostMessage(messageID, models.MessageKindText, []byte(text), senderID, receiverID, nil, 0, blindindex.Tokens{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return messageID
+}
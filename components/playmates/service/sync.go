@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"playmates/components/connection-manager"
+	"playmates/components/playmates/models"
+)
+
+const (
+	maxSyncPageSize = 500
+	// Больше этого при resume не отдаём, остальное клиент дочитает через /sync
+	maxResumeEvents = 5000
+)
+
+func (s *Service) Sync(userID int, since int64, limit int) (models.SyncResult, error) {
+	if limit <= 0 || limit > maxSyncPageSize {
+		limit = maxSyncPageSize
+	}
+
+	eventsDB, err := s.repo.GetEvents(userID, since, limit+1)
+	if err != nil {
+		log.Printf("err get events: %v\n", err)
+		return models.SyncResult{}, err
+	}
+
+	result := models.SyncResult{Cursor: since}
+	if len(eventsDB) > limit {
+		result.HasMore = true
+		eventsDB = eventsDB[:limit]
+	}
+	if len(eventsDB) > 0 {
+		result.Cursor = eventsDB[len(eventsDB)-1].ID
+	}
+
+	result.Events, err = s.hydrateEvents(userID, eventsDB)
+	if err != nil {
+		return models.SyncResult{}, err
+	}
+
+	return result, nil
+}
+
+func (s *Service) CursorForMessage(userID, messageID int) (int64, error) {
+	cursor, err := s.repo.CursorForMessage(userID, messageID)
+	if err != nil {
+		log.Printf("err cursor for message: %v\n", err)
+		return 0, err
+	}
+
+	return cursor, nil
+}
+
+// hydrateEvents подставляет в события журнала userID расшифрованные сообщения.
+func (s *Service) hydrateEvents(userID int, eventsDB []models.ChatEventDB) ([]models.SyncEvent, error) {
+	var messageIDs []int
+	for _, event := range eventsDB {
+		if event.MessageID != nil {
+			messageIDs = append(messageIDs, *event.MessageID)
+		}
+	}
+
+	messagesDB, err := s.repo.GetMessagesByIDs(messageIDs)
+	if err != nil {
+		log.Printf("err get messages by ids: %v\n", err)
+		return nil, err
+	}
+
+	// Сообщения расшифровываем заранее, чтобы подгрузить вложения и цитаты одним запросом
+	msgs := make([]models.Message, 0, len(messagesDB))
+	for _, msgDB := range messagesDB {
+		msg, err := s.openMessage(msgDB)
+		if err != nil {
+			log.Printf("err decrypt message: %v\n", err)
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	if err = s.enrichMessages(userID, msgs); err != nil {
+		return nil, err
+	}
+
+	opened := make(map[int]models.Message, len(msgs))
+	for _, msg := range msgs {
+		opened[msg.ID] = msg
+	}
+
+	events := make([]models.SyncEvent, 0, len(eventsDB))
+	for _, event := range eventsDB {
+		var payload interface{} = json.RawMessage(event.Data)
+		if len(event.Data) == 0 {
+			payload = struct{}{}
+		}
+
+		if event.MessageID != nil {
+			msg, exists := opened[*event.MessageID]
+			if !exists {
+				continue
+			}
+			payload = msg
+		}
+
+		rawPayload, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, models.SyncEvent{
+			Cursor:  event.ID,
+			Type:    event.Type,
+			Payload: rawPayload,
+			Time:    event.CreatedAt,
+		})
+	}
+
+	return events, nil
+}
+
+// handleResume досылает соединению события, пропущенные с курсора клиента.
+func (s *Service) handleResume(client *connection_manager.Client, env models.Envelope) {
+	var payload models.ResumePayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil || payload.Cursor < 0 {
+		s.sendError(client, env.ID, models.ErrCodeBadRequest, "invalid payload")
+		return
+	}
+
+	cursor := payload.Cursor
+	hasMore := true
+	for sent := 0; hasMore && sent < maxResumeEvents; {
+		result, err := s.Sync(client.UserID, cursor, maxSyncPageSize)
+		if err != nil {
+			s.sendError(client, env.ID, models.ErrCodeInternal, "failed to resume")
+			return
+		}
+
+		for _, event := range result.Events {
+			frame, err := json.Marshal(models.Envelope{
+				V:       models.ProtocolVersion,
+				Type:    event.Type,
+				Cursor:  event.Cursor,
+				Payload: event.Payload,
+			})
+			if err != nil {
+				log.Println("Error encoding event:", err)
+				continue
+			}
+
+			// Пачка больше очереди отправки, поэтому ждём, пока писатель её разгребёт
+			if !client.SendWait(frame) {
+				return
+			}
+		}
+
+		sent += len(result.Events)
+		cursor = result.Cursor
+		hasMore = result.HasMore
+	}
+
+	s.sendEventToClient(client, models.EventResumeDone, env.ID, models.ResumeDonePayload{
+		Cursor:  cursor,
+		HasMore: hasMore,
+	})
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"playmates/components/connection-manager"
+	"playmates/components/playmates/models"
+	"sync"
+	"time"
+)
+
+// Индикаторы набора не сохраняются: живут только в памяти узла, к которому подключён набирающий.
+// Ключ - соединение и либо собеседник, либо группа.
+type typingKey struct {
+	connID         string
+	receiverID     int
+	conversationID int
+}
+
+type typingWindow struct {
+	start time.Time
+	count int
+}
+
+type typingTracker struct {
+	mu      sync.Mutex
+	timers  map[typingKey]*time.Timer
+	windows map[string]*typingWindow
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{
+		timers:  make(map[typingKey]*time.Timer),
+		windows: make(map[string]*typingWindow),
+	}
+}
+
+// allow - ограничение числа typing-событий от одного соединения за окно.
+func (t *typingTracker) allow(connID string, limit int, window time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	w, exists := t.windows[connID]
+	if !exists || now.Sub(w.start) >= window {
+		w = &typingWindow{start: now}
+		t.windows[connID] = w
+	}
+
+	w.count++
+	return w.count <= limit
+}
+
+// start взводит таймер автоматической остановки. started = true, если набор только начался.
+func (t *typingTracker) start(key typingKey, timeout time.Duration, onTimeout func()) (started bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if timer, exists := t.timers[key]; exists {
+		timer.Reset(timeout)
+		return false
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		t.mu.Lock()
+		// Таймер могли остановить или заменить, пока он срабатывал
+		if t.timers[key] != timer {
+			t.mu.Unlock()
+			return
+		}
+		delete(t.timers, key)
+		t.mu.Unlock()
+
+		onTimeout()
+	})
+	t.timers[key] = timer
+
+	return true
+}
+
+// stop снимает таймер. Возвращает false, если набор уже был остановлен.
+func (t *typingTracker) stop(key typingKey) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timer, exists := t.timers[key]
+	if !exists {
+		return false
+	}
+
+	timer.Stop()
+	delete(t.timers, key)
+	return true
+}
+
+// dropConn забывает соединение и возвращает ключи, по которым нужно отправить typing.stop.
+func (t *typingTracker) dropConn(connID string) []typingKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.windows, connID)
+
+	var active []typingKey
+	for key, timer := range t.timers {
+		if key.connID != connID {
+			continue
+		}
+		timer.Stop()
+		delete(t.timers, key)
+		active = append(active, key)
+	}
+
+	return active
+}
+
+func (s *Service) handleTyping(client *connection_manager.Client, env models.Envelope) {
+	var payload models.TypingPayload
+	err := json.Unmarshal(env.Payload, &payload)
+	validTarget := payload.ConversationID > 0 || (payload.ReceiverID > 0 && payload.ReceiverID != client.UserID)
+	if err != nil || !validTarget {
+		s.sendError(client, env.ID, models.ErrCodeBadRequest, "invalid payload")
+		return
+	}
+
+	if !s.typing.allow(client.ID, s.ws.TypingLimit, s.ws.TypingWindow) {
+		s.sendError(client, env.ID, models.ErrCodeRateLimited, "too many typing events")
+		return
+	}
+
+	key := typingKey{connID: client.ID, receiverID: payload.ReceiverID}
+	if payload.ConversationID > 0 {
+		key = typingKey{connID: client.ID, conversationID: payload.ConversationID}
+	}
+	userID := client.UserID
+
+	if env.Type == models.EventTypingStop {
+		if s.typing.stop(key) {
+			s.sendTyping(userID, key, models.EventTypingStop)
+		}
+		return
+	}
+
+	// Пока набор продолжается, повторные start только продлевают таймер
+	started := s.typing.start(key, s.ws.TypingTimeout, func() {
+		s.sendTyping(userID, key, models.EventTypingStop)
+	})
+	if !started {
+		return
+	}
+
+	if !s.canType(userID, key) {
+		s.typing.stop(key)
+		return
+	}
+
+	s.sendTyping(userID, key, models.EventTypingStart)
+}
+
+// canType - в личной переписке не должно быть блокировки, в группе нужно быть участником.
+func (s *Service) canType(userID int, key typingKey) bool {
+	if key.conversationID > 0 {
+		_, member, err := s.repo.GetMemberRole(key.conversationID, userID)
+		if err != nil {
+			log.Println("Error checking membership:", err)
+		}
+		return err == nil && member
+	}
+
+	blocked, err := s.repo.IsBlocked(userID, key.receiverID)
+	if err != nil {
+		log.Println("Error checking block:", err)
+	}
+	return err == nil && !blocked
+}
+
+func (s *Service) stopTyping(client *connection_manager.Client) {
+	for _, key := range s.typing.dropConn(client.ID) {
+		s.sendTyping(client.UserID, key, models.EventTypingStop)
+	}
+}
+
+func (s *Service) sendTyping(userID int, key typingKey, eventType string) {
+	if key.conversationID == 0 {
+		s.sendEvent(key.receiverID, eventType, "", models.TypingPayload{UserID: userID})
+		return
+	}
+
+	members, err := s.repo.GetConversationMemberIDs(key.conversationID)
+	if err != nil {
+		log.Println("Error getting conversation members:", err)
+		return
+	}
+
+	payload := models.TypingPayload{UserID: userID, ConversationID: key.conversationID}
+	for _, memberID := range members {
+		if memberID != userID {
+			s.sendEvent(memberID, eventType, "", payload)
+		}
+	}
+}
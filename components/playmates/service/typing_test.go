@@ -0,0 +1,131 @@
+package service
+
+import (
+	"encoding/json"
+	"playmates/components/playmates/models"
+	"testing"
+	"time"
+)
+
+func TestTypingAllow(t *testing.T) {
+	tracker := newTypingTracker()
+
+	for i := 0; i < 2; i++ {
+		if !tracker.allow("c1", 2, 50*time.Millisecond) {
+			t.Fatalf("event %d rejected within the limit", i+1)
+		}
+	}
+	if tracker.allow("c1", 2, 50*time.Millisecond) {
+		t.Fatal("event over the limit allowed")
+	}
+	// Лимит у каждого соединения свой
+	if !tracker.allow("c2", 2, 50*time.Millisecond) {
+		t.Fatal("other connection rejected")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !tracker.allow("c1", 2, 50*time.Millisecond) {
+		t.Fatal("event rejected in a new window")
+	}
+}
+
+func TestTypingAutoStop(t *testing.T) {
+	tracker := newTypingTracker()
+	key := typingKey{connID: "c1", receiverID: 2}
+	stopped := make(chan struct{}, 2)
+	onTimeout := func() { stopped <- struct{}{} }
+
+	if !tracker.start(key, 100*time.Millisecond, onTimeout) {
+		t.Fatal("first start did not start typing")
+	}
+
+	// Повторный start продлевает таймер, а не начинает набор заново
+	time.Sleep(60 * time.Millisecond)
+	if tracker.start(key, 100*time.Millisecond, onTimeout) {
+		t.Fatal("repeated start started typing again")
+	}
+
+	select {
+	case <-stopped:
+		t.Fatal("typing stopped before the extended timeout")
+	case <-time.After(70 * time.Millisecond):
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("typing did not stop on timeout")
+	}
+
+	// После автоостановки stop уже ничего не снимает
+	if tracker.stop(key) {
+		t.Fatal("stop after timeout reported active typing")
+	}
+
+	select {
+	case <-stopped:
+		t.Fatal("timeout fired twice")
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestTypingStopCancelsTimer(t *testing.T) {
+	tracker := newTypingTracker()
+	key := typingKey{connID: "c1", conversationID: 7}
+	fired := make(chan struct{}, 1)
+
+	tracker.start(key, 30*time.Millisecond, func() { fired <- struct{}{} })
+	if !tracker.stop(key) {
+		t.Fatal("stop did not find active typing")
+	}
+	if tracker.stop(key) {
+		t.Fatal("second stop reported active typing")
+	}
+
+	select {
+	case <-fired:
+		t.Fatal("stopped typing timed out")
+	case <-time.After(80 * time.Millisecond):
+	}
+}
+
+func TestTypingDropConn(t *testing.T) {
+	tracker := newTypingTracker()
+	noop := func() {}
+
+	phoneChat := typingKey{connID: "phone", receiverID: 2}
+	phoneGroup := typingKey{connID: "phone", conversationID: 7}
+	desktop := typingKey{connID: "desktop", receiverID: 2}
+	for _, key := range []typingKey{phoneChat, phoneGroup, desktop} {
+		tracker.start(key, time.Hour, noop)
+	}
+
+	active := tracker.dropConn("phone")
+	if len(active) != 2 {
+		t.Fatalf("dropConn returned %v, want both phone keys", active)
+	}
+	for _, key := range active {
+		if key != phoneChat && key != phoneGroup {
+			t.Fatalf("dropConn returned foreign key %+v", key)
+		}
+	}
+
+	if !tracker.stop(desktop) {
+		t.Fatal("dropConn stopped typing on another connection")
+	}
+}
+
+// Проверки typing идут до обращения к базе: лишний stop и превышение лимита ничего не рассылают.
+func TestHandleTypingRejects(t *testing.T) {
+	s := &Service{typing: newTypingTracker(), ws: WebSocketConfig{TypingLimit: 1, TypingWindow: time.Minute, TypingTimeout: time.Minute}}
+	client, conn := addTestClient(t, s, 1)
+
+	s.handleTyping(client, models.Envelope{V: models.ProtocolVersion, Type: models.EventTypingStart, ID: "t1", Payload: json.RawMessage(`{"receiver_id":1}`)})
+	assertError(t, nextEnvelope(t, conn), "t1", models.ErrCodeBadRequest)
+
+	s.handleTyping(client, models.Envelope{V: models.ProtocolVersion, Type: models.EventTypingStop, ID: "t2", Payload: json.RawMessage(`{"receiver_id":2}`)})
+	assertNoFrame(t, conn)
+
+	s.handleTyping(client, models.Envelope{V: models.ProtocolVersion, Type: models.EventTypingStop, ID: "t3", Payload: json.RawMessage(`{"receiver_id":2}`)})
+	assertError(t, nextEnvelope(t, conn), "t3", models.ErrCodeRateLimited)
+}
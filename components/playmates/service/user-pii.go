@@ -0,0 +1,104 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"playmates/components/blindindex"
+	"playmates/components/playmates/models"
+	"strings"
+)
+
+// Сколько слов about_me показывать в сниппете поиска
+const snippetWords = 20
+
+// openUser расшифровывает about_me, а email - только если профиль смотрит его владелец,
+// остальным email не отдаётся. У строк, которые ещё не перенесла команда backfill-users,
+// зашифрованных значений нет и открытые поля остаются как есть.
+func (s *Service) openUser(user *models.User, viewerID int) error {
+	if viewerID != user.ID {
+		user.Email = ""
+	} else if len(user.EmailSealed) > 0 {
+		email, err := s.sealer.DecryptWithAD(user.EmailSealed, models.UserFieldAD(user.ID, models.UserFieldEmail))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt email: %w", err)
+		}
+		user.Email = string(email)
+	}
+
+	if len(user.AboutMeSealed) > 0 {
+		aboutMe, err := s.sealer.DecryptWithAD(user.AboutMeSealed, models.UserFieldAD(user.ID, models.UserFieldAboutMe))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt about_me: %w", err)
+		}
+		user.AboutMe = string(aboutMe)
+	}
+
+	return nil
+}
+
+// openListedUser - openUser для пользователей в выдаче, без email: если about_me не
+// расшифровался, пользователь остаётся без него, чтобы одна строка не ломала весь список.
+func (s *Service) openListedUser(user *models.User) {
+	if err := s.openUser(user, 0); err != nil {
+		log.Printf("err open user: %d, err: %v\n", user.ID, err)
+		user.Email, user.AboutMe = "", ""
+	}
+}
+
+// sealAboutMe шифрует about_me для записи и возвращает токены слепого индекса.
+// Пустой about_me хранится как NULL.
+func (s *Service) sealAboutMe(user *models.User) (blindindex.Tokens, error) {
+	user.AboutMeSealed = nil
+	tokens := s.index.ProfileTokens(user.AboutMe)
+	if user.AboutMe == "" {
+		return tokens, nil
+	}
+
+	sealed, err := s.sealer.EncryptWithAD([]byte(user.AboutMe), models.UserFieldAD(user.ID, models.UserFieldAboutMe))
+	if err != nil {
+		return blindindex.Tokens{}, fmt.Errorf("failed to encrypt about_me: %w", err)
+	}
+	user.AboutMeSealed = sealed
+
+	return tokens, nil
+}
+
+// profileSnippet заменяет ts_headline для зашифрованного about_me: до snippetWords слов
+// вокруг первого совпадения, совпавшие слова в <mark>. Слово совпадает, только если оно
+// целиком равно слову запроса, как и в слепом индексе: иначе сниппет подсвечивал бы
+// слова, по которым профиль не нашёлся бы.
+func profileSnippet(aboutMe, query string) string {
+	queryWords := blindindex.Words(query)
+	fields := strings.Fields(aboutMe)
+
+	first := -1
+	marked := make([]string, len(fields))
+	for i, field := range fields {
+		marked[i] = field
+		if matchesQuery(field, queryWords) {
+			marked[i] = "<mark>" + field + "</mark>"
+			if first < 0 {
+				first = i
+			}
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	start := max(0, first-snippetWords/4)
+	end := min(len(fields), start+snippetWords)
+
+	return strings.Join(marked[start:end], " ")
+}
+
+func matchesQuery(field string, queryWords []string) bool {
+	for _, word := range blindindex.Words(field) {
+		for _, q := range queryWords {
+			if word == q {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"playmates/components/playmates/models"
+	"playmates/components/sealer"
+	"strings"
+	"testing"
+)
+
+func newTestUser(t *testing.T, s *sealer.Sealer, id int) models.User {
+	t.Helper()
+
+	email, err := s.EncryptWithAD([]byte("user@example.com"), models.UserFieldAD(id, models.UserFieldEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aboutMe, err := s.EncryptWithAD([]byte("about me"), models.UserFieldAD(id, models.UserFieldAboutMe))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return models.User{ID: id, EmailSealed: email, AboutMeSealed: aboutMe}
+}
+
+func TestOpenUserShowsEmailOnlyToOwner(t *testing.T) {
+	server, err := sealer.New([]byte(strings.Repeat("s", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Service{sealer: server}
+
+	own := newTestUser(t, server, 5)
+	if err = s.openUser(&own, 5); err != nil {
+		t.Fatal(err)
+	}
+	if own.Email != "user@example.com" || own.AboutMe != "about me" {
+		t.Errorf("openUser() for owner = %q, %q", own.Email, own.AboutMe)
+	}
+
+	other := newTestUser(t, server, 5)
+	if err = s.openUser(&other, 6); err != nil {
+		t.Fatal(err)
+	}
+	if other.Email != "" || other.AboutMe != "about me" {
+		t.Errorf("openUser() for another user = %q, %q, want email hidden", other.Email, other.AboutMe)
+	}
+
+	// Строка, которую ещё не перенесла backfill-users, хранит email открытым
+	plaintext := models.User{ID: 5, Email: "user@example.com", AboutMe: "about me"}
+	if err = s.openUser(&plaintext, 6); err != nil {
+		t.Fatal(err)
+	}
+	if plaintext.Email != "" {
+		t.Errorf("openUser() for another user kept plaintext email %q", plaintext.Email)
+	}
+
+	// В выдаче поиска и рекомендаций email не виден никому, даже самому пользователю
+	listed := newTestUser(t, server, 5)
+	s.openListedUser(&listed)
+	if listed.Email != "" || listed.AboutMe != "about me" {
+		t.Errorf("openListedUser() = %q, %q, want email hidden", listed.Email, listed.AboutMe)
+	}
+}
+
+// Сниппет подсвечивает те же целые слова, по которым находит слепой индекс.
+func TestProfileSnippet(t *testing.T) {
+	tests := []struct {
+		name    string
+		aboutMe string
+		query   string
+		want    string
+	}{
+		{"whole word", "Играю в Dota по вечерам", "dota", "Играю в <mark>Dota</mark> по вечерам"},
+		{"punctuation", "Люблю шутеры, стратегии.", "стратегии", "Люблю шутеры, <mark>стратегии.</mark>"},
+		{"several words", "играю в dota и cs", "cs dota", "играю в <mark>dota</mark> и <mark>cs</mark>"},
+		{"prefix", "программист и геймер", "прог", ""},
+		{"longer word", "dota2 каждый день", "dota", ""},
+		{"no match", "играю в шахматы", "dota", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := profileSnippet(tt.aboutMe, tt.query); got != tt.want {
+				t.Errorf("profileSnippet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
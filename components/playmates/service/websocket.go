@@ -0,0 +1,384 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"playmates/components/broker"
+	"playmates/components/connection-manager"
+	"playmates/components/playmates/models"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+const maxMessageLength = 4000
+
+type WebSocketConfig struct {
+	PongWait    time.Duration
+	IdleTimeout time.Duration
+	ReadLimit   int64
+
+	TypingTimeout time.Duration
+	TypingLimit   int
+	TypingWindow  time.Duration
+}
+
+// CloseConn закрывает соединение, которое ещё не попало в ConnectionManager.
+func CloseConn(c *websocket.Conn, code int, text string) {
+	c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+}
+
+func (s *Service) HandleWebSocket(c *websocket.Conn, userID int) {
+	if userID <= 0 {
+		CloseConn(c, websocket.ClosePolicyViolation, "invalid user ID")
+		return
+	}
+
+	// Добавляем соединение в менеджер. Удаляем при выходе именно его,
+	// а не все соединения пользователя.
+	client, first := s.connectionManager.Add(userID, c)
+	if first {
+		s.userConnected(userID)
+	}
+	defer func() {
+		s.stopTyping(client)
+		if s.connectionManager.Remove(client) {
+			s.userDisconnected(userID)
+		}
+		client.Close()
+	}()
+
+	// Дедлайн чтения продлевается понгами, но не дальше, чем через IdleTimeout после последнего кадра
+	lastFrame := time.Now()
+	extendDeadline := func() {
+		deadline := time.Now().Add(s.ws.PongWait)
+		if idle := lastFrame.Add(s.ws.IdleTimeout); idle.Before(deadline) {
+			deadline = idle
+		}
+		c.SetReadDeadline(deadline)
+	}
+
+	c.SetReadLimit(s.ws.ReadLimit)
+	c.SetPongHandler(func(string) error {
+		extendDeadline()
+		return nil
+	})
+	extendDeadline()
+
+	for {
+		// Чтение кадра. Писать в c отсюда нельзя, этим занимается писатель клиента
+		_, frame, err := c.ReadMessage()
+		if err != nil {
+			code, text, reason := s.classifyReadError(err, lastFrame)
+			if reason != connection_manager.ReasonClientClosed {
+				log.Printf("WebSocket read error: user %d, conn %s, err: %v\n", userID, client.ID, err)
+			}
+			client.Disconnect(code, text, reason)
+			break
+		}
+
+		lastFrame = time.Now()
+		extendDeadline()
+
+		if s.connectionManager.Touch(client) {
+			s.broadcastPresence(userID)
+		}
+
+		env, err := parseEnvelope(frame)
+		if err != nil {
+			s.sendError(client, "", models.ErrCodeBadRequest, "invalid frame")
+			continue
+		}
+
+		if env.V != models.ProtocolVersion {
+			s.sendError(client, env.ID, models.ErrCodeUnsupportedVersion, "unsupported protocol version")
+			continue
+		}
+
+		s.dispatch(client, env)
+	}
+}
+
+func (s *Service) classifyReadError(err error, lastFrame time.Time) (int, string, string) {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+		return websocket.CloseNormalClosure, "", connection_manager.ReasonClientClosed
+	}
+
+	if errors.Is(err, websocket.ErrReadLimit) {
+		return websocket.ClosePolicyViolation, "message too big", connection_manager.ReasonPolicy
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		if time.Since(lastFrame) >= s.ws.IdleTimeout {
+			return websocket.CloseNormalClosure, "idle timeout", connection_manager.ReasonIdleTimeout
+		}
+		return websocket.ClosePolicyViolation, "pong timeout", connection_manager.ReasonPongTimeout
+	}
+
+	return websocket.CloseInternalServerErr, "read error", connection_manager.ReasonReadError
+}
+
+// parseEnvelope разбирает кадр. Кадры старого формата (голый models.Message без type)
+// считаются message.send, чтобы старые клиенты продолжали работать.
+func parseEnvelope(frame []byte) (models.Envelope, error) {
+	var env models.Envelope
+	if err := json.Unmarshal(frame, &env); err != nil {
+		return models.Envelope{}, err
+	}
+
+	if env.Type == "" {
+		var legacy models.Message
+		if err := json.Unmarshal(frame, &legacy); err != nil {
+			return models.Envelope{}, err
+		}
+
+		payload, err := json.Marshal(models.MessageSendPayload{
+			ReceiverID: legacy.ReceiverID,
+			Msg:        legacy.Msg,
+		})
+		if err != nil {
+			return models.Envelope{}, err
+		}
+
+		return models.Envelope{V: models.ProtocolVersion, Type: models.EventMessageSend, Payload: payload}, nil
+	}
+
+	if env.V == 0 {
+		env.V = models.ProtocolVersion
+	}
+
+	return env, nil
+}
+
+func (s *Service) dispatch(client *connection_manager.Client, env models.Envelope) {
+	switch env.Type {
+	case models.EventMessageSend:
+		s.handleMessageSend(client, env)
+	case models.EventTypingStart, models.EventTypingStop, models.EventTyping:
+		s.handleTyping(client, env)
+	case models.EventResume:
+		s.handleResume(client, env)
+	case models.EventRead:
+		s.handleRead(client, env)
+	case models.EventDelivered:
+		s.handleDelivered(client, env)
+	case models.EventMessageEdit:
+		s.handleMessageEdit(client, env)
+	case models.EventMessageDelete:
+		s.handleMessageDelete(client, env)
+	case models.EventReactionAdd, models.EventReactionRemove:
+		s.handleReaction(client, env)
+	default:
+		s.sendError(client, env.ID, models.ErrCodeUnknownType, "unknown event type")
+	}
+}
+
+func (s *Service) handleMessageSend(client *connection_manager.Client, env models.Envelope) {
+	userID := client.UserID
+
+	var payload models.MessageSendPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		s.sendError(client, env.ID, models.ErrCodeBadRequest, "invalid payload")
+		return
+	}
+
+	if payload.ConversationID > 0 {
+		s.handleConversationSend(client, env, payload)
+		return
+	}
+
+	if payload.ReceiverID <= 0 || payload.ReceiverID == userID {
+		s.sendError(client, env.ID, models.ErrCodeBadRequest, "invalid receiver")
+		return
+	}
+
+	var attachmentIDs []int
+	var err error
+	if len(payload.Ciphertext) > 0 {
+		err = checkEncryptedContent(payload)
+	} else {
+		attachmentIDs, err = s.checkMessageContent(userID, payload.Msg, payload.AttachmentIDs)
+	}
+	if err != nil {
+		s.sendMessageError(client, env.ID, err, "failed to send message")
+		return
+	}
+
+	if err = s.checkReply(userID, payload); err != nil {
+		s.sendMessageError(client, env.ID, err, "failed to send message")
+		return
+	}
+
+	// Токены поиска солятся ID беседы, а шифротекст к ней привязан, поэтому её нужно знать до сохранения
+	conversationID, err := s.repo.EnsureDirectConversation(userID, payload.ReceiverID)
+	if err != nil {
+		log.Println("Error getting conversation:", err)
+		s.sendError(client, env.ID, models.ErrCodeInternal, "failed to send message")
+		return
+	}
+
+	messageID, kind, body, tokens, err := s.directMessageBody(conversationID, userID, payload)
+	if err != nil {
+		s.sendMessageError(client, env.ID, err, "failed to send message")
+		return
+	}
+
+	// Сохраняем сообщение в базе данных
+	saved, cursors, err := s.repo.PostMessage(messageID, kind, body, userID, payload.ReceiverID, attachmentIDs, payload.ReplyToID, tokens)
+	if err != nil {
+		log.Println("Error saving message:", err)
+		s.sendError(client, env.ID, models.ErrCodeInternal, "failed to send message")
+		return
+	}
+
+	// Сообщение заменяет индикатор набора, отдельный typing.stop не нужен
+	s.typing.stop(typingKey{connID: client.ID, receiverID: payload.ReceiverID})
+
+	if err = s.repo.TouchUser(userID); err != nil {
+		log.Println("Error updating last activity:", err)
+	}
+
+	ack, err := newEnvelope(models.EventAck, env.ID, models.AckPayload{
+		MessageID: saved.ID,
+		Time:      saved.Time,
+	})
+	if err == nil {
+		ack.Cursor = cursors[userID]
+		s.sendToClient(client, ack)
+	}
+
+	// Сообщение собирается из сохранённой строки, а не из кадра клиента.
+	// Получатель получает его на все устройства, отправитель - на остальные свои.
+	message, err := s.newMessageEnvelope(models.Message{
+		ID:             saved.ID,
+		ConversationID: saved.ConversationID,
+		SenderID:       saved.SenderID,
+		ReceiverID:     saved.ReceiverID,
+		Kind:           saved.Kind,
+		Msg:            payload.Msg,
+		Ciphertext:     payload.Ciphertext,
+		Time:           saved.Time,
+		ReplyTo:        replyRef(saved.ReplyToID),
+	}, len(attachmentIDs) > 0)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	message.Cursor = cursors[payload.ReceiverID]
+	s.publish(payload.ReceiverID, "", message)
+
+	message.Cursor = cursors[userID]
+	s.publish(userID, client.ID, message)
+}
+
+func (s *Service) sendError(client *connection_manager.Client, id, code, message string) {
+	s.sendEventToClient(client, models.EventError, id, models.ErrorPayload{Code: code, Message: message})
+}
+
+// sendEvent отправляет событие на все устройства пользователя.
+func (s *Service) sendEvent(userID int, eventType, id string, payload interface{}) {
+	s.sendEventExcept(userID, "", eventType, id, payload)
+}
+
+// sendEventExcept отправляет событие на все устройства пользователя, кроме соединения exceptID.
+func (s *Service) sendEventExcept(userID int, exceptID string, eventType, id string, payload interface{}) {
+	env, err := newEnvelope(eventType, id, payload)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	s.publish(userID, exceptID, env)
+}
+
+// publish отправляет кадр через брокер, поэтому он доходит и до устройств на других узлах.
+func (s *Service) publish(userID int, exceptID string, env models.Envelope) {
+	frame, err := json.Marshal(env)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	err = s.broker.Publish(context.Background(), broker.Delivery{
+		UserID:     userID,
+		ExceptConn: exceptID,
+		Frame:      frame,
+	})
+	if err != nil {
+		log.Println("Error publishing event:", err)
+	}
+}
+
+// publishCursors отправляет кадр каждому пользователю из cursors с его курсором журнала.
+// У пользователя exceptUserID пропускается соединение exceptConn.
+func (s *Service) publishCursors(env models.Envelope, cursors map[int]int64, exceptUserID int, exceptConn string) {
+	for userID, cursor := range cursors {
+		env.Cursor = cursor
+
+		exceptID := ""
+		if userID == exceptUserID {
+			exceptID = exceptConn
+		}
+		s.publish(userID, exceptID, env)
+	}
+}
+
+// deliverLocal отдаёт кадр из брокера соединениям пользователя на этом узле.
+func (s *Service) deliverLocal(d broker.Delivery) {
+	clients, exists := s.connectionManager.Get(d.UserID)
+	if !exists {
+		return
+	}
+
+	for _, client := range clients {
+		if client.ID == d.ExceptConn {
+			continue
+		}
+		writeFrame(client, d.Frame)
+	}
+}
+
+func (s *Service) sendEventToClient(client *connection_manager.Client, eventType, id string, payload interface{}) {
+	env, err := newEnvelope(eventType, id, payload)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	s.sendToClient(client, env)
+}
+
+func (s *Service) sendToClient(client *connection_manager.Client, env models.Envelope) {
+	frame, err := json.Marshal(env)
+	if err != nil {
+		log.Println("Error encoding event:", err)
+		return
+	}
+
+	writeFrame(client, frame)
+}
+
+func writeFrame(client *connection_manager.Client, frame []byte) {
+	if !client.Send(frame) {
+		log.Printf("Frame dropped: user %d, conn %s\n", client.UserID, client.ID)
+	}
+}
+
+func newEnvelope(eventType, id string, payload interface{}) (models.Envelope, error) {
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return models.Envelope{}, err
+	}
+
+	return models.Envelope{
+		V:       models.ProtocolVersion,
+		Type:    eventType,
+		ID:      id,
+		Payload: rawPayload,
+	}, nil
+}
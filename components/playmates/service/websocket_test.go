@@ -0,0 +1,214 @@
+package service
+
+import (
+	"encoding/json"
+	"playmates/components/broker"
+	"playmates/components/connection-manager"
+	"playmates/components/playmates/models"
+	"testing"
+	"time"
+)
+
+// frameConn отдаёт кадры, записанные писателем клиента, в канал.
+type frameConn struct {
+	frames chan []byte
+}
+
+func (f *frameConn) WriteMessage(messageType int, data []byte) error {
+	f.frames <- data
+	return nil
+}
+
+func (f *frameConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return nil
+}
+
+func (f *frameConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (f *frameConn) Close() error {
+	return nil
+}
+
+// addTestClient подключает устройство пользователя к менеджеру соединений сервиса.
+func addTestClient(t *testing.T, s *Service, userID int) (*connection_manager.Client, *frameConn) {
+	t.Helper()
+
+	if s.connectionManager == nil {
+		s.connectionManager = connection_manager.New(64, time.Second, time.Hour)
+	}
+
+	conn := &frameConn{frames: make(chan []byte, 64)}
+	client, _ := s.connectionManager.Add(userID, conn)
+	t.Cleanup(func() {
+		s.connectionManager.Remove(client)
+		client.Close()
+	})
+
+	return client, conn
+}
+
+func nextEnvelope(t *testing.T, conn *frameConn) models.Envelope {
+	t.Helper()
+
+	select {
+	case frame := <-conn.frames:
+		var env models.Envelope
+		if err := json.Unmarshal(frame, &env); err != nil {
+			t.Fatalf("invalid frame %s: %v", frame, err)
+		}
+		return env
+	case <-time.After(2 * time.Second):
+		t.Fatal("no frame written")
+		return models.Envelope{}
+	}
+}
+
+func assertNoFrame(t *testing.T, conn *frameConn) {
+	t.Helper()
+
+	select {
+	case frame := <-conn.frames:
+		t.Errorf("unexpected frame %s", frame)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func assertError(t *testing.T, env models.Envelope, id, code string) {
+	t.Helper()
+
+	if env.Type != models.EventError || env.ID != id || env.V != models.ProtocolVersion {
+		t.Fatalf("envelope = %+v, want error with id %q", env, id)
+	}
+	var payload models.ErrorPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Code != code {
+		t.Errorf("error code = %q, want %q", payload.Code, code)
+	}
+}
+
+func TestParseEnvelope(t *testing.T) {
+	env, err := parseEnvelope([]byte(`{"v":1,"type":"typing.start","id":"7","payload":{"receiver_id":2}}`))
+	if err != nil || env.Type != models.EventTypingStart || env.ID != "7" || string(env.Payload) != `{"receiver_id":2}` {
+		t.Errorf("parseEnvelope() = %+v, %v", env, err)
+	}
+
+	// Версия по умолчанию - текущая
+	env, err = parseEnvelope([]byte(`{"type":"read","payload":{}}`))
+	if err != nil || env.V != models.ProtocolVersion {
+		t.Errorf("parseEnvelope() without v = %+v, %v", env, err)
+	}
+
+	env, err = parseEnvelope([]byte(`{"v":2,"type":"read"}`))
+	if err != nil || env.V != 2 {
+		t.Errorf("parseEnvelope() keeps client version: %+v, %v", env, err)
+	}
+
+	if _, err = parseEnvelope([]byte(`not json`)); err == nil {
+		t.Error("parseEnvelope() of invalid JSON succeeded")
+	}
+}
+
+// Кадр старого формата становится message.send, но отправитель и время из него не берутся.
+func TestParseEnvelopeLegacyMessage(t *testing.T) {
+	env, err := parseEnvelope([]byte(`{"sender_id":99,"receiver_id":2,"msg":"hi","time":"2001-01-01T00:00:00Z"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != models.EventMessageSend || env.V != models.ProtocolVersion {
+		t.Fatalf("parseEnvelope() = %+v, want message.send", env)
+	}
+
+	var raw map[string]interface{}
+	if err = json.Unmarshal(env.Payload, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if raw["receiver_id"] != float64(2) || raw["msg"] != "hi" {
+		t.Errorf("payload = %s", env.Payload)
+	}
+	if _, ok := raw["sender_id"]; ok {
+		t.Errorf("payload = %s keeps client sender_id", env.Payload)
+	}
+	if _, ok := raw["time"]; ok {
+		t.Errorf("payload = %s keeps client time", env.Payload)
+	}
+}
+
+func TestDispatchUnknownType(t *testing.T) {
+	s := &Service{}
+	client, conn := addTestClient(t, s, 1)
+
+	s.dispatch(client, models.Envelope{V: models.ProtocolVersion, Type: "message.explode", ID: "42"})
+	assertError(t, nextEnvelope(t, conn), "42", models.ErrCodeUnknownType)
+}
+
+// Невалидная отправка отклоняется с ID кадра до обращения к базе.
+func TestHandleMessageSendRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"invalid payload", `"text"`},
+		{"no receiver", `{"msg":"hi"}`},
+		{"to self", `{"receiver_id":1,"msg":"hi"}`},
+		{"negative receiver", `{"receiver_id":-3,"msg":"hi"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			client, conn := addTestClient(t, s, 1)
+
+			s.handleMessageSend(client, models.Envelope{V: models.ProtocolVersion, Type: models.EventMessageSend, ID: "m1", Payload: json.RawMessage(tt.payload)})
+			assertError(t, nextEnvelope(t, conn), "m1", models.ErrCodeBadRequest)
+			assertNoFrame(t, conn)
+		})
+	}
+}
+
+func TestNewEnvelope(t *testing.T) {
+	env, err := newEnvelope(models.EventAck, "5", models.AckPayload{MessageID: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.V != models.ProtocolVersion || env.Type != models.EventAck || env.ID != "5" {
+		t.Errorf("newEnvelope() = %+v", env)
+	}
+
+	var ack models.AckPayload
+	if err = json.Unmarshal(env.Payload, &ack); err != nil || ack.MessageID != 10 {
+		t.Errorf("ack payload = %s, %v", env.Payload, err)
+	}
+}
+
+// Кадр из брокера уходит на все устройства пользователя, кроме отправившего.
+func TestDeliverLocalFansOutToDevices(t *testing.T) {
+	s := &Service{}
+	phone, phoneConn := addTestClient(t, s, 1)
+	_, desktopConn := addTestClient(t, s, 1)
+	_, otherConn := addTestClient(t, s, 2)
+
+	frame, err := json.Marshal(models.Envelope{V: models.ProtocolVersion, Type: models.EventMessageNew})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.deliverLocal(broker.Delivery{UserID: 1, Frame: frame})
+	for _, conn := range []*frameConn{phoneConn, desktopConn} {
+		if env := nextEnvelope(t, conn); env.Type != models.EventMessageNew {
+			t.Errorf("device got %+v", env)
+		}
+	}
+	assertNoFrame(t, otherConn)
+
+	// Эхо своего сообщения - только на другие устройства отправителя
+	s.deliverLocal(broker.Delivery{UserID: 1, ExceptConn: phone.ID, Frame: frame})
+	nextEnvelope(t, desktopConn)
+	assertNoFrame(t, phoneConn)
+
+	// Пользователь без соединений на этом узле
+	s.deliverLocal(broker.Delivery{UserID: 3, Frame: frame})
+}
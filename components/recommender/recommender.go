@@ -0,0 +1,129 @@
+package recommender
+
+import (
+	"math"
+	"playmates/components/playmates/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Weights struct {
+	Games        float64
+	Age          float64
+	Languages    float64
+	Availability float64
+	Activity     float64
+}
+
+type Recommender struct {
+	weights          Weights
+	ageSpan          float64
+	activityHalfLife time.Duration
+}
+
+func New(weights Weights, ageSpan int, activityHalfLife time.Duration) *Recommender {
+	if ageSpan <= 0 {
+		ageSpan = 10
+	}
+	if activityHalfLife <= 0 {
+		activityHalfLife = 72 * time.Hour
+	}
+
+	return &Recommender{
+		weights:          weights,
+		ageSpan:          float64(ageSpan),
+		activityHalfLife: activityHalfLife,
+	}
+}
+
+// Score оценивает кандидата для пользователя me. Каждый критерий нормирован в [0, 1]
+// и умножается на свой вес.
+func (r *Recommender) Score(me, candidate models.User, now time.Time) float64 {
+	score := r.weights.Games * overlap(me.Games, candidate.Games)
+	score += r.weights.Age * r.ageProximity(me.Age, candidate.Age)
+	score += r.weights.Languages * overlap(me.Languages, candidate.Languages)
+	score += r.weights.Availability * overlap(me.Availability, candidate.Availability)
+	score += r.weights.Activity * r.activity(candidate.LastActiveAt, now)
+
+	return score
+}
+
+func (r *Recommender) Rank(me models.User, candidates []models.User, limit int, now time.Time) []models.Recommendation {
+	recs := make([]models.Recommendation, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.ID == me.ID {
+			continue
+		}
+		recs = append(recs, models.Recommendation{
+			User:  candidate,
+			Score: r.Score(me, candidate, now),
+		})
+	}
+
+	sort.SliceStable(recs, func(i, j int) bool {
+		if recs[i].Score != recs[j].Score {
+			return recs[i].Score > recs[j].Score
+		}
+		return recs[i].User.ID > recs[j].User.ID
+	})
+
+	if limit > 0 && len(recs) > limit {
+		recs = recs[:limit]
+	}
+
+	return recs
+}
+
+func (r *Recommender) ageProximity(a, b int) float64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+
+	diff := math.Abs(float64(a - b))
+	return math.Max(0, 1-diff/r.ageSpan)
+}
+
+func (r *Recommender) activity(lastActive, now time.Time) float64 {
+	if lastActive.IsZero() {
+		return 0
+	}
+
+	elapsed := now.Sub(lastActive)
+	if elapsed <= 0 {
+		return 1
+	}
+
+	return math.Pow(0.5, float64(elapsed)/float64(r.activityHalfLife))
+}
+
+// overlap - коэффициент Жаккара для двух списков без учёта регистра.
+func overlap(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	set := make(map[string]struct{}, len(a))
+	for _, v := range a {
+		set[strings.ToLower(v)] = struct{}{}
+	}
+
+	union := len(set)
+	common := 0
+	seen := make(map[string]struct{}, len(b))
+	for _, v := range b {
+		v = strings.ToLower(v)
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+
+		if _, ok := set[v]; ok {
+			common++
+		} else {
+			union++
+		}
+	}
+
+	return float64(common) / float64(union)
+}
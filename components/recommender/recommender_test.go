@@ -0,0 +1,156 @@
+package recommender
+
+import (
+	"math"
+	"playmates/components/playmates/models"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestScoreUsesOnlyWeightedCriteria(t *testing.T) {
+	me := models.User{
+		ID: 1, Age: 20,
+		Games:        []string{"dota", "cs"},
+		Languages:    []string{"ru"},
+		Availability: []string{"evening"},
+	}
+	candidate := models.User{
+		ID: 2, Age: 25,
+		Games:        []string{"CS", "valorant"},
+		Languages:    []string{"ru", "en"},
+		Availability: []string{"evening"},
+		LastActiveAt: now.Add(-72 * time.Hour),
+	}
+
+	tests := []struct {
+		name    string
+		weights Weights
+		want    float64
+	}{
+		// Игры: общая одна из трёх, регистр не важен
+		{"games", Weights{Games: 1}, 1.0 / 3},
+		// Возраст: разница 5 при ageSpan 10
+		{"age", Weights{Age: 1}, 0.5},
+		{"languages", Weights{Languages: 1}, 0.5},
+		{"availability", Weights{Availability: 1}, 1},
+		// Активность: ровно один период полураспада назад
+		{"activity", Weights{Activity: 1}, 0.5},
+		{"weighted sum", Weights{Games: 0.3, Age: 0.2, Languages: 0.2, Availability: 0.2, Activity: 0.1}, 0.3/3 + 0.1 + 0.1 + 0.2 + 0.05},
+		{"zero weights", Weights{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(tt.weights, 10, 72*time.Hour)
+			if got := r.Score(me, candidate, now); !almostEqual(got, tt.want) {
+				t.Errorf("Score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScoreMissingData(t *testing.T) {
+	r := New(Weights{Games: 1, Age: 1, Languages: 1, Availability: 1, Activity: 1}, 10, 72*time.Hour)
+
+	// Пустой профиль и неизвестная активность ничего не добавляют
+	if got := r.Score(models.User{ID: 1}, models.User{ID: 2}, now); got != 0 {
+		t.Errorf("Score() of empty profiles = %v, want 0", got)
+	}
+
+	// Активность в будущем (рассинхрон часов) считается максимальной
+	future := models.User{ID: 2, LastActiveAt: now.Add(time.Minute)}
+	if got := r.Score(models.User{ID: 1}, future, now); got != 1 {
+		t.Errorf("Score() with future activity = %v, want 1", got)
+	}
+
+	// Разница в возрасте больше ageSpan не уходит в минус
+	if got := r.Score(models.User{ID: 1, Age: 18}, models.User{ID: 2, Age: 60}, now); got != 0 {
+		t.Errorf("Score() with large age gap = %v, want 0", got)
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	r := New(Weights{Age: 1, Activity: 1}, 0, 0)
+	if r.ageSpan != 10 {
+		t.Errorf("ageSpan = %v, want 10", r.ageSpan)
+	}
+	if r.activityHalfLife != 72*time.Hour {
+		t.Errorf("activityHalfLife = %v, want 72h", r.activityHalfLife)
+	}
+}
+
+func TestRank(t *testing.T) {
+	r := New(Weights{Games: 1}, 10, 72*time.Hour)
+	me := models.User{ID: 1, Games: []string{"dota", "cs"}}
+	candidates := []models.User{
+		{ID: 2, Games: []string{"chess"}},
+		{ID: 3, Games: []string{"dota", "cs"}},
+		me,
+		{ID: 4, Games: []string{"dota"}},
+		{ID: 5, Games: []string{"chess"}},
+	}
+
+	recs := r.Rank(me, candidates, 0, now)
+
+	// Сам пользователь исключается, при равном счёте выше более новый ID
+	wantIDs := []int{3, 4, 5, 2}
+	if len(recs) != len(wantIDs) {
+		t.Fatalf("Rank() returned %d users, want %d", len(recs), len(wantIDs))
+	}
+	for i, id := range wantIDs {
+		if recs[i].User.ID != id {
+			t.Errorf("recs[%d].User.ID = %d, want %d", i, recs[i].User.ID, id)
+		}
+	}
+	if !almostEqual(recs[0].Score, 1) || !almostEqual(recs[1].Score, 0.5) {
+		t.Errorf("scores = %v, %v, want 1, 0.5", recs[0].Score, recs[1].Score)
+	}
+
+	limited := r.Rank(me, candidates, 2, now)
+	if len(limited) != 2 || limited[0].User.ID != 3 || limited[1].User.ID != 4 {
+		t.Errorf("Rank() with limit 2 = %+v", limited)
+	}
+}
+
+func TestRankWeightsChangeOrder(t *testing.T) {
+	me := models.User{ID: 1, Age: 20, Games: []string{"dota"}}
+	sameGame := models.User{ID: 2, Age: 40, Games: []string{"dota"}}
+	sameAge := models.User{ID: 3, Age: 20, Games: []string{"chess"}}
+	candidates := []models.User{sameGame, sameAge}
+
+	byGames := New(Weights{Games: 0.8, Age: 0.2}, 10, 72*time.Hour).Rank(me, candidates, 0, now)
+	if byGames[0].User.ID != sameGame.ID {
+		t.Errorf("with games weight first = %d, want %d", byGames[0].User.ID, sameGame.ID)
+	}
+
+	byAge := New(Weights{Games: 0.2, Age: 0.8}, 10, 72*time.Hour).Rank(me, candidates, 0, now)
+	if byAge[0].User.ID != sameAge.ID {
+		t.Errorf("with age weight first = %d, want %d", byAge[0].User.ID, sameAge.ID)
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want float64
+	}{
+		{nil, []string{"a"}, 0},
+		{[]string{"a"}, nil, 0},
+		{[]string{"a", "b"}, []string{"A", "B"}, 1},
+		{[]string{"a", "b"}, []string{"b", "c"}, 1.0 / 3},
+		// Повторы во втором списке не раздувают объединение
+		{[]string{"a"}, []string{"a", "a", "b"}, 0.5},
+	}
+
+	for _, tt := range tests {
+		if got := overlap(tt.a, tt.b); !almostEqual(got, tt.want) {
+			t.Errorf("overlap(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"playmates/components/playmates/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const attachmentColumns = "id, uploader_id, message_id, kind, name, content_type, size, width, height, file_key, storage_key, thumbnail_key, created_at"
+
+func scanAttachment(row rowScanner) (models.AttachmentDB, error) {
+	var a models.AttachmentDB
+	err := row.Scan(&a.ID, &a.UploaderID, &a.MessageID, &a.Kind, &a.Name, &a.ContentType, &a.Size,
+		&a.Width, &a.Height, &a.FileKey, &a.StorageKey, &a.ThumbnailKey, &a.CreatedAt)
+	return a, err
+}
+
+// CreateAttachment сохраняет загруженное, но ещё не отправленное вложение.
+func (r *Repository) CreateAttachment(a models.AttachmentDB) (models.AttachmentDB, error) {
+	err := r.db.QueryRow(`
+        INSERT INTO attachments (uploader_id, kind, name, content_type, size, width, height, file_key, storage_key, thumbnail_key)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING id, created_at
+    `, a.UploaderID, a.Kind, a.Name, a.ContentType, a.Size, a.Width, a.Height, a.FileKey, a.StorageKey, a.ThumbnailKey).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return models.AttachmentDB{}, fmt.Errorf("error while inserting attachment: %w", err)
+	}
+
+	return a, nil
+}
+
+func (r *Repository) GetAttachment(id int) (models.AttachmentDB, bool, error) {
+	a, err := scanAttachment(r.db.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return models.AttachmentDB{}, false, nil
+	}
+	if err != nil {
+		return models.AttachmentDB{}, false, fmt.Errorf("error while getting attachment: %w", err)
+	}
+
+	return a, true, nil
+}
+
+// CountPendingAttachments - сколько из ids загружены uploaderID и ещё не прикреплены к сообщению.
+func (r *Repository) CountPendingAttachments(uploaderID int, ids []int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`
+        SELECT COUNT(*) FROM attachments
+        WHERE id = ANY($1) AND uploader_id = $2 AND message_id IS NULL
+    `, pq.Array(toInt64s(ids)), uploaderID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error while counting attachments: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetAttachmentsByMessageIDs возвращает вложения сообщений в порядке загрузки.
+func (r *Repository) GetAttachmentsByMessageIDs(messageIDs []int) (map[int][]models.AttachmentDB, error) {
+	attachments := make(map[int][]models.AttachmentDB)
+	if len(messageIDs) == 0 {
+		return attachments, nil
+	}
+
+	rows, err := r.db.Query(`
+        SELECT `+attachmentColumns+`
+        FROM attachments
+        WHERE message_id = ANY($1)
+        ORDER BY id
+    `, pq.Array(toInt64s(messageIDs)))
+	if err != nil {
+		return nil, fmt.Errorf("error while getting attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		attachments[*a.MessageID] = append(attachments[*a.MessageID], a)
+	}
+
+	return attachments, nil
+}
+
+// DeleteStaleAttachments удаляет вложения, так и не отправленные до pendingBefore, и вложения
+// сообщений, удалённых у всех. Ключи их блобов, как и при каскадном удалении, попадают
+// в deleted_blobs.
+func (r *Repository) DeleteStaleAttachments(pendingBefore time.Time) (int64, error) {
+	res, err := r.db.Exec(`
+        DELETE FROM attachments a
+        WHERE (a.message_id IS NULL AND a.created_at < $1)
+           OR EXISTS (SELECT 1 FROM messages m WHERE m.id = a.message_id AND m.deleted_at IS NOT NULL)
+    `, pendingBefore)
+	if err != nil {
+		return 0, fmt.Errorf("error while deleting stale attachments: %w", err)
+	}
+
+	deleted, _ := res.RowsAffected()
+	return deleted, nil
+}
+
+// GetDeletedBlobs возвращает ключи блобов удалённых вложений, файлы которых ещё не удалены.
+func (r *Repository) GetDeletedBlobs(limit int) ([]string, error) {
+	rows, err := r.db.Query("SELECT storage_key FROM deleted_blobs ORDER BY deleted_at LIMIT $1", limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting deleted blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// ForgetDeletedBlobs убирает ключи, файлы которых уже удалены.
+func (r *Repository) ForgetDeletedBlobs(keys []string) error {
+	_, err := r.db.Exec("DELETE FROM deleted_blobs WHERE storage_key = ANY($1)", pq.Array(keys))
+	if err != nil {
+		return fmt.Errorf("error while forgetting deleted blobs: %w", err)
+	}
+
+	return nil
+}
+
+// linkAttachments прикрепляет загруженные отправителем вложения к сообщению. Вложение,
+// уже отправленное или чужое, отменяет всю транзакцию.
+func linkAttachments(tx *sql.Tx, messageID, senderID int, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	res, err := tx.Exec(`
+        UPDATE attachments
+        SET message_id = $1
+        WHERE id = ANY($2) AND uploader_id = $3 AND message_id IS NULL
+    `, messageID, pq.Array(toInt64s(ids)), senderID)
+	if err != nil {
+		return fmt.Errorf("error while linking attachments: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected != int64(len(ids)) {
+		return fmt.Errorf("error while linking attachments: %d of %d available", rowsAffected, len(ids))
+	}
+
+	return nil
+}
@@ -2,33 +2,66 @@ package repository
 
 import (
 	"fmt"
+	"playmates/components/blindindex"
 	"playmates/components/playmates/models"
 
 	"github.com/lib/pq"
 )
 
-func (r *Repository) Register(username, email, hashedPassword string) error {
-	_, err := r.db.Exec(
-		"INSERT INTO users (username, email, password_hash, age, gender, about_me, games) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+// NextUserID резервирует ID нового пользователя, чтобы зашифровать email с привязкой к нему до вставки.
+func (r *Repository) NextUserID() (int, error) {
+	var id int
+	err := r.db.QueryRow("SELECT nextval(pg_get_serial_sequence('users', 'id'))").Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reserve user id: %w", err)
+	}
+
+	return id, nil
+}
+
+// Register создаёт пользователя с зашифрованным email. Уникальность проверяется по хэшам
+// всех ключей email_hmac, пока не все строки перехэшированы активным, и по открытому email
+// строк, которые ещё не перенесла команда backfill-users.
+func (r *Repository) Register(id int, username string, emailHashes blindindex.EmailHashes, emailSealed []byte, email, hashedPassword string) error {
+	res, err := r.db.Exec(`
+        INSERT INTO users (id, username, email_hash, email_hash_key_id, email_sealed, password_hash, age, gender, games)
+        SELECT $1::int, $2::text, $3::bytea, $11::smallint, $4::bytea, $5::text, $6::int, $7::text, $8::text[]
+        WHERE NOT EXISTS (
+            SELECT 1 FROM users WHERE email_hash = ANY($10) OR (email IS NOT NULL AND lower(email) = $9)
+        )
+    `,
+		id,
 		username,
-		email,
-		string(hashedPassword),
+		emailHashes.Active,
+		emailSealed,
+		hashedPassword,
 		0,
 		"",
-		"",
 		pq.Array([]string{}),
+		email,
+		pq.ByteaArray(emailHashes.All),
+		emailHashes.KeyID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("failed to create user: email is already in use")
+	}
+
 	return nil
 }
 
-func (r *Repository) Login(email string) (*models.User, error) {
+// Login ищет пользователя по HMAC email любым из ключей email_hmac, а среди ещё не
+// перенесённых строк - по открытому email.
+func (r *Repository) Login(emailHashes [][]byte, email string) (*models.User, error) {
 	var user models.User
 
-	err := r.db.QueryRow("SELECT id, password_hash FROM users WHERE email = $1", email).Scan(&user.ID, &user.PasswordHash)
+	err := r.db.QueryRow(
+		"SELECT id, password_hash FROM users WHERE email_hash = ANY($1) OR (email IS NOT NULL AND lower(email) = $2)",
+		pq.ByteaArray(emailHashes), email,
+	).Scan(&user.ID, &user.PasswordHash)
 	if err != nil {
 		return nil, fmt.Errorf("sql error: %w", err)
 	}
@@ -0,0 +1,38 @@
+package repository
+
+import "fmt"
+
+func (r *Repository) BlockUser(blockerID, blockedID int) error {
+	_, err := r.db.Exec(
+		"INSERT INTO blocks (blocker_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		blockerID, blockedID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to block user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) UnblockUser(blockerID, blockedID int) error {
+	_, err := r.db.Exec("DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2", blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("failed to unblock user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) IsBlocked(firstID, secondID int) (bool, error) {
+	var blocked bool
+	err := r.db.QueryRow(`
+        SELECT EXISTS (
+            SELECT 1 FROM blocks
+            WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+        )`, firstID, secondID).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check block: %w", err)
+	}
+
+	return blocked, nil
+}
@@ -0,0 +1,74 @@
+package repository
+
+import "testing"
+
+func candidateIDs(t *testing.T, r *Repository, userID int) []int {
+	t.Helper()
+
+	// Лимит с запасом: в базе могут быть и чужие пользователи
+	users, err := r.GetRecommendationCandidates(userID, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]int, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+// Блокировка в любую сторону убирает пользователей из рекомендаций друг друга.
+func TestBlockExcludesFromRecommendations(t *testing.T) {
+	r, conn := testRepository(t)
+
+	me := insertTestUser(t, conn, "me")
+	blockedByMe := insertTestUser(t, conn, "blocked")
+	blockedMe := insertTestUser(t, conn, "blocker")
+	other := insertTestUser(t, conn, "other")
+
+	if err := r.BlockUser(me, blockedByMe); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.BlockUser(blockedMe, me); err != nil {
+		t.Fatal(err)
+	}
+	// Повторная блокировка не ошибка
+	if err := r.BlockUser(me, blockedByMe); err != nil {
+		t.Errorf("BlockUser() twice = %v", err)
+	}
+
+	ids := candidateIDs(t, r, me)
+	if containsUser(ids, me) || containsUser(ids, blockedByMe) || containsUser(ids, blockedMe) {
+		t.Errorf("candidates %v include the user or blocked users", ids)
+	}
+	if !containsUser(ids, other) {
+		t.Errorf("candidates %v miss user %d", ids, other)
+	}
+	if containsUser(candidateIDs(t, r, blockedMe), me) {
+		t.Error("blocker sees the blocked user in recommendations")
+	}
+
+	for _, pair := range [][2]int{{me, blockedByMe}, {blockedByMe, me}, {me, blockedMe}} {
+		if blocked, err := r.IsBlocked(pair[0], pair[1]); err != nil || !blocked {
+			t.Errorf("IsBlocked(%d, %d) = %v, %v, want true", pair[0], pair[1], blocked, err)
+		}
+	}
+	if blocked, err := r.IsBlocked(me, other); err != nil || blocked {
+		t.Errorf("IsBlocked(%d, %d) = %v, %v, want false", me, other, blocked, err)
+	}
+
+	if err := r.UnblockUser(me, blockedByMe); err != nil {
+		t.Fatal(err)
+	}
+	if !containsUser(candidateIDs(t, r, me), blockedByMe) {
+		t.Error("unblocked user is still excluded")
+	}
+	// Снять чужую блокировку нельзя
+	if err := r.UnblockUser(me, blockedMe); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := r.IsBlocked(me, blockedMe); !blocked {
+		t.Error("UnblockUser() removed a block made by the other user")
+	}
+}
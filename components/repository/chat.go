@@ -2,67 +2,222 @@ package repository
 
 import (
 	"fmt"
+	"playmates/components/blindindex"
 	"playmates/components/playmates/models"
+
+	"github.com/lib/pq"
 )
 
-func (r *Repository) PostMessage(message string, senderID, receiverID int) error {
+// У сообщений в группе receiver_id пустой, у старых личных может не быть conversation_id
+const messageColumns = "id, COALESCE(conversation_id, 0), sender_id, COALESCE(receiver_id, 0), message, created_at, edited_at, deleted_at, reply_to_id, seal_version, kind"
+
+func scanMessage(row rowScanner) (models.MessageDB, error) {
+	var msg models.MessageDB
+	err := row.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.ReceiverID, &msg.Msg, &msg.Time, &msg.EditedAt, &msg.DeletedAt, &msg.ReplyToID, &msg.SealVersion, &msg.Kind)
+	return msg, err
+}
+
+// PostMessage сохраняет сообщение вместе с вложениями и в той же транзакции пишет message.new
+// в журналы отправителя и получателя. Возвращает курсоры этих событий по ID пользователя.
+// replyToID = 0 - не ответ. searchTokens - слепой индекс текста для conversationID пары.
+// messageID берётся из NextMessageID: текст зашифрован с привязкой к нему.
+func (r *Repository) PostMessage(messageID int, kind string, message []byte, senderID, receiverID int, attachmentIDs []int, replyToID int, searchTokens blindindex.Tokens) (models.MessageDB, map[int]int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.MessageDB{}, nil, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	conversationID, err := ensureDirectConversation(tx, senderID, receiverID)
+	if err != nil {
+		return models.MessageDB{}, nil, err
+	}
+
 	query := `
-        INSERT INTO messages (sender_id, receiver_id, message)
-        VALUES ($1, $2, $3)
+        INSERT INTO messages (id, conversation_id, sender_id, receiver_id, message, reply_to_id, seal_version, kind)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7, $8)
+        RETURNING created_at, reply_to_id
     `
-	_, err := r.db.Exec(query, senderID, receiverID, message)
+	msg := models.MessageDB{
+		ID:             messageID,
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		ReceiverID:     receiverID,
+		Msg:            message,
+		SealVersion:    models.MessageSealVersion,
+		Kind:           kind,
+	}
+
+	err = tx.QueryRow(query, messageID, conversationID, senderID, receiverID, message, replyToID, msg.SealVersion, kind).Scan(&msg.Time, &msg.ReplyToID)
+	if err != nil {
+		return models.MessageDB{}, nil, fmt.Errorf("error while inserting message into the database: %w", err)
+	}
+
+	if err = linkAttachments(tx, msg.ID, senderID, attachmentIDs); err != nil {
+		return models.MessageDB{}, nil, err
+	}
+
+	if err = setSearchTokens(tx, msg.ID, conversationID, searchTokens); err != nil {
+		return models.MessageDB{}, nil, err
+	}
+
+	cursors, err := appendEvents(tx, []int{senderID, receiverID}, models.EventMessageNew, &msg.ID, nil)
 	if err != nil {
-		return fmt.Errorf("error while inserting message into the database: %w", err)
+		return models.MessageDB{}, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.MessageDB{}, nil, fmt.Errorf("error while committing message: %w", err)
 	}
 
-	return nil
+	return msg, cursors, nil
 }
 
-func (r *Repository) GetMessages(currentUserID, otherUserID int) ([]models.MessageDB, error) {
-	query := `
-        SELECT sender_id, message, created_at
+// NextMessageID резервирует ID сообщения до вставки: шифротекст привязывается к нему.
+// Неиспользованный ID просто пропадает, как при откате вставки.
+func (r *Repository) NextMessageID() (int, error) {
+	var id int
+	err := r.db.QueryRow("SELECT nextval(pg_get_serial_sequence('messages', 'id'))").Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error while reserving message id: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *Repository) GetMessagesByIDs(ids []int) (map[int]models.MessageDB, error) {
+	messages := make(map[int]models.MessageDB, len(ids))
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	rows, err := r.db.Query(`
+        SELECT `+messageColumns+`
         FROM messages
-        WHERE (sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1)
-        ORDER BY created_at ASC
-    `
-	rows, err := r.db.Query(query, currentUserID, otherUserID)
+        WHERE id = ANY($1)
+    `, pq.Array(toInt64s(ids)))
 	if err != nil {
-		return nil, fmt.Errorf("error while getting messages from the database: %w", err)
+		return nil, fmt.Errorf("error while getting messages by ids: %w", err)
 	}
 	defer rows.Close()
 
-	var messages []models.MessageDB
 	for rows.Next() {
-		var msg models.MessageDB
-		err := rows.Scan(&msg.SenderID, &msg.Msg, &msg.Time)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			return nil, fmt.Errorf("error while scanning rows: %w", err)
 		}
-		messages = append(messages, msg)
+		messages[msg.ID] = msg
 	}
 
 	return messages, nil
 }
 
+// GetMessages возвращает страницу переписки по возрастанию (created_at, id) и признак того,
+// что в направлении курсора есть ещё сообщения. Без курсоров отдаются последние page.Limit.
+func (r *Repository) GetMessages(currentUserID, otherUserID int, page models.MessagePageParams) ([]models.MessageDB, bool, error) {
+	// Условие на пару повторяет выражения индекса idx_messages_pair_created_at
+	return r.getMessagesPage(`
+        LEAST(sender_id, receiver_id) = LEAST($1::int, $2::int)
+          AND GREATEST(sender_id, receiver_id) = GREATEST($1::int, $2::int)
+    `, currentUserID, otherUserID, page)
+}
+
+// GetConversationMessages - то же, что GetMessages, для группы.
+func (r *Repository) GetConversationMessages(currentUserID, conversationID int, page models.MessagePageParams) ([]models.MessageDB, bool, error) {
+	return r.getMessagesPage("conversation_id = $2", currentUserID, conversationID, page)
+}
+
+// getMessagesPage выбирает страницу по условию where с параметрами $1 - текущий пользователь и $2.
+func (r *Repository) getMessagesPage(where string, currentUserID, target int, page models.MessagePageParams) ([]models.MessageDB, bool, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages m
+        WHERE ` + where + `
+          AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.user_id = $1 AND h.message_id = m.id)
+    `
+	args := []interface{}{currentUserID, target}
+
+	order := "DESC"
+	switch {
+	case page.After > 0:
+		args = append(args, page.After)
+		query += " AND (created_at, id) > (SELECT created_at, id FROM messages WHERE id = $3)"
+		order = "ASC"
+	case page.Before > 0:
+		args = append(args, page.Before)
+		query += " AND (created_at, id) < (SELECT created_at, id FROM messages WHERE id = $3)"
+	}
+
+	args = append(args, page.Limit+1)
+	query += fmt.Sprintf(" ORDER BY created_at %s, id %s LIMIT $%d", order, order, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("error while getting messages from the database: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []models.MessageDB{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, false, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	hasMore := len(messages) > page.Limit
+	if hasMore {
+		messages = messages[:page.Limit]
+	}
+
+	if order == "DESC" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	return messages, hasMore, nil
+}
+
 func (r *Repository) GetUserChats(userID int) ([]models.ChatPreviewDB, error) {
 	query := `
         SELECT DISTINCT ON (other_user_id)
+            COALESCE(m.conversation_id, 0),
             m.id AS message_id,
             m.sender_id,
             m.receiver_id,
+            COALESCE(c.e2ee, FALSE),
+            m.kind,
             m.message,
+            m.seal_version,
             m.created_at,
+            m.edited_at,
+            m.deleted_at,
             CASE
                 WHEN m.sender_id = $1 THEN m.receiver_id
                 ELSE m.sender_id
             END AS other_user_id,
-            u.username AS other_username
+            u.username AS other_username,
+            u.last_seen_at AS other_last_seen_at,
+            u.hide_presence AS other_hide_presence,
+            (
+                SELECT COUNT(*)
+                FROM messages mu
+                WHERE mu.sender_id = u.id AND mu.receiver_id = $1
+                  AND mu.id > COALESCE(cr.last_read_message_id, 0)
+                  AND mu.deleted_at IS NULL
+                  AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.user_id = $1 AND h.message_id = mu.id)
+            ) AS unread_count
         FROM messages m
         JOIN users u ON u.id = CASE
             WHEN m.sender_id = $1 THEN m.receiver_id
             ELSE m.sender_id
         END
-        WHERE m.sender_id = $1 OR m.receiver_id = $1
+        LEFT JOIN chat_reads cr ON cr.user_id = $1 AND cr.peer_id = u.id
+        LEFT JOIN conversations c ON c.id = m.conversation_id
+        WHERE ((m.sender_id = $1 AND m.receiver_id IS NOT NULL) OR m.receiver_id = $1)
+          AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.user_id = $1 AND h.message_id = m.id)
         ORDER BY other_user_id, m.created_at DESC
     `
 
@@ -74,15 +229,24 @@ func (r *Repository) GetUserChats(userID int) ([]models.ChatPreviewDB, error) {
 
 	var chats []models.ChatPreviewDB
 	for rows.Next() {
-		var chat models.ChatPreviewDB
+		chat := models.ChatPreviewDB{Type: models.ConversationDirect}
 		err := rows.Scan(
+			&chat.ConversationID,
 			&chat.LastMessageID,
 			&chat.SenderID,
 			&chat.ReceiverID,
+			&chat.E2EE,
+			&chat.LastKind,
 			&chat.LastMessage,
+			&chat.LastSealVersion,
 			&chat.LastMessageTime,
+			&chat.LastEditedAt,
+			&chat.LastDeletedAt,
 			&chat.OtherUserID,
 			&chat.OtherUsername,
+			&chat.OtherLastSeenAt,
+			&chat.OtherHidden,
+			&chat.UnreadCount,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("error while scanning rows: %w", err)
@@ -92,3 +256,26 @@ func (r *Repository) GetUserChats(userID int) ([]models.ChatPreviewDB, error) {
 
 	return chats, nil
 }
+
+func (r *Repository) GetChatPartnerIDs(userID int) ([]int, error) {
+	rows, err := r.db.Query(`
+        SELECT DISTINCT CASE WHEN sender_id = $1 THEN receiver_id ELSE sender_id END
+        FROM messages
+        WHERE (sender_id = $1 AND receiver_id IS NOT NULL) OR receiver_id = $1
+    `, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting chat partners: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
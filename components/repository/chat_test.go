@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"playmates/components/playmates/models"
+	"testing"
+)
+
+func pageIDs(t *testing.T, r *Repository, userID, peerID int, page models.MessagePageParams) ([]int, bool) {
+	t.Helper()
+
+	msgs, hasMore, err := r.GetMessages(userID, peerID, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]int, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.ID
+	}
+
+	return ids, hasMore
+}
+
+func assertPage(t *testing.T, gotIDs []int, gotMore bool, wantIDs []int, wantMore bool) {
+	t.Helper()
+
+	if len(gotIDs) != len(wantIDs) || gotMore != wantMore {
+		t.Fatalf("page = %v (has_more %v), want %v (has_more %v)", gotIDs, gotMore, wantIDs, wantMore)
+	}
+	for i := range wantIDs {
+		if gotIDs[i] != wantIDs[i] {
+			t.Fatalf("page = %v, want %v", gotIDs, wantIDs)
+		}
+	}
+}
+
+// Страницы всегда идут по возрастанию, курсоры не включают само сообщение.
+func TestGetMessagesCursors(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+
+	var m []int
+	for i := 0; i < 5; i++ {
+		sender, receiver := alice, bob
+		if i%2 == 1 {
+			sender, receiver = bob, alice
+		}
+		m = append(m, postTestMessage(t, r, sender, receiver, "hi").ID)
+	}
+
+	ids, hasMore := pageIDs(t, r, alice, bob, models.MessagePageParams{Limit: 2})
+	assertPage(t, ids, hasMore, []int{m[3], m[4]}, true)
+
+	ids, hasMore = pageIDs(t, r, alice, bob, models.MessagePageParams{Before: m[3], Limit: 2})
+	assertPage(t, ids, hasMore, []int{m[1], m[2]}, true)
+
+	ids, hasMore = pageIDs(t, r, alice, bob, models.MessagePageParams{Before: m[1], Limit: 2})
+	assertPage(t, ids, hasMore, []int{m[0]}, false)
+
+	ids, hasMore = pageIDs(t, r, bob, alice, models.MessagePageParams{After: m[0], Limit: 2})
+	assertPage(t, ids, hasMore, []int{m[1], m[2]}, true)
+
+	ids, hasMore = pageIDs(t, r, bob, alice, models.MessagePageParams{After: m[2], Limit: 2})
+	assertPage(t, ids, hasMore, []int{m[3], m[4]}, false)
+
+	ids, hasMore = pageIDs(t, r, bob, alice, models.MessagePageParams{After: m[4], Limit: 2})
+	assertPage(t, ids, hasMore, []int{}, false)
+}
+
+func TestGetMessagesSkipsHidden(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob, eve := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob"), insertTestUser(t, conn, "eve")
+
+	first := postTestMessage(t, r, alice, bob, "one").ID
+	second := postTestMessage(t, r, alice, bob, "two").ID
+	// Переписка с другим собеседником в страницу не попадает
+	postTestMessage(t, r, alice, eve, "other")
+
+	if _, _, err := r.HideMessage(second, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, hasMore := pageIDs(t, r, bob, alice, models.MessagePageParams{Limit: 10})
+	assertPage(t, ids, hasMore, []int{first}, false)
+
+	ids, hasMore = pageIDs(t, r, alice, bob, models.MessagePageParams{Limit: 10})
+	assertPage(t, ids, hasMore, []int{first, second}, false)
+}
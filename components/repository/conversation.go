@@ -0,0 +1,671 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"playmates/components/blindindex"
+	"playmates/components/playmates/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EnsureDirectConversation возвращает ID личной беседы пары, создавая её при первом обращении.
+func (r *Repository) EnsureDirectConversation(userA, userB int) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	conversationID, err := ensureDirectConversation(tx, userA, userB)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error while committing direct conversation: %w", err)
+	}
+
+	return conversationID, nil
+}
+
+// IsConversationE2EE - включено ли в беседе E2EE: тогда в неё принимаются только шифротексты.
+func (r *Repository) IsConversationE2EE(conversationID int) (bool, error) {
+	var e2ee bool
+	err := r.db.QueryRow("SELECT e2ee FROM conversations WHERE id = $1", conversationID).Scan(&e2ee)
+	if err != nil {
+		return false, fmt.Errorf("error while getting conversation: %w", err)
+	}
+
+	return e2ee, nil
+}
+
+// SetDirectE2EE включает или выключает E2EE в личной беседе пары, создавая её при необходимости,
+// и пишет chat.e2ee в журналы обоих. changed = false, если настройка уже такая.
+func (r *Repository) SetDirectE2EE(userID, peerID int, enabled bool) (models.E2EEPayload, map[int]int64, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.E2EEPayload{}, nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	conversationID, err := ensureDirectConversation(tx, userID, peerID)
+	if err != nil {
+		return models.E2EEPayload{}, nil, false, err
+	}
+
+	res, err := tx.Exec("UPDATE conversations SET e2ee = $2 WHERE id = $1 AND e2ee <> $2", conversationID, enabled)
+	if err != nil {
+		return models.E2EEPayload{}, nil, false, fmt.Errorf("error while updating conversation e2ee: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.E2EEPayload{}, nil, false, nil
+	}
+
+	payload := models.E2EEPayload{ConversationID: conversationID, UserID: userID, Enabled: enabled, Time: time.Now()}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return models.E2EEPayload{}, nil, false, fmt.Errorf("failed to marshal e2ee: %w", err)
+	}
+
+	cursors, err := appendEvents(tx, []int{userID, peerID}, models.EventChatE2EE, nil, data)
+	if err != nil {
+		return models.E2EEPayload{}, nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.E2EEPayload{}, nil, false, fmt.Errorf("error while committing e2ee: %w", err)
+	}
+
+	return payload, cursors, true, nil
+}
+
+// ensureDirectConversation возвращает личную беседу пары, создавая её при первом сообщении.
+func ensureDirectConversation(tx *sql.Tx, userA, userB int) (int, error) {
+	low, high := userA, userB
+	if low > high {
+		low, high = high, low
+	}
+
+	var conversationID int
+	err := tx.QueryRow(
+		"SELECT id FROM conversations WHERE direct_user_low = $1 AND direct_user_high = $2",
+		low, high,
+	).Scan(&conversationID)
+	if err == nil {
+		return conversationID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("error while getting direct conversation: %w", err)
+	}
+
+	// Две первые реплики могут прийти одновременно, ON CONFLICT отдаст уже созданную беседу
+	err = tx.QueryRow(`
+        INSERT INTO conversations (type, direct_user_low, direct_user_high)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (direct_user_low, direct_user_high) DO UPDATE SET type = EXCLUDED.type
+        RETURNING id
+    `, models.ConversationDirect, low, high).Scan(&conversationID)
+	if err != nil {
+		return 0, fmt.Errorf("error while creating direct conversation: %w", err)
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO conversation_members (conversation_id, user_id)
+        VALUES ($1, $2), ($1, $3)
+        ON CONFLICT DO NOTHING
+    `, conversationID, low, high)
+	if err != nil {
+		return 0, fmt.Errorf("error while adding direct conversation members: %w", err)
+	}
+
+	return conversationID, nil
+}
+
+func conversationMemberIDs(tx *sql.Tx, conversationID int) ([]int, error) {
+	rows, err := tx.Query("SELECT user_id FROM conversation_members WHERE conversation_id = $1", conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting conversation members: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// messageAudience - кому отправлять события о сообщении: пара для личного, участники для группового.
+func messageAudience(tx *sql.Tx, msg models.MessageDB) ([]int, error) {
+	if msg.ReceiverID != 0 {
+		return []int{msg.SenderID, msg.ReceiverID}, nil
+	}
+
+	return conversationMemberIDs(tx, msg.ConversationID)
+}
+
+func appendMembersEvent(tx *sql.Tx, audience []int, payload models.MembersPayload) (map[int]int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal members event: %w", err)
+	}
+
+	return appendEvents(tx, audience, models.EventConversationMembers, nil, data)
+}
+
+func (r *Repository) CreateConversation(ownerID int, title string, memberIDs []int) (models.Conversation, map[int]int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.Conversation{}, nil, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	conversation := models.Conversation{
+		Type:      models.ConversationGroup,
+		Title:     title,
+		CreatedBy: ownerID,
+	}
+
+	err = tx.QueryRow(`
+        INSERT INTO conversations (type, title, created_by)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at
+    `, models.ConversationGroup, title, ownerID).Scan(&conversation.ID, &conversation.CreatedAt)
+	if err != nil {
+		return models.Conversation{}, nil, fmt.Errorf("error while creating conversation: %w", err)
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO conversation_members (conversation_id, user_id, role)
+        VALUES ($1, $2, $3)
+    `, conversation.ID, ownerID, models.RoleOwner)
+	if err != nil {
+		return models.Conversation{}, nil, fmt.Errorf("error while adding conversation owner: %w", err)
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO conversation_members (conversation_id, user_id, role)
+        SELECT $1, id, $3 FROM users WHERE id = ANY($2)
+        ON CONFLICT DO NOTHING
+    `, conversation.ID, pq.Array(toInt64s(memberIDs)), models.RoleMember)
+	if err != nil {
+		return models.Conversation{}, nil, fmt.Errorf("error while adding conversation members: %w", err)
+	}
+
+	audience, err := conversationMemberIDs(tx, conversation.ID)
+	if err != nil {
+		return models.Conversation{}, nil, err
+	}
+
+	cursors, err := appendMembersEvent(tx, audience, models.MembersPayload{
+		ConversationID: conversation.ID,
+		Action:         models.MembersCreated,
+		ActorID:        ownerID,
+		UserIDs:        audience,
+	})
+	if err != nil {
+		return models.Conversation{}, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Conversation{}, nil, fmt.Errorf("error while committing conversation: %w", err)
+	}
+
+	return conversation, cursors, nil
+}
+
+func (r *Repository) GetConversation(conversationID int) (models.Conversation, bool, error) {
+	var (
+		conversation models.Conversation
+		title        sql.NullString
+		createdBy    sql.NullInt64
+	)
+
+	err := r.db.QueryRow(`
+        SELECT id, type, title, created_by, created_at
+        FROM conversations
+        WHERE id = $1
+    `, conversationID).Scan(&conversation.ID, &conversation.Type, &title, &createdBy, &conversation.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Conversation{}, false, nil
+	}
+	if err != nil {
+		return models.Conversation{}, false, fmt.Errorf("error while getting conversation: %w", err)
+	}
+	conversation.Title = title.String
+	conversation.CreatedBy = int(createdBy.Int64)
+
+	rows, err := r.db.Query(`
+        SELECT cm.user_id, u.username, cm.role, cm.joined_at
+        FROM conversation_members cm
+        JOIN users u ON u.id = cm.user_id
+        WHERE cm.conversation_id = $1
+        ORDER BY cm.joined_at, cm.user_id
+    `, conversationID)
+	if err != nil {
+		return models.Conversation{}, false, fmt.Errorf("error while getting conversation members: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member models.ConversationMember
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Role, &member.JoinedAt); err != nil {
+			return models.Conversation{}, false, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		conversation.Members = append(conversation.Members, member)
+	}
+
+	return conversation, true, nil
+}
+
+// GetMemberRole возвращает роль userID в беседе; ok = false, если он не участник.
+func (r *Repository) GetMemberRole(conversationID, userID int) (string, bool, error) {
+	var role string
+	err := r.db.QueryRow(
+		"SELECT role FROM conversation_members WHERE conversation_id = $1 AND user_id = $2",
+		conversationID, userID,
+	).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error while getting member role: %w", err)
+	}
+
+	return role, true, nil
+}
+
+func (r *Repository) GetConversationMemberIDs(conversationID int) ([]int, error) {
+	rows, err := r.db.Query("SELECT user_id FROM conversation_members WHERE conversation_id = $1", conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting conversation members: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// AddConversationMembers добавляет участников и возвращает тех, кого действительно добавили.
+func (r *Repository) AddConversationMembers(conversationID, actorID int, userIDs []int) ([]int, map[int]int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+        INSERT INTO conversation_members (conversation_id, user_id, role)
+        SELECT $1, id, $3 FROM users WHERE id = ANY($2)
+        ON CONFLICT DO NOTHING
+        RETURNING user_id
+    `, conversationID, pq.Array(toInt64s(userIDs)), models.RoleMember)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while adding conversation members: %w", err)
+	}
+
+	var added []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		added = append(added, id)
+	}
+	rows.Close()
+
+	if len(added) == 0 {
+		return nil, nil, nil
+	}
+
+	audience, err := conversationMemberIDs(tx, conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cursors, err := appendMembersEvent(tx, audience, models.MembersPayload{
+		ConversationID: conversationID,
+		Action:         models.MembersAdded,
+		ActorID:        actorID,
+		UserIDs:        added,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("error while committing conversation members: %w", err)
+	}
+
+	return added, cursors, nil
+}
+
+// RemoveConversationMember исключает userID (action - left или kicked). Если ушёл владелец,
+// владельцем становится самый давний администратор, а без них - самый давний участник:
+// тогда newOwner и ownerCursors описывают второе событие. Опустевшая группа удаляется вместе с сообщениями.
+func (r *Repository) RemoveConversationMember(conversationID, actorID, userID int, action string) (cursors map[int]int64, newOwner int, ownerCursors map[int]int64, ok bool, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, 0, nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Удалённый участник тоже получает событие, поэтому состав берём до удаления
+	audience, err := conversationMemberIDs(tx, conversationID)
+	if err != nil {
+		return nil, 0, nil, false, err
+	}
+
+	var role string
+	err = tx.QueryRow(`
+        DELETE FROM conversation_members
+        WHERE conversation_id = $1 AND user_id = $2
+        RETURNING role
+    `, conversationID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil, false, nil
+	}
+	if err != nil {
+		return nil, 0, nil, false, fmt.Errorf("error while removing conversation member: %w", err)
+	}
+
+	if len(audience) == 1 {
+		if _, err = tx.Exec("DELETE FROM conversations WHERE id = $1", conversationID); err != nil {
+			return nil, 0, nil, false, fmt.Errorf("error while deleting empty conversation: %w", err)
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, 0, nil, false, fmt.Errorf("error while committing conversation member: %w", err)
+		}
+		return map[int]int64{}, 0, nil, true, nil
+	}
+
+	cursors, err = appendMembersEvent(tx, audience, models.MembersPayload{
+		ConversationID: conversationID,
+		Action:         action,
+		ActorID:        actorID,
+		UserIDs:        []int{userID},
+	})
+	if err != nil {
+		return nil, 0, nil, false, err
+	}
+
+	if role == models.RoleOwner {
+		err = tx.QueryRow(`
+            UPDATE conversation_members
+            SET role = $2
+            WHERE conversation_id = $1 AND user_id = (
+                SELECT user_id FROM conversation_members
+                WHERE conversation_id = $1
+                ORDER BY role = $3 DESC, joined_at, user_id
+                LIMIT 1
+            )
+            RETURNING user_id
+        `, conversationID, models.RoleOwner, models.RoleAdmin).Scan(&newOwner)
+		if err != nil {
+			return nil, 0, nil, false, fmt.Errorf("error while transferring ownership: %w", err)
+		}
+
+		ownerCursors, err = appendMembersEvent(tx, audience, models.MembersPayload{
+			ConversationID: conversationID,
+			Action:         models.MembersRole,
+			ActorID:        actorID,
+			UserIDs:        []int{newOwner},
+			Role:           models.RoleOwner,
+		})
+		if err != nil {
+			return nil, 0, nil, false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, 0, nil, false, fmt.Errorf("error while committing conversation member: %w", err)
+	}
+
+	return cursors, newOwner, ownerCursors, true, nil
+}
+
+func (r *Repository) SetMemberRole(conversationID, actorID, userID int, role string) (map[int]int64, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+        UPDATE conversation_members
+        SET role = $3
+        WHERE conversation_id = $1 AND user_id = $2 AND role <> $3
+    `, conversationID, userID, role)
+	if err != nil {
+		return nil, false, fmt.Errorf("error while setting member role: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		return nil, false, nil
+	}
+
+	audience, err := conversationMemberIDs(tx, conversationID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cursors, err := appendMembersEvent(tx, audience, models.MembersPayload{
+		ConversationID: conversationID,
+		Action:         models.MembersRole,
+		ActorID:        actorID,
+		UserIDs:        []int{userID},
+		Role:           role,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("error while committing member role: %w", err)
+	}
+
+	return cursors, true, nil
+}
+
+// PostConversationMessage сохраняет сообщение в группе и пишет message.new в журналы всех участников.
+func (r *Repository) PostConversationMessage(messageID int, message []byte, senderID, conversationID int, attachmentIDs []int, replyToID int, searchTokens blindindex.Tokens) (models.MessageDB, map[int]int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.MessageDB{}, nil, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	msg := models.MessageDB{
+		ID:             messageID,
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Msg:            message,
+		SealVersion:    models.MessageSealVersion,
+		Kind:           models.MessageKindText,
+	}
+
+	err = tx.QueryRow(`
+        INSERT INTO messages (id, conversation_id, sender_id, message, reply_to_id, seal_version)
+        VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6)
+        RETURNING created_at, reply_to_id
+    `, messageID, conversationID, senderID, message, replyToID, msg.SealVersion).Scan(&msg.Time, &msg.ReplyToID)
+	if err != nil {
+		return models.MessageDB{}, nil, fmt.Errorf("error while inserting message into the database: %w", err)
+	}
+
+	if err = linkAttachments(tx, msg.ID, senderID, attachmentIDs); err != nil {
+		return models.MessageDB{}, nil, err
+	}
+
+	if err = setSearchTokens(tx, msg.ID, conversationID, searchTokens); err != nil {
+		return models.MessageDB{}, nil, err
+	}
+
+	audience, err := conversationMemberIDs(tx, conversationID)
+	if err != nil {
+		return models.MessageDB{}, nil, err
+	}
+
+	cursors, err := appendEvents(tx, audience, models.EventMessageNew, &msg.ID, nil)
+	if err != nil {
+		return models.MessageDB{}, nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.MessageDB{}, nil, fmt.Errorf("error while committing message: %w", err)
+	}
+
+	return msg, cursors, nil
+}
+
+// GetGroupChats - группы пользователя с последним видимым ему сообщением и числом непрочитанных.
+func (r *Repository) GetGroupChats(userID int) ([]models.ChatPreviewDB, error) {
+	rows, err := r.db.Query(`
+        SELECT
+            c.id,
+            COALESCE(c.title, ''),
+            (SELECT COUNT(*) FROM conversation_members x WHERE x.conversation_id = c.id) AS member_count,
+            COALESCE(lm.id, 0),
+            COALESCE(lm.sender_id, 0),
+            c.e2ee,
+            COALESCE(lm.kind, ''),
+            COALESCE(lm.message, ''::bytea),
+            COALESCE(lm.seal_version, 0),
+            COALESCE(lm.created_at, c.created_at),
+            lm.edited_at,
+            lm.deleted_at,
+            (
+                SELECT COUNT(*)
+                FROM messages mu
+                WHERE mu.conversation_id = c.id AND mu.sender_id <> $1
+                  AND mu.id > cm.last_read_message_id
+                  AND mu.deleted_at IS NULL
+                  AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.user_id = $1 AND h.message_id = mu.id)
+            ) AS unread_count
+        FROM conversation_members cm
+        JOIN conversations c ON c.id = cm.conversation_id AND c.type = $2
+        LEFT JOIN LATERAL (
+            SELECT m.id, m.sender_id, m.kind, m.message, m.seal_version, m.created_at, m.edited_at, m.deleted_at
+            FROM messages m
+            WHERE m.conversation_id = c.id
+              AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.user_id = $1 AND h.message_id = m.id)
+            ORDER BY m.created_at DESC, m.id DESC
+            LIMIT 1
+        ) lm ON TRUE
+        WHERE cm.user_id = $1
+    `, userID, models.ConversationGroup)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting group chats: %w", err)
+	}
+	defer rows.Close()
+
+	var chats []models.ChatPreviewDB
+	for rows.Next() {
+		chat := models.ChatPreviewDB{Type: models.ConversationGroup}
+		err := rows.Scan(
+			&chat.ConversationID,
+			&chat.Title,
+			&chat.MemberCount,
+			&chat.LastMessageID,
+			&chat.SenderID,
+			&chat.E2EE,
+			&chat.LastKind,
+			&chat.LastMessage,
+			&chat.LastSealVersion,
+			&chat.LastMessageTime,
+			&chat.LastEditedAt,
+			&chat.LastDeletedAt,
+			&chat.UnreadCount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		chats = append(chats, chat)
+	}
+
+	return chats, nil
+}
+
+// MarkConversationRead сдвигает отметку прочтения группы. Событие получают только устройства самого читателя.
+func (r *Repository) MarkConversationRead(userID, conversationID, upTo int) (models.Receipt, map[int]int64, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+        SELECT COALESCE(MAX(id), 0)
+        FROM messages
+        WHERE conversation_id = $1 AND id <= $2
+    `, conversationID, upTo).Scan(&upTo)
+	if err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("error while getting last message: %w", err)
+	}
+
+	res, err := tx.Exec(`
+        UPDATE conversation_members
+        SET last_read_message_id = $3
+        WHERE conversation_id = $1 AND user_id = $2 AND last_read_message_id < $3
+    `, conversationID, userID, upTo)
+	if err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("error while updating conversation read: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		return models.Receipt{}, nil, false, nil
+	}
+
+	receipt := models.Receipt{
+		UserID:         userID,
+		ConversationID: conversationID,
+		Status:         models.ReceiptRead,
+		MessageID:      upTo,
+		Time:           time.Now(),
+	}
+
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+
+	cursors, err := appendEvents(tx, []int{userID}, models.EventReceipt, nil, data)
+	if err != nil {
+		return models.Receipt{}, nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("error while committing receipt: %w", err)
+	}
+
+	return receipt, cursors, true, nil
+}
+
+func toInt64s(ids []int) []int64 {
+	ids64 := make([]int64, len(ids))
+	for i, id := range ids {
+		ids64[i] = int64(id)
+	}
+
+	return ids64
+}
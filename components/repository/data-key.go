@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// GetConversationKey возвращает обёрнутый ключ данных беседы; nil, если ключ ещё не создан.
+// exists = false, если беседы нет.
+func (r *Repository) GetConversationKey(conversationID int) ([]byte, bool, error) {
+	var wrapped []byte
+	err := r.db.QueryRow("SELECT data_key FROM conversations WHERE id = $1", conversationID).Scan(&wrapped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error while getting conversation key: %w", err)
+	}
+
+	return wrapped, true, nil
+}
+
+// InitConversationKey сохраняет ключ беседы, если его ещё нет, и возвращает ключ, который
+// в итоге лежит в базе: при гонке двух первых сообщений побеждает записанный раньше.
+func (r *Repository) InitConversationKey(conversationID int, wrapped []byte) ([]byte, error) {
+	var stored []byte
+	err := r.db.QueryRow(`
+        UPDATE conversations
+        SET data_key = COALESCE(data_key, $2)
+        WHERE id = $1
+        RETURNING data_key
+    `, conversationID, wrapped).Scan(&stored)
+	if err != nil {
+		return nil, fmt.Errorf("error while saving conversation key: %w", err)
+	}
+
+	return stored, nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"fmt"
+	"playmates/components/playmates/models"
+)
+
+// SetDeviceKeys публикует или заменяет ключи устройства. ok = false, если это новое
+// устройство, а у пользователя уже maxDevices устройств.
+func (r *Repository) SetDeviceKeys(userID int, keys models.DeviceKeys, maxDevices int) (models.DeviceKeys, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.DeviceKeys{}, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Блокировка пользователя не даёт параллельным запросам обойти лимит устройств
+	if _, err = tx.Exec("SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return models.DeviceKeys{}, false, fmt.Errorf("error while locking user: %w", err)
+	}
+
+	var others int
+	err = tx.QueryRow(
+		"SELECT COUNT(*) FROM device_keys WHERE user_id = $1 AND device_id <> $2",
+		userID, keys.DeviceID,
+	).Scan(&others)
+	if err != nil {
+		return models.DeviceKeys{}, false, fmt.Errorf("error while counting devices: %w", err)
+	}
+	if others >= maxDevices {
+		return models.DeviceKeys{}, false, nil
+	}
+
+	err = tx.QueryRow(`
+        INSERT INTO device_keys (user_id, device_id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (user_id, device_id) DO UPDATE SET
+            identity_key = EXCLUDED.identity_key,
+            signed_prekey_id = EXCLUDED.signed_prekey_id,
+            signed_prekey = EXCLUDED.signed_prekey,
+            signed_prekey_signature = EXCLUDED.signed_prekey_signature,
+            updated_at = NOW()
+        RETURNING updated_at
+    `, userID, keys.DeviceID, keys.IdentityKey, keys.SignedPrekey.ID, keys.SignedPrekey.PublicKey, keys.SignedPrekey.Signature,
+	).Scan(&keys.UpdatedAt)
+	if err != nil {
+		return models.DeviceKeys{}, false, fmt.Errorf("error while saving device keys: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.DeviceKeys{}, false, fmt.Errorf("error while committing device keys: %w", err)
+	}
+
+	return keys, true, nil
+}
+
+func (r *Repository) GetDeviceKeys(userID int) ([]models.DeviceKeys, error) {
+	rows, err := r.db.Query(`
+        SELECT device_id, identity_key, signed_prekey_id, signed_prekey, signed_prekey_signature, updated_at
+        FROM device_keys
+        WHERE user_id = $1
+        ORDER BY device_id
+    `, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting device keys: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []models.DeviceKeys{}
+	for rows.Next() {
+		var keys models.DeviceKeys
+		err := rows.Scan(&keys.DeviceID, &keys.IdentityKey, &keys.SignedPrekey.ID, &keys.SignedPrekey.PublicKey,
+			&keys.SignedPrekey.Signature, &keys.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		devices = append(devices, keys)
+	}
+
+	return devices, nil
+}
+
+// DeleteDeviceKeys убирает устройство из каталога. ok = false, если его там не было.
+func (r *Repository) DeleteDeviceKeys(userID int, deviceID string) (bool, error) {
+	res, err := r.db.Exec("DELETE FROM device_keys WHERE user_id = $1 AND device_id = $2", userID, deviceID)
+	if err != nil {
+		return false, fmt.Errorf("error while deleting device keys: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"playmates/components/blindindex"
+	"playmates/components/playmates/models"
+	"time"
+)
+
+func (r *Repository) GetMessage(messageID int) (models.MessageDB, bool, error) {
+	msg, err := scanMessage(r.db.QueryRow("SELECT "+messageColumns+" FROM messages WHERE id = $1", messageID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.MessageDB{}, false, nil
+	}
+	if err != nil {
+		return models.MessageDB{}, false, fmt.Errorf("error while getting message: %w", err)
+	}
+
+	return msg, true, nil
+}
+
+// EditMessage заменяет текст сообщения, сохраняя предыдущую версию в message_edits.
+// message должен быть зашифрован datakeys.Keys.SealMessage для этого сообщения.
+// ok = false, если сообщение не принадлежит senderID, удалено или отправлено раньше editableSince.
+func (r *Repository) EditMessage(messageID, senderID int, message []byte, searchTokens blindindex.Tokens, editableSince time.Time) (models.MessageDB, map[int]int64, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	prev, err := scanMessage(tx.QueryRow(`
+        SELECT `+messageColumns+`
+        FROM messages
+        WHERE id = $1 AND sender_id = $2 AND deleted_at IS NULL AND created_at > $3
+        FOR UPDATE
+    `, messageID, senderID, editableSince))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.MessageDB{}, nil, false, nil
+	}
+	if err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while getting message: %w", err)
+	}
+
+	// Время версии - момент, когда она стала текущей
+	since := prev.Time
+	if prev.EditedAt != nil {
+		since = *prev.EditedAt
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO message_edits (message_id, message, created_at, seal_version) VALUES ($1, $2, $3, $4)",
+		prev.ID, prev.Msg, since, prev.SealVersion,
+	)
+	if err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while saving message edit: %w", err)
+	}
+
+	msg, err := scanMessage(tx.QueryRow(`
+        UPDATE messages
+        SET message = $2, seal_version = $3, edited_at = NOW()
+        WHERE id = $1
+        RETURNING `+messageColumns, messageID, message, models.MessageSealVersion))
+	if err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while editing message: %w", err)
+	}
+
+	if err = setSearchTokens(tx, msg.ID, msg.ConversationID, searchTokens); err != nil {
+		return models.MessageDB{}, nil, false, err
+	}
+
+	audience, err := messageAudience(tx, msg)
+	if err != nil {
+		return models.MessageDB{}, nil, false, err
+	}
+
+	cursors, err := appendEvents(tx, audience, models.EventMessageEdited, &msg.ID, nil)
+	if err != nil {
+		return models.MessageDB{}, nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while committing message edit: %w", err)
+	}
+
+	return msg, cursors, true, nil
+}
+
+// DeleteMessage удаляет сообщение у всех: текст и история правок стираются, остаётся надгробие.
+func (r *Repository) DeleteMessage(messageID, senderID int) (models.MessageDB, map[int]int64, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	msg, err := scanMessage(tx.QueryRow(`
+        UPDATE messages
+        SET message = ''::bytea, deleted_at = NOW()
+        WHERE id = $1 AND sender_id = $2 AND deleted_at IS NULL
+        RETURNING `+messageColumns, messageID, senderID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.MessageDB{}, nil, false, nil
+	}
+	if err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while deleting message: %w", err)
+	}
+
+	if _, err = tx.Exec("DELETE FROM message_edits WHERE message_id = $1", messageID); err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while deleting message edits: %w", err)
+	}
+
+	// Удалённое сообщение не может оставаться закреплённым и находиться поиском
+	if _, err = tx.Exec("DELETE FROM message_pins WHERE message_id = $1", messageID); err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while deleting message pins: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM message_search_tokens WHERE message_id = $1", messageID); err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while deleting search tokens: %w", err)
+	}
+
+	audience, err := messageAudience(tx, msg)
+	if err != nil {
+		return models.MessageDB{}, nil, false, err
+	}
+
+	cursors, err := appendEvents(tx, audience, models.EventMessageDeleted, &msg.ID, nil)
+	if err != nil {
+		return models.MessageDB{}, nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.MessageDB{}, nil, false, fmt.Errorf("error while committing message delete: %w", err)
+	}
+
+	return msg, cursors, true, nil
+}
+
+// HideMessage удаляет сообщение только у userID. ok = false, если userID не участник переписки
+// или сообщение уже скрыто.
+func (r *Repository) HideMessage(messageID, userID int) (map[int]int64, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+        INSERT INTO message_hidden (user_id, message_id)
+        SELECT $2, id
+        FROM messages
+        WHERE id = $1 AND (
+            sender_id = $2 OR receiver_id = $2
+            OR EXISTS (
+                SELECT 1 FROM conversation_members cm
+                WHERE cm.conversation_id = messages.conversation_id AND cm.user_id = $2
+            )
+        )
+        ON CONFLICT DO NOTHING
+    `, messageID, userID)
+	if err != nil {
+		return nil, false, fmt.Errorf("error while hiding message: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		return nil, false, nil
+	}
+
+	data, err := json.Marshal(models.MessageHiddenPayload{MessageID: messageID})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to marshal hidden message: %w", err)
+	}
+
+	// message_id не пишем: событие не должно разворачиваться в само сообщение при синхронизации
+	cursors, err := appendEvents(tx, []int{userID}, models.EventMessageHidden, nil, data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, false, fmt.Errorf("error while committing hidden message: %w", err)
+	}
+
+	return cursors, true, nil
+}
+
+func (r *Repository) GetMessageEdits(messageID int) ([]models.MessageEditDB, error) {
+	rows, err := r.db.Query(`
+        SELECT message, created_at, seal_version
+        FROM message_edits
+        WHERE message_id = $1
+        ORDER BY id ASC
+    `, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting message edits: %w", err)
+	}
+	defer rows.Close()
+
+	edits := []models.MessageEditDB{}
+	for rows.Next() {
+		var edit models.MessageEditDB
+		if err := rows.Scan(&edit.Msg, &edit.Time, &edit.SealVersion); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		edits = append(edits, edit)
+	}
+
+	return edits, nil
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"playmates/components/blindindex"
+	"playmates/components/playmates/models"
+	"testing"
+	"time"
+)
+
+func TestEditMessageWindow(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+	msg := postTestMessage(t, r, alice, bob, "v1")
+
+	// Окно правки закрылось
+	_, _, ok, err := r.EditMessage(msg.ID, alice, []byte("late"), blindindex.Tokens{}, time.Now().Add(time.Minute))
+	if err != nil || ok {
+		t.Fatalf("edit after the window: ok = %v, err = %v", ok, err)
+	}
+
+	// Чужое сообщение не правится
+	if _, _, ok, err = r.EditMessage(msg.ID, bob, []byte("bob"), blindindex.Tokens{}, time.Now().Add(-time.Hour)); err != nil || ok {
+		t.Fatalf("edit by receiver: ok = %v, err = %v", ok, err)
+	}
+
+	edited, cursors, ok, err := r.EditMessage(msg.ID, alice, []byte("v2"), blindindex.Tokens{}, time.Now().Add(-time.Hour))
+	if err != nil || !ok {
+		t.Fatalf("edit within the window: ok = %v, err = %v", ok, err)
+	}
+	if string(edited.Msg) != "v2" || edited.EditedAt == nil {
+		t.Fatalf("edited message = %+v", edited)
+	}
+	if cursors[alice] == 0 || cursors[bob] == 0 {
+		t.Fatalf("edit event is missing from journals: %v", cursors)
+	}
+
+	if _, _, _, err = r.EditMessage(msg.ID, alice, []byte("v3"), blindindex.Tokens{}, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	edits, err := r.GetMessageEdits(msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 2 || string(edits[0].Msg) != "v1" || string(edits[1].Msg) != "v2" {
+		t.Fatalf("edit history = %+v, want v1, v2", edits)
+	}
+	// Время версии - когда она стала текущей
+	if !edits[0].Time.Equal(msg.Time) || !edits[1].Time.Equal(*edited.EditedAt) {
+		t.Fatalf("edit times = %v, %v", edits[0].Time, edits[1].Time)
+	}
+}
+
+func TestDeleteMessageForEveryone(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+	msg := postTestMessage(t, r, alice, bob, "v1")
+
+	if _, _, _, err := r.EditMessage(msg.ID, alice, []byte("v2"), blindindex.Tokens{}, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, ok, err := r.DeleteMessage(msg.ID, bob); err != nil || ok {
+		t.Fatalf("delete by receiver: ok = %v, err = %v", ok, err)
+	}
+
+	deleted, cursors, ok, err := r.DeleteMessage(msg.ID, alice)
+	if err != nil || !ok {
+		t.Fatalf("delete by sender: ok = %v, err = %v", ok, err)
+	}
+	if len(deleted.Msg) != 0 || deleted.DeletedAt == nil {
+		t.Fatalf("deleted message keeps its text: %+v", deleted)
+	}
+	if cursors[alice] == 0 || cursors[bob] == 0 {
+		t.Fatalf("delete event is missing from journals: %v", cursors)
+	}
+
+	edits, err := r.GetMessageEdits(msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 0 {
+		t.Fatalf("edit history survived delete: %+v", edits)
+	}
+
+	if _, _, ok, err = r.DeleteMessage(msg.ID, alice); err != nil || ok {
+		t.Fatalf("second delete: ok = %v, err = %v", ok, err)
+	}
+	if _, _, ok, err = r.EditMessage(msg.ID, alice, []byte("v3"), blindindex.Tokens{}, time.Now().Add(-time.Hour)); err != nil || ok {
+		t.Fatalf("edit of deleted message: ok = %v, err = %v", ok, err)
+	}
+}
+
+func TestHideMessage(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob, eve := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob"), insertTestUser(t, conn, "eve")
+	msg := postTestMessage(t, r, alice, bob, "hi")
+
+	if _, ok, err := r.HideMessage(msg.ID, eve); err != nil || ok {
+		t.Fatalf("hide by outsider: ok = %v, err = %v", ok, err)
+	}
+
+	cursors, ok, err := r.HideMessage(msg.ID, bob)
+	if err != nil || !ok {
+		t.Fatalf("hide by receiver: ok = %v, err = %v", ok, err)
+	}
+	// Скрытие видят только устройства скрывшего
+	if cursors[bob] == 0 || cursors[alice] != 0 {
+		t.Fatalf("hidden event journals: %v", cursors)
+	}
+
+	if _, ok, err = r.HideMessage(msg.ID, bob); err != nil || ok {
+		t.Fatalf("second hide: ok = %v, err = %v", ok, err)
+	}
+
+	events, err := r.GetEvents(bob, cursors[bob]-1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Type != models.EventMessageHidden || events[0].MessageID != nil {
+		t.Fatalf("hidden event = %+v", events)
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"fmt"
+	"playmates/components/playmates/models"
+)
+
+func (r *Repository) CreateNotification(userID int, notificationType string, payload []byte) (models.Notification, error) {
+	notification := models.Notification{
+		UserID:  userID,
+		Type:    notificationType,
+		Payload: payload,
+	}
+
+	err := r.db.QueryRow(`
+        INSERT INTO notifications (user_id, type, payload)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at
+    `, userID, notificationType, payload).Scan(&notification.ID, &notification.CreatedAt)
+	if err != nil {
+		return models.Notification{}, fmt.Errorf("failed to create notification: %w", err)
+	}
+
+	return notification, nil
+}
+
+func (r *Repository) GetNotifications(userID int, unreadOnly bool, limit int) ([]models.Notification, error) {
+	rows, err := r.db.Query(`
+        SELECT id, user_id, type, payload, read_at, created_at
+        FROM notifications
+        WHERE user_id = $1 AND (NOT $2 OR read_at IS NULL)
+        ORDER BY created_at DESC, id DESC
+        LIMIT $3
+    `, userID, unreadOnly, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		var notification models.Notification
+		err := rows.Scan(
+			&notification.ID, &notification.UserID, &notification.Type, &notification.Payload,
+			&notification.ReadAt, &notification.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, nil
+}
+
+func (r *Repository) MarkNotificationRead(userID, notificationID int) (bool, error) {
+	res, err := r.db.Exec(
+		"UPDATE notifications SET read_at = NOW() WHERE id = $1 AND user_id = $2 AND read_at IS NULL",
+		notificationID, userID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark notification as read: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"playmates/components/playmates/models"
+	"time"
+)
+
+// PinMessage закрепляет сообщение в его переписке, если там меньше maxPins закреплённых.
+// changed = false - уже закреплено или сообщение удалено, full = true - лимит исчерпан.
+func (r *Repository) PinMessage(msg models.MessageDB, userID, maxPins int) (models.PinPayload, map[int]int64, bool, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.PinPayload{}, nil, false, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Блокировка беседы сериализует закрепления, иначе параллельные запросы обойдут лимит
+	var pinned int
+	err = tx.QueryRow(`
+        SELECT (SELECT COUNT(*) FROM message_pins WHERE conversation_id = c.id)
+        FROM conversations c
+        WHERE c.id = $1
+        FOR UPDATE
+    `, msg.ConversationID).Scan(&pinned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.PinPayload{}, nil, false, false, nil
+	}
+	if err != nil {
+		return models.PinPayload{}, nil, false, false, fmt.Errorf("error while counting pins: %w", err)
+	}
+
+	if pinned >= maxPins {
+		// Повторное закрепление уже закреплённого не упирается в лимит
+		var exists bool
+		err = tx.QueryRow(
+			"SELECT EXISTS (SELECT 1 FROM message_pins WHERE conversation_id = $1 AND message_id = $2)",
+			msg.ConversationID, msg.ID,
+		).Scan(&exists)
+		if err != nil {
+			return models.PinPayload{}, nil, false, false, fmt.Errorf("error while getting pin: %w", err)
+		}
+		return models.PinPayload{}, nil, false, !exists, nil
+	}
+
+	payload := models.PinPayload{MessageID: msg.ID, ConversationID: msg.ConversationID, UserID: userID}
+	err = tx.QueryRow(`
+        INSERT INTO message_pins (conversation_id, message_id, pinned_by)
+        SELECT conversation_id, id, $2
+        FROM messages
+        WHERE id = $1 AND deleted_at IS NULL
+        ON CONFLICT DO NOTHING
+        RETURNING created_at
+    `, msg.ID, userID).Scan(&payload.Time)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.PinPayload{}, nil, false, false, nil
+	}
+	if err != nil {
+		return models.PinPayload{}, nil, false, false, fmt.Errorf("error while pinning message: %w", err)
+	}
+
+	cursors, err := appendPinEvent(tx, msg, models.EventMessagePinned, payload)
+	if err != nil {
+		return models.PinPayload{}, nil, false, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.PinPayload{}, nil, false, false, fmt.Errorf("error while committing pin: %w", err)
+	}
+
+	return payload, cursors, true, false, nil
+}
+
+// UnpinMessage открепляет сообщение. changed = false, если оно не было закреплено.
+func (r *Repository) UnpinMessage(msg models.MessageDB, userID int) (models.PinPayload, map[int]int64, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.PinPayload{}, nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"DELETE FROM message_pins WHERE conversation_id = $1 AND message_id = $2",
+		msg.ConversationID, msg.ID,
+	)
+	if err != nil {
+		return models.PinPayload{}, nil, false, fmt.Errorf("error while unpinning message: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		return models.PinPayload{}, nil, false, nil
+	}
+
+	payload := models.PinPayload{MessageID: msg.ID, ConversationID: msg.ConversationID, UserID: userID, Time: time.Now()}
+	cursors, err := appendPinEvent(tx, msg, models.EventMessageUnpinned, payload)
+	if err != nil {
+		return models.PinPayload{}, nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.PinPayload{}, nil, false, fmt.Errorf("error while committing unpin: %w", err)
+	}
+
+	return payload, cursors, true, nil
+}
+
+// GetPins возвращает закреплённые сообщения беседы, последние закреплённые первыми.
+func (r *Repository) GetPins(conversationID int) ([]models.PinDB, error) {
+	rows, err := r.db.Query(`
+        SELECT `+messageColumns+`, COALESCE(p.pinned_by, 0), p.created_at
+        FROM message_pins p
+        JOIN messages ON messages.id = p.message_id
+        WHERE p.conversation_id = $1
+        ORDER BY p.created_at DESC
+    `, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting pins: %w", err)
+	}
+	defer rows.Close()
+
+	pins := []models.PinDB{}
+	for rows.Next() {
+		var pin models.PinDB
+		msg := &pin.Message
+		err := rows.Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.ReceiverID, &msg.Msg, &msg.Time,
+			&msg.EditedAt, &msg.DeletedAt, &msg.ReplyToID, &msg.SealVersion, &msg.Kind, &pin.PinnedBy, &pin.PinnedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		pins = append(pins, pin)
+	}
+
+	return pins, nil
+}
+
+// GetDirectConversationID - ID личной беседы пары; exists = false, если они ещё не переписывались.
+func (r *Repository) GetDirectConversationID(userA, userB int) (int, bool, error) {
+	low, high := userA, userB
+	if low > high {
+		low, high = high, low
+	}
+
+	var conversationID int
+	err := r.db.QueryRow(
+		"SELECT id FROM conversations WHERE direct_user_low = $1 AND direct_user_high = $2",
+		low, high,
+	).Scan(&conversationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("error while getting direct conversation: %w", err)
+	}
+
+	return conversationID, true, nil
+}
+
+func appendPinEvent(tx *sql.Tx, msg models.MessageDB, eventType string, payload models.PinPayload) (map[int]int64, error) {
+	audience, err := messageAudience(tx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal pin: %w", err)
+	}
+
+	// Без message_id: при синхронизации событие не должно подменяться самим сообщением
+	return appendEvents(tx, audience, eventType, nil, data)
+}
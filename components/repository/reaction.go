@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"playmates/components/playmates/models"
+
+	"github.com/lib/pq"
+)
+
+// SetReaction ставит (add) или снимает реакцию userID и пишет message.reaction в журналы
+// участников переписки. changed = false, если сообщения нет, оно удалено или реакция уже
+// в нужном состоянии. Видимость сообщения для userID проверяет вызывающий.
+func (r *Repository) SetReaction(messageID, userID int, emoji string, add bool) (models.ReactionEventPayload, map[int]int64, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.ReactionEventPayload{}, nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// FOR SHARE не даёт удалить сообщение у всех, пока ставится реакция
+	msg, err := scanMessage(tx.QueryRow(`
+        SELECT `+messageColumns+`
+        FROM messages
+        WHERE id = $1 AND deleted_at IS NULL
+        FOR SHARE
+    `, messageID))
+	if err == sql.ErrNoRows {
+		return models.ReactionEventPayload{}, nil, false, nil
+	}
+	if err != nil {
+		return models.ReactionEventPayload{}, nil, false, fmt.Errorf("error while getting message: %w", err)
+	}
+
+	query := `
+        INSERT INTO message_reactions (message_id, user_id, emoji)
+        VALUES ($1, $2, $3)
+        ON CONFLICT DO NOTHING
+    `
+	action := models.ReactionAdded
+	if !add {
+		query = "DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3"
+		action = models.ReactionRemoved
+	}
+
+	res, err := tx.Exec(query, messageID, userID, emoji)
+	if err != nil {
+		return models.ReactionEventPayload{}, nil, false, fmt.Errorf("error while setting reaction: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		return models.ReactionEventPayload{}, nil, false, nil
+	}
+
+	payload := models.ReactionEventPayload{
+		MessageID:      messageID,
+		ConversationID: msg.ConversationID,
+		UserID:         userID,
+		Emoji:          emoji,
+		Action:         action,
+	}
+	err = tx.QueryRow(`
+        SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2
+    `, messageID, emoji).Scan(&payload.Count)
+	if err != nil {
+		return models.ReactionEventPayload{}, nil, false, fmt.Errorf("error while counting reactions: %w", err)
+	}
+
+	audience, err := messageAudience(tx, msg)
+	if err != nil {
+		return models.ReactionEventPayload{}, nil, false, err
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return models.ReactionEventPayload{}, nil, false, fmt.Errorf("failed to marshal reaction: %w", err)
+	}
+
+	// message_id не пишем: при синхронизации событие должно остаться реакцией, а не сообщением
+	cursors, err := appendEvents(tx, audience, models.EventReaction, nil, data)
+	if err != nil {
+		return models.ReactionEventPayload{}, nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.ReactionEventPayload{}, nil, false, fmt.Errorf("error while committing reaction: %w", err)
+	}
+
+	return payload, cursors, true, nil
+}
+
+// GetReactions возвращает сводку реакций по сообщениям в порядке первой постановки эмодзи.
+func (r *Repository) GetReactions(messageIDs []int, userID int) (map[int][]models.Reaction, error) {
+	reactions := make(map[int][]models.Reaction)
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+
+	rows, err := r.db.Query(`
+        SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+        FROM message_reactions
+        WHERE message_id = ANY($1)
+        GROUP BY message_id, emoji
+        ORDER BY message_id, MIN(created_at), emoji
+    `, pq.Array(toInt64s(messageIDs)), userID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var reaction models.Reaction
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, &reaction.Mine); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		reactions[messageID] = append(reactions[messageID], reaction)
+	}
+
+	return reactions, nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"playmates/components/playmates/models"
+	"reflect"
+	"testing"
+)
+
+func TestSetReactionAggregates(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+	msg := postTestMessage(t, r, alice, bob, "gg")
+
+	payload, cursors, changed, err := r.SetReaction(msg.ID, bob, "🔥", true)
+	if err != nil || !changed {
+		t.Fatalf("add reaction: changed = %v, err = %v", changed, err)
+	}
+	if payload.Count != 1 || payload.Action != models.ReactionAdded || payload.ConversationID != msg.ConversationID {
+		t.Fatalf("reaction event = %+v", payload)
+	}
+	if cursors[alice] == 0 || cursors[bob] == 0 {
+		t.Fatalf("reaction event is missing from journals: %v", cursors)
+	}
+
+	// Повторная постановка ничего не меняет
+	if _, _, changed, err = r.SetReaction(msg.ID, bob, "🔥", true); err != nil || changed {
+		t.Fatalf("duplicate reaction: changed = %v, err = %v", changed, err)
+	}
+
+	if payload, _, _, err = r.SetReaction(msg.ID, alice, "🔥", true); err != nil || payload.Count != 2 {
+		t.Fatalf("second user reaction = %+v, err = %v", payload, err)
+	}
+	if _, _, _, err = r.SetReaction(msg.ID, alice, "👍", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Сводка в порядке первой постановки, Mine - с точки зрения запросившего
+	reactions, err := r.GetReactions([]int{msg.ID}, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.Reaction{{Emoji: "🔥", Count: 2, Mine: true}, {Emoji: "👍", Count: 1, Mine: false}}
+	if !reflect.DeepEqual(reactions[msg.ID], want) {
+		t.Fatalf("reactions = %+v, want %+v", reactions[msg.ID], want)
+	}
+
+	payload, _, changed, err = r.SetReaction(msg.ID, bob, "🔥", false)
+	if err != nil || !changed || payload.Count != 1 || payload.Action != models.ReactionRemoved {
+		t.Fatalf("remove reaction = %+v, changed = %v, err = %v", payload, changed, err)
+	}
+	if _, _, changed, err = r.SetReaction(msg.ID, bob, "🔥", false); err != nil || changed {
+		t.Fatalf("remove missing reaction: changed = %v, err = %v", changed, err)
+	}
+}
+
+func TestSetReactionOnDeletedMessage(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+	msg := postTestMessage(t, r, alice, bob, "gg")
+
+	if _, _, _, err := r.DeleteMessage(msg.ID, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, changed, err := r.SetReaction(msg.ID, bob, "🔥", true); err != nil || changed {
+		t.Fatalf("reaction on deleted message: changed = %v, err = %v", changed, err)
+	}
+}
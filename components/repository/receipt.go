@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"playmates/components/playmates/models"
+)
+
+// MarkRead сдвигает отметку прочтения userID в переписке с peerID. Прочитанное считается и доставленным.
+// changed = false, если отметка уже была не меньше.
+func (r *Repository) MarkRead(userID, peerID, upTo int) (models.Receipt, map[int]int64, bool, error) {
+	return r.advanceReceipt(userID, peerID, upTo, models.ReceiptRead)
+}
+
+func (r *Repository) MarkDelivered(userID, peerID, upTo int) (models.Receipt, map[int]int64, bool, error) {
+	return r.advanceReceipt(userID, peerID, upTo, models.ReceiptDelivered)
+}
+
+func (r *Repository) advanceReceipt(userID, peerID, upTo int, status string) (models.Receipt, map[int]int64, bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Отметка не может уйти дальше последнего сообщения, которое peerID отправил userID
+	err = tx.QueryRow(`
+        SELECT COALESCE(MAX(id), 0)
+        FROM messages
+        WHERE sender_id = $1 AND receiver_id = $2 AND id <= $3
+    `, peerID, userID, upTo).Scan(&upTo)
+	if err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("error while getting last message: %w", err)
+	}
+	if upTo == 0 {
+		return models.Receipt{}, nil, false, nil
+	}
+
+	query := `
+        INSERT INTO chat_reads (user_id, peer_id, last_delivered_message_id, last_read_message_id)
+        VALUES ($1, $2, $3, $3)
+        ON CONFLICT (user_id, peer_id) DO UPDATE SET
+            last_delivered_message_id = GREATEST(chat_reads.last_delivered_message_id, EXCLUDED.last_delivered_message_id),
+            last_read_message_id = EXCLUDED.last_read_message_id,
+            updated_at = NOW()
+        WHERE chat_reads.last_read_message_id < EXCLUDED.last_read_message_id
+        RETURNING updated_at
+    `
+	if status == models.ReceiptDelivered {
+		query = `
+            INSERT INTO chat_reads (user_id, peer_id, last_delivered_message_id)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (user_id, peer_id) DO UPDATE SET
+                last_delivered_message_id = EXCLUDED.last_delivered_message_id,
+                updated_at = NOW()
+            WHERE chat_reads.last_delivered_message_id < EXCLUDED.last_delivered_message_id
+            RETURNING updated_at
+        `
+	}
+
+	receipt := models.Receipt{
+		UserID:    userID,
+		PeerID:    peerID,
+		Status:    status,
+		MessageID: upTo,
+	}
+
+	err = tx.QueryRow(query, userID, peerID, upTo).Scan(&receipt.Time)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Receipt{}, nil, false, nil
+	}
+	if err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("error while updating chat reads: %w", err)
+	}
+
+	data, err := json.Marshal(receipt)
+	if err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("failed to marshal receipt: %w", err)
+	}
+
+	// Отправитель узнаёт о прочтении, остальные устройства читателя снимают счётчик
+	cursors, err := appendEvents(tx, []int{peerID, userID}, models.EventReceipt, nil, data)
+	if err != nil {
+		return models.Receipt{}, nil, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Receipt{}, nil, false, fmt.Errorf("error while committing receipt: %w", err)
+	}
+
+	return receipt, cursors, true, nil
+}
+
+// GetReadState возвращает, докуда peerID получил и прочитал сообщения userID.
+func (r *Repository) GetReadState(userID, peerID int) (models.ReadState, error) {
+	var state models.ReadState
+	err := r.db.QueryRow(`
+        SELECT last_delivered_message_id, last_read_message_id
+        FROM chat_reads
+        WHERE user_id = $1 AND peer_id = $2
+    `, peerID, userID).Scan(&state.DeliveredUpTo, &state.ReadUpTo)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return models.ReadState{}, fmt.Errorf("error while getting read state: %w", err)
+	}
+
+	return state, nil
+}
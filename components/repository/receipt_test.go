@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"math"
+	"playmates/components/playmates/models"
+	"testing"
+)
+
+func assertReadState(t *testing.T, r *Repository, senderID, readerID int, want models.ReadState) {
+	t.Helper()
+
+	state, err := r.GetReadState(senderID, readerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != want {
+		t.Fatalf("read state = %+v, want %+v", state, want)
+	}
+}
+
+// Отметки двигаются только вперёд и не уходят дальше последнего сообщения собеседника.
+func TestMarkDeliveredAdvancesForward(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+
+	first := postTestMessage(t, r, alice, bob, "one").ID
+	second := postTestMessage(t, r, alice, bob, "two").ID
+	// Своё сообщение не двигает отметку о сообщениях собеседника
+	own := postTestMessage(t, r, bob, alice, "mine").ID
+
+	receipt, cursors, changed, err := r.MarkDelivered(bob, alice, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || receipt.MessageID != second || receipt.Status != models.ReceiptDelivered {
+		t.Fatalf("receipt = %+v, changed = %v", receipt, changed)
+	}
+	if cursors[alice] == 0 || cursors[bob] == 0 {
+		t.Fatalf("receipt event is missing from journals: %v", cursors)
+	}
+
+	if _, _, changed, err = r.MarkDelivered(bob, alice, first); err != nil || changed {
+		t.Fatalf("delivered moved back: changed = %v, err = %v", changed, err)
+	}
+	assertReadState(t, r, alice, bob, models.ReadState{DeliveredUpTo: second})
+
+	// Дальше последнего сообщения alice отметка не уходит
+	receipt, _, changed, err = r.MarkDelivered(bob, alice, math.MaxInt32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed || receipt.MessageID != 0 {
+		t.Fatalf("delivered moved past the last message: %+v", receipt)
+	}
+
+	// До own bob ничего alice не писал
+	if _, _, changed, err = r.MarkDelivered(alice, bob, own-1); err != nil || changed {
+		t.Fatalf("delivered without messages: changed = %v, err = %v", changed, err)
+	}
+}
+
+func TestMarkReadImpliesDelivered(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+
+	first := postTestMessage(t, r, alice, bob, "one").ID
+	second := postTestMessage(t, r, alice, bob, "two").ID
+	third := postTestMessage(t, r, alice, bob, "three").ID
+
+	if _, _, changed, err := r.MarkRead(bob, alice, first); err != nil || !changed {
+		t.Fatalf("mark read: changed = %v, err = %v", changed, err)
+	}
+	assertReadState(t, r, alice, bob, models.ReadState{DeliveredUpTo: first, ReadUpTo: first})
+
+	if _, _, _, err := r.MarkDelivered(bob, alice, third); err != nil {
+		t.Fatal(err)
+	}
+
+	// Прочтение не откатывает доставку, которая ушла дальше
+	if _, _, changed, err := r.MarkRead(bob, alice, second); err != nil || !changed {
+		t.Fatalf("mark read: changed = %v, err = %v", changed, err)
+	}
+	assertReadState(t, r, alice, bob, models.ReadState{DeliveredUpTo: third, ReadUpTo: second})
+
+	if _, _, changed, err := r.MarkRead(bob, alice, first); err != nil || changed {
+		t.Fatalf("read moved back: changed = %v, err = %v", changed, err)
+	}
+	assertReadState(t, r, alice, bob, models.ReadState{DeliveredUpTo: third, ReadUpTo: second})
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"playmates/components/blindindex"
+	"playmates/components/db"
+	"playmates/components/playmates/models"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Тесты репозитория идут на настоящей базе: запросы держатся на особенностях Postgres
+// (массивы, tsvector, FOR UPDATE SKIP LOCKED), заглушка их не проверит.
+
+// testRepository подключается к базе с применёнными миграциями из PLAYMATES_TEST_DB.
+// Без неё тесты с базой пропускаются.
+func testRepository(t *testing.T) (*Repository, *sql.DB) {
+	t.Helper()
+
+	connStr := os.Getenv("PLAYMATES_TEST_DB")
+	if connStr == "" {
+		t.Skip("PLAYMATES_TEST_DB is not set")
+	}
+
+	conn, err := db.ConnectPostgres(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return New(conn), conn
+}
+
+var testSeq atomic.Int64
+
+// testName - уникальное имя, чтобы тесты не мешали друг другу и данным в базе.
+func testName(name string) string {
+	return fmt.Sprintf("%s-%d-%d", name, time.Now().UnixNano(), testSeq.Add(1))
+}
+
+// testWord - уникальное слово из одних букв: tsvector и триграммы режут имена по цифрам и дефисам.
+func testWord() string {
+	n := uint64(time.Now().UnixNano()) + uint64(testSeq.Add(1))
+	word := []byte("zq")
+	for ; n > 0; n /= 26 {
+		word = append(word, byte('a'+n%26))
+	}
+	return string(word)
+}
+
+// testEmail - уникальный email.
+func testEmail(name string) string {
+	return testName(name) + "@example.com"
+}
+
+// insertTestUser создаёт пользователя с уникальным именем и удаляет его после теста.
+func insertTestUser(t *testing.T, conn *sql.DB, name string) int {
+	t.Helper()
+
+	var id int
+	err := conn.QueryRow(`
+        INSERT INTO users (username, password_hash, age, gender, games)
+        VALUES ($1, 'hash', 0, '', '{}')
+        RETURNING id
+    `, testName(name)).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteUsersOnCleanup(t, conn, id)
+
+	return id
+}
+
+func deleteUsersOnCleanup(t *testing.T, conn *sql.DB, ids ...int) {
+	t.Cleanup(func() {
+		if _, err := conn.Exec("DELETE FROM users WHERE id = ANY($1)", pq.Array(ids)); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// containsUser - есть ли пользователь id в выдаче.
+func containsUser(ids []int, id int) bool {
+	for _, got := range ids {
+		if got == id {
+			return true
+		}
+	}
+	return false
+}
+
+// postTestMessage отправляет личное сообщение. Репозиторию содержимое не важно, шифрование тут не нужно.
+func postTestMessage(t *testing.T, r *Repository, senderID, receiverID int, text string) models.MessageDB {
+	t.Helper()
+
+	id, err := r.NextMessageID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, _, err := r.PostMessage(id, models.MessageKindText, []byte(text), senderID, receiverID, nil, 0, blindindex.Tokens{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"playmates/components/playmates/models"
+	"time"
+)
+
+func (r *Repository) CreateSavedSearch(userID int, name string, params models.SearchParams) (models.SavedSearch, error) {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return models.SavedSearch{}, fmt.Errorf("failed to marshal search params: %w", err)
+	}
+
+	search := models.SavedSearch{
+		UserID: userID,
+		Name:   name,
+		Params: params,
+	}
+
+	err = r.db.QueryRow(`
+        INSERT INTO saved_searches (user_id, name, params)
+        VALUES ($1, $2, $3)
+        RETURNING id, last_run_at, created_at
+    `, userID, name, rawParams).Scan(&search.ID, &search.LastRunAt, &search.CreatedAt)
+	if err != nil {
+		return models.SavedSearch{}, fmt.Errorf("failed to create saved search: %w", err)
+	}
+
+	return search, nil
+}
+
+func (r *Repository) CountSavedSearches(userID int) (int, error) {
+	var total int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM saved_searches WHERE user_id = $1", userID).Scan(&total)
+	if err != nil {
+		return -1, fmt.Errorf("failed to count saved searches: %w", err)
+	}
+
+	return total, nil
+}
+
+func (r *Repository) GetSavedSearches(userID int) ([]models.SavedSearch, error) {
+	rows, err := r.db.Query(`
+        SELECT id, user_id, name, params, last_run_at, created_at
+        FROM saved_searches
+        WHERE user_id = $1
+        ORDER BY id DESC
+    `, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saved searches: %w", err)
+	}
+	defer rows.Close()
+
+	return scanSavedSearches(rows)
+}
+
+func (r *Repository) DeleteSavedSearch(userID, searchID int) (bool, error) {
+	res, err := r.db.Exec("DELETE FROM saved_searches WHERE id = $1 AND user_id = $2", searchID, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete saved search: %w", err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+
+	return rowsAffected > 0, nil
+}
+
+// ClaimDueSavedSearches забирает поиски, которые не запускались с dueBefore, и сразу проставляет
+// им last_run_at = runAt. В результате LastRunAt содержит время предыдущего запуска.
+// SKIP LOCKED позволяет нескольким инстансам разбирать очередь параллельно.
+func (r *Repository) ClaimDueSavedSearches(dueBefore, runAt time.Time, limit int) ([]models.SavedSearch, error) {
+	rows, err := r.db.Query(`
+        WITH due AS (
+            SELECT id, last_run_at
+            FROM saved_searches
+            WHERE last_run_at <= $1
+            ORDER BY last_run_at
+            LIMIT $2
+            FOR UPDATE SKIP LOCKED
+        )
+        UPDATE saved_searches s
+        SET last_run_at = $3
+        FROM due
+        WHERE s.id = due.id
+        RETURNING s.id, s.user_id, s.name, s.params, due.last_run_at, s.created_at
+    `, dueBefore, limit, runAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim saved searches: %w", err)
+	}
+	defer rows.Close()
+
+	return scanSavedSearches(rows)
+}
+
+func scanSavedSearches(rows *sql.Rows) ([]models.SavedSearch, error) {
+	searches := []models.SavedSearch{}
+	for rows.Next() {
+		var search models.SavedSearch
+		var rawParams []byte
+
+		err := rows.Scan(&search.ID, &search.UserID, &search.Name, &rawParams, &search.LastRunAt, &search.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+
+		if err = json.Unmarshal(rawParams, &search.Params); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal search params: %w", err)
+		}
+
+		searches = append(searches, search)
+	}
+
+	return searches, nil
+}
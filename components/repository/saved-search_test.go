@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"playmates/components/playmates/models"
+	"testing"
+	"time"
+)
+
+// Время запусков тестовых поисков далеко в прошлом, чтобы не задеть поиски в базе.
+var testRunEpoch = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func createDueSearch(t *testing.T, r *Repository, userID int, lastRunAt time.Time) models.SavedSearch {
+	t.Helper()
+
+	search, err := r.CreateSavedSearch(userID, "duo", models.SearchParams{Games: []string{"dota"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.db.Exec("UPDATE saved_searches SET last_run_at = $2 WHERE id = $1", search.ID, lastRunAt); err != nil {
+		t.Fatal(err)
+	}
+	search.LastRunAt = lastRunAt
+
+	return search
+}
+
+func claimedIDs(t *testing.T, r *Repository, dueBefore, runAt time.Time) map[int]models.SavedSearch {
+	t.Helper()
+
+	searches, err := r.ClaimDueSavedSearches(dueBefore, runAt, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed := make(map[int]models.SavedSearch, len(searches))
+	for _, search := range searches {
+		claimed[search.ID] = search
+	}
+	return claimed
+}
+
+func TestClaimDueSavedSearches(t *testing.T) {
+	r, conn := testRepository(t)
+	userID := insertTestUser(t, conn, "searcher")
+
+	due := createDueSearch(t, r, userID, testRunEpoch)
+	notDue := createDueSearch(t, r, userID, testRunEpoch.Add(2*time.Hour))
+
+	runAt := testRunEpoch.Add(time.Hour)
+	claimed := claimedIDs(t, r, runAt, runAt)
+	if _, ok := claimed[notDue.ID]; ok {
+		t.Error("search that is not due was claimed")
+	}
+	got, ok := claimed[due.ID]
+	if !ok {
+		t.Fatal("due search was not claimed")
+	}
+	// LastRunAt - время прошлого запуска, от него считаются новые совпадения
+	if !got.LastRunAt.Equal(due.LastRunAt) || got.Params.Games[0] != "dota" {
+		t.Errorf("claimed search = %+v, want last run %v", got, due.LastRunAt)
+	}
+
+	// Поиск забран: повторный проход того же интервала его не видит
+	if _, ok := claimedIDs(t, r, runAt, runAt)[due.ID]; ok {
+		t.Error("search was claimed twice")
+	}
+}
+
+// Поиск, который сейчас обрабатывает другой инстанс, пропускается, а не ждёт блокировки.
+func TestClaimDueSavedSearchesSkipsLocked(t *testing.T) {
+	r, conn := testRepository(t)
+	userID := insertTestUser(t, conn, "searcher")
+
+	locked := createDueSearch(t, r, userID, testRunEpoch)
+	free := createDueSearch(t, r, userID, testRunEpoch)
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("SELECT id FROM saved_searches WHERE id = $1 FOR UPDATE", locked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	runAt := testRunEpoch.Add(time.Hour)
+	done := make(chan map[int]models.SavedSearch, 1)
+	go func() {
+		searches, err := r.ClaimDueSavedSearches(runAt, runAt, 1000)
+		if err != nil {
+			t.Error(err)
+		}
+		claimed := make(map[int]models.SavedSearch, len(searches))
+		for _, search := range searches {
+			claimed[search.ID] = search
+		}
+		done <- claimed
+	}()
+
+	var claimed map[int]models.SavedSearch
+	select {
+	case claimed = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ClaimDueSavedSearches() waited for a locked row")
+	}
+	if _, ok := claimed[locked.ID]; ok {
+		t.Error("locked search was claimed")
+	}
+	if _, ok := claimed[free.ID]; !ok {
+		t.Error("free search was not claimed")
+	}
+
+	// Блокировку сняли без запуска - поиск забирает следующий проход
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := claimedIDs(t, r, runAt, runAt)[locked.ID]; !ok {
+		t.Error("search was not claimed after the lock was released")
+	}
+}
+
+// Дайджест собирается только из профилей, созданных или изменённых после прошлого запуска.
+func TestSearchUsersUpdatedSince(t *testing.T) {
+	r, conn := testRepository(t)
+	game := testName("game")
+
+	old := insertTestUser(t, conn, "old")
+	setGames(t, r, old, game)
+	fresh := insertTestUser(t, conn, "fresh")
+	setGames(t, r, fresh, game)
+
+	since := time.Now().Add(-time.Hour)
+	if _, err := conn.Exec("UPDATE users SET updated_at = $2 WHERE id = $1", old, since.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := searchIDs(t, r, models.SearchParams{Games: []string{game}, UpdatedSince: since})
+	if len(got) != 1 || got[0] != fresh {
+		t.Errorf("SearchUsers() = %v, want only %d", got, fresh)
+	}
+}
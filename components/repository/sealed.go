@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"fmt"
+	"playmates/components/playmates/models"
+)
+
+// SealedColumn - колонка с данными, зашифрованными ключом сервера.
+type SealedColumn struct {
+	Table  string
+	Column string
+}
+
+func (c SealedColumn) String() string {
+	return c.Table + "." + c.Column
+}
+
+// SealedColumns - колонки без associated data; их перешифровывает reencrypt при смене ключа.
+// Тексты сообщений привязаны к полям сообщения, для них есть GetSealedMessages.
+// Файлы вложений зашифрованы своими ключами и перешифровки не требуют, только file_key.
+var SealedColumns = []SealedColumn{
+	{Table: "attachments", Column: "name"},
+	{Table: "attachments", Column: "file_key"},
+}
+
+// DataKeyColumn - ключи данных бесед. Они обёрнуты мастер-ключом KeyProvider, а не sealer,
+// поэтому в SealedColumns не входят.
+var DataKeyColumn = SealedColumn{Table: "conversations", Column: "data_key"}
+
+// UserSealedField - зашифрованное поле профиля. Шифротекст привязан к пользователю через
+// models.UserFieldAD(id, Field), поэтому перешифровывается с тем же associated data.
+type UserSealedField struct {
+	SealedColumn
+	Field string
+}
+
+var UserSealedFields = []UserSealedField{
+	{SealedColumn{Table: "users", Column: "email_sealed"}, models.UserFieldEmail},
+	{SealedColumn{Table: "users", Column: "about_me_sealed"}, models.UserFieldAboutMe},
+}
+
+type SealedValue struct {
+	ID   int
+	Data []byte
+}
+
+// GetSealedBatch возвращает непустые значения колонки с ID больше afterID по возрастанию ID.
+func (r *Repository) GetSealedBatch(col SealedColumn, afterID, limit int) ([]SealedValue, error) {
+	if !knownSealedColumn(col) {
+		return nil, fmt.Errorf("unknown sealed column %s", col)
+	}
+
+	rows, err := r.db.Query(fmt.Sprintf(`
+        SELECT id, %[2]s
+        FROM %[1]s
+        WHERE id > $1 AND length(%[2]s) > 0
+        ORDER BY id
+        LIMIT $2
+    `, col.Table, col.Column), afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting %s: %w", col, err)
+	}
+	defer rows.Close()
+
+	var values []SealedValue
+	for rows.Next() {
+		var v SealedValue
+		if err := rows.Scan(&v.ID, &v.Data); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+// ReplaceSealed записывает перешифрованное значение, только если в строке всё ещё old.
+// ok = false, если значение успели изменить (правка или удаление сообщения).
+func (r *Repository) ReplaceSealed(col SealedColumn, id int, old, new []byte) (bool, error) {
+	if !knownSealedColumn(col) {
+		return false, fmt.Errorf("unknown sealed column %s", col)
+	}
+
+	res, err := r.db.Exec(fmt.Sprintf(
+		"UPDATE %[1]s SET %[2]s = $3 WHERE id = $1 AND %[2]s = $2",
+		col.Table, col.Column,
+	), id, old, new)
+	if err != nil {
+		return false, fmt.Errorf("error while updating %s: %w", col, err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// knownSealedColumn не даёт подставить в запрос произвольные имена.
+func knownSealedColumn(col SealedColumn) bool {
+	if col == DataKeyColumn {
+		return true
+	}
+	for _, known := range SealedColumns {
+		if known == col {
+			return true
+		}
+	}
+	for _, known := range UserSealedFields {
+		if known.SealedColumn == col {
+			return true
+		}
+	}
+	return false
+}
+
+// SealedMessage - шифротекст сообщения или его прошлой версии. Message содержит поля,
+// к которым привязан шифротекст; для прошлой версии SealVersion - версия самой правки.
+type SealedMessage struct {
+	ID      int // ID строки в messages или message_edits
+	Message models.MessageDB
+}
+
+// GetSealedMessages возвращает неудалённые сообщения с ID больше afterID; edits = true -
+// их прошлые версии из message_edits.
+func (r *Repository) GetSealedMessages(edits bool, afterID, limit int) ([]SealedMessage, error) {
+	query := `
+        SELECT id, ` + messageColumns + `
+        FROM messages
+        WHERE id > $1 AND length(message) > 0 AND kind = 'text'
+        ORDER BY id
+        LIMIT $2
+    `
+	if edits {
+		query = `
+            SELECT e.id, m.id, COALESCE(m.conversation_id, 0), m.sender_id, COALESCE(m.receiver_id, 0),
+                e.message, e.created_at, m.edited_at, m.deleted_at, m.reply_to_id, e.seal_version, m.kind
+            FROM message_edits e
+            JOIN messages m ON m.id = e.message_id
+            WHERE e.id > $1
+            ORDER BY e.id
+            LIMIT $2
+        `
+	}
+
+	rows, err := r.db.Query(query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting sealed messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []SealedMessage
+	for rows.Next() {
+		var sm SealedMessage
+		msg := &sm.Message
+		err := rows.Scan(&sm.ID, &msg.ID, &msg.ConversationID, &msg.SenderID, &msg.ReceiverID, &msg.Msg,
+			&msg.Time, &msg.EditedAt, &msg.DeletedAt, &msg.ReplyToID, &msg.SealVersion, &msg.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		messages = append(messages, sm)
+	}
+
+	return messages, nil
+}
+
+// ReplaceSealedMessage записывает шифротекст с текущей seal_version, только если
+// в строке всё ещё old. ok = false, если сообщение успели изменить или удалить.
+func (r *Repository) ReplaceSealedMessage(edits bool, id int, old, new []byte) (bool, error) {
+	table := "messages"
+	if edits {
+		table = "message_edits"
+	}
+
+	res, err := r.db.Exec(
+		"UPDATE "+table+" SET message = $3, seal_version = $4 WHERE id = $1 AND message = $2",
+		id, old, new, models.MessageSealVersion,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error while updating %s: %w", table, err)
+	}
+
+	rowsAffected, _ := res.RowsAffected()
+	return rowsAffected > 0, nil
+}
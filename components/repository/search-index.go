@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"playmates/components/blindindex"
+	"playmates/components/playmates/models"
+
+	"github.com/lib/pq"
+)
+
+// SetSearchTokens заменяет токены слепого индекса сообщения и отмечает его проиндексированным.
+// Токены построены по тексту из msg.Msg, поэтому пишутся, только если в строке всё ещё этот
+// шифротекст. ok = false, если сообщение успели изменить или удалить: правка сама пишет свои токены.
+func (r *Repository) SetSearchTokens(msg models.MessageDB, tokens blindindex.Tokens) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(
+		"SELECT id FROM messages WHERE id = $1 AND message = $2 AND deleted_at IS NULL FOR UPDATE",
+		msg.ID, msg.Msg,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error while locking message: %w", err)
+	}
+
+	if err = setSearchTokens(tx, msg.ID, msg.ConversationID, tokens); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("error while committing search tokens: %w", err)
+	}
+
+	return true, nil
+}
+
+// GetUnindexedMessages - неудалённые сообщения с ID больше afterID, которые не проиндексированы
+// текущей версией слепого индекса или проиндексированы не ключом keyID.
+func (r *Repository) GetUnindexedMessages(afterID int, keyID uint16, limit int) ([]models.MessageDB, error) {
+	rows, err := r.db.Query(`
+        SELECT `+messageColumns+`
+        FROM messages
+        WHERE id > $1 AND deleted_at IS NULL AND kind = 'text'
+          AND (search_version IS NULL OR search_version < $2 OR COALESCE(search_key_id, 1) <> $4)
+        ORDER BY id
+        LIMIT $3
+    `, afterID, blindindex.Version, limit, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting unindexed messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []models.MessageDB
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
+
+// SearchMessages ищет сообщения переписки, у которых есть все токены, от новых к старым.
+// Совпадение токенов не гарантирует совпадение фразы, окончательно фильтрует вызывающий.
+func (r *Repository) SearchMessages(userID, conversationID int, tokens [][]byte, before, limit int) ([]models.MessageDB, bool, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages m
+        WHERE m.conversation_id = $1
+          AND m.deleted_at IS NULL
+          AND m.id IN (
+              SELECT message_id
+              FROM message_search_tokens
+              WHERE conversation_id = $1 AND token = ANY($2)
+              GROUP BY message_id
+              HAVING COUNT(*) = $3
+          )
+          AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.user_id = $4 AND h.message_id = m.id)
+    `
+	args := []interface{}{conversationID, pq.ByteaArray(tokens), len(tokens), userID}
+
+	if before > 0 {
+		args = append(args, before)
+		query += fmt.Sprintf(" AND m.id < $%d", len(args))
+	}
+
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY m.id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("error while searching messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []models.MessageDB{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, false, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	return messages, hasMore, nil
+}
+
+func setSearchTokens(tx *sql.Tx, messageID, conversationID int, tokens blindindex.Tokens) error {
+	if _, err := tx.Exec("DELETE FROM message_search_tokens WHERE message_id = $1", messageID); err != nil {
+		return fmt.Errorf("error while deleting search tokens: %w", err)
+	}
+
+	// Сообщения без беседы (до миграции групп) индексировать не к чему
+	if len(tokens.Values) > 0 && conversationID != 0 {
+		_, err := tx.Exec(`
+            INSERT INTO message_search_tokens (conversation_id, token, message_id)
+            SELECT $1, t, $3 FROM unnest($2::bytea[]) AS t
+            ON CONFLICT DO NOTHING
+        `, conversationID, pq.ByteaArray(tokens.Values), messageID)
+		if err != nil {
+			return fmt.Errorf("error while inserting search tokens: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE messages SET search_version = $2, search_key_id = $3 WHERE id = $1", messageID, blindindex.Version, tokens.KeyID); err != nil {
+		return fmt.Errorf("error while updating search version: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"bytes"
+	"database/sql"
+	"playmates/components/blindindex"
+	"playmates/components/playmates/models"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// unindexedMessage отправляет сообщение без индекса и читает его так же, как backfill-search.
+func unindexedMessage(t *testing.T, r *Repository, conn *sql.DB, senderID, receiverID int, text string, index *blindindex.Index) models.MessageDB {
+	t.Helper()
+
+	posted := postTestMessage(t, r, senderID, receiverID, text)
+	if _, err := conn.Exec("UPDATE messages SET search_version = NULL WHERE id = $1", posted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := r.GetUnindexedMessages(posted.ID-1, index.KeyID(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].ID != posted.ID {
+		t.Fatalf("unindexed messages = %+v, want %d", messages, posted.ID)
+	}
+
+	return messages[0]
+}
+
+// storedTokens возвращает токены сообщения по возрастанию и ID ключа, которым оно проиндексировано.
+func storedTokens(t *testing.T, conn *sql.DB, messageID int) ([][]byte, sql.NullInt64) {
+	t.Helper()
+
+	var tokens pq.ByteaArray
+	var keyID sql.NullInt64
+	err := conn.QueryRow(`
+        SELECT COALESCE((SELECT array_agg(token ORDER BY token) FROM message_search_tokens WHERE message_id = m.id), '{}'),
+               m.search_key_id
+        FROM messages m
+        WHERE m.id = $1 AND m.search_version = $2
+    `, messageID, blindindex.Version).Scan(&tokens, &keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokens, keyID
+}
+
+func sortedTokens(tokens blindindex.Tokens) [][]byte {
+	sorted := append([][]byte(nil), tokens.Values...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	return sorted
+}
+
+func assertTokens(t *testing.T, conn *sql.DB, messageID int, want blindindex.Tokens) {
+	t.Helper()
+
+	got, keyID := storedTokens(t, conn, messageID)
+	wantValues := sortedTokens(want)
+	if len(got) != len(wantValues) || keyID.Int64 != int64(want.KeyID) {
+		t.Fatalf("stored %d tokens with key %v, want %d with key %d", len(got), keyID, len(wantValues), want.KeyID)
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], wantValues[i]) {
+			t.Fatalf("stored tokens differ from the expected text")
+		}
+	}
+}
+
+func TestSetSearchTokens(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+	index := blindindex.New(bytes.Repeat([]byte{3}, 32), 5)
+
+	msg := unindexedMessage(t, r, conn, alice, bob, "rush b", index)
+	tokens := index.Tokens(msg.ConversationID, "rush b")
+
+	ok, err := r.SetSearchTokens(msg, tokens)
+	if err != nil || !ok {
+		t.Fatalf("SetSearchTokens() = %v, %v", ok, err)
+	}
+	assertTokens(t, conn, msg.ID, tokens)
+}
+
+// Правка между чтением и записью побеждает: устаревшие токены не затирают токены правки,
+// а сообщение не остаётся отмеченным проиндексированным по старому тексту.
+func TestSetSearchTokensSkipsEditedMessage(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+	index := blindindex.New(bytes.Repeat([]byte{3}, 32), 5)
+
+	msg := unindexedMessage(t, r, conn, alice, bob, "rush b", index)
+	stale := index.Tokens(msg.ConversationID, "rush b")
+
+	fresh := index.Tokens(msg.ConversationID, "go mid")
+	if _, _, ok, err := r.EditMessage(msg.ID, alice, []byte("go mid"), fresh, time.Now().Add(-time.Hour)); err != nil || !ok {
+		t.Fatalf("edit: ok = %v, err = %v", ok, err)
+	}
+
+	ok, err := r.SetSearchTokens(msg, stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("stale tokens written over the edit")
+	}
+	assertTokens(t, conn, msg.ID, fresh)
+}
+
+func TestSetSearchTokensSkipsDeletedMessage(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+	index := blindindex.New(bytes.Repeat([]byte{3}, 32), 5)
+
+	msg := unindexedMessage(t, r, conn, alice, bob, "rush b", index)
+	if _, _, _, err := r.DeleteMessage(msg.ID, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := r.SetSearchTokens(msg, index.Tokens(msg.ConversationID, "rush b"))
+	if err != nil || ok {
+		t.Fatalf("tokens written for deleted message: ok = %v, err = %v", ok, err)
+	}
+
+	var count int
+	if err = conn.QueryRow("SELECT COUNT(*) FROM message_search_tokens WHERE message_id = $1", msg.ID).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("deleted message has %d search tokens", count)
+	}
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"playmates/components/playmates/models"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// Пространство advisory-блокировок журналов событий, второй ключ - ID пользователя
+const chatEventsLockSpace = 1036
+
+// appendEvents пишет одно и то же событие в журналы нескольких пользователей.
+//
+// ID из BIGSERIAL выдаются до коммита, и без блокировки транзакция с меньшим ID могла бы
+// закоммититься после той, чей больший ID клиент уже прочитал, - GetEvents пропустил бы
+// её событие навсегда. Поэтому журналы пользователей блокируются до конца транзакции:
+// в журнале одного пользователя ID идут в порядке коммитов.
+func appendEvents(tx *sql.Tx, userIDs []int, eventType string, messageID *int, data []byte) (map[int]int64, error) {
+	if err := lockEventLogs(tx, userIDs); err != nil {
+		return nil, err
+	}
+
+	cursors := make(map[int]int64, len(userIDs))
+
+	for _, userID := range userIDs {
+		if _, exists := cursors[userID]; exists {
+			continue
+		}
+
+		var cursor int64
+		err := tx.QueryRow(`
+            INSERT INTO chat_events (user_id, type, message_id, data)
+            VALUES ($1, $2, $3, $4)
+            RETURNING id
+        `, userID, eventType, messageID, data).Scan(&cursor)
+		if err != nil {
+			return nil, fmt.Errorf("error while inserting chat event: %w", err)
+		}
+
+		cursors[userID] = cursor
+	}
+
+	return cursors, nil
+}
+
+// lockEventLogs берёт блокировки журналов в порядке возрастания ID, чтобы транзакции
+// с пересекающимися получателями не ждали друг друга по кругу.
+func lockEventLogs(tx *sql.Tx, userIDs []int) error {
+	ids := make([]int64, 0, len(userIDs))
+	seen := make(map[int]struct{}, len(userIDs))
+	for _, id := range userIDs {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, int64(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1, id) FROM unnest($2::int[]) AS id", chatEventsLockSpace, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("error while locking event logs: %w", err)
+	}
+
+	return nil
+}
+
+// GetEvents возвращает события после since. Пропусков нет, потому что appendEvents
+// выдаёт ID журнала пользователя в порядке коммитов.
+func (r *Repository) GetEvents(userID int, since int64, limit int) ([]models.ChatEventDB, error) {
+	rows, err := r.db.Query(`
+        SELECT id, user_id, type, message_id, data, created_at
+        FROM chat_events
+        WHERE user_id = $1 AND id > $2
+        ORDER BY id ASC
+        LIMIT $3
+    `, userID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting chat events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.ChatEventDB{}
+	for rows.Next() {
+		var event models.ChatEventDB
+		err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.MessageID, &event.Data, &event.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// CursorForMessage переводит ID последнего известного клиенту сообщения в курсор журнала.
+func (r *Repository) CursorForMessage(userID, messageID int) (int64, error) {
+	var cursor int64
+	err := r.db.QueryRow(`
+        SELECT COALESCE(MAX(id), 0)
+        FROM chat_events
+        WHERE user_id = $1 AND type = $2 AND message_id <= $3
+    `, userID, models.EventMessageNew, messageID).Scan(&cursor)
+	if err != nil {
+		return 0, fmt.Errorf("error while getting cursor for message: %w", err)
+	}
+
+	return cursor, nil
+}
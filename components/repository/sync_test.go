@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"playmates/components/playmates/models"
+	"testing"
+	"time"
+)
+
+func TestGetEventsOrder(t *testing.T) {
+	r, conn := testRepository(t)
+	alice, bob := insertTestUser(t, conn, "alice"), insertTestUser(t, conn, "bob")
+
+	var sent []int
+	for i := 0; i < 3; i++ {
+		sent = append(sent, postTestMessage(t, r, alice, bob, "hi").ID)
+	}
+
+	events, err := r.GetEvents(bob, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != len(sent) {
+		t.Fatalf("got %d events, want %d", len(events), len(sent))
+	}
+	for i, event := range events {
+		if event.Type != models.EventMessageNew || event.MessageID == nil || *event.MessageID != sent[i] {
+			t.Fatalf("event %d = %+v, want message.new for %d", i, event, sent[i])
+		}
+		if i > 0 && event.ID <= events[i-1].ID {
+			t.Fatalf("event ids are not ascending: %d after %d", event.ID, events[i-1].ID)
+		}
+	}
+
+	// Со следующей страницы - ровно то, что после курсора
+	page, err := r.GetEvents(bob, events[0].ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].ID != events[1].ID {
+		t.Fatalf("page after %d = %+v, want event %d", events[0].ID, page, events[1].ID)
+	}
+
+	cursor, err := r.CursorForMessage(bob, sent[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != events[1].ID {
+		t.Fatalf("cursor for message %d = %d, want %d", sent[1], cursor, events[1].ID)
+	}
+}
+
+// Транзакция, взявшая ID журнала позже, не может закоммититься раньше: иначе клиент,
+// прочитавший больший ID, пропустил бы меньший навсегда.
+func TestAppendEventsCommitOrder(t *testing.T) {
+	_, conn := testRepository(t)
+	userID := insertTestUser(t, conn, "journal")
+
+	first, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+
+	firstCursors, err := appendEvents(first, []int{userID}, models.EventReceipt, nil, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		cursor int64
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		tx, err := conn.Begin()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer tx.Rollback()
+
+		cursors, err := appendEvents(tx, []int{userID}, models.EventReceipt, nil, []byte("{}"))
+		if err == nil {
+			err = tx.Commit()
+		}
+		done <- result{cursor: cursors[userID], err: err}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("second append did not wait for the first transaction")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case second := <-done:
+		if second.err != nil {
+			t.Fatal(second.err)
+		}
+		if second.cursor <= firstCursors[userID] {
+			t.Fatalf("second event id %d is not after %d", second.cursor, firstCursors[userID])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second append is still blocked")
+	}
+}
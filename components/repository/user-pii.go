@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"playmates/components/blindindex"
+
+	"github.com/lib/pq"
+)
+
+// PlaintextUser - пользователь, у которого email или about_me ещё лежат открытыми.
+type PlaintextUser struct {
+	ID      int
+	Email   sql.NullString
+	AboutMe sql.NullString
+}
+
+// GetPlaintextUsers возвращает пользователей с ID больше afterID, у которых есть открытый email или about_me.
+func (r *Repository) GetPlaintextUsers(afterID, limit int) ([]PlaintextUser, error) {
+	rows, err := r.db.Query(`
+        SELECT id, email, about_me
+        FROM users
+        WHERE id > $1 AND (email IS NOT NULL OR about_me IS NOT NULL)
+        ORDER BY id
+        LIMIT $2
+    `, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting plaintext users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []PlaintextUser
+	for rows.Next() {
+		var user PlaintextUser
+		if err = rows.Scan(&user.ID, &user.Email, &user.AboutMe); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// SealUserPII заменяет открытые email и about_me зашифрованными, только если они не
+// изменились с момента чтения. Возвращает false, если строку успели изменить.
+// Без emailSealed email не трогается, about_me переносится, только если он был открытым.
+func (r *Repository) SealUserPII(user PlaintextUser, emailHash blindindex.EmailHashes, emailSealed, aboutMeSealed []byte, tokens blindindex.Tokens) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+        UPDATE users SET
+            email_hash = COALESCE($2, email_hash),
+            email_hash_key_id = CASE WHEN $2::bytea IS NULL THEN email_hash_key_id ELSE $8 END,
+            email_sealed = COALESCE($3, email_sealed),
+            email = CASE WHEN $3::bytea IS NULL THEN email END,
+            about_me_sealed = CASE WHEN $6 THEN $4 ELSE about_me_sealed END,
+            about_me = CASE WHEN $6 THEN NULL ELSE about_me END
+        WHERE id = $1 AND email IS NOT DISTINCT FROM $5 AND about_me IS NOT DISTINCT FROM $7
+    `, user.ID, emailHash.Active, emailSealed, aboutMeSealed, user.Email, user.AboutMe.Valid, user.AboutMe, emailHash.KeyID)
+	if err != nil {
+		return false, fmt.Errorf("error while sealing user: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if user.AboutMe.Valid {
+		if err = setUserSearchTokens(tx, user.ID, tokens); err != nil {
+			return false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("error while committing user: %w", err)
+	}
+
+	return true, nil
+}
+
+// UnindexedUser - пользователь, чей about_me проиндексирован не текущим ключом индекса.
+type UnindexedUser struct {
+	ID            int
+	AboutMeSealed []byte
+}
+
+// GetUnindexedUsers возвращает пользователей с ID больше afterID, у которых есть зашифрованный
+// about_me, проиндексированный не ключом keyID.
+func (r *Repository) GetUnindexedUsers(afterID int, keyID uint16, limit int) ([]UnindexedUser, error) {
+	rows, err := r.db.Query(`
+        SELECT id, about_me_sealed
+        FROM users
+        WHERE id > $1 AND about_me_sealed IS NOT NULL AND COALESCE(search_key_id, 1) <> $2
+        ORDER BY id
+        LIMIT $3
+    `, afterID, keyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting unindexed users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []UnindexedUser
+	for rows.Next() {
+		var user UnindexedUser
+		if err = rows.Scan(&user.ID, &user.AboutMeSealed); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// ReindexUser заменяет слепой индекс about_me, только если about_me не изменился с момента
+// чтения. Возвращает false, если строку успели изменить.
+func (r *Repository) ReindexUser(user UnindexedUser, tokens blindindex.Tokens) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow("SELECT id FROM users WHERE id = $1 AND about_me_sealed = $2 FOR UPDATE", user.ID, user.AboutMeSealed).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error while locking user: %w", err)
+	}
+
+	if err = setUserSearchTokens(tx, user.ID, tokens); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("error while committing user: %w", err)
+	}
+
+	return true, nil
+}
+
+// StaleEmail - пользователь, чей email захэширован не активным ключом email_hmac.
+type StaleEmail struct {
+	ID          int
+	EmailSealed []byte
+}
+
+// GetStaleEmails возвращает пользователей с ID больше afterID, у которых зашифрованный email
+// захэширован не ключом keyID.
+func (r *Repository) GetStaleEmails(afterID int, keyID uint16, limit int) ([]StaleEmail, error) {
+	rows, err := r.db.Query(`
+        SELECT id, email_sealed
+        FROM users
+        WHERE id > $1 AND email_sealed IS NOT NULL AND email_hash_key_id IS DISTINCT FROM $2
+        ORDER BY id
+        LIMIT $3
+    `, afterID, keyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting stale emails: %w", err)
+	}
+	defer rows.Close()
+
+	var users []StaleEmail
+	for rows.Next() {
+		var user StaleEmail
+		if err = rows.Scan(&user.ID, &user.EmailSealed); err != nil {
+			return nil, fmt.Errorf("error while scanning rows: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// RehashEmail заменяет HMAC email, только если email не изменился с момента чтения.
+// Возвращает false, если строку успели изменить.
+func (r *Repository) RehashEmail(user StaleEmail, emailHash []byte, keyID uint16) (bool, error) {
+	res, err := r.db.Exec(
+		"UPDATE users SET email_hash = $3, email_hash_key_id = $4 WHERE id = $1 AND email_sealed = $2",
+		user.ID, user.EmailSealed, emailHash, keyID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error while rehashing email: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func setUserSearchTokens(tx *sql.Tx, userID int, tokens blindindex.Tokens) error {
+	if _, err := tx.Exec("DELETE FROM user_search_tokens WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("error while deleting user search tokens: %w", err)
+	}
+
+	if len(tokens.Values) > 0 {
+		_, err := tx.Exec(`
+            INSERT INTO user_search_tokens (token, user_id)
+            SELECT t, $2 FROM unnest($1::bytea[]) AS t
+            ON CONFLICT DO NOTHING
+        `, pq.ByteaArray(tokens.Values), userID)
+		if err != nil {
+			return fmt.Errorf("error while inserting user search tokens: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE users SET search_key_id = $2 WHERE id = $1", userID, tokens.KeyID); err != nil {
+		return fmt.Errorf("error while updating user search key: %w", err)
+	}
+
+	return nil
+}
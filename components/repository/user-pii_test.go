@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"database/sql"
+	"playmates/components/blindindex"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// insertPlaintextUser создаёт пользователя так, как он выглядел до шифрования профилей.
+func insertPlaintextUser(t *testing.T, conn *sql.DB, email, aboutMe string) int {
+	t.Helper()
+
+	var id int
+	err := conn.QueryRow(`
+        INSERT INTO users (username, email, about_me, password_hash, age, gender, games)
+        VALUES ($1, $2, $3, 'hash', 0, '', '{}')
+        RETURNING id
+    `, email, email, aboutMe).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteUsersOnCleanup(t, conn, id)
+
+	return id
+}
+
+func registerUser(t *testing.T, r *Repository, conn *sql.DB, hashes blindindex.EmailHashes, email string) (int, error) {
+	t.Helper()
+
+	id, err := r.NextUserID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteUsersOnCleanup(t, conn, id)
+
+	return id, r.Register(id, email, hashes, []byte("sealed "+email), email, "hash")
+}
+
+func newTestHashers(t *testing.T) (*blindindex.EmailHasher, *blindindex.EmailHasher) {
+	t.Helper()
+
+	key1, key2 := []byte(strings.Repeat("1", 32)), []byte(strings.Repeat("2", 32))
+	old, err := blindindex.NewEmailHasher(map[uint16][]byte{1: key1}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := blindindex.NewEmailHasher(map[uint16][]byte{1: key1, 2: key2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return old, rotated
+}
+
+func assertLogin(t *testing.T, r *Repository, hasher *blindindex.EmailHasher, email string, wantID int) {
+	t.Helper()
+
+	email = blindindex.NormalizeEmail(email)
+	user, err := r.Login(hasher.Hashes(email).All, email)
+	if err != nil {
+		t.Errorf("Login(%s) = %v, want user %d", email, err, wantID)
+		return
+	}
+	if user.ID != wantID {
+		t.Errorf("Login(%s) = user %d, want %d", email, user.ID, wantID)
+	}
+}
+
+// Во время backfill-users и ротации email_hmac в базе одновременно лежат открытые email,
+// хэши старым и новым ключом. Логин и проверка уникальности должны видеть их все.
+func TestRegisterLoginDuringBackfill(t *testing.T) {
+	r, conn := testRepository(t)
+	old, rotated := newTestHashers(t)
+
+	// Ещё не перенесён: email открытый, в исходном регистре
+	legacyEmail := "Legacy-" + testEmail("user")
+	legacyID := insertPlaintextUser(t, conn, legacyEmail, "")
+
+	// Перенесён до ротации: хэш старым ключом
+	oldEmail := blindindex.NormalizeEmail(testEmail("old"))
+	oldID, err := registerUser(t, r, conn, old.Hashes(oldEmail), oldEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertLogin(t, r, rotated, legacyEmail, legacyID)
+	assertLogin(t, r, rotated, oldEmail, oldID)
+
+	for _, email := range []string{legacyEmail, oldEmail} {
+		email = blindindex.NormalizeEmail(email)
+		if _, err := registerUser(t, r, conn, rotated.Hashes(email), email); err == nil {
+			t.Errorf("Register(%s) with taken email succeeded", email)
+		}
+	}
+
+	newEmail := blindindex.NormalizeEmail(testEmail("new"))
+	newID, err := registerUser(t, r, conn, rotated.Hashes(newEmail), newEmail)
+	if err != nil {
+		t.Fatalf("Register() = %v", err)
+	}
+	assertLogin(t, r, rotated, newEmail, newID)
+
+	// backfill-users переносит открытый email и перехэширует старый
+	legacy := PlaintextUser{ID: legacyID, Email: sql.NullString{String: legacyEmail, Valid: true}}
+	normalized := blindindex.NormalizeEmail(legacyEmail)
+	ok, err := r.SealUserPII(legacy, rotated.Hashes(normalized), []byte("sealed"), nil, blindindex.Tokens{KeyID: 1})
+	if err != nil || !ok {
+		t.Fatalf("SealUserPII() = %v, %v", ok, err)
+	}
+	assertLogin(t, r, rotated, legacyEmail, legacyID)
+
+	stale := findStaleEmail(t, r, rotated.KeyID(), oldID)
+	if stale == nil {
+		t.Fatal("GetStaleEmails() did not return the user hashed with the old key")
+	}
+	if ok, err = r.RehashEmail(*stale, rotated.Hash(oldEmail), rotated.KeyID()); err != nil || !ok {
+		t.Fatalf("RehashEmail() = %v, %v", ok, err)
+	}
+	if findStaleEmail(t, r, rotated.KeyID(), oldID) != nil {
+		t.Error("GetStaleEmails() returned a rehashed user")
+	}
+
+	// После перехэширования старый ключ можно убрать
+	current, err := blindindex.NewEmailHasher(map[uint16][]byte{2: []byte(strings.Repeat("2", 32))}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertLogin(t, r, current, oldEmail, oldID)
+	assertLogin(t, r, current, legacyEmail, legacyID)
+}
+
+func findStaleEmail(t *testing.T, r *Repository, keyID uint16, userID int) *StaleEmail {
+	t.Helper()
+
+	users, err := r.GetStaleEmails(userID-1, keyID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) == 0 || users[0].ID != userID {
+		return nil
+	}
+	return &users[0]
+}
+
+// Профиль, изменённый между чтением и записью backfill-users, не перезаписывается.
+func TestSealUserPIIRace(t *testing.T) {
+	r, conn := testRepository(t)
+	_, hasher := newTestHashers(t)
+
+	read := func(id int, email, aboutMe string) PlaintextUser {
+		return PlaintextUser{
+			ID:      id,
+			Email:   sql.NullString{String: email, Valid: true},
+			AboutMe: sql.NullString{String: aboutMe, Valid: true},
+		}
+	}
+	seal := func(user PlaintextUser) (bool, error) {
+		email := blindindex.NormalizeEmail(user.Email.String)
+		return r.SealUserPII(user, hasher.Hashes(email), []byte("sealed email"), []byte("sealed about"), blindindex.Tokens{KeyID: 1})
+	}
+
+	tests := []struct {
+		name   string
+		change string
+	}{
+		{"about_me updated", "UPDATE users SET about_me = 'changed' WHERE id = $1"},
+		{"about_me cleared", "UPDATE users SET about_me = NULL WHERE id = $1"},
+		{"email case changed", "UPDATE users SET email = upper(email) WHERE id = $1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := testEmail("race")
+			id := insertPlaintextUser(t, conn, email, "about me")
+			user := read(id, email, "about me")
+
+			if _, err := conn.Exec(tt.change, id); err != nil {
+				t.Fatal(err)
+			}
+
+			ok, err := seal(user)
+			if err != nil || ok {
+				t.Fatalf("SealUserPII() after concurrent change = %v, %v, want false", ok, err)
+			}
+
+			var emailSealed []byte
+			var plainEmail sql.NullString
+			if err = conn.QueryRow("SELECT email, email_sealed FROM users WHERE id = $1", id).Scan(&plainEmail, &emailSealed); err != nil {
+				t.Fatal(err)
+			}
+			if !plainEmail.Valid || emailSealed != nil {
+				t.Error("raced row was sealed")
+			}
+		})
+	}
+
+	// Несколько проходов с одним и тем же чтением: записывает только первый
+	email := testEmail("parallel")
+	id := insertPlaintextUser(t, conn, email, "about me")
+	user := read(id, email, "about me")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sealed := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := seal(user)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				sealed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sealed != 1 {
+		t.Errorf("SealUserPII() succeeded %d times, want 1", sealed)
+	}
+
+	var keyID sql.NullInt64
+	var plainEmail, aboutMe sql.NullString
+	err := conn.QueryRow("SELECT email, about_me, email_hash_key_id FROM users WHERE id = $1", id).Scan(&plainEmail, &aboutMe, &keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plainEmail.Valid || aboutMe.Valid || keyID.Int64 != int64(hasher.KeyID()) {
+		t.Errorf("sealed row: email = %v, about_me = %v, email_hash_key_id = %v", plainEmail, aboutMe, keyID)
+	}
+}
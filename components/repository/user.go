@@ -3,27 +3,42 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"playmates/components/blindindex"
 	"playmates/components/playmates/models"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 )
 
-func (r *Repository) GetUser(id int) (models.User, error) {
+const userColumns = "id, username, email, age, gender, games, about_me, languages, availability, last_active_at, last_seen_at, hide_presence, email_sealed, about_me_sealed"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner, extra ...interface{}) (models.User, error) {
 	var user models.User
+	var email sql.NullString
 	var age sql.NullInt64
 	var gender sql.NullString
 	var aboutMe sql.NullString
+	var lastActiveAt sql.NullTime
 
-	err := r.db.QueryRow("SELECT id, username, email, age, gender, games, about_me FROM users WHERE id = $1", id).Scan(
-		&user.ID, &user.Username, &user.Email, &age, &gender, pq.Array(&user.Games), &aboutMe,
-	)
+	dest := []interface{}{
+		&user.ID, &user.Username, &email, &age, &gender, pq.Array(&user.Games), &aboutMe,
+		pq.Array(&user.Languages), pq.Array(&user.Availability), &lastActiveAt, &user.LastSeenAt, &user.HidePresence,
+		&user.EmailSealed, &user.AboutMeSealed,
+	}
 
+	err := row.Scan(append(dest, extra...)...)
 	if err != nil {
-		fmt.Println(err)
-		return models.User{}, fmt.Errorf("cannot get user: %w", err)
+		return models.User{}, err
 	}
 
+	if email.Valid {
+		user.Email = email.String
+	}
 	if aboutMe.Valid {
 		user.AboutMe = aboutMe.String
 	}
@@ -33,66 +48,211 @@ func (r *Repository) GetUser(id int) (models.User, error) {
 	if age.Valid {
 		user.Age = int(age.Int64)
 	}
+	if lastActiveAt.Valid {
+		user.LastActiveAt = lastActiveAt.Time
+	}
 
 	return user, nil
 }
 
-func (r *Repository) SetUser(user models.User) error {
+func (r *Repository) GetUser(id int) (models.User, error) {
+	user, err := scanUser(r.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = $1", id))
+	if err != nil {
+		fmt.Println(err)
+		return models.User{}, fmt.Errorf("cannot get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// SetUser обновляет профиль. about_me пишется только зашифрованным (AboutMeSealed),
+// tokens заменяют слепой индекс about_me.
+func (r *Repository) SetUser(user models.User, tokens blindindex.Tokens) error {
 	for i := range user.Games {
 		user.Games[i] = strings.ToLower(user.Games[i])
 	}
+	for i := range user.Languages {
+		user.Languages[i] = strings.ToLower(user.Languages[i])
+	}
+	for i := range user.Availability {
+		user.Availability[i] = strings.ToLower(user.Availability[i])
+	}
 
-	_, err := r.db.Exec(
-		`UPDATE users SET age = $1, gender = $2, games = $3, about_me = $4 WHERE id = $5`,
-		user.Age, user.Gender, pq.Array(user.Games), user.AboutMe, user.ID,
-	)
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error while starting transaction: %w", err)
+	}
+	defer tx.Rollback()
 
+	_, err = tx.Exec(
+		`UPDATE users SET age = $1, gender = $2, games = $3, about_me = NULL, about_me_sealed = $4, languages = $5, availability = $6, hide_presence = $7, updated_at = NOW() WHERE id = $8`,
+		user.Age, user.Gender, pq.Array(user.Games), user.AboutMeSealed, pq.Array(user.Languages), pq.Array(user.Availability), user.HidePresence, user.ID,
+	)
 	if err != nil {
-		fmt.Println(user)
+		return fmt.Errorf("error while updating user: %w", err)
+	}
+
+	if err = setUserSearchTokens(tx, user.ID, tokens); err != nil {
 		return err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error while committing user: %w", err)
+	}
+
 	return nil
 }
 
-func (r *Repository) SearchUsers(minAge, maxAge, offset int, games []string, gender string) ([]models.User, int, error) {
-	query := "SELECT id, username, email, age, gender, games, about_me FROM users WHERE 1=1"
+func (r *Repository) TouchUser(id int) error {
+	_, err := r.db.Exec("UPDATE users SET last_active_at = NOW() WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to update last activity: %w", err)
+	}
+
+	return nil
+}
+
+// buildSearchFilter собирает WHERE для поиска. Используется и в SearchUsers, и в CountSearch,
+// чтобы выборка и подсчёт не расходились.
+func buildSearchFilter(params models.SearchParams) (string, []interface{}, error) {
+	query := " WHERE 1=1"
 	args := []interface{}{}
 
-	if minAge > 0 && maxAge > 0 && minAge > maxAge {
-		return nil, -1, fmt.Errorf("minimum age can't be greater than maximum age")
+	if params.MinAge > 0 && params.MaxAge > 0 && params.MinAge > params.MaxAge {
+		return "", nil, fmt.Errorf("minimum age can't be greater than maximum age")
 	}
 
-	if minAge > 0 {
+	if params.MinAge > 0 {
 		query += fmt.Sprintf(" AND age >= $%d", len(args)+1)
-		args = append(args, minAge)
+		args = append(args, params.MinAge)
 	}
 
-	if maxAge > 0 {
+	if params.MaxAge > 0 {
 		query += fmt.Sprintf(" AND age <= $%d", len(args)+1)
-		args = append(args, maxAge)
+		args = append(args, params.MaxAge)
 	}
 
-	if gender != "" {
+	if params.Gender != "" {
 		query += fmt.Sprintf(" AND gender = $%d", len(args)+1)
-		args = append(args, gender)
+		args = append(args, params.Gender)
 	}
 
-	if len(games) > 0 {
-		for _, game := range games {
-			query += fmt.Sprintf(" AND games @> $%d", len(args)+1)
-			args = append(args, pq.Array([]string{game}))
+	// Повторы в списке не дают набрать at_least:N, поэтому игры сравниваются без них
+	if games := models.UniqueGames(params.Games); len(games) > 0 {
+		mode, minMatches, err := params.MatchMode()
+		if err != nil {
+			return "", nil, err
+		}
+
+		gamesArg := len(args) + 1
+		args = append(args, pq.Array(games))
+
+		switch mode {
+		case models.MatchAll:
+			query += fmt.Sprintf(" AND games @> $%d", gamesArg)
+		case models.MatchAny:
+			query += fmt.Sprintf(" AND games && $%d", gamesArg)
+		case models.MatchAtLeast:
+			// && отсекает строки по GIN-индексу, подзапрос считает точное число совпадений
+			query += fmt.Sprintf(
+				" AND games && $%d AND (SELECT COUNT(DISTINCT g) FROM unnest(games) AS g WHERE g = ANY($%d)) >= $%d",
+				gamesArg, gamesArg, len(args)+1,
+			)
+			args = append(args, minMatches)
 		}
 	}
 
-	total, err := r.CountSearch(minAge, maxAge, games, gender)
+	if excluded := models.UniqueGames(params.ExcludeGames); len(excluded) > 0 {
+		query += fmt.Sprintf(" AND NOT (COALESCE(games, '{}') && $%d)", len(args)+1)
+		args = append(args, pq.Array(excluded))
+	}
+
+	if params.Query != "" {
+		// Зашифрованный about_me ищется по слепому индексу: у профиля должны быть все слова запроса.
+		// Токены - целые слова, поэтому префиксный поиск по about_me ("прог" находит
+		// "программист") остался только у ещё не перенесённых строк: после backfill-users
+		// about_me = NULL и search_vector видит только username. Это осознанная потеря -
+		// префиксы в слепом индексе раскрывали бы about_me по частям.
+		tokenMatch := ""
+		if len(params.QueryTokens) > 0 {
+			tokenMatch = fmt.Sprintf(
+				" OR id IN (SELECT user_id FROM user_search_tokens WHERE token = ANY($%d) GROUP BY user_id HAVING COUNT(*) = $%d)",
+				len(args)+4, len(args)+5,
+			)
+		}
+		query += fmt.Sprintf(
+			" AND (search_vector @@ to_tsquery('simple', $%d) OR username ILIKE $%d OR username %% $%d%s)",
+			len(args)+1, len(args)+2, len(args)+3, tokenMatch,
+		)
+		args = append(args, params.PrefixTsQuery(), escapeLike(params.Query)+"%", params.Query)
+		if len(params.QueryTokens) > 0 {
+			args = append(args, pq.ByteaArray(params.QueryTokens), len(params.QueryTokens))
+		}
+	}
+
+	if params.Online {
+		ids := make([]int64, len(params.OnlineIDs))
+		for i, id := range params.OnlineIDs {
+			ids[i] = int64(id)
+		}
+		query += fmt.Sprintf(" AND id = ANY($%d) AND NOT hide_presence", len(args)+1)
+		args = append(args, pq.Array(ids))
+	}
+
+	if !params.UpdatedSince.IsZero() {
+		query += fmt.Sprintf(" AND updated_at > $%d", len(args)+1)
+		args = append(args, params.UpdatedSince)
+	}
+
+	if len(params.ExcludeIDs) > 0 {
+		ids := make([]int64, len(params.ExcludeIDs))
+		for i, id := range params.ExcludeIDs {
+			ids[i] = int64(id)
+		}
+		query += fmt.Sprintf(" AND id <> ALL($%d)", len(args)+1)
+		args = append(args, pq.Array(ids))
+	}
+
+	return query, args, nil
+}
+
+func (r *Repository) SetLastSeen(id int, lastSeen time.Time) error {
+	_, err := r.db.Exec("UPDATE users SET last_seen_at = $1 WHERE id = $2", lastSeen, id)
+	if err != nil {
+		return fmt.Errorf("failed to update last seen: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) SearchUsers(params models.SearchParams) ([]models.UserSearchResult, int, error) {
+	filter, args, err := buildSearchFilter(params)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	total, err := r.CountSearch(params)
 	if err != nil {
 		return nil, -1, fmt.Errorf("failed to count users: %w", err)
 	}
 
-	query += " ORDER BY id DESC"
+	var query string
+	if params.Query != "" {
+		tsArg, qArg := len(args)+1, len(args)+2
+		args = append(args, params.PrefixTsQuery(), params.Query)
+
+		query = "SELECT " + userColumns + fmt.Sprintf(`,
+            ts_headline('simple', coalesce(about_me, ''), to_tsquery('simple', $%d),
+                'StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=5, MaxFragments=2') AS snippet,
+            ts_rank(search_vector, to_tsquery('simple', $%d)) + similarity(username, $%d) AS rank
+            FROM users`, tsArg, tsArg, qArg) + filter
+		query += " ORDER BY rank DESC, id DESC"
+	} else {
+		query = "SELECT " + userColumns + ", '' AS snippet, 0::real AS rank FROM users" + filter
+		query += " ORDER BY id DESC"
+	}
 	query += fmt.Sprintf(" LIMIT 20 OFFSET $%d", len(args)+1)
-	args = append(args, offset)
+	args = append(args, params.Offset)
 
 	rows, err := r.db.Query(query, args...)
 	if err != nil {
@@ -101,71 +261,70 @@ func (r *Repository) SearchUsers(minAge, maxAge, offset int, games []string, gen
 	}
 	defer rows.Close()
 
-	users := []models.User{}
+	users := []models.UserSearchResult{}
 	for rows.Next() {
-		var user models.User
-		var age sql.NullInt64
-		var gen sql.NullString
-		var aboutMe sql.NullString
-
-		err = rows.Scan(
-			&user.ID, &user.Username, &user.Email, &age, &gen, pq.Array(&user.Games), &aboutMe,
-		)
+		var result models.UserSearchResult
+		result.User, err = scanUser(rows, &result.Snippet, &result.Rank)
 		if err != nil {
 			fmt.Println(fmt.Sprintf("failed to scan user row: %v", err))
 			return nil, -1, fmt.Errorf("failed to scan user row: %w", err)
 		}
 
-		if aboutMe.Valid {
-			user.AboutMe = aboutMe.String
-		}
-		if gen.Valid {
-			user.Gender = gen.String
-		}
-		if age.Valid {
-			user.Age = int(age.Int64)
-		}
-
-		users = append(users, user)
+		users = append(users, result)
 	}
 
 	return users, total, nil
 }
 
-func (r *Repository) CountSearch(minAge, maxAge int, games []string, gender string) (int, error) {
-	query := "SELECT COUNT(*) FROM users WHERE 1=1"
-	args := []interface{}{}
-
-	if minAge > 0 && maxAge > 0 && minAge > maxAge {
-		return -1, fmt.Errorf("minimum age can't be greater than maximum age")
-	}
-
-	if minAge > 0 {
-		query += fmt.Sprintf(" AND age >= $%d", len(args)+1)
-		args = append(args, minAge)
+func (r *Repository) CountSearch(params models.SearchParams) (int, error) {
+	filter, args, err := buildSearchFilter(params)
+	if err != nil {
+		return -1, err
 	}
 
-	if maxAge > 0 {
-		query += fmt.Sprintf(" AND age <= $%d", len(args)+1)
-		args = append(args, maxAge)
+	var total int
+	err = r.db.QueryRow("SELECT COUNT(*) FROM users"+filter, args...).Scan(&total)
+	if err != nil {
+		return -1, fmt.Errorf("failed to execute query count: %w", err)
 	}
+	return total, nil
+}
 
-	if gender != "" {
-		query += fmt.Sprintf(" AND gender = $%d", len(args)+1)
-		args = append(args, gender)
+func (r *Repository) GetRecommendationCandidates(userID, limit int) ([]models.User, error) {
+	query := `
+        SELECT ` + userColumns + `
+        FROM users u
+        WHERE u.id <> $1
+          AND NOT EXISTS (
+              SELECT 1 FROM messages m
+              WHERE (m.sender_id = $1 AND m.receiver_id = u.id) OR (m.sender_id = u.id AND m.receiver_id = $1)
+          )
+          AND NOT EXISTS (
+              SELECT 1 FROM blocks b
+              WHERE (b.blocker_id = $1 AND b.blocked_id = u.id) OR (b.blocker_id = u.id AND b.blocked_id = $1)
+          )
+        ORDER BY u.games && (SELECT games FROM users WHERE id = $1) DESC, u.last_active_at DESC NULLS LAST
+        LIMIT $2
+    `
+
+	rows, err := r.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recommendation candidates: %w", err)
 	}
+	defer rows.Close()
 
-	if len(games) > 0 {
-		for _, game := range games {
-			query += fmt.Sprintf(" AND games @> $%d", len(args)+1)
-			args = append(args, pq.Array([]string{game}))
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
 		}
+		users = append(users, user)
 	}
 
-	var total int
-	err := r.db.QueryRow(query, args...).Scan(&total)
-	if err != nil {
-		return -1, fmt.Errorf("failed to execute query count: %w", err)
-	}
-	return total, nil
+	return users, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
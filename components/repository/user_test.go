@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"playmates/components/playmates/models"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestBuildSearchFilterMatchModes(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    models.SearchParams
+		wantQuery string
+		wantArgs  int
+	}{
+		{"all", models.SearchParams{Games: []string{"Dota", "cs"}}, " AND games @> $1", 1},
+		{"any", models.SearchParams{Games: []string{"dota", "cs"}, Match: models.MatchAny}, " AND games && $1", 1},
+		{
+			"at least",
+			models.SearchParams{Games: []string{"dota", "cs", "chess"}, Match: "at_least:2"},
+			" AND games && $1 AND (SELECT COUNT(DISTINCT g) FROM unnest(games) AS g WHERE g = ANY($1)) >= $2",
+			2,
+		},
+		{"exclude games", models.SearchParams{ExcludeGames: []string{"chess"}}, " AND NOT (COALESCE(games, '{}') && $1)", 1},
+		{"exclude ids", models.SearchParams{ExcludeIDs: []int{3, 5}}, " AND id <> ALL($1)", 1},
+		// Одна проверка массивом вместо условия на каждую игру
+		{"many games", models.SearchParams{Games: []string{"a", "b", "c", "d"}, Match: models.MatchAny}, " AND games && $1", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := buildSearchFilter(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != " WHERE 1=1"+tt.wantQuery {
+				t.Errorf("query = %q, want %q", query, " WHERE 1=1"+tt.wantQuery)
+			}
+			if len(args) != tt.wantArgs {
+				t.Errorf("args = %v, want %d", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestBuildSearchFilterNormalizesGames(t *testing.T) {
+	_, args, err := buildSearchFilter(models.SearchParams{Games: []string{"Dota", " dota", "CS"}, Match: "at_least:5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	games, ok := args[0].(driver.Valuer)
+	if !ok {
+		t.Fatalf("games arg = %T", args[0])
+	}
+	value, _ := games.Value()
+	if value != `{"dota","cs"}` {
+		t.Errorf("games = %v, want {\"dota\",\"cs\"}", value)
+	}
+	// N не больше числа разных игр
+	if args[1] != 2 {
+		t.Errorf("at_least = %v, want 2", args[1])
+	}
+}
+
+func TestBuildSearchFilterErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params models.SearchParams
+	}{
+		{"age range", models.SearchParams{MinAge: 30, MaxAge: 20}},
+		{"match mode", models.SearchParams{Games: []string{"dota"}, Match: "most"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := buildSearchFilter(tt.params); err == nil {
+				t.Error("buildSearchFilter() succeeded")
+			}
+		})
+	}
+}
+
+func searchIDs(t *testing.T, r *Repository, params models.SearchParams) []int {
+	t.Helper()
+
+	users, total, err := r.SearchUsers(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Подсчёт идёт тем же фильтром, что и выборка
+	count, err := r.CountSearch(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != count || total != len(users) {
+		t.Errorf("SearchUsers() total = %d, CountSearch() = %d, results = %d", total, count, len(users))
+	}
+
+	ids := make([]int, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids
+}
+
+func setGames(t *testing.T, r *Repository, userID int, games ...string) {
+	t.Helper()
+
+	if _, err := r.db.Exec("UPDATE users SET games = $2 WHERE id = $1", userID, pq.Array(games)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchUsersMatchModes(t *testing.T) {
+	r, conn := testRepository(t)
+
+	// Уникальные названия игр отделяют тестовых пользователей от остальных в базе
+	dota, cs, chess := testName("dota"), testName("cs"), testName("chess")
+	both := insertTestUser(t, conn, "both")
+	setGames(t, r, both, dota, cs)
+	onlyDota := insertTestUser(t, conn, "dota")
+	setGames(t, r, onlyDota, dota)
+	all3 := insertTestUser(t, conn, "all3")
+	setGames(t, r, all3, dota, cs, chess)
+
+	tests := []struct {
+		name   string
+		params models.SearchParams
+		want   []int
+	}{
+		{"all", models.SearchParams{Games: []string{dota, cs}}, []int{all3, both}},
+		{"any", models.SearchParams{Games: []string{dota, cs}, Match: models.MatchAny}, []int{all3, onlyDota, both}},
+		{"at least 2", models.SearchParams{Games: []string{dota, cs, chess}, Match: "at_least:2"}, []int{all3, both}},
+		// Повтор игры в запросе не засчитывается вторым совпадением
+		{"at least with repeats", models.SearchParams{Games: []string{dota, strings.ToUpper(dota)}, Match: "at_least:2"}, []int{all3, onlyDota, both}},
+		{"exclude games", models.SearchParams{Games: []string{dota}, ExcludeGames: []string{chess}}, []int{onlyDota, both}},
+		{"exclude ids", models.SearchParams{Games: []string{dota}, ExcludeIDs: []int{both}}, []int{all3, onlyDota}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchIDs(t, r, tt.params)
+			if len(got) != len(tt.want) {
+				t.Fatalf("SearchUsers() = %v, want %v", got, tt.want)
+			}
+			// Без q выдача идёт от новых к старым
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("SearchUsers() = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestBuildSearchFilterQuery(t *testing.T) {
+	query, args, err := buildSearchFilter(models.SearchParams{Query: "mid_carry", QueryTokens: [][]byte{{1}, {2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(query, "search_vector @@ to_tsquery('simple', $1) OR username ILIKE $2 OR username % $3") {
+		t.Errorf("query = %q, want tsquery, prefix and trigram match", query)
+	}
+	if args[0] != "mid:* & carry:*" {
+		t.Errorf("tsquery = %v", args[0])
+	}
+	// _ в LIKE экранируется, чтобы не совпадать с любым символом
+	if args[1] != `mid\_carry%` {
+		t.Errorf("username prefix = %v", args[1])
+	}
+	if args[2] != "mid_carry" || args[4] != 2 {
+		t.Errorf("args = %v", args)
+	}
+}
+
+func setAboutMe(t *testing.T, r *Repository, userID int, aboutMe string) {
+	t.Helper()
+
+	if _, err := r.db.Exec("UPDATE users SET about_me = $2 WHERE id = $1", userID, aboutMe); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setUsername(t *testing.T, r *Repository, userID int, username string) {
+	t.Helper()
+
+	if _, err := r.db.Exec("UPDATE users SET username = $2 WHERE id = $1", userID, username); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchUsersFullText(t *testing.T) {
+	r, conn := testRepository(t)
+	word := testWord()
+
+	byName := insertTestUser(t, conn, "name")
+	setUsername(t, r, byName, word+"sniper")
+	byBio := insertTestUser(t, conn, "bio")
+	setAboutMe(t, r, byBio, "Играю по вечерам, main "+word+"sniper и немного support")
+
+	t.Run("prefix", func(t *testing.T) {
+		got := searchIDs(t, r, models.SearchParams{Query: word + "snip"})
+		if !containsUser(got, byName) || !containsUser(got, byBio) {
+			t.Errorf("SearchUsers() = %v, want %d and %d", got, byName, byBio)
+		}
+	})
+
+	t.Run("typo", func(t *testing.T) {
+		got := searchIDs(t, r, models.SearchParams{Query: word + "snipre"})
+		if !containsUser(got, byName) {
+			t.Errorf("SearchUsers() = %v, want %d by trigram similarity", got, byName)
+		}
+	})
+
+	t.Run("rank and snippet", func(t *testing.T) {
+		users, _, err := r.SearchUsers(models.SearchParams{Query: word + "sniper"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 2 {
+			t.Fatalf("SearchUsers() returned %d users, want 2", len(users))
+		}
+		// Совпадение в имени весит больше, чем в about_me
+		if users[0].ID != byName || users[0].Rank <= users[1].Rank {
+			t.Errorf("ranking = %d (%v), %d (%v), want %d first", users[0].ID, users[0].Rank, users[1].ID, users[1].Rank, byName)
+		}
+		if !strings.Contains(users[1].Snippet, "<mark>"+word+"sniper</mark>") {
+			t.Errorf("snippet = %q, want highlighted match", users[1].Snippet)
+		}
+	})
+}
@@ -1,49 +1,179 @@
 package sealer
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
 )
 
+// Формат шифротекста: magic, версия формата, ID ключа (uint16 BE), nonce, шифротекст GCM.
+// Старые шифротексты без заголовка (nonce, шифротекст) расшифровываются legacy-ключом.
+const (
+	magic         = 0xFE
+	formatVersion = 0x01
+	headerSize    = 4
+)
+
 type Sealer struct {
-	aead cipher.AEAD
+	active uint16
+	keys   map[uint16]cipher.AEAD
+	// legacy расшифровывает шифротексты без заголовка; nil - такие не принимаются
+	legacy cipher.AEAD
 }
 
+// New создаёт Sealer с одним ключом, он же принимает старый формат без заголовка.
 func New(key []byte) (*Sealer, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
+	return NewKeyring(map[uint16][]byte{1: key}, 1, 1)
+}
+
+// NewKeyring создаёт Sealer с несколькими ключами. Шифрует только active, остальные
+// нужны для расшифровки до перешифрования. legacy - ID ключа для шифротекстов без
+// заголовка, 0 - без поддержки старого формата.
+func NewKeyring(keys map[uint16][]byte, active, legacy uint16) (*Sealer, error) {
+	s := &Sealer{active: active, keys: make(map[uint16]cipher.AEAD, len(keys))}
+
+	for id, key := range keys {
+		if id == 0 {
+			return nil, fmt.Errorf("key id 0 is reserved")
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", id, err)
+		}
+		s.keys[id] = aead
+	}
+
+	if _, ok := s.keys[active]; !ok {
+		return nil, fmt.Errorf("active key %d is not in the keyring", active)
+	}
+
+	if legacy != 0 {
+		aead, ok := s.keys[legacy]
+		if !ok {
+			return nil, fmt.Errorf("legacy key %d is not in the keyring", legacy)
+		}
+		s.legacy = aead
+	}
+
+	return s, nil
+}
+
+// CheckKey проверяет, что key подходит как ключ AES-128, AES-192 или AES-256.
+func CheckKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	default:
+		return fmt.Errorf("key must be 16, 24 or 32 bytes, got %d", len(key))
+	}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if err := CheckKey(key); err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Sealer{aead: gcm}, nil
+	return cipher.NewGCM(block)
 }
 
 func (s *Sealer) Encrypt(text []byte) ([]byte, error) {
-	nonce := make([]byte, s.aead.NonceSize())
+	return s.EncryptWithAD(text, nil)
+}
+
+func (s *Sealer) Decrypt(data []byte) ([]byte, error) {
+	return s.DecryptWithAD(data, nil)
+}
+
+// EncryptWithAD шифрует text, привязывая его к ad: расшифровать можно только с тем же ad.
+// Сам ad в шифротекст не попадает, его нужно восстановить из контекста записи.
+func (s *Sealer) EncryptWithAD(text, ad []byte) ([]byte, error) {
+	aead := s.keys[s.active]
+
+	out := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(text)+aead.Overhead())
+	out[0], out[1] = magic, formatVersion
+	binary.BigEndian.PutUint16(out[2:headerSize], s.active)
+
+	nonce := out[headerSize:]
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
 
-	ciphertext := s.aead.Seal(nil, nonce, text, nil)
-	return append(nonce, ciphertext...), nil
+	return aead.Seal(out, nonce, text, ad), nil
 }
 
-func (s *Sealer) Decrypt(data []byte) ([]byte, error) {
-	nonceSize := s.aead.NonceSize()
+func (s *Sealer) DecryptWithAD(data, ad []byte) ([]byte, error) {
+	text, _, err := s.open(data, ad)
+	return text, err
+}
+
+// Reseal перешифровывает data активным ключом. changed = false, если data уже
+// зашифрован активным ключом и трогать его не нужно.
+func (s *Sealer) Reseal(data []byte) ([]byte, bool, error) {
+	return s.ResealWithAD(data, nil, nil)
+}
+
+// ResealWithAD расшифровывает data с oldAD и шифрует активным ключом с newAD.
+// changed = false, если data уже зашифрован активным ключом с тем же ad.
+func (s *Sealer) ResealWithAD(data, oldAD, newAD []byte) ([]byte, bool, error) {
+	text, current, err := s.open(data, oldAD)
+	if err != nil {
+		return nil, false, err
+	}
+	if current && bytes.Equal(oldAD, newAD) {
+		return data, false, nil
+	}
+
+	sealed, err := s.EncryptWithAD(text, newAD)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return sealed, true, nil
+}
+
+// open расшифровывает data; current - зашифрован ли он активным ключом в новом формате.
+func (s *Sealer) open(data, ad []byte) ([]byte, bool, error) {
+	if len(data) >= headerSize && data[0] == magic && data[1] == formatVersion {
+		keyID := binary.BigEndian.Uint16(data[2:headerSize])
+		if aead, ok := s.keys[keyID]; ok {
+			text, err := openAEAD(aead, data[headerSize:], ad)
+			if err == nil {
+				return text, keyID == s.active, nil
+			}
+		}
+		// Заголовок мог оказаться случайным началом nonce старого шифротекста
+	}
+
+	if s.legacy == nil {
+		return nil, false, fmt.Errorf("unknown ciphertext key")
+	}
+
+	text, err := openAEAD(s.legacy, data, ad)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return text, false, nil
+}
+
+func openAEAD(aead cipher.AEAD, data, ad []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
 	if len(data) < nonceSize {
 		return nil, fmt.Errorf("ciphertext too short")
 	}
 
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	text, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	text, err := aead.Open(nil, nonce, ciphertext, ad)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,281 @@
+package sealer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"playmates/components/playmates/models"
+	"strings"
+	"testing"
+)
+
+var (
+	key1 = []byte(strings.Repeat("1", 32))
+	key2 = []byte(strings.Repeat("2", 16))
+)
+
+// legacySeal шифрует в старом формате без заголовка: nonce, шифротекст GCM.
+func legacySeal(t *testing.T, key, text []byte) []byte {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	return aead.Seal(nonce, nonce, text, nil)
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		keys           map[uint16][]byte
+		active, legacy uint16
+		wantErr        string
+	}{
+		{"reserved id", map[uint16][]byte{0: key1}, 0, 0, "reserved"},
+		{"active missing", map[uint16][]byte{1: key1}, 2, 0, "active key 2"},
+		{"legacy missing", map[uint16][]byte{1: key1}, 1, 3, "legacy key 3"},
+		{"bad key size", map[uint16][]byte{1: key1, 2: []byte("short")}, 1, 0, "key 2"},
+		{"empty keyring", map[uint16][]byte{}, 1, 0, "active key 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.keys, tt.active, tt.legacy)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewKeyring() = %v, want error about %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	for _, size := range []int{16, 24, 32} {
+		if err := CheckKey(make([]byte, size)); err != nil {
+			t.Errorf("CheckKey(%d bytes) = %v", size, err)
+		}
+	}
+	for _, size := range []int{0, 15, 31, 64} {
+		if err := CheckKey(make([]byte, size)); err == nil {
+			t.Errorf("CheckKey(%d bytes) = nil, want error", size)
+		}
+	}
+}
+
+func TestEncryptWritesActiveKeyID(t *testing.T) {
+	s, err := NewKeyring(map[uint16][]byte{1: key1, 7: key2}, 7, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := s.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealed[0] != magic || sealed[1] != formatVersion || binary.BigEndian.Uint16(sealed[2:headerSize]) != 7 {
+		t.Errorf("header = %x, want key id 7", sealed[:headerSize])
+	}
+
+	text, err := s.Decrypt(sealed)
+	if err != nil || string(text) != "hello" {
+		t.Errorf("Decrypt() = %q, %v", text, err)
+	}
+}
+
+func TestDecryptAfterRotation(t *testing.T) {
+	old, err := New(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := old.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Новый активный ключ, старый остался для расшифровки
+	rotated, err := NewKeyring(map[uint16][]byte{1: key1, 2: key2}, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, err := rotated.Decrypt(sealed); err != nil || string(text) != "hello" {
+		t.Errorf("Decrypt() with rotated keyring = %q, %v", text, err)
+	}
+
+	// Ключ 1 убран после reencrypt
+	withoutOld, err := NewKeyring(map[uint16][]byte{2: key2}, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := withoutOld.Decrypt(sealed); err == nil {
+		t.Error("Decrypt() without the key succeeded")
+	}
+}
+
+func TestDecryptLegacyFormat(t *testing.T) {
+	sealed := legacySeal(t, key1, []byte("hello"))
+
+	s, err := NewKeyring(map[uint16][]byte{1: key1, 2: key2}, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, err := s.Decrypt(sealed); err != nil || string(text) != "hello" {
+		t.Errorf("Decrypt() of legacy ciphertext = %q, %v", text, err)
+	}
+
+	// legacy = 0 - старый формат не принимается, даже если ключ есть
+	strict, err := NewKeyring(map[uint16][]byte{1: key1, 2: key2}, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strict.Decrypt(sealed); err == nil {
+		t.Error("Decrypt() of legacy ciphertext without legacy key succeeded")
+	}
+}
+
+func TestDecryptLegacyWithHeaderLikeNonce(t *testing.T) {
+	s, err := New(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nonce старого шифротекста случайно начинается как заголовок с ID известного ключа
+	for i := 0; i < 100; i++ {
+		sealed := legacySeal(t, key1, []byte("hello"))
+		sealed[0], sealed[1] = magic, formatVersion
+		binary.BigEndian.PutUint16(sealed[2:headerSize], 1)
+
+		// Шифротекст с подменённым nonce не должен расшифровываться ни одним путём
+		if _, err := s.Decrypt(sealed); err == nil {
+			t.Fatal("Decrypt() of tampered ciphertext succeeded")
+		}
+	}
+
+	// Настоящий шифротекст, у которого nonce совпал с заголовком, открывается legacy-ключом
+	block, _ := aes.NewCipher(key1)
+	aead, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aead.NonceSize())
+	nonce[0], nonce[1] = magic, formatVersion
+	binary.BigEndian.PutUint16(nonce[2:headerSize], 1)
+	sealed := aead.Seal(nonce, nonce, []byte("hello"), nil)
+
+	if text, err := s.Decrypt(sealed); err != nil || string(text) != "hello" {
+		t.Errorf("Decrypt() of legacy ciphertext with header-like nonce = %q, %v", text, err)
+	}
+}
+
+func TestReseal(t *testing.T) {
+	s, err := NewKeyring(map[uint16][]byte{1: key1, 2: key2}, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := s.Encrypt([]byte("current"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, changed, err := s.Reseal(current)
+	if err != nil || changed || !bytes.Equal(out, current) {
+		t.Errorf("Reseal() of active-key ciphertext: changed = %v, err = %v", changed, err)
+	}
+
+	old, err := New(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSealed, err := old.Encrypt([]byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{
+		"old key":       oldSealed,
+		"legacy format": legacySeal(t, key1, []byte("old")),
+	} {
+		out, changed, err := s.Reseal(data)
+		if err != nil || !changed {
+			t.Errorf("Reseal() of %s: changed = %v, err = %v", name, changed, err)
+			continue
+		}
+		if binary.BigEndian.Uint16(out[2:headerSize]) != 2 {
+			t.Errorf("Reseal() of %s wrote key id %d, want 2", name, binary.BigEndian.Uint16(out[2:headerSize]))
+		}
+		if text, err := s.Decrypt(out); err != nil || string(text) != "old" {
+			t.Errorf("Decrypt() after Reseal() of %s = %q, %v", name, text, err)
+		}
+	}
+
+	if _, _, err := s.Reseal([]byte("garbage")); err == nil {
+		t.Error("Reseal() of garbage succeeded")
+	}
+}
+
+func TestDecryptWithWrongAD(t *testing.T) {
+	s, err := New(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ad := []byte("row 1")
+	sealed, err := s.EncryptWithAD([]byte("hello"), ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text, err := s.DecryptWithAD(sealed, ad); err != nil || string(text) != "hello" {
+		t.Fatalf("DecryptWithAD() = %q, %v", text, err)
+	}
+	for _, other := range [][]byte{nil, []byte("row 2"), []byte("row 1 ")} {
+		if _, err := s.DecryptWithAD(sealed, other); err == nil {
+			t.Errorf("DecryptWithAD() with ad %q succeeded", other)
+		}
+	}
+	if _, _, err := s.ResealWithAD(sealed, []byte("row 2"), ad); err == nil {
+		t.Error("ResealWithAD() with wrong old ad succeeded")
+	}
+}
+
+// Шифротекст сообщения, перенесённый в строку, где отличается любое из полей AD, не расшифруется.
+func TestMessageADBindsRow(t *testing.T) {
+	s, err := New(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := s.EncryptWithAD([]byte("hello"), models.MessageAD(10, 3, 1, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ad   []byte
+	}{
+		{"message id", models.MessageAD(11, 3, 1, 2)},
+		{"conversation", models.MessageAD(10, 4, 1, 2)},
+		{"sender", models.MessageAD(10, 3, 5, 2)},
+		{"receiver", models.MessageAD(10, 3, 1, 5)},
+		{"sender and receiver swapped", models.MessageAD(10, 3, 2, 1)},
+		{"group message", models.MessageAD(10, 3, 1, 0)},
+		{"legacy row", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.DecryptWithAD(sealed, tt.ad); err == nil {
+				t.Error("DecryptWithAD() under another row's AD succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS blocks;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS languages,
+    DROP COLUMN IF EXISTS availability,
+    DROP COLUMN IF EXISTS last_active_at;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_users_games ON users USING GIN (games);
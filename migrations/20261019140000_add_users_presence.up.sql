@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ADD COLUMN last_seen_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN hide_presence BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS broker_payloads;
+DROP TABLE IF EXISTS ws_sessions;
+DROP TABLE IF EXISTS ws_nodes;
@@ -0,0 +1,19 @@
+CREATE TABLE ws_nodes (
+    node_id VARCHAR(64) PRIMARY KEY,
+    heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE ws_sessions (
+    node_id VARCHAR(64) NOT NULL REFERENCES ws_nodes(node_id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    connected_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, node_id)
+);
+
+CREATE INDEX idx_ws_sessions_node_id ON ws_sessions(node_id);
+
+CREATE TABLE broker_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    payload BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
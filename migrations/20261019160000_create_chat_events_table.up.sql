@@ -0,0 +1,11 @@
+CREATE TABLE chat_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    data JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_chat_events_user_id ON chat_events(user_id, id);
+CREATE INDEX idx_chat_events_message_id ON chat_events(message_id);
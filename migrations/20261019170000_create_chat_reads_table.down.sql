@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_receiver_id;
+
+DROP TABLE IF EXISTS chat_reads;
@@ -0,0 +1,10 @@
+CREATE TABLE chat_reads (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    peer_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    last_delivered_message_id INT NOT NULL DEFAULT 0,
+    last_read_message_id INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, peer_id)
+);
+
+CREATE INDEX idx_messages_receiver_id ON messages(receiver_id, sender_id, id);
@@ -0,0 +1,21 @@
+ALTER TABLE messages
+    ADD COLUMN edited_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Предыдущие версии сообщения, зашифрованные тем же ключом, что и messages.message
+CREATE TABLE message_edits (
+    id SERIAL PRIMARY KEY,
+    message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    message BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_message_edits_message_id ON message_edits(message_id, id);
+
+-- Сообщения, удалённые пользователем только у себя
+CREATE TABLE message_hidden (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, message_id)
+);
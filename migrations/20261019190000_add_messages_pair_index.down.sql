@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_messages_pair_created_at;
@@ -0,0 +1,7 @@
+-- Переписка двух пользователей независимо от направления, для постраничной выдачи
+CREATE INDEX idx_messages_pair_created_at ON messages (
+    LEAST(sender_id, receiver_id),
+    GREATEST(sender_id, receiver_id),
+    created_at,
+    id
+);
@@ -0,0 +1,13 @@
+-- Групповые сообщения в старой схеме не представимы
+DELETE FROM messages WHERE receiver_id IS NULL;
+
+DROP INDEX IF EXISTS idx_messages_conversation_created_at;
+
+ALTER TABLE messages
+    DROP CONSTRAINT IF EXISTS messages_receiver_or_conversation,
+    DROP COLUMN IF EXISTS conversation_id,
+    ALTER COLUMN receiver_id SET NOT NULL;
+
+DROP TABLE IF EXISTS conversation_members;
+
+DROP TABLE IF EXISTS conversations;
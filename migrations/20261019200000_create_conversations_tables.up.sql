@@ -0,0 +1,47 @@
+CREATE TABLE conversations (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(16) NOT NULL CHECK (type IN ('direct', 'group')),
+    title VARCHAR(100),
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    -- Для личных переписок - пара участников, чтобы на каждую пару была одна беседа
+    direct_user_low INT REFERENCES users(id) ON DELETE CASCADE,
+    direct_user_high INT REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (direct_user_low, direct_user_high)
+);
+
+CREATE TABLE conversation_members (
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member')),
+    last_read_message_id INT NOT NULL DEFAULT 0,
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX idx_conversation_members_user_id ON conversation_members(user_id);
+
+-- У сообщений в группе нет получателя
+ALTER TABLE messages
+    ADD COLUMN conversation_id INT REFERENCES conversations(id) ON DELETE CASCADE,
+    ALTER COLUMN receiver_id DROP NOT NULL,
+    ADD CONSTRAINT messages_receiver_or_conversation CHECK (receiver_id IS NOT NULL OR conversation_id IS NOT NULL);
+
+CREATE INDEX idx_messages_conversation_created_at ON messages(conversation_id, created_at, id);
+
+-- Существующие пары превращаются в личные беседы из двух участников
+INSERT INTO conversations (type, direct_user_low, direct_user_high, created_at)
+SELECT 'direct', LEAST(sender_id, receiver_id), GREATEST(sender_id, receiver_id), MIN(created_at)
+FROM messages
+GROUP BY LEAST(sender_id, receiver_id), GREATEST(sender_id, receiver_id);
+
+INSERT INTO conversation_members (conversation_id, user_id, joined_at)
+SELECT id, direct_user_low, created_at FROM conversations WHERE type = 'direct'
+UNION ALL
+SELECT id, direct_user_high, created_at FROM conversations WHERE type = 'direct';
+
+UPDATE messages m
+SET conversation_id = c.id
+FROM conversations c
+WHERE c.direct_user_low = LEAST(m.sender_id, m.receiver_id)
+  AND c.direct_user_high = GREATEST(m.sender_id, m.receiver_id);
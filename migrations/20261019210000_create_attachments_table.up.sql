@@ -0,0 +1,22 @@
+-- Вложения сообщений. Содержимое лежит в blob store, зашифрованное ключом file_key;
+-- сам file_key и имя файла зашифрованы ключом сервера, как messages.message.
+-- Пока message_id пустой, вложение загружено, но ещё не отправлено.
+CREATE TABLE attachments (
+    id SERIAL PRIMARY KEY,
+    uploader_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('image', 'video', 'file')),
+    name BYTEA NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    width INT,
+    height INT,
+    file_key BYTEA NOT NULL,
+    storage_key VARCHAR(64) NOT NULL,
+    thumbnail_key VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_attachments_message_id ON attachments(message_id);
+
+CREATE INDEX idx_attachments_pending ON attachments(created_at) WHERE message_id IS NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS message_pins;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS reply_to_id;
@@ -0,0 +1,10 @@
+-- Ответ ссылается на исходное сообщение; цитату сервер собирает при выдаче
+ALTER TABLE messages ADD COLUMN reply_to_id INT REFERENCES messages(id) ON DELETE SET NULL;
+
+CREATE TABLE message_pins (
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    pinned_by INT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, message_id)
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_messages_search_pending;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS search_version;
+
+DROP TABLE IF EXISTS message_search_tokens;
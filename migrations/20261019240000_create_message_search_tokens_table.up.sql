@@ -0,0 +1,14 @@
+-- Слепой индекс: HMAC-токены слов сообщения. Сам текст остаётся зашифрованным.
+CREATE TABLE message_search_tokens (
+    conversation_id INT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    token BYTEA NOT NULL,
+    message_id INT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    PRIMARY KEY (conversation_id, token, message_id)
+);
+
+CREATE INDEX idx_message_search_tokens_message_id ON message_search_tokens(message_id);
+
+-- Версия индексации сообщения; NULL - ещё не проиндексировано, подхватит backfill-search
+ALTER TABLE messages ADD COLUMN search_version SMALLINT;
+
+CREATE INDEX idx_messages_search_pending ON messages(id) WHERE search_version IS NULL AND deleted_at IS NULL;
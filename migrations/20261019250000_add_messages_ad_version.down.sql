@@ -0,0 +1,2 @@
+ALTER TABLE message_edits DROP COLUMN IF EXISTS ad_version;
+ALTER TABLE messages DROP COLUMN IF EXISTS ad_version;
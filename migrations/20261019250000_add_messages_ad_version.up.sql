@@ -0,0 +1,4 @@
+-- Версия associated data шифротекста: 0 - зашифровано без привязки к полям сообщения,
+-- такие строки переводит на текущую версию reencrypt
+ALTER TABLE messages ADD COLUMN ad_version SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE message_edits ADD COLUMN ad_version SMALLINT NOT NULL DEFAULT 0;
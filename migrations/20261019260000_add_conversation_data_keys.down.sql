@@ -0,0 +1,15 @@
+-- Без data_key сообщения с seal_version >= 2 не расшифровать. Откат возможен только после
+-- того, как их переведут обратно на ключ сервера, иначе тексты будут потеряны
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM messages WHERE seal_version >= 2)
+        OR EXISTS (SELECT 1 FROM message_edits WHERE seal_version >= 2) THEN
+        RAISE EXCEPTION 'messages are encrypted with conversation data keys, dropping data_key would make them unreadable';
+    END IF;
+END
+$$;
+
+ALTER TABLE message_edits RENAME COLUMN seal_version TO ad_version;
+ALTER TABLE messages RENAME COLUMN seal_version TO ad_version;
+
+ALTER TABLE conversations DROP COLUMN IF EXISTS data_key;
@@ -0,0 +1,6 @@
+-- Ключ данных беседы, обёрнутый мастер-ключом KeyProvider. Создаётся при первом сообщении.
+ALTER TABLE conversations ADD COLUMN data_key BYTEA;
+
+-- ad_version становится версией шифрования текста: 2 - ключ данных беседы
+ALTER TABLE messages RENAME COLUMN ad_version TO seal_version;
+ALTER TABLE message_edits RENAME COLUMN ad_version TO seal_version;
@@ -0,0 +1,5 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS kind;
+
+ALTER TABLE conversations DROP COLUMN IF EXISTS e2ee;
+
+DROP TABLE IF EXISTS device_keys;
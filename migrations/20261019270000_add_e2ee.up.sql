@@ -0,0 +1,18 @@
+-- Открытые ключи устройств для E2EE. Закрытые ключи с устройств не уходят.
+CREATE TABLE device_keys (
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id TEXT NOT NULL,
+    identity_key BYTEA NOT NULL,
+    signed_prekey_id INT NOT NULL,
+    signed_prekey BYTEA NOT NULL,
+    signed_prekey_signature BYTEA NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, device_id)
+);
+
+-- E2EE включает и выключает любой из собеседников, включить можно, когда оба опубликовали
+-- ключи устройств. Пока включено, обычные сообщения в беседу не принимаются
+ALTER TABLE conversations ADD COLUMN e2ee BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- e2ee: в message лежит шифротекст клиента, сервер его не расшифровывает
+ALTER TABLE messages ADD COLUMN kind TEXT NOT NULL DEFAULT 'text';
@@ -0,0 +1,12 @@
+-- Откат возможен только до запуска backfill-users: зашифрованные значения не восстанавливаются
+DROP TABLE IF EXISTS user_search_tokens;
+
+DROP INDEX IF EXISTS idx_users_email_lower;
+DROP INDEX IF EXISTS idx_users_email_hash;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS about_me_sealed,
+    DROP COLUMN IF EXISTS email_sealed,
+    DROP COLUMN IF EXISTS email_hash_key_id,
+    DROP COLUMN IF EXISTS email_hash,
+    ALTER COLUMN email SET NOT NULL;
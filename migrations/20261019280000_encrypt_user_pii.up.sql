@@ -0,0 +1,24 @@
+-- Email и about_me хранятся зашифрованными. Открытые колонки остаются, пока их не
+-- перенесёт команда backfill-users, после этого в них NULL.
+ALTER TABLE users
+    ADD COLUMN email_hash BYTEA,
+    ADD COLUMN email_hash_key_id SMALLINT,
+    ADD COLUMN email_sealed BYTEA,
+    ADD COLUMN about_me_sealed BYTEA,
+    ALTER COLUMN email DROP NOT NULL;
+
+-- HMAC нормализованного email: поиск при логине и уникальность. email_hash_key_id - ID ключа
+-- email_hmac, которым он посчитан, заполняется вместе с email_hash
+CREATE UNIQUE INDEX idx_users_email_hash ON users(email_hash);
+
+-- Логин и проверка уникальности по ещё не перенесённым строкам
+CREATE INDEX idx_users_email_lower ON users(lower(email)) WHERE email IS NOT NULL;
+
+-- Слепой индекс about_me для поиска профилей
+CREATE TABLE user_search_tokens (
+    token BYTEA NOT NULL,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (token, user_id)
+);
+
+CREATE INDEX idx_user_search_tokens_user ON user_search_tokens(user_id);
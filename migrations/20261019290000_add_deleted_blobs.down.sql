@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS attachments_record_deleted_blobs ON attachments;
+
+DROP FUNCTION IF EXISTS record_deleted_attachment_blobs();
+
+DROP TABLE IF EXISTS deleted_blobs;
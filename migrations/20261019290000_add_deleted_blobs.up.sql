@@ -0,0 +1,20 @@
+-- Ключи блобов удалённых вложений. Строки вложений пропадают и каскадом (удаление сообщения,
+-- пользователя, выход из группы), поэтому ключи собирает триггер, а файлы удаляет сборщик вложений.
+CREATE TABLE deleted_blobs (
+    storage_key VARCHAR(64) PRIMARY KEY,
+    deleted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE FUNCTION record_deleted_attachment_blobs() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO deleted_blobs (storage_key) VALUES (OLD.storage_key) ON CONFLICT DO NOTHING;
+    IF OLD.thumbnail_key IS NOT NULL THEN
+        INSERT INTO deleted_blobs (storage_key) VALUES (OLD.thumbnail_key) ON CONFLICT DO NOTHING;
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER attachments_record_deleted_blobs
+    AFTER DELETE ON attachments
+    FOR EACH ROW EXECUTE FUNCTION record_deleted_attachment_blobs();
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS search_key_id;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS search_key_id;
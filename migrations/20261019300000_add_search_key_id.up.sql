@@ -0,0 +1,5 @@
+-- ID ключа слепого индекса, которым посчитаны токены. NULL - ключ 1: до этой миграции
+-- индекс строился ключом, выведенным из sealer_secret, или search_secret
+ALTER TABLE messages ADD COLUMN search_key_id SMALLINT;
+
+ALTER TABLE users ADD COLUMN search_key_id SMALLINT;